@@ -0,0 +1,121 @@
+package taplink
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"sync"
+)
+
+type affinityKey struct{}
+
+// WithAffinityKey returns a copy of ctx carrying key, e.g. a user ID. When
+// affinity is enabled, requests made with the same key use the same
+// connection pool.
+func WithAffinityKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, affinityKey{}, key)
+}
+
+// EnableAffinity splits the client's connections into n pools, each with its
+// own transport cloned from the client's, including its proxy and TLS
+// settings. Requests made with a context from WithAffinityKey use the pool
+// for their key, and other requests use the client's HTTP client as before.
+// Affinity only picks the connection pool: the host is still chosen by the
+// usual host selection.
+//
+// The pools are cloned when they're first used, and cloned again once the
+// client's transport changes, e.g. when SetPinnedSPKIHashes or
+// SetMinTLSVersion is called, so they always have the current settings.
+//
+// Affinity needs the transport to be an *http.Transport. With any other
+// transport, such as on App Engine, and for clients from ClientFromContext,
+// every request uses the HTTP client as before. A value of n below 2
+// disables affinity.
+func (c *Client) EnableAffinity(n int) {
+	var a *affinityPools
+	if n > 1 {
+		a = &affinityPools{n: n}
+	}
+	c.Lock()
+	old := c.affinity
+	c.affinity = a
+	c.Unlock()
+	old.closeIdle()
+}
+
+// affinityPools are the connection pools of EnableAffinity
+type affinityPools struct {
+	n int
+
+	// pools were cloned from base, whose transport was then transport
+	base      *http.Client
+	transport http.RoundTripper
+	pools     []*http.Client
+
+	mu sync.Mutex
+}
+
+// get returns the pools for base, cloning them again if base or its
+// transport has changed since they were cloned. It returns nil if base's
+// transport can't be split.
+func (a *affinityPools) get(base *http.Client) []*http.Client {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.base == base && sameTransport(a.transport, base.Transport) {
+		return a.pools
+	}
+	closeIdle(a.pools)
+	a.base, a.transport, a.pools = base, base.Transport, nil
+	t, ok := base.Transport.(*http.Transport)
+	if base.Transport == nil {
+		t, ok = http.DefaultTransport.(*http.Transport)
+	}
+	if !ok {
+		return nil
+	}
+	a.pools = make([]*http.Client, a.n)
+	for i := range a.pools {
+		client := *base
+		client.Transport = t.Clone()
+		a.pools[i] = &client
+	}
+	return a.pools
+}
+
+// closeIdle closes the idle connections of the pools
+func (a *affinityPools) closeIdle() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	closeIdle(a.pools)
+	a.mu.Unlock()
+}
+
+// affinityClient returns the client to use for requests made with ctx, out
+// of base and the affinity pools cloned from the client's HTTP client
+func (c *Client) affinityClient(ctx context.Context, base *http.Client) *http.Client {
+	key, ok := ctx.Value(affinityKey{}).(string)
+	if !ok {
+		return base
+	}
+	c.RLock()
+	a := c.affinity
+	c.RUnlock()
+	if a == nil || base != c.baseHTTPClient() {
+		return base
+	}
+	pools := a.get(base)
+	if len(pools) == 0 {
+		return base
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return pools[h.Sum32()%uint32(len(pools))]
+}
+
+func closeIdle(clients []*http.Client) {
+	for _, client := range clients {
+		client.CloseIdleConnections()
+	}
+}
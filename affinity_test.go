@@ -0,0 +1,94 @@
+package taplink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAffinityClient(t *testing.T) {
+	c := New(testAppID).(*Client)
+	base := c.globals.httpClient()
+	ctx := WithAffinityKey(context.Background(), "user1")
+	assert.Equal(t, base, c.affinityClient(ctx, base))
+
+	c.EnableAffinity(4)
+	assert.Equal(t, base, c.affinityClient(context.Background(), base))
+	pool := c.affinityClient(ctx, base)
+	assert.Len(t, c.affinity.pools, 4)
+	assert.NotEqual(t, base, pool)
+	assert.Equal(t, pool, c.affinityClient(WithAffinityKey(context.Background(), "user1"), base))
+	assert.NotEqual(t, base.Transport, pool.Transport)
+
+	// Keys are spread over the pools.
+	used := make(map[*http.Client]bool)
+	for i := 0; i < 100; i++ {
+		used[c.affinityClient(WithAffinityKey(context.Background(), fmt.Sprint(i)), base)] = true
+	}
+	assert.Len(t, used, 4)
+
+	c.EnableAffinity(1)
+	assert.Equal(t, base, c.affinityClient(ctx, base))
+
+	// Other transports can't be split.
+	st, restore := useScript()
+	defer restore()
+	c = New(testAppID).(*Client)
+	c.EnableAffinity(4)
+	assert.Equal(t, st, c.affinityClient(ctx, c.globals.httpClient()).Transport)
+}
+
+func TestAffinityConnections(t *testing.T) {
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(benchSaltResponse)
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.StartTLS()
+	u, _ := url.Parse(srv.URL)
+	prevHost := DefaultHost
+	DefaultHost = u.Host
+	HTTPClient.Transport = srv.Client().Transport
+	defer func() {
+		DefaultHost = prevHost
+		HTTPClient.Transport = origTransport
+		srv.Close()
+	}()
+
+	c := New(testAppID).(*Client)
+	c.EnableAffinity(2)
+	base := c.globals.httpClient()
+	var keys []string
+	for i := 0; len(keys) < 2; i++ {
+		key := fmt.Sprint(i)
+		ctx := WithAffinityKey(context.Background(), key)
+		if len(keys) == 0 || c.affinityClient(ctx, base) != c.affinityClient(WithAffinityKey(context.Background(), keys[0]), base) {
+			keys = append(keys, key)
+		}
+	}
+
+	// Each pool has its own connection, and requests without a key share the
+	// HTTPClient's.
+	for i := 0; i < 3; i++ {
+		for _, key := range keys {
+			_, err := c.GetSaltContext(WithAffinityKey(context.Background(), key), testHashBytes, 0)
+			assert.NoError(t, err)
+		}
+		_, err := c.GetSalt(testHashBytes, 0)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&conns))
+	assert.NoError(t, c.Shutdown(context.Background()))
+}
@@ -1,16 +1,24 @@
 package taplink
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"strconv"
 	"time"
 )
 
 // Host selection algorithms
 const (
-	HostSelectRandom     = iota
+	// HostSelectRandom starts each request at a random server
+	HostSelectRandom = iota
+	// HostSelectRoundRobin starts each request at the server after the one
+	// the previous request started at
 	HostSelectRoundRobin = iota
+	// HostSelectPrimary starts each request at the first server
+	HostSelectPrimary = iota
 )
 
 var (
@@ -20,61 +28,242 @@ var (
 	// DefaultKeepAlive is the default HTTP keep-alive duration
 	DefaultKeepAlive = 30 * time.Second
 
-	// RetryLimit indicates how many times a connection should be retried before failing
+	// RetryLimit indicates how many times a connection should be retried
+	// before failing. It only seeds the retry limit of new clients.
+	//
+	// Deprecated: use Config.SetRetryPolicy instead.
 	RetryLimit = 3
-	// RetryDelay is the duration to wait between retry attempts
+	// RetryDelay is the duration to wait between retry attempts. It's no
+	// longer used by default, see Config.SetBackoff and ConstantBackoff.
+	//
+	// Deprecated: use Config.SetBackoff(ConstantBackoff(d)) instead.
 	RetryDelay = 1 * time.Second
 
-	// maxResponseSize is the largest Content-Length allowed from the API
-	// prevents consuming too much memory from overly large upstream responses
-	// that should theoretically never be the case, but it's there just in case
-	maxResponseSize int64 = 1024 * 500
+	// DefaultMaxResponseSize is the largest response body read from the API,
+	// which prevents consuming too much memory from overly large upstream
+	// responses. It only seeds new clients, see Config.SetMaxResponseSize.
+	DefaultMaxResponseSize int64 = 1024 * 500
 
 	// ErrHostNotFound is returned if the given host does not exist
 	ErrHostNotFound = errors.New("host not found")
+	// ErrUnexpectedContentType is matched by the error returned when a
+	// successful response doesn't have the expected content type
+	ErrUnexpectedContentType = errors.New("unexpected content type")
+	// ErrEmptyResponse is matched by the error returned when a successful
+	// response, such as a 204, has no body
+	ErrEmptyResponse = errors.New("empty response")
+	// ErrResponseTooLarge is matched by the error returned when a successful
+	// response is larger than the max response size, rather than returning
+	// it truncated
+	ErrResponseTooLarge = errors.New("response too large")
+	// ErrInvalidHost is matched by the error returned when loading a config
+	// with a server which isn't a valid host name, or which uses plain http
+	// while DefaultHost uses https
+	ErrInvalidHost = errors.New("invalid host")
+	// ErrVersionBelowMinimum is returned if a request or response uses a data
+	// pool version older than the configured minimum version
+	ErrVersionBelowMinimum = errors.New("version below minimum")
+	// ErrInvalidHash is returned, without making a request, for a hash which
+	// isn't HashSize bytes long
+	ErrInvalidHash = errors.New("invalid hash")
+	// ErrRetriesExhausted is matched by errors returned after every attempt
+	// to reach the API has failed. Use errors.Is(err, ErrRetriesExhausted).
+	ErrRetriesExhausted = errors.New("retries exhausted")
+	// ErrInvalidInterval is returned when a background loop is started with
+	// an interval of 0 or less
+	ErrInvalidInterval = errors.New("invalid interval")
 )
 
-// API is an interface which exposes TapLink API functionality
-type API interface {
+// Verifier is an interface which verifies existing passwords
+type Verifier interface {
+	VerifyPassword(hash []byte, expectedHash []byte, versionID int64) (*VerifyPassword, error)
+}
 
-	// Config
-	Config() Configuration
+// VerifierContext is an interface which verifies existing passwords with a
+// context for the requests to the API
+type VerifierContext interface {
+	VerifyPasswordContext(ctx context.Context, hash []byte, expectedHash []byte, versionID int64) (*VerifyPassword, error)
+}
 
-	// API funcs
-	VerifyPassword(hash []byte, expectedHash []byte, versionID int64) (*VerifyPassword, error)
+// Provisioner is an interface which creates hashes for new passwords
+type Provisioner interface {
 	NewPassword(hash []byte) (*NewPassword, error)
+}
+
+// ProvisionerContext is an interface which creates hashes for new passwords
+// with a context for the requests to the API
+type ProvisionerContext interface {
+	NewPasswordContext(ctx context.Context, hash []byte) (*NewPassword, error)
+}
+
+// UpgradeVerifier is an interface which verifies existing passwords, and
+// stores the upgraded hash when a newer data pool version is available
+type UpgradeVerifier interface {
+	VerifyPasswordWithUpgrade(hash []byte, expected []byte, versionID int64, store UpgradeStore) (*VerifyPassword, error)
+}
+
+// UpgradeVerifierContext is like UpgradeVerifier, with a context for the
+// requests to the API
+type UpgradeVerifierContext interface {
+	VerifyPasswordWithUpgradeContext(ctx context.Context, hash []byte, expected []byte, versionID int64, store UpgradeStore) (*VerifyPassword, error)
+}
+
+// EncodedHasher is an interface which creates and verifies hashes encoded
+// with their version by FormatHash
+type EncodedHasher interface {
+	NewPasswordString(hash1 []byte) (string, error)
+	VerifyPasswordString(hash1 []byte, encoded string) (*VerifyPassword, error)
+}
+
+// EncodedHasherContext is like EncodedHasher, with a context for the
+// requests to the API
+type EncodedHasherContext interface {
+	NewPasswordStringContext(ctx context.Context, hash1 []byte) (string, error)
+	VerifyPasswordStringContext(ctx context.Context, hash1 []byte, encoded string) (*VerifyPassword, error)
+}
+
+// HexHasher is an interface which creates and verifies hashes hex encoded
+type HexHasher interface {
+	NewPasswordHex(hash1Hex string) (hash2Hex string, versionID int64, err error)
+	VerifyPasswordHex(hash1Hex, expectedHex string, versionID int64) (*VerifyPassword, error)
+}
+
+// HexHasherContext is like HexHasher, with a context for the requests to the
+// API
+type HexHasherContext interface {
+	NewPasswordHexContext(ctx context.Context, hash1Hex string) (hash2Hex string, versionID int64, err error)
+	VerifyPasswordHexContext(ctx context.Context, hash1Hex, expectedHex string, versionID int64) (*VerifyPassword, error)
+}
+
+// Batcher is an interface which verifies and creates hashes for many
+// passwords at once
+type Batcher interface {
+	VerifyPasswordBatch(items []VerifyItem, concurrency int) ([]VerifyResult, error)
+	VerifyPasswordBatchContext(ctx context.Context, items []VerifyItem, concurrency int) ([]VerifyResult, error)
+	NewPasswordBatch(items []NewPasswordItem, concurrency int) ([]NewPasswordResult, error)
+	NewPasswordBatchContext(ctx context.Context, items []NewPasswordItem, concurrency int) ([]NewPasswordResult, error)
+	Migrate(ctx context.Context, src <-chan MigrateItem, apply func(key string, newHash []byte, newVersionID int64) error, workers int) (MigrateReport, error)
+}
+
+// SaltProvider is an interface which gets salts from the data pool
+type SaltProvider interface {
+	GetSalt(hash []byte, versionID int64) (*Salt, error)
+}
+
+// SaltProviderContext is an interface which gets salts from the data pool
+// with a context for the requests to the API
+type SaltProviderContext interface {
+	GetSaltContext(ctx context.Context, hash []byte, versionID int64) (*Salt, error)
+}
+
+// VersionProvider is an interface which gets the latest data pool version
+type VersionProvider interface {
+	LatestVersion() (int64, error)
+	LatestVersionContext(ctx context.Context) (int64, error)
+}
+
+// PasswordHasher is an interface which creates hashes for new passwords,
+// with or without a context. Code which only creates hashes can depend on it
+// rather than API, so it can be given a mock such as taplinkmock.Client.
+type PasswordHasher interface {
+	Provisioner
+	ProvisionerContext
+}
+
+// PasswordVerifier is an interface which verifies existing passwords, with or
+// without a context. Like PasswordHasher, it's a smaller surface to mock than
+// API.
+type PasswordVerifier interface {
+	Verifier
+	VerifierContext
+}
+
+// Inspector is an interface which exposes the client config and stats
+type Inspector interface {
+	// Config
+	Config() Configuration
 
 	// Stats returns stats about each host the client has connected to
 	Stats() Statistics
 }
 
+// API is an interface which exposes TapLink API functionality. It's the
+// union of the smaller interfaces above, which code needing less of it can
+// depend on instead.
+type API interface {
+	Verifier
+	VerifierContext
+	UpgradeVerifier
+	UpgradeVerifierContext
+	EncodedHasher
+	EncodedHasherContext
+	HexHasher
+	HexHasherContext
+	Batcher
+	Provisioner
+	ProvisionerContext
+	SaltProvider
+	SaltProviderContext
+	VersionProvider
+	Inspector
+	io.Closer
+}
+
+// saltResponse is the API's response to a salt request, and how a Salt is
+// marshalled. The new salt and version are left out when there's no upgrade.
 type saltResponse struct {
 	Salt2Hex     string `json:"s2"`
 	VersionID    int64  `json:"vid"`
-	NewSalt2Hex  string `json:"new_s2"`
-	NewVersionID int64  `json:"new_vid"`
+	NewSalt2Hex  string `json:"new_s2,omitempty"`
+	NewVersionID int64  `json:"new_vid,omitempty"`
 }
 
 // Version is a version number for the TapLink API
 type Version int64
 
+// VersionLatest is the version which requests the latest data pool version.
+// It's the zero value, so a version ID of 0 means the latest everywhere.
+const VersionLatest Version = 0
+
 // String implements fmt.Stringer interface. If the version is empty, the API expects "" so this return it that way
 func (v Version) String() string {
-	if v == 0 {
+	if v.IsLatest() {
 		return fmt.Sprintf("")
 	}
 	return fmt.Sprintf("%d", v)
 }
 
-// Salt contains a salt for the current version, and NewSalt if a new version is available
+// IsLatest returns whether v requests the latest data pool version
+func (v Version) IsLatest() bool {
+	return v == VersionLatest
+}
+
+// ParseVersion parses a version ID as stored, e.g. in a database column: ""
+// or "latest" for VersionLatest, or a decimal version ID. A negative or
+// malformed version fails with an error matching ErrInvalidVersion.
+func ParseVersion(s string) (Version, error) {
+	if s == "" || s == "latest" {
+		return VersionLatest, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidVersion, s)
+	}
+	return Version(v), nil
+}
+
+// Salt contains a salt for the current version, and NewSalt if a new version is available.
+// It's marshalled to JSON as the API's salt response: s2, vid, new_s2 and new_vid.
 type Salt struct {
 	Salt []byte
 	// VersionID is the version ID used in the request
-	VersionID int64 `json:"-"`
+	VersionID int64
 	// NewVersionID is the new version ID to use, if any.
-	NewVersionID int64 `json:"vid"`
+	NewVersionID int64
 	// NewSalt is the new salt to use if newer data pool settings are available
-	NewSalt []byte `json:"-"`
+	NewSalt []byte
+	// ResponseInfo is from the API response the salt came from
+	ResponseInfo
 }
 
 func (s Salt) String() string {
@@ -88,6 +277,18 @@ type VerifyPassword struct {
 	NewVersionID int64
 	Hash         []byte
 	NewHash      []byte
+	// Degraded is true if the result came from the fallback verifier because
+	// the API couldn't be reached
+	Degraded bool
+	// Upgraded is true if VerifyPasswordWithUpgrade stored NewHash and
+	// NewVersionID, and StoreErr is the error if storing them failed
+	Upgraded bool
+	StoreErr error
+	// NewEncoded is NewHash and NewVersionID encoded by FormatHash, which is
+	// set by VerifyPasswordString
+	NewEncoded string
+	// ResponseInfo is from the API response, and empty for Degraded results
+	ResponseInfo
 }
 
 // String returns the hex-encoded value of the password hash
@@ -99,6 +300,11 @@ func (v VerifyPassword) String() string {
 type NewPassword struct {
 	Hash      []byte
 	VersionID int64
+	// NewVersionID is the newer version the API offered, if the hash was
+	// made with an older one pinned by Config.SetDefaultVersion
+	NewVersionID int64
+	// ResponseInfo is from the API response
+	ResponseInfo
 }
 
 // String returns the hex-encoded value of the password hash
@@ -107,14 +313,43 @@ func (p NewPassword) String() string {
 }
 
 // New returns a new TapLink API connection
-func New(appID string) API {
-	cfg := &Config{
-		appID: appID,
-		stats: newStatistics(),
-		headers: map[string]string{
-			"User-Agent": userAgent,
-			"Accept":     "application/json",
-		},
+func New(appID string, opts ...Option) API {
+	return newClient(appID, opts...)
+}
+
+// NewStrict is like New, but returns ErrInvalidAppID if appID isn't a
+// 128-character hex string rather than failing each request with it.
+func NewStrict(appID string, opts ...Option) (API, error) {
+	if !validAppID(appID) {
+		return nil, ErrInvalidAppID
+	}
+	return newClient(appID, opts...), nil
+}
+
+// NewWithError is like NewStrict, but also returns an error for invalid
+// options, which New leaves out. The error matches ErrInvalidOption, and
+// wraps one error for each invalid option.
+func NewWithError(appID string, opts ...Option) (API, error) {
+	if !validAppID(appID) {
+		return nil, ErrInvalidAppID
+	}
+	c := newClient(appID, opts...)
+	if errs := c.cfg.(*Config).optionErrs; len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return c, nil
+}
+
+func newClient(appID string, opts ...Option) *Client {
+	cfg := newConfig(appID, opts...)
+	c := &Client{cfg: cfg, globals: cfg.globals, lc: newLifecycle(), flights: newSaltGroup(), latest: newLatestVersion()}
+	cfg.client = c
+	for _, opt := range cfg.clientOpts {
+		opt(c)
 	}
-	return &Client{cfg: cfg}
+	c.lc.onStop(cfg.StopAutoReload)
+	c.lc.onStop(cfg.StopHealthChecks)
+	c.lc.onStop(c.DisableVerifyMemo)
+	c.lc.onStop(c.DisableSaltCache)
+	return c
 }
@@ -1,26 +1,24 @@
 package taplink
 
 import (
-	"bytes"
 	"crypto/hmac"
 	"crypto/sha512"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
-	"strings"
-	"sync"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/TapLink/taplink-go/taplinktest"
 	"github.com/stretchr/testify/assert"
 )
 
 var (
 	testAppID    = "7ddf60de9250dce2f9f9a4ff1f5be257eb42e81d872a9381271edddae1fb83f2f99b89f138354fb8098d1e9b6681d6b0a58bbd2b26637b545c1c32607e85d7cf"
 	errRespAppID = "First part of the path must be a 64-byte AppID, encoded as a 128-character hexidecimal string, e.g. '/<AppID>/'"
-	errRespHash  = "Second part of the path must be a 64-byte Hash, encoded as a 128-character hexidecimal string, e.g. '/<AppID>/<Hash>/'"
 
 	testHashString            = "7ddf60de9250dce2f9f9a4ff1f5be257eb42e81d872a9381271edddae1fb83f2f99b89f138354fb8098d1e9b6681d6b0a58bbd2b26637b545c1c32607e85d7cf"
 	testHashBytes             = hexString(testHashString).Bytes()
@@ -39,40 +37,6 @@ func (s hexString) Bytes() []byte {
 	return b
 }
 
-type testRoundTripper struct {
-	code    int
-	latency time.Duration
-	headers map[string]string
-	body    []byte
-	err     error
-}
-
-func (rt *testRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	if rt.err != nil {
-		return nil, rt.err
-	}
-	if rt.latency > time.Duration(0) {
-		time.Sleep(rt.latency)
-	}
-	hdr := make(map[string][]string, 0)
-	if rt.code > 200 && rt.body == nil {
-		rt.body = []byte(http.StatusText(rt.code))
-	}
-	resp := &http.Response{
-		StatusCode: rt.code,
-		Status:     http.StatusText(rt.code),
-		Body:       ioutil.NopCloser(bytes.NewBuffer(rt.body)),
-		Header:     http.Header(hdr),
-	}
-	resp.Header.Set("X-TEST", "true")
-	if rt.headers != nil {
-		for k, v := range rt.headers {
-			resp.Header.Set(k, v)
-		}
-	}
-	return resp, nil
-}
-
 func TestNew(t *testing.T) {
 	a := New(testAppID)
 	assert.Equal(t, testAppID, a.Config().AppID())
@@ -80,66 +44,79 @@ func TestNew(t *testing.T) {
 }
 
 func TestWithTestServer(t *testing.T) {
-	HTTPClient.Transport = &testRoundTripper{503, 0, nil, nil, nil}
-	defer func() {
-		HTTPClient.Transport = origTransport
-	}()
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(503, http.StatusText(503)))
 	c := New(testAppID).(*Client)
-	_, err := c.getFromAPI("/foobar")
-	assert.Equal(t, http.StatusText(503), err.Error())
+	_, err := c.getFromAPI("foobar")
+	assert.Equal(t, http.StatusText(503), errors.Unwrap(err).Error())
 }
 
 func TestWithInvalidJSONResponse(t *testing.T) {
-	HTTPClient.Transport = &testRoundTripper{200, 0, nil, []byte("foobar"), nil}
-	defer func() {
-		HTTPClient.Transport = origTransport
-	}()
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(200, "foobar"))
 	c := New(testAppID).(*Client)
-	_, err := c.getSalt([]byte(""), 0)
-	assert.True(t, strings.HasPrefix(err.Error(), "invalid character"))
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.ErrorIs(t, err, ErrMalformedResponse)
+	assert.ErrorContains(t, err, "invalid character")
 }
 
 func TestWithInvalidHexStringResponse(t *testing.T) {
-	HTTPClient.Transport = &testRoundTripper{200, 0, nil, []byte(`{"s2":"---invalid hex string here---","vid":3}`), nil}
-	defer func() {
-		HTTPClient.Transport = origTransport
-	}()
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(200, `{"s2":"---invalid hex string here---","vid":3}`))
 	c := New(testAppID).(*Client)
-	_, err := c.getSalt([]byte(""), 0)
-	assert.Equal(t, hex.ErrLength, err)
+	c.Config().SetSaltEncoding(SaltEncodingHex)
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.ErrorIs(t, err, ErrMalformedSalt)
+	assert.ErrorIs(t, err, hex.InvalidByteError('-'))
 }
 
 func TestWithReadFailure(t *testing.T) {
-	hdr := map[string]string{"Content-Length": "111111111"}
-	HTTPClient.Transport = &testRoundTripper{200, 0, hdr, nil, nil}
-	defer func() {
-		HTTPClient.Transport = origTransport
-	}()
+	// The body is cut off before the declared length
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "111111111")
+		w.Write([]byte("{"))
+	}))
+	defer srv.Close()
+	c := New(testAppID, WithServers([]string{srv.URL}), WithRetry(1, 0), WithStatsEnabled()).(*Client)
+	_, err := c.getFromAPI("foo")
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Contains(t, err.Error(), "reading response from "+srv.URL)
+	assert.Equal(t, Errors{TransportErrorCode: 1}, c.Stats().Get(srv.URL).Errors())
+}
 
-	c := New(testAppID).(*Client)
-	_, err := c.getFromAPI("/foo")
-	assert.EqualError(t, err, "unexpected EOF")
+func TestEmptyResponse(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(204, ""), taplinktest.Respond(200, ""))
+	c := New(testAppID, WithRetry(2, 0), WithStatsEnabled()).(*Client)
+	_, err := c.getFromAPI("foo")
+	assert.ErrorIs(t, err, ErrEmptyResponse)
+	assert.Equal(t, Errors{EmptyResponseCode: 2}, c.Stats().Get(DefaultHost).Errors())
 }
 
 func TestInvalidURL(t *testing.T) {
 	c := New(testAppID).(*Client)
-	_, err := c.getFromAPI("/foobar")
+	_, err := c.getFromAPI("foobar")
 	assert.Error(t, err)
 }
 
 // TestHTTPClientFailure tests a request to a bogus server/port to ensure that
-// the HTTPClient fails and the RetryLimit and RetryDelay are respected.
+// the HTTPClient fails and the RetryLimit and backoff are respected.
 func TestHTTPClientFailure(t *testing.T) {
-	HTTPClient.Transport = &testRoundTripper{503, 0, nil, nil, errors.New("test error")}
-	defer func() {
-		HTTPClient.Transport = origTransport
-	}()
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.TransportError(errors.New("test error")))
 	c := New(testAppID).(*Client)
 	c.Stats().Enable()
+	delay := 50 * time.Millisecond
+	c.Config().SetBackoff(ConstantBackoff(delay))
 	// First attempt isn't delayed, so subtract 1 from the RetryLimit
-	expectedTime := time.Now().Add(RetryDelay * time.Duration(RetryLimit-1))
+	expectedTime := time.Now().Add(delay * time.Duration(RetryLimit-1))
 	host := c.Config().Host(0)
-	_, err := c.getFromAPI("/foobar")
+	_, err := c.getFromAPI("foobar")
 	assert.NotNil(t, err)
 	assert.Equal(t, int(RetryLimit), c.Stats().Get(host).Errors().Len())
 	if !assert.True(t, time.Now().After(expectedTime)) {
@@ -149,7 +126,7 @@ func TestHTTPClientFailure(t *testing.T) {
 
 func TestInvalidRequest(t *testing.T) {
 	c := New(testAppID).(*Client)
-	_, err := c.getFromAPI("/foobar")
+	_, err := c.getFromAPI("foobar")
 	assert.Error(t, err)
 }
 
@@ -157,10 +134,10 @@ func TestIncErrs(t *testing.T) {
 	c := New(testAppID).(*Client)
 	host := c.Config().Host(0)
 	c.Stats().Disable()
-	c.Stats().AddError(host, 999)
+	c.Stats().AddError(host, 999, 0)
 	assert.Equal(t, 0, c.Stats().Get(host).Errors().Len())
 	c.Stats().Enable()
-	c.Stats().AddError(host, 999)
+	c.Stats().AddError(host, 999, 0)
 	assert.Equal(t, 1, c.Stats().Get(host).Errors().Len())
 }
 
@@ -169,7 +146,7 @@ func TestIncErrsNoLatency(t *testing.T) {
 	host := c.Config().Host(0)
 	errCode := 503
 	c.Stats().Enable()
-	c.Stats().AddError(host, errCode)
+	c.Stats().AddError(host, errCode, 0)
 	assert.Equal(t, 1, c.Stats().Get(host).Errors().Len())
 	assert.Equal(t, 0, c.Stats().Get(host).Latency().Len())
 }
@@ -186,47 +163,62 @@ func TestIncSuccess(t *testing.T) {
 }
 
 func TestGetSalt(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
 	c := New(testAppID).(*Client)
 	c.Stats().Enable()
 	host := c.Config().Host(0)
-	s, err := c.getSalt(testHashBytes, 0)
+	s, err := c.GetSalt(testHashBytes, 0)
 	if !assert.NoError(t, err) {
 		return
 	}
-	assert.Equal(t, s.Salt, testHashExpectedSaltBytes)
+	assert.Equal(t, s.Salt, f.Salt(testHashBytes, 3))
 	assert.Equal(t, int(1), c.Stats().Get(host).Requests())
-	assert.Equal(t, testHashExpectedSalt, fmt.Sprintf("%s", s))
+	assert.Equal(t, hex.EncodeToString(f.Salt(testHashBytes, 3)), fmt.Sprintf("%s", s))
 }
 
 func TestGetSaltErr(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
 	c := New(testAppID).(*Client)
-	s, err := c.getSalt(nil, 0)
+	s, err := c.GetSalt(nil, 0)
 	assert.Nil(t, s)
-	assert.Error(t, err)
-	assert.EqualError(t, err, errRespHash)
+	assert.Equal(t, ErrInvalidHash, err)
+	s, err = c.GetSalt([]byte{}, 0)
+	assert.Nil(t, s)
+	assert.Equal(t, ErrInvalidHash, err)
+	assert.Equal(t, 0, st.Attempts(DefaultHost))
 }
 
 func TestNewPassword(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
 	c := New(testAppID).(*Client)
 	p, err := c.NewPassword(testHashBytes)
 	assert.NoError(t, err)
 
 	// Get a hash of the expected salt and the input password
-	sum := hmac.New(sha512.New, testHashExpectedSaltBytes)
+	sum := hmac.New(sha512.New, f.Salt(testHashBytes, 3))
 	sum.Write(testHashBytes)
 	assert.Equal(t, p.Hash, sum.Sum(nil))
-	assert.Equal(t, testPasswordSumHashStr, fmt.Sprintf("%s", p))
+	assert.Equal(t, hex.EncodeToString(f.Hash(testHashBytes, 3)), fmt.Sprintf("%s", p))
+	assert.Equal(t, int64(3), p.VersionID)
 }
 
 func TestNewPasswordInvalid(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
 	c := New(testAppID).(*Client)
 	p, err := c.NewPassword(nil)
 	assert.Error(t, err)
 	assert.Nil(t, p)
-	assert.EqualError(t, err, errRespHash)
+	assert.Equal(t, ErrInvalidHash, err)
+	assert.Equal(t, 0, st.Attempts(DefaultHost))
 }
 
 func TestVerifyPassword(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
 	c := New(testAppID).(*Client)
 	p, err := c.NewPassword(testHashBytes)
 	if !assert.NoError(t, err) {
@@ -237,15 +229,17 @@ func TestVerifyPassword(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, v)
 	assert.True(t, v.Matched)
-	assert.Equal(t, testPasswordSumHashStr, fmt.Sprintf("%s", v))
+	assert.Equal(t, hex.EncodeToString(f.Hash(testHashBytes, 3)), fmt.Sprintf("%s", v))
 }
 
 func TestVerifyPasswordNewVersion(t *testing.T) {
+	_, restore := useFake()
+	defer restore()
 	c := New(testAppID).(*Client)
 
-	// Get the old expected. Need to use the older version of getSalt for that.
+	// Get the old expected. Need to use the GetSalt for that.
 	// Cannot depend on NewPassword because it uses the latest version.
-	salt, err := c.getSalt(testHashBytes, 2)
+	salt, err := c.GetSalt(testHashBytes, 2)
 	if !assert.NoError(t, err) {
 		return
 	}
@@ -275,13 +269,18 @@ func TestVerifyPasswordNewVersion(t *testing.T) {
 }
 
 func TestVerifyPasswordError(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
 	c := New(testAppID).(*Client)
 	p, err := c.VerifyPassword([]byte("foobar"), nil, 0)
-	assert.Error(t, err)
+	assert.Equal(t, ErrInvalidHash, err)
 	assert.Nil(t, p)
+	assert.Equal(t, 0, st.Attempts(DefaultHost))
 }
 
 func TestVerifyPasswordFail(t *testing.T) {
+	_, restore := useFake()
+	defer restore()
 	c := New(testAppID).(*Client)
 	p, err := c.VerifyPassword(testHashBytes, []byte("foobar"), 0)
 	assert.NoError(t, err)
@@ -294,6 +293,41 @@ func TestVersionID(t *testing.T) {
 	assert.Equal(t, "1", fmt.Sprintf("%s", Version(1)))
 }
 
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		s   string
+		v   Version
+		err error
+	}{
+		{"", VersionLatest, nil},
+		{"latest", VersionLatest, nil},
+		{"0", VersionLatest, nil},
+		{"3", 3, nil},
+		{"-1", 0, ErrInvalidVersion},
+		{"v3", 0, ErrInvalidVersion},
+		{"Latest", 0, ErrInvalidVersion},
+		{"99999999999999999999", 0, ErrInvalidVersion},
+	}
+	for _, tt := range tests {
+		v, err := ParseVersion(tt.s)
+		assert.Equal(t, tt.v, v, tt.s)
+		if tt.err != nil {
+			assert.ErrorIs(t, err, tt.err, tt.s)
+		} else {
+			assert.NoError(t, err, tt.s)
+		}
+	}
+	assert.True(t, VersionLatest.IsLatest())
+	assert.False(t, Version(1).IsLatest())
+
+	// A parsed version round trips through String.
+	for _, v := range []Version{VersionLatest, 1, 42} {
+		parsed, err := ParseVersion(v.String())
+		assert.NoError(t, err)
+		assert.Equal(t, v, parsed)
+	}
+}
+
 // TestVectorsV3 runs tests for correctness of the results vs. known values
 func TestVectorsV3(t *testing.T) {
 
@@ -306,7 +340,7 @@ func TestVectorsV3(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, hexString("9a4893d65a8eec23e520d0c7abe9c170ba61548c754b4805226e48d7519c55ed7f0daec920c5a99019042745007b99822e6853b8620be67955610b6d25f4b2f9").Bytes(), p.Hash)
 
-	s, err := c.getSalt(hash1, 0)
+	s, err := c.GetSalt(hash1, 0)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(3), s.VersionID)
 	assert.Equal(t, hexString("080b64a980fe49664e6e29e7532ce4dab19a070da0618e32b20d7d0578e120458c1fcf7f3de0a9da7bbf7ba49cacabc05230c605f7032ab51323992ff3c35895").Bytes(), s.Salt)
@@ -326,7 +360,7 @@ func TestVectorsV2(t *testing.T) {
 	sum.Write([]byte("secret"))
 	hash1 := sum.Sum(nil)
 
-	s, err := c.getSalt(hash1, 2)
+	s, err := c.GetSalt(hash1, 2)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(2), s.VersionID)
 	assert.Equal(t, hexString("6190928f03b4ca59aed71614876857679e1edcf9b03ce3443a006713bcb2a305d33ee250c327df00f946041ca435a2cf72dd421e02f1e0d8de3efd5406674f6f").Bytes(), s.Salt)
@@ -347,49 +381,57 @@ func TestVectorsV2(t *testing.T) {
 	assert.Equal(t, hexString("9a4893d65a8eec23e520d0c7abe9c170ba61548c754b4805226e48d7519c55ed7f0daec920c5a99019042745007b99822e6853b8620be67955610b6d25f4b2f9").Bytes(), p.NewHash)
 }
 
-// BenchmarkGetSalt tests parallel performance of getting multiple salts from a single client
-// To avoid making requests over the network, a pre-defined response is set.
-func BenchmarkGetSalt(b *testing.B) {
-
-	HTTPClient.Transport = &testRoundTripper{200, 0, nil, []byte(`{"s2":"edb8b9f2560a5bb7a354ca14c0dd72c377474fbad0afb9d73dd8fa01210777b995320979df40c7eab64450a7ef368ff8019350c613538f6abad9c4d9d8879bf5","vid":3}`), nil}
-	defer func() {
-		HTTPClient.Transport = origTransport
-	}()
-
-	var i int
-	var mu sync.Mutex
+func TestGetSaltBelowMinimumVersion(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
 	c := New(testAppID).(*Client)
-	b.ReportAllocs()
-	b.RunParallel(func(pb *testing.PB) {
-		for pb.Next() {
-			c.getSalt(testHashBytes, 0)
-			mu.Lock()
-			i++
-			mu.Unlock()
-		}
-	})
-	b.Logf("Processed %d requests", i)
+	c.SetMinimumVersion(3)
+	_, err := c.GetSalt(testHashBytes, 2)
+	assert.Equal(t, ErrVersionBelowMinimum, err)
+	_, err = c.VerifyPassword(testHashBytes, nil, 2)
+	assert.Equal(t, ErrVersionBelowMinimum, err)
+	assert.Len(t, st.Hosts(), 0)
 }
 
-// BenchmarkGetSaltNetwork runs
-func BenchmarkGetSaltNetwork(b *testing.B) {
-	var i int
-	var mu sync.Mutex
+func TestGetSaltResponseBelowMinimumVersion(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":2}`))
 	c := New(testAppID).(*Client)
-	b.ReportAllocs()
-	b.RunParallel(func(pb *testing.PB) {
-		for pb.Next() {
-			s, err := c.getSalt(testHashBytes, 0)
-			if err != nil {
-				b.Fail()
-			}
-			if !bytes.Equal(testHashExpectedSaltBytes, s.Salt) {
-				b.Fail()
-			}
-			mu.Lock()
-			i++
-			mu.Unlock()
-		}
+	var rejected int64
+	c.Config().OnVersionRejected(func(versionID, minimum int64) {
+		rejected = versionID
 	})
-	b.Logf("Sent %d requests", i)
+	c.SetMinimumVersion(3)
+	s, err := c.GetSalt(testHashBytes, 0)
+	assert.Nil(t, s)
+	assert.Equal(t, ErrVersionBelowMinimum, err)
+	assert.Equal(t, int64(2), rejected)
+
+	c.SetMinimumVersion(2)
+	s, err = c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, testHashExpectedSaltBytes, s.Salt)
+}
+
+func TestInterfaces(t *testing.T) {
+	var c interface{} = New(testAppID)
+	_, ok := c.(Verifier)
+	assert.True(t, ok)
+	_, ok = c.(Provisioner)
+	assert.True(t, ok)
+	_, ok = c.(Inspector)
+	assert.True(t, ok)
+	_, ok = c.(VerifierContext)
+	assert.True(t, ok)
+	_, ok = c.(ProvisionerContext)
+	assert.True(t, ok)
+
+	// The API interface must remain the union of the narrow interfaces.
+	var a API = New(testAppID)
+	var _ Verifier = a
+	var _ Provisioner = a
+	var _ Inspector = a
+	var _ VerifierContext = a
+	var _ ProvisionerContext = a
 }
@@ -0,0 +1,147 @@
+package taplink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIError is returned for an error response from the API. After retries,
+// it's the error from the last attempt and can be found with errors.As.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Host is the host which sent the response
+	Host string
+	// Attempts is how many requests had been made, including this one
+	Attempts int
+	// Body is the response body, which the API uses for the error message
+	Body []byte
+
+	// Message is the error message: the "error" or "message" of a JSON body,
+	// and otherwise the trimmed body, or the status text if it's empty
+	Message string
+	// Code is the error code of a JSON body, if it has one
+	Code string
+	// RequestID is the request ID of a JSON body or the X-Request-Id header,
+	// to quote to TapLink support
+	RequestID string
+}
+
+// jsonError is the body of an error response in JSON. The code may be a
+// string or a number.
+type jsonError struct {
+	Error     string          `json:"error"`
+	Message   string          `json:"message"`
+	Code      json.RawMessage `json:"code"`
+	RequestID string          `json:"requestId"`
+}
+
+// code returns the error code as a string
+func (je *jsonError) code() string {
+	var s string
+	if json.Unmarshal(je.Code, &s) == nil {
+		return s
+	}
+	if string(je.Code) == "null" {
+		return ""
+	}
+	return string(je.Code)
+}
+
+// newAPIError returns the error for the error response with status, header
+// and body, which is parsed for a structured error if it's JSON
+func newAPIError(status int, header http.Header, body []byte, host string, attempts int) *APIError {
+	e := &APIError{StatusCode: status, Host: host, Attempts: attempts, Body: body, RequestID: header.Get("X-Request-Id")}
+	var je jsonError
+	if json.Unmarshal(body, &je) == nil && (je.Error != "" || je.Message != "") {
+		e.Message, e.Code = je.Error, je.code()
+		if e.Message == "" {
+			e.Message = je.Message
+		}
+		if je.RequestID != "" {
+			e.RequestID = je.RequestID
+		}
+		return e
+	}
+	e.Message = strings.TrimSpace(string(body))
+	if e.Message == "" {
+		e.Message = http.StatusText(status)
+	}
+	return e
+}
+
+// Error returns the error message
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return strings.TrimSpace(string(e.Body))
+}
+
+// Attempt is a failed attempt of a request, as recorded in a
+// MultiAttemptError
+type Attempt struct {
+	Host string
+	// StatusCode is the status of the response, or 0 if there wasn't one
+	StatusCode int
+	Err        error
+	Latency    time.Duration
+}
+
+// MultiAttemptError is returned once every attempt of a request has failed.
+// It has each attempt in order, and unwraps to the error of the last one, so
+// errors.Is and errors.As find e.g. its *APIError. It matches
+// ErrRetriesExhausted.
+type MultiAttemptError struct {
+	Attempts []Attempt
+}
+
+// Error summarizes the attempts, with the host and error of each
+func (e *MultiAttemptError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "all %d attempts failed", len(e.Attempts))
+	for i, a := range e.Attempts {
+		sep := ", "
+		if i == 0 {
+			sep = ": "
+		}
+		fmt.Fprintf(&b, "%s#%d %s: %v", sep, i+1, a.Host, a.Err)
+	}
+	return b.String()
+}
+
+// Unwrap returns the error of the last attempt
+func (e *MultiAttemptError) Unwrap() error {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+	return e.Attempts[len(e.Attempts)-1].Err
+}
+
+// Is makes the error match ErrRetriesExhausted
+func (e *MultiAttemptError) Is(target error) bool {
+	return target == ErrRetriesExhausted
+}
+
+// IsClientError returns whether err is from a 4xx response, which means the
+// request itself was rejected and retrying it won't help
+func IsClientError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500
+}
+
+// IsServerError returns whether err is from a 5xx response
+func IsServerError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode >= 500
+}
+
+// IsTimeout returns whether err is from a request which timed out, including
+// one whose context deadline was exceeded
+func IsTimeout(err error) bool {
+	return isTimeout(err)
+}
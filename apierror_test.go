@@ -0,0 +1,162 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+)
+
+func TestAPIErrorClientError(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(400, "bad request\n"))
+	c := New(testAppID).(*Client)
+	_, err := c.GetSalt(testHashBytes, 0)
+
+	var apiErr *APIError
+	if !assert.True(t, errors.As(err, &apiErr)) {
+		return
+	}
+	assert.Equal(t, 400, apiErr.StatusCode)
+	assert.Equal(t, DefaultHost, apiErr.Host)
+	assert.Equal(t, 1, apiErr.Attempts)
+	assert.Equal(t, "bad request\n", string(apiErr.Body))
+	assert.Equal(t, "bad request", err.Error())
+	assert.True(t, IsClientError(err))
+	assert.False(t, IsServerError(err))
+	assert.False(t, IsTimeout(err))
+}
+
+func TestAPIErrorServerError(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Repeat(RetryLimit, taplinktest.Respond(503, "unavailable"))...)
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	_, err := c.GetSalt(testHashBytes, 0)
+
+	var apiErr *APIError
+	if !assert.True(t, errors.As(err, &apiErr)) {
+		return
+	}
+	assert.Equal(t, 503, apiErr.StatusCode)
+	assert.Equal(t, RetryLimit, apiErr.Attempts)
+	assert.Equal(t, "unavailable", errors.Unwrap(err).Error())
+	assert.True(t, errors.Is(err, ErrRetriesExhausted))
+	assert.True(t, IsServerError(err))
+	assert.False(t, IsClientError(err))
+}
+
+func TestAPIErrorTimeout(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Repeat(RetryLimit, taplinktest.Timeout(0))...)
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.True(t, IsTimeout(err))
+	assert.False(t, IsClientError(err))
+	assert.False(t, IsServerError(err))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err = c.GetSaltContext(ctx, testHashBytes, 0)
+	assert.True(t, IsTimeout(err))
+}
+
+func TestAPIErrorJSONBody(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(
+		taplinktest.Respond(400, `{"error": "Invalid AppID", "code": "invalid_app_id", "requestId": "req-1"}`),
+		taplinktest.Respond(404, `{"message": "Unknown version", "code": 4041}`),
+	)
+	c := New(testAppID).(*Client)
+
+	_, err := c.GetSalt(testHashBytes, 0)
+	var apiErr *APIError
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, "Invalid AppID", err.Error())
+		assert.Equal(t, "invalid_app_id", apiErr.Code)
+		assert.Equal(t, "req-1", apiErr.RequestID)
+	}
+
+	_, err = c.GetSalt(testHashBytes, 2)
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, "Unknown version", err.Error())
+		assert.Equal(t, "4041", apiErr.Code)
+		assert.Equal(t, "", apiErr.RequestID)
+	}
+}
+
+func TestAPIErrorPlainAndEmptyBody(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(
+		taplinktest.Respond(400, `  {"unrelated": true}`+"\n"),
+		taplinktest.Respond(403, ""),
+	)
+	c := New(testAppID, WithStatsEnabled()).(*Client)
+
+	// JSON without a message is kept as text
+	_, err := c.GetSalt(testHashBytes, 0)
+	var apiErr *APIError
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, `{"unrelated": true}`, err.Error())
+		assert.Equal(t, "", apiErr.Code)
+	}
+
+	// An empty body is the status' error, not a transport error
+	_, err = c.GetSalt(testHashBytes, 2)
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, 403, apiErr.StatusCode)
+		assert.Equal(t, "Forbidden", err.Error())
+	}
+	assert.Equal(t, Errors{400: 1, 403: 1}, c.Stats().Get(DefaultHost).Errors())
+}
+
+func TestMultiAttemptError(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.EnqueueFor("a.com", taplinktest.Timeout(0), taplinktest.Respond(502, "bad gateway"))
+	st.EnqueueFor("b.com", taplinktest.Respond(503, "unavailable"))
+	c := New(testAppID, WithServers([]string{"a.com", "b.com"}), WithRetry(3, 0), WithStatsEnabled()).(*Client)
+
+	_, err := c.GetSalt(testHashBytes, 0)
+	var multi *MultiAttemptError
+	if !assert.ErrorAs(t, err, &multi) || !assert.Len(t, multi.Attempts, 3) {
+		return
+	}
+	assert.ErrorIs(t, err, ErrRetriesExhausted)
+	assert.Equal(t, []string{"a.com", "b.com", "a.com"}, st.Hosts())
+	assert.Equal(t, "all 3 attempts failed: #1 a.com: "+multi.Attempts[0].Err.Error()+", #2 b.com: unavailable, #3 a.com: bad gateway", err.Error())
+
+	// The attempts match what the stats recorded.
+	a, b := c.Stats().Get("a.com"), c.Stats().Get("b.com")
+	assert.Equal(t, "a.com", multi.Attempts[0].Host)
+	assert.Equal(t, 0, multi.Attempts[0].StatusCode)
+	assert.True(t, IsTimeout(multi.Attempts[0].Err))
+	assert.Equal(t, 1, a.Timeouts())
+	assert.Equal(t, "b.com", multi.Attempts[1].Host)
+	assert.Equal(t, 503, multi.Attempts[1].StatusCode)
+	assert.Equal(t, 1, b.Errors().Count(503))
+	assert.Equal(t, "a.com", multi.Attempts[2].Host)
+	assert.Equal(t, 502, multi.Attempts[2].StatusCode)
+	assert.Equal(t, 1, a.Errors().Count(502))
+	for _, attempt := range multi.Attempts {
+		assert.Greater(t, attempt.Latency, time.Duration(0))
+	}
+
+	// It unwraps to the last attempt's error.
+	var apiErr *APIError
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, 502, apiErr.StatusCode)
+		assert.Equal(t, "a.com", apiErr.Host)
+	}
+}
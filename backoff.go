@@ -0,0 +1,65 @@
+package taplink
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff decides how long to wait before retrying a request
+type Backoff interface {
+	// NextDelay returns how long to wait before the given attempt, where the
+	// first attempt is 0. It's never called for the first attempt.
+	NextDelay(attempt int) time.Duration
+}
+
+// ExponentialBackoff waits Base before the first retry, multiplying the delay
+// by Factor for each retry after that, up to Max. Each delay is randomly
+// adjusted by up to ±Jitter (a fraction, e.g. 0.2 for ±20%) so that many
+// clients retrying at once don't do so in lockstep.
+type ExponentialBackoff struct {
+	Base   time.Duration
+	Factor float64
+	Max    time.Duration
+	Jitter float64
+}
+
+// defaultBackoff is the Backoff used by configs which don't set one
+var defaultBackoff = ExponentialBackoff{
+	Base:   250 * time.Millisecond,
+	Factor: 2,
+	Max:    10 * time.Second,
+	Jitter: 0.2,
+}
+
+// NextDelay implements the Backoff interface
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	if attempt <= 0 {
+		return 0
+	}
+	d := float64(b.Base) * math.Pow(b.Factor, float64(attempt-1))
+	if b.Jitter > 0 {
+		d *= 1 + b.Jitter*(2*rand.Float64()-1)
+	}
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	return time.Duration(d)
+}
+
+type constantBackoff time.Duration
+
+// ConstantBackoff returns a Backoff which always waits d between attempts,
+// which is how retries were delayed before backoff was configurable:
+//
+//	cfg.SetBackoff(taplink.ConstantBackoff(taplink.RetryDelay))
+func ConstantBackoff(d time.Duration) Backoff {
+	return constantBackoff(d)
+}
+
+func (b constantBackoff) NextDelay(attempt int) time.Duration {
+	if attempt <= 0 {
+		return 0
+	}
+	return time.Duration(b)
+}
@@ -0,0 +1,64 @@
+package taplink
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Base: 100 * time.Millisecond, Factor: 2, Max: time.Second}
+	assert.Equal(t, time.Duration(0), b.NextDelay(0))
+	assert.Equal(t, 100*time.Millisecond, b.NextDelay(1))
+	assert.Equal(t, 200*time.Millisecond, b.NextDelay(2))
+	assert.Equal(t, 400*time.Millisecond, b.NextDelay(3))
+	assert.Equal(t, time.Second, b.NextDelay(5))
+	assert.Equal(t, time.Second, b.NextDelay(100))
+
+	// The default has ±20% jitter, and never goes over the cap.
+	for i := 0; i < 100; i++ {
+		d := defaultBackoff.NextDelay(1)
+		assert.True(t, d >= 200*time.Millisecond && d <= 300*time.Millisecond, d)
+		d = defaultBackoff.NextDelay(10)
+		assert.True(t, d >= 8*time.Second && d <= 10*time.Second, d)
+	}
+	assert.Equal(t, time.Duration(0), defaultBackoff.NextDelay(0))
+}
+
+func TestConstantBackoff(t *testing.T) {
+	b := ConstantBackoff(time.Second)
+	assert.Equal(t, time.Duration(0), b.NextDelay(0))
+	assert.Equal(t, time.Second, b.NextDelay(1))
+	assert.Equal(t, time.Second, b.NextDelay(10))
+}
+
+type recordingBackoff struct {
+	attempts []int
+}
+
+func (b *recordingBackoff) NextDelay(attempt int) time.Duration {
+	b.attempts = append(b.attempts, attempt)
+	return 0
+}
+
+func TestBackoffRetries(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(503, http.StatusText(503)))
+	c := New(testAppID).(*Client)
+	assert.Equal(t, defaultBackoff, c.Config().Backoff())
+	b := &recordingBackoff{}
+	c.Config().SetBackoff(b)
+	c.Stats().Enable()
+
+	_, err := c.getFromAPI("foobar")
+	assert.Error(t, err)
+	assert.Equal(t, []int{1, 2}, b.attempts)
+	assert.Equal(t, RetryLimit, c.Stats().Get(DefaultHost).Errors().Count(503))
+
+	c.Config().SetBackoff(nil)
+	assert.Equal(t, defaultBackoff, c.Config().Backoff())
+}
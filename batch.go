@@ -0,0 +1,111 @@
+package taplink
+
+import (
+	"context"
+	"sync"
+)
+
+// VerifyItem is a password to verify with VerifyPasswordBatch. Key is passed
+// back in its result, e.g. to identify the user.
+type VerifyItem struct {
+	Key       any
+	Hash      []byte
+	Expected  []byte
+	VersionID int64
+}
+
+// VerifyResult is the result of verifying a VerifyItem
+type VerifyResult struct {
+	Key      any
+	Password *VerifyPassword
+	Err      error
+}
+
+// NewPasswordItem is a password to hash with NewPasswordBatch
+type NewPasswordItem struct {
+	Key  any
+	Hash []byte
+}
+
+// NewPasswordResult is the result of hashing a NewPasswordItem
+type NewPasswordResult struct {
+	Key      any
+	Password *NewPassword
+	Err      error
+}
+
+// VerifyPasswordBatch verifies items with up to concurrency requests at a
+// time. The results are in the same order as items, and an item which fails
+// doesn't stop the others.
+func (c *Client) VerifyPasswordBatch(items []VerifyItem, concurrency int) ([]VerifyResult, error) {
+	return c.VerifyPasswordBatchContext(context.Background(), items, concurrency)
+}
+
+// VerifyPasswordBatchContext is like VerifyPasswordBatch, but requests to the
+// API are made with ctx. If ctx is done before the batch is, the items which
+// weren't verified have ctx.Err() as their error, and it's returned too.
+func (c *Client) VerifyPasswordBatchContext(ctx context.Context, items []VerifyItem, concurrency int) ([]VerifyResult, error) {
+	results := make([]VerifyResult, len(items))
+	err := runBatch(ctx, len(items), concurrency, func(i int, err error) {
+		item := &items[i]
+		results[i].Key = item.Key
+		if err == nil {
+			results[i].Password, err = c.VerifyPasswordContext(ctx, item.Hash, item.Expected, item.VersionID)
+		}
+		results[i].Err = err
+	})
+	return results, err
+}
+
+// NewPasswordBatch hashes items with up to concurrency requests at a time.
+// The results are in the same order as items, and an item which fails
+// doesn't stop the others.
+func (c *Client) NewPasswordBatch(items []NewPasswordItem, concurrency int) ([]NewPasswordResult, error) {
+	return c.NewPasswordBatchContext(context.Background(), items, concurrency)
+}
+
+// NewPasswordBatchContext is like NewPasswordBatch, but requests to the API
+// are made with ctx, as with VerifyPasswordBatchContext.
+func (c *Client) NewPasswordBatchContext(ctx context.Context, items []NewPasswordItem, concurrency int) ([]NewPasswordResult, error) {
+	results := make([]NewPasswordResult, len(items))
+	err := runBatch(ctx, len(items), concurrency, func(i int, err error) {
+		results[i].Key = items[i].Key
+		if err == nil {
+			results[i].Password, err = c.NewPasswordContext(ctx, items[i].Hash)
+		}
+		results[i].Err = err
+	})
+	return results, err
+}
+
+// runBatch calls fn for each of n items, from up to concurrency goroutines.
+// Once ctx is done, fn is called with ctx.Err() for the items left.
+func runBatch(ctx context.Context, n, concurrency int, fn func(i int, err error)) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i, ctx.Err())
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			fn(i, ctx.Err())
+		}
+	}
+	close(next)
+	wg.Wait()
+	return ctx.Err()
+}
@@ -0,0 +1,113 @@
+package taplink
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+// concurrencyTransport records the most requests it had in flight at once
+type concurrencyTransport struct {
+	http.RoundTripper
+	inflight, max int32
+	mu            sync.Mutex
+}
+
+func (t *concurrencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := atomic.AddInt32(&t.inflight, 1)
+	defer atomic.AddInt32(&t.inflight, -1)
+	t.mu.Lock()
+	if n > t.max {
+		t.max = n
+	}
+	t.mu.Unlock()
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestVerifyPasswordBatch(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	ct := &concurrencyTransport{RoundTripper: st}
+	HTTPClient.Transport = ct
+	st.SetDefault(taplinktest.RespondAfter(5*time.Millisecond, 200, hedgeSaltBody))
+
+	c := New(testAppID).(*Client)
+	c.SetCoalescing(false)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	p, err := c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+
+	items := make([]VerifyItem, 20)
+	for i := range items {
+		items[i] = VerifyItem{Key: i, Hash: testHashBytes, Expected: p.Hash}
+	}
+	// An item which fails doesn't stop the rest.
+	items[7].Expected = []byte("foobar")
+	items[11].Hash = nil
+
+	results, err := c.VerifyPasswordBatch(items, 3)
+	assert.NoError(t, err)
+	assert.Len(t, results, len(items))
+	for i, r := range results {
+		assert.Equal(t, i, r.Key)
+		switch i {
+		case 7:
+			assert.NoError(t, r.Err)
+			assert.False(t, r.Password.Matched)
+		case 11:
+			assert.Equal(t, ErrInvalidHash, r.Err)
+		default:
+			assert.NoError(t, r.Err)
+			assert.True(t, r.Password.Matched, i)
+		}
+	}
+	assert.True(t, ct.max <= 3, "too many requests were concurrent")
+	assert.True(t, ct.max > 1, "requests weren't concurrent")
+}
+
+func TestVerifyPasswordBatchCancel(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.RespondAfter(20*time.Millisecond, 200, hedgeSaltBody))
+	c := New(testAppID).(*Client)
+	c.SetCoalescing(false)
+
+	items := make([]VerifyItem, 10)
+	for i := range items {
+		items[i] = VerifyItem{Key: i, Hash: testHashBytes, Expected: testHashBytes}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	results, err := c.VerifyPasswordBatchContext(ctx, items, 1)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Len(t, results, len(items))
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, context.DeadlineExceeded, results[len(items)-1].Err)
+	for i, r := range results {
+		assert.Equal(t, i, r.Key)
+	}
+	assert.True(t, st.Attempts(DefaultHost) < len(items))
+}
+
+func TestNewPasswordBatch(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, hedgeSaltBody))
+	c := New(testAppID).(*Client)
+
+	results, err := c.NewPasswordBatch([]NewPasswordItem{{Key: "a", Hash: testHashBytes}, {Key: "b"}, {Key: "c", Hash: testHashBytes}}, 0)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, "a", results[0].Key)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, int64(3), results[0].Password.VersionID)
+	assert.Equal(t, ErrInvalidHash, results[1].Err)
+	assert.Nil(t, results[1].Password)
+	assert.Equal(t, results[0].Password.Hash, results[2].Password.Hash)
+}
@@ -0,0 +1,7 @@
+#!/bin/sh
+# Runs the benchmark suite and writes the results as JSON so they can be
+# compared across commits. Usage: ./bench.sh [output file]
+set -e
+out=${1:-bench_output.json}
+go test -run '^$' -bench . -benchmem -count=5 -json > "$out"
+echo "benchmark results written to $out"
@@ -0,0 +1,387 @@
+package taplink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+)
+
+// benchNetworkEnv must be set to run benchmarks against the real TapLink API.
+const benchNetworkEnv = "TAPLINK_BENCH_NETWORK"
+
+var benchSaltResponse = []byte(`{"s2":"` + testHashExpectedSalt + `","vid":3}`)
+
+// withBenchServer starts a local TLS server which answers every request with
+// a canned salt response, and points the package at it for the duration of
+// the benchmark. Requests go over real loopback HTTP/TLS connections. It
+// returns a count of the requests the server has answered.
+func withBenchServer(b *testing.B) *int64 {
+	var requests int64
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(benchSaltResponse)
+	}))
+	u, _ := url.Parse(srv.URL)
+	prevHost := DefaultHost
+	DefaultHost = u.Host
+	HTTPClient.Transport = srv.Client().Transport
+	b.Cleanup(func() {
+		DefaultHost = prevHost
+		HTTPClient.Transport = origTransport
+		srv.Close()
+	})
+	return &requests
+}
+
+// benchGetSalt gets a salt and reports whether it was the expected one. It
+// uses b.Error rather than b.Fatal so it's safe to call from RunParallel.
+func benchGetSalt(b *testing.B, c *Client) bool {
+	s, err := c.GetSalt(testHashBytes, 0)
+	if err != nil {
+		b.Error(err)
+		return false
+	}
+	if !bytes.Equal(testHashExpectedSaltBytes, s.Salt) {
+		b.Error("unexpected salt")
+		return false
+	}
+	return true
+}
+
+// BenchmarkGetSalt measures the latency of a single goroutine getting salts
+// from a local server, and how many requests 64 goroutines getting the same
+// salt make with and without coalescing.
+func BenchmarkGetSalt(b *testing.B) {
+	requests := withBenchServer(b)
+	procs := runtime.GOMAXPROCS(0)
+	p := (64 + procs - 1) / procs
+	for _, enabled := range []bool{false, true} {
+		c := New(testAppID).(*Client)
+		c.SetCoalescing(enabled)
+		name := "CoalescingDisabled"
+		if enabled {
+			name = "CoalescingEnabled"
+		}
+		b.Run(name, func(b *testing.B) {
+			start := atomic.LoadInt64(requests)
+			b.ReportAllocs()
+			b.SetParallelism(p)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if !benchGetSalt(b, c) {
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(atomic.LoadInt64(requests)-start)/float64(b.N), "requests/op")
+		})
+	}
+	for _, enabled := range []bool{false, true} {
+		c := New(testAppID).(*Client)
+		name := "StatsDisabled"
+		if enabled {
+			c.Stats().Enable()
+			name = "StatsEnabled"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !benchGetSalt(b, c) {
+					return
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGetSaltParallel measures the throughput of at least 64 goroutines
+// sharing a single client and getting salts from a local server.
+func BenchmarkGetSaltParallel(b *testing.B) {
+	withBenchServer(b)
+	// RunParallel starts p*GOMAXPROCS goroutines, so round up
+	procs := runtime.GOMAXPROCS(0)
+	p := (64 + procs - 1) / procs
+	for _, enabled := range []bool{false, true} {
+		c := New(testAppID).(*Client)
+		// Measure the requests themselves, rather than waiting on shared ones
+		c.SetCoalescing(false)
+		name := "StatsDisabled"
+		if enabled {
+			c.Stats().Enable()
+			name = "StatsEnabled"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetParallelism(p)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if !benchGetSalt(b, c) {
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkGetSaltTransport measures the throughput of 64 goroutines getting
+// salts from a local server with the default transport options, and with
+// the 2 idle connections per host of a transport without them, which makes
+// most requests open a new connection.
+func BenchmarkGetSaltTransport(b *testing.B) {
+	withBenchServer(b)
+	procs := runtime.GOMAXPROCS(0)
+	p := (64 + procs - 1) / procs
+	for _, bm := range []struct {
+		name string
+		opts TransportOptions
+	}{
+		{"MaxIdleConnsPerHost2", TransportOptions{MaxIdleConnsPerHost: http.DefaultMaxIdleConnsPerHost}},
+		{"Default", DefaultTransportOptions},
+	} {
+		c := New(testAppID, WithTransportOptions(bm.opts)).(*Client)
+		c.SetCoalescing(false)
+		b.Run(bm.name, func(b *testing.B) {
+			var conns int64
+			trace := &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) {
+					if !info.Reused {
+						atomic.AddInt64(&conns, 1)
+					}
+				},
+			}
+			ctx := httptrace.WithClientTrace(context.Background(), trace)
+			b.ReportAllocs()
+			b.SetParallelism(p)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := c.GetSaltContext(ctx, testHashBytes, 0); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(conns), "new-conns")
+		})
+		c.Shutdown(context.Background())
+	}
+}
+
+// BenchmarkGetSaltAffinity measures how often connections are reused when 64
+// goroutines each verify the same few accounts, with and without affinity.
+// With a single local server behind no load balancer, about as many are
+// reused either way.
+func BenchmarkGetSaltAffinity(b *testing.B) {
+	withBenchServer(b)
+	procs := runtime.GOMAXPROCS(0)
+	p := (64 + procs - 1) / procs
+	for _, pools := range []int{0, 8} {
+		c := New(testAppID).(*Client)
+		c.SetCoalescing(false)
+		c.EnableAffinity(pools)
+		b.Run(fmt.Sprintf("Pools%d", pools), func(b *testing.B) {
+			var conns, reused int64
+			trace := &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) {
+					atomic.AddInt64(&conns, 1)
+					if info.Reused {
+						atomic.AddInt64(&reused, 1)
+					}
+				},
+			}
+			var n int64
+			b.ReportAllocs()
+			b.SetParallelism(p)
+			b.RunParallel(func(pb *testing.PB) {
+				ctx := WithAffinityKey(httptrace.WithClientTrace(context.Background(), trace), fmt.Sprint(atomic.AddInt64(&n, 1)%16))
+				for pb.Next() {
+					s, err := c.GetSaltContext(ctx, testHashBytes, 0)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					if !bytes.Equal(testHashExpectedSaltBytes, s.Salt) {
+						b.Error("unexpected salt")
+						return
+					}
+				}
+			})
+			if conns > 0 {
+				b.ReportMetric(float64(reused)/float64(conns)*100, "%reused")
+			}
+		})
+		c.Shutdown(context.Background())
+	}
+}
+
+// BenchmarkGetSaltCached measures getting the same salt from a local server
+// with the salt cache disabled, and enabled, where every request after the
+// first is a cache hit.
+func BenchmarkGetSaltCached(b *testing.B) {
+	requests := withBenchServer(b)
+	for _, enabled := range []bool{false, true} {
+		c := New(testAppID).(*Client)
+		name := "CacheDisabled"
+		if enabled {
+			c.EnableSaltCache(time.Minute, 1024)
+			name = "CacheEnabled"
+		}
+		b.Run(name, func(b *testing.B) {
+			start := atomic.LoadInt64(requests)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !benchGetSalt(b, c) {
+					return
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(requests)-start)/float64(b.N), "requests/op")
+		})
+		c.Shutdown(context.Background())
+	}
+}
+
+// withBenchFake points the package at a taplinktest.Fake for the duration of
+// the benchmark, used as the transport, or served over loopback TLS if
+// serve is set
+func withBenchFake(b *testing.B, serve bool) *taplinktest.Fake {
+	f := taplinktest.NewFake(3)
+	prevHost := DefaultHost
+	if serve {
+		srv := httptest.NewTLSServer(f)
+		u, _ := url.Parse(srv.URL)
+		DefaultHost = u.Host
+		HTTPClient.Transport = srv.Client().Transport
+		b.Cleanup(srv.Close)
+	} else {
+		HTTPClient.Transport = f
+	}
+	b.Cleanup(func() {
+		DefaultHost = prevHost
+		HTTPClient.Transport = origTransport
+	})
+	return f
+}
+
+// benchVerifyItems returns n items with distinct hashes, each of which
+// matches the hash the Fake makes with the latest version
+func benchVerifyItems(f *taplinktest.Fake, n int) []VerifyItem {
+	items := make([]VerifyItem, n)
+	for i := range items {
+		hash := bytes.Repeat([]byte{byte(i)}, len(testHashBytes))
+		items[i] = VerifyItem{Key: i, Hash: hash, Expected: f.Hash(hash, 3), VersionID: 3}
+	}
+	return items
+}
+
+// BenchmarkVerifyPasswordBatch measures verifying a batch of 64 passwords
+// against a taplinktest.Fake, whose requests take a millisecond, with
+// increasing concurrency. Each op is one batch.
+func BenchmarkVerifyPasswordBatch(b *testing.B) {
+	f := withBenchFake(b, false)
+	f.Latency = time.Millisecond
+	items := benchVerifyItems(f, 64)
+	for _, concurrency := range []int{1, 8, 64} {
+		c := New(testAppID).(*Client)
+		b.Run(fmt.Sprintf("Concurrency%d", concurrency), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				results, err := c.VerifyPasswordBatch(items, concurrency)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, r := range results {
+					if r.Err != nil || !r.Password.Matched {
+						b.Fatalf("item %v: %v", r.Key, r.Err)
+					}
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed())/float64(b.N*len(items)), "ns/item")
+		})
+		c.Shutdown(context.Background())
+	}
+}
+
+// BenchmarkVerifyPasswordFake measures verifying a password against a
+// taplinktest.Fake used as the transport, which leaves out the network, with
+// a tenth of its requests failing and being retried, and served over
+// loopback TLS.
+func BenchmarkVerifyPasswordFake(b *testing.B) {
+	for _, bm := range []struct {
+		name      string
+		serve     bool
+		errorRate float64
+	}{
+		{"Transport", false, 0},
+		{"TransportErrors", false, 0.1},
+		{"TLSServer", true, 0},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			f := withBenchFake(b, bm.serve)
+			f.ErrorRate = bm.errorRate
+			item := benchVerifyItems(f, 1)[0]
+			c := New(testAppID).(*Client)
+			c.Config().SetBackoff(ConstantBackoff(0))
+			defer c.Shutdown(context.Background())
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				vp, err := c.VerifyPassword(item.Hash, item.Expected, item.VersionID)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if !vp.Matched {
+					b.Fatal("password didn't match")
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(f.Requests())/float64(b.N), "requests/op")
+		})
+	}
+}
+
+// BenchmarkAddSuccess records successes for a single host without stopping,
+// and reports the heap in use afterwards, which stays flat as b.N grows as
+// only the latest DefaultStatsCapacity events are kept.
+func BenchmarkAddSuccess(b *testing.B) {
+	s := newStatistics()
+	s.Enable()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.AddSuccess("foo.com", time.Millisecond)
+	}
+	b.StopTimer()
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	b.ReportMetric(float64(m.HeapInuse), "heap-bytes")
+	b.ReportMetric(float64(s.Get("foo.com").Requests()), "events")
+}
+
+// BenchmarkGetSaltNetwork gets salts from the real TapLink API. It's skipped
+// unless TAPLINK_BENCH_NETWORK is set, as it's slow and uses up API quota.
+func BenchmarkGetSaltNetwork(b *testing.B) {
+	if os.Getenv(benchNetworkEnv) == "" {
+		b.Skipf("set %s to benchmark against the TapLink API", benchNetworkEnv)
+	}
+	c := New(testAppID).(*Client)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if !benchGetSalt(b, c) {
+				return
+			}
+		}
+	})
+}
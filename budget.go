@@ -0,0 +1,120 @@
+package taplink
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrRequestTimeout is matched by the error of an attempt which took
+	// longer than the request timeout, see Config.SetRequestTimeout
+	ErrRequestTimeout = errors.New("request timeout exceeded")
+	// ErrOperationTimeout is matched by the error returned once the operation
+	// timeout has run out, see Config.SetOperationTimeout
+	ErrOperationTimeout = errors.New("operation timeout exceeded")
+	// ErrDeadlineExceeded is matched by the error returned when retries stop
+	// because the retry deadline has passed, see Config.SetMaxElapsed
+	ErrDeadlineExceeded = errors.New("retry deadline exceeded")
+)
+
+// budgetError is returned when the request or operation timeout runs out.
+// It's a timeout, and wraps the error the attempt failed with, if any.
+type budgetError struct {
+	// budget is ErrRequestTimeout, ErrOperationTimeout or ErrDeadlineExceeded
+	budget   error
+	timeout  time.Duration
+	attempts int
+	err      error
+}
+
+func (e *budgetError) Error() string {
+	msg := fmt.Sprintf("request timeout of %s exceeded on attempt %d", e.timeout, e.attempts)
+	switch e.budget {
+	case ErrOperationTimeout:
+		msg = fmt.Sprintf("operation timeout of %s exceeded after %d attempts", e.timeout, e.attempts)
+	case ErrDeadlineExceeded:
+		msg = fmt.Sprintf("retry deadline of %s exceeded after %d attempts", e.timeout, e.attempts)
+	}
+	if e.err != nil {
+		msg += ": " + e.err.Error()
+	}
+	return msg
+}
+
+func (e *budgetError) Unwrap() error {
+	return e.err
+}
+
+func (e *budgetError) Is(target error) bool {
+	return target == e.budget
+}
+
+// Timeout makes budgetError a timeout for IsTimeout
+func (e *budgetError) Timeout() bool {
+	return true
+}
+
+// Temporary is there for the net.Error interface
+func (e *budgetError) Temporary() bool {
+	return true
+}
+
+// RequestTimeout returns how long each attempt of a request may take, or 0
+// if there's no limit other than the HTTP client's timeout
+func (c *Config) RequestTimeout() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.requestTimeout
+}
+
+// SetRequestTimeout limits each attempt of a request to d, including reading
+// the response. An attempt which runs out of time is recorded as a timeout
+// and retried, and its error matches ErrRequestTimeout. Zero, the default,
+// leaves attempts limited by the HTTP client's timeout only.
+func (c *Config) SetRequestTimeout(d time.Duration) {
+	c.Lock()
+	c.requestTimeout = d
+	c.Unlock()
+}
+
+// OperationTimeout returns how long a whole request, with its retries, may
+// take, or 0 if there's no limit
+func (c *Config) OperationTimeout() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.operationTimeout
+}
+
+// SetOperationTimeout limits a whole request to d, including every attempt
+// and the delays between them, however many attempts the retry limit
+// allows. Once it runs out the request fails with an error matching
+// ErrOperationTimeout, which wraps the error of the last attempt. Zero, the
+// default, means no limit.
+func (c *Config) SetOperationTimeout(d time.Duration) {
+	c.Lock()
+	c.operationTimeout = d
+	c.Unlock()
+}
+
+// MaxElapsed returns how long after a request started retries may begin, or
+// 0 if there's no limit
+func (c *Config) MaxElapsed() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.maxElapsed
+}
+
+// SetMaxElapsed stops retrying a request once d has passed since it started,
+// even if the retry limit allows more attempts. Before sleeping until the
+// next attempt, and before making it, the request fails with an error
+// matching ErrDeadlineExceeded, which wraps the error of the last attempt, if
+// the deadline has passed or would pass before the delay ends. Unlike the
+// operation timeout an attempt already made isn't cut short, so a request
+// can take up to d plus one attempt. A context's deadline applies too, and
+// whichever is sooner wins. Zero, the default, means no limit.
+func (c *Config) SetMaxElapsed(d time.Duration) {
+	c.Lock()
+	c.maxElapsed = d
+	c.Unlock()
+}
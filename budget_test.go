@@ -0,0 +1,134 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudgetError(t *testing.T) {
+	err := &budgetError{budget: ErrRequestTimeout, timeout: time.Second, attempts: 2, err: context.DeadlineExceeded}
+	assert.EqualError(t, err, "request timeout of 1s exceeded on attempt 2: context deadline exceeded")
+	assert.ErrorIs(t, err, ErrRequestTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, errors.Is(err, ErrOperationTimeout))
+	assert.True(t, IsTimeout(err))
+
+	err = &budgetError{budget: ErrOperationTimeout, timeout: time.Second, attempts: 3}
+	assert.EqualError(t, err, "operation timeout of 1s exceeded after 3 attempts")
+	assert.ErrorIs(t, fmt.Errorf("wrapped: %w", err), ErrOperationTimeout)
+	assert.False(t, errors.Is(err, ErrRequestTimeout))
+	assert.True(t, IsTimeout(err))
+}
+
+func TestRequestTimeout(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(
+		taplinktest.RespondAfter(time.Second, 200, `{"servers":[]}`),
+		taplinktest.RespondAfter(time.Second, 200, `{"servers":[]}`),
+		taplinktest.Respond(200, `{"servers":[]}`),
+	)
+	c := New(testAppID).(*Client)
+	c.Stats().Enable()
+	c.Config().SetRequestTimeout(20 * time.Millisecond)
+	assert.Equal(t, 20*time.Millisecond, c.Config().RequestTimeout())
+
+	resp, err := c.getFromAPI(testAppID)
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Equal(t, 2, c.Stats().Get(DefaultHost).Timeouts())
+
+	// Once every attempt times out, the error says so
+	st.Enqueue(taplinktest.Repeat(RetryLimit, taplinktest.RespondAfter(time.Second, 200, "{}"))...)
+	_, err = c.getFromAPI(testAppID)
+	assert.ErrorIs(t, err, ErrRetriesExhausted)
+	assert.ErrorIs(t, err, ErrRequestTimeout)
+	assert.False(t, errors.Is(err, ErrOperationTimeout))
+	assert.True(t, IsTimeout(err))
+	assert.Equal(t, 2+int(RetryLimit), c.Stats().Get(DefaultHost).Timeouts())
+}
+
+func TestOperationTimeout(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Repeat(RetryLimit, taplinktest.RespondAfter(time.Second, 200, "{}"))...)
+	c := New(testAppID).(*Client)
+	c.Config().SetRequestTimeout(30 * time.Millisecond)
+	c.Config().SetOperationTimeout(50 * time.Millisecond)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	assert.Equal(t, 50*time.Millisecond, c.Config().OperationTimeout())
+
+	start := time.Now()
+	_, err := c.getFromAPI(testAppID)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.ErrorIs(t, err, ErrOperationTimeout)
+	// The error of the last complete attempt is wrapped
+	assert.ErrorIs(t, err, ErrRequestTimeout)
+	assert.False(t, errors.Is(err, ErrRetriesExhausted))
+	assert.True(t, IsTimeout(err))
+	// It still counts as the API being unreachable, for the fallback
+	assert.True(t, unreachable(err))
+}
+
+func TestOperationTimeoutCallerCancelled(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.RespondAfter(time.Second, 200, "{}"))
+	c := New(testAppID).(*Client)
+	c.Config().SetOperationTimeout(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.getFromAPIContext(ctx, testAppID)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, errors.Is(err, ErrOperationTimeout))
+}
+
+func TestMaxElapsed(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.RespondAfter(30*time.Millisecond, 503, "unavailable"))
+	c := New(testAppID).(*Client)
+	c.Config().SetRetryPolicy(100, 0)
+	c.Config().SetBackoff(ConstantBackoff(10 * time.Millisecond))
+	c.Config().SetMaxElapsed(100 * time.Millisecond)
+	assert.Equal(t, 100*time.Millisecond, c.Config().MaxElapsed())
+
+	start := time.Now()
+	_, err := c.getFromAPI(testAppID)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.ErrorIs(t, err, ErrDeadlineExceeded)
+	assert.ErrorContains(t, err, "retry deadline of 100ms exceeded after")
+	assert.ErrorContains(t, err, "unavailable")
+	assert.False(t, errors.Is(err, ErrRetriesExhausted))
+	assert.Less(t, st.Attempts(DefaultHost), 10)
+
+	// A delay which would end past the deadline isn't slept
+	c.Config().SetBackoff(ConstantBackoff(time.Second))
+	start = time.Now()
+	_, err = c.getFromAPI(testAppID)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.ErrorIs(t, err, ErrDeadlineExceeded)
+}
+
+func TestMaxElapsedContextSooner(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(503, "unavailable"))
+	c := New(testAppID).(*Client)
+	c.Config().SetRetryPolicy(100, 0)
+	c.Config().SetBackoff(ConstantBackoff(10 * time.Millisecond))
+	c.Config().SetMaxElapsed(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.getFromAPIContext(ctx, testAppID)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, errors.Is(err, ErrDeadlineExceeded))
+}
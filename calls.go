@@ -0,0 +1,68 @@
+package taplink
+
+// ClientStats counts the client's calls to the API, such as a GetSalt or the
+// loading of the configuration, apart from their attempts. During an
+// incident retries multiply the requests recorded for each host, while
+// calls show how many operations actually succeeded.
+type ClientStats struct {
+	// Calls is the number of calls started, Succeeded and Failed the number
+	// of them which finished, and Attempts the number of attempts the
+	// finished calls made
+	Calls     int `json:"calls"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Attempts  int `json:"attempts"`
+}
+
+// AttemptsPerCall returns the average number of attempts of the finished
+// calls, or 0 if none have finished
+func (s ClientStats) AttemptsPerCall() float64 {
+	if n := s.Succeeded + s.Failed; n > 0 {
+		return float64(s.Attempts) / float64(n)
+	}
+	return 0
+}
+
+// callStats is implemented by the built-in stats, which count calls as well
+// as the attempts recorded for each host
+type callStats interface {
+	startCall() (done func(attempts int, failed bool))
+}
+
+// startCall counts a call as started, if stats are enabled, and returns a
+// func which counts it as finished
+func (s *statistics) startCall() (done func(attempts int, failed bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.enabled {
+		return func(int, bool) {}
+	}
+	s.calls.Calls++
+	return func(attempts int, failed bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if failed {
+			s.calls.Failed++
+		} else {
+			s.calls.Succeeded++
+		}
+		s.calls.Attempts += attempts
+	}
+}
+
+// ClientStats returns the counts of the client's calls
+func (s *statistics) ClientStats() ClientStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.calls
+}
+
+// ClientStats returns the counts of the client's calls to the API and their
+// attempts, which are recorded while stats are enabled. They're only kept by
+// the built-in stats, and are zero with stats given with WithStatistics.
+func (c *Client) ClientStats() ClientStats {
+	if s, ok := c.Stats().(*statistics); ok {
+		return s.ClientStats()
+	}
+	return ClientStats{}
+}
@@ -0,0 +1,48 @@
+package taplink
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientStats(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Repeat(RetryLimit, taplinktest.Respond(503, "unavailable"))...)
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(0))
+
+	// Nothing is counted while stats are disabled.
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.Error(t, err)
+	assert.Equal(t, ClientStats{}, c.ClientStats())
+
+	c.Stats().Enable()
+	st.Enqueue(taplinktest.Repeat(RetryLimit, taplinktest.Respond(503, "unavailable"))...)
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.Error(t, err)
+	assert.Equal(t, ClientStats{Calls: 1, Failed: 1, Attempts: RetryLimit}, c.ClientStats())
+	assert.Equal(t, RetryLimit, c.Stats().Get(DefaultHost).Errors().Count(503))
+
+	st.Enqueue(taplinktest.Respond(503, "unavailable"), taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	cs := c.ClientStats()
+	assert.Equal(t, ClientStats{Calls: 2, Succeeded: 1, Failed: 1, Attempts: RetryLimit + 2}, cs)
+	assert.InDelta(t, float64(RetryLimit+2)/2, cs.AttemptsPerCall(), 1e-9)
+
+	// They're in the snapshot, and cleared by Reset.
+	b, err := json.Marshal(c.Stats().Snapshot())
+	assert.NoError(t, err)
+	var snap struct {
+		Calls map[string]int `json:"calls"`
+	}
+	assert.NoError(t, json.Unmarshal(b, &snap))
+	assert.Equal(t, map[string]int{"calls": 2, "succeeded": 1, "failed": 1, "attempts": RetryLimit + 2}, snap.Calls)
+	c.Stats().Reset()
+	assert.Equal(t, ClientStats{}, c.ClientStats())
+	assert.Equal(t, 0.0, ClientStats{}.AttemptsPerCall())
+}
@@ -0,0 +1,151 @@
+package taplink
+
+import (
+	"time"
+)
+
+// CircuitState is the state of a host's circuit breaker
+type CircuitState int
+
+// Circuit breaker states
+const (
+	// CircuitClosed means requests are made to the host as usual
+	CircuitClosed CircuitState = iota
+	// CircuitOpen means the host has failed too often, and is skipped until
+	// the cool-down is over
+	CircuitOpen
+	// CircuitHalfOpen means the cool-down is over and a single probe request
+	// is allowed, which closes the circuit if it succeeds
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// circuitBreaker holds the circuit breaker settings
+type circuitBreaker struct {
+	failures int
+	window   time.Duration
+	cooldown time.Duration
+}
+
+// circuit is the circuit breaker state of a host
+type circuit struct {
+	state CircuitState
+	// streak is the number of consecutive failures since streakStart
+	streak      int
+	streakStart time.Time
+	lastFailure time.Time
+	// openUntil is when the cool-down is over, and probeStart when the probe
+	// request of a half-open circuit was allowed through
+	openUntil  time.Time
+	probeStart time.Time
+}
+
+// circuitStats is implemented by the built-in stats, which keep the circuit
+// breaker state of each host
+type circuitStats interface {
+	setCircuitBreaker(b *circuitBreaker)
+	allowHost(host string, now time.Time) bool
+	lastFailure(host string) time.Time
+}
+
+// isCircuitFailure returns whether an error code counts towards opening the
+// circuit. Client errors are the request's fault rather than the host's.
+func isCircuitFailure(code int) bool {
+	return code < 400 || code >= 500
+}
+
+// record updates the circuit with the outcome of a request. It must be
+// called with the stats lock held.
+func (c *circuit) record(b *circuitBreaker, failed bool, now time.Time) {
+	if !failed {
+		*c = circuit{lastFailure: c.lastFailure}
+		return
+	}
+	c.lastFailure = now
+	if c.state == CircuitHalfOpen {
+		c.state, c.openUntil = CircuitOpen, now.Add(b.cooldown)
+		return
+	}
+	if c.streak == 0 || now.Sub(c.streakStart) > b.window {
+		c.streak, c.streakStart = 0, now
+	}
+	c.streak++
+	if c.state == CircuitClosed && c.streak >= b.failures {
+		c.state, c.openUntil = CircuitOpen, now.Add(b.cooldown)
+	}
+}
+
+// allow returns whether a request can be made to the host now, letting a
+// probe through once the cool-down is over. A probe which hasn't finished
+// within another cool-down is given up on, and another allowed.
+func (c *circuit) allow(b *circuitBreaker, now time.Time) bool {
+	switch c.state {
+	case CircuitOpen:
+		if now.Before(c.openUntil) {
+			return false
+		}
+	case CircuitHalfOpen:
+		if now.Sub(c.probeStart) < b.cooldown {
+			return false
+		}
+	default:
+		return true
+	}
+	c.state, c.probeStart = CircuitHalfOpen, now
+	return true
+}
+
+// EnableCircuitBreaker stops requests to a host after failures consecutive
+// errors or timeouts within window. The host is skipped by Host for cooldown,
+// after which a single probe request is let through: if it succeeds requests
+// are made to the host again, and otherwise the cool-down starts over. If
+// every host is skipped, the one which failed least recently is used.
+//
+// Client errors (4xx) don't count as failures. The breaker state is kept by
+// the built-in stats, see HostStats.CircuitState, so the breaker does nothing
+// if other stats are given with WithStatistics.
+func (c *Config) EnableCircuitBreaker(failures int, window, cooldown time.Duration) {
+	if cs, ok := c.Stats().(circuitStats); ok {
+		cs.setCircuitBreaker(&circuitBreaker{failures: failures, window: window, cooldown: cooldown})
+	}
+}
+
+// DisableCircuitBreaker disables the circuit breaker and closes every circuit
+func (c *Config) DisableCircuitBreaker() {
+	if cs, ok := c.Stats().(circuitStats); ok {
+		cs.setCircuitBreaker(nil)
+	}
+}
+
+// pickHost returns the first host from hosts[start:] (wrapping around) which
+// the circuit breaker allows, or if none are, the least recently failed one
+func pickHost(stats Statistics, hosts []string, start int) string {
+	cs, ok := stats.(circuitStats)
+	if !ok {
+		return hosts[start%len(hosts)]
+	}
+	now := statsNow(stats)
+	var fallback string
+	var fallbackFailed time.Time
+	for i := range hosts {
+		host := hosts[(start+i)%len(hosts)]
+		if cs.allowHost(host, now) {
+			return host
+		}
+		if failed := cs.lastFailure(host); fallback == "" || failed.Before(fallbackFailed) {
+			fallback, fallbackFailed = host, failed
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,104 @@
+package taplink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	c := newConfig("")
+	c.options = &Options{Servers: []string{"foo.com", "bar.com"}}
+	c.EnableCircuitBreaker(3, time.Minute, 50*time.Millisecond)
+
+	// Client errors don't count, and successes reset the streak.
+	c.Stats().AddError("foo.com", 503, 0)
+	c.Stats().AddError("foo.com", 400, 0)
+	c.Stats().AddTimeout("foo.com", 0)
+	c.Stats().AddSuccess("foo.com", time.Millisecond)
+	c.Stats().AddError("foo.com", 503, 0)
+	c.Stats().AddError("foo.com", 999, 0)
+	assert.Equal(t, CircuitClosed, c.Stats().Get("foo.com").CircuitState())
+	assert.Equal(t, "foo.com", c.Host(0))
+
+	c.Stats().AddTimeout("foo.com", 0)
+	assert.Equal(t, CircuitOpen, c.Stats().Get("foo.com").CircuitState())
+	assert.Equal(t, "bar.com", c.Host(0))
+	assert.Equal(t, "bar.com", c.Host(1))
+
+	// After the cool-down, a single probe is let through.
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, "foo.com", c.Host(0))
+	assert.Equal(t, CircuitHalfOpen, c.Stats().Get("foo.com").CircuitState())
+	assert.Equal(t, "bar.com", c.Host(0))
+
+	// A failed probe starts the cool-down over.
+	c.Stats().AddError("foo.com", 503, 0)
+	assert.Equal(t, CircuitOpen, c.Stats().Get("foo.com").CircuitState())
+	assert.Equal(t, "bar.com", c.Host(0))
+
+	// A successful one closes the circuit.
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, "foo.com", c.Host(0))
+	c.Stats().AddSuccess("foo.com", time.Millisecond)
+	assert.Equal(t, CircuitClosed, c.Stats().Get("foo.com").CircuitState())
+	assert.Equal(t, "foo.com", c.Host(0))
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	c := newConfig("")
+	c.options = &Options{Servers: []string{"foo.com", "bar.com"}}
+	c.EnableCircuitBreaker(2, 20*time.Millisecond, time.Minute)
+	c.Stats().AddError("foo.com", 503, 0)
+	time.Sleep(30 * time.Millisecond)
+	c.Stats().AddError("foo.com", 503, 0)
+	assert.Equal(t, CircuitClosed, c.Stats().Get("foo.com").CircuitState())
+	c.Stats().AddError("foo.com", 503, 0)
+	assert.Equal(t, CircuitOpen, c.Stats().Get("foo.com").CircuitState())
+}
+
+func TestCircuitBreakerAllOpen(t *testing.T) {
+	c := newConfig("")
+	c.options = &Options{Servers: []string{"foo.com", "bar.com"}}
+	c.EnableCircuitBreaker(1, time.Minute, time.Minute)
+	c.Stats().AddError("bar.com", 503, 0)
+	time.Sleep(time.Millisecond)
+	c.Stats().AddError("foo.com", 503, 0)
+
+	// Rather than fail, the least recently failed host is used.
+	assert.Equal(t, "bar.com", c.Host(0))
+	assert.Equal(t, "bar.com", c.Host(1))
+
+	c.DisableCircuitBreaker()
+	assert.Equal(t, CircuitClosed, c.Stats().Get("foo.com").CircuitState())
+	assert.Equal(t, "foo.com", c.Host(0))
+}
+
+func TestCircuitBreakerSkipsHost(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.EnqueueFor("foo.com", taplinktest.Repeat(2, taplinktest.Respond(503, "unavailable"))...)
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID).(*Client)
+	c.SetCoalescing(false)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	c.Config().(*Config).options = &Options{Servers: []string{"foo.com", "bar.com"}}
+	c.Config().EnableCircuitBreaker(2, time.Minute, time.Minute)
+
+	for i := 0; i < 4; i++ {
+		_, err := c.GetSalt(testHashBytes, 0)
+		assert.NoError(t, err)
+	}
+	// Each of the first two requests fails over from foo.com, then it's skipped.
+	assert.Equal(t, 2, st.Attempts("foo.com"))
+	assert.Equal(t, 4, st.Attempts("bar.com"))
+}
+
+func TestCircuitStateString(t *testing.T) {
+	assert.Equal(t, "closed", CircuitClosed.String())
+	assert.Equal(t, "open", CircuitOpen.String())
+	assert.Equal(t, "half-open", CircuitHalfOpen.String())
+	assert.Equal(t, "unknown", CircuitState(-1).String())
+}
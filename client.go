@@ -2,17 +2,13 @@ package taplink
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha512"
+	"context"
+	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net"
+	"mime"
 	"net/http"
-	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -20,12 +16,29 @@ import (
 
 var (
 	// ensures the Client implements the API interface
-	_ API = (*Client)(nil)
+	_ API         = (*Client)(nil)
+	_ Verifier    = (*Client)(nil)
+	_ Provisioner = (*Client)(nil)
+	_ Inspector   = (*Client)(nil)
+
+	_ VerifierContext    = (*Client)(nil)
+	_ ProvisionerContext = (*Client)(nil)
+
+	_ PasswordHasher   = (*Client)(nil)
+	_ PasswordVerifier = (*Client)(nil)
 )
 
 // Client is a struct which implements the API interface
 type Client struct {
-	cfg Configuration
+	cfg      Configuration
+	globals  *globals
+	lc       *lifecycle
+	affinity *affinityPools
+	memo     *verifyMemo
+	salts    *saltCache
+	flights  *saltGroup
+	fallback *fallback
+	latest   *latestVersion
 	sync.RWMutex
 }
 
@@ -39,6 +52,46 @@ func (c *Client) Config() Configuration {
 	return c.cfg
 }
 
+// SetMinimumVersion updates the lowest data pool version the client will
+// accept. It can be raised at runtime as migrations complete. A negative
+// version is rejected with ErrInvalidVersion, as by Config.SetMinimumVersion.
+func (c *Client) SetMinimumVersion(v int64) error {
+	return c.cfg.SetMinimumVersion(v)
+}
+
+// EnableVerifyMemo memoizes VerifyPassword results, both matches and
+// mismatches, for ttl so that identical verifications repeated in quick
+// succession (for example login retries) don't each make a request. The ttl
+// is capped at MaxVerifyMemoTTL, and at most maxEntries results are kept.
+//
+// The memo is held in memory only, and is keyed by a SHA-256 of the
+// verification inputs, so the password hash isn't stored. The memoized
+// results are, including their Hash, which is the expected hash when it
+// matched, and NewHash. They're overwritten with zeros when they expire or
+// are evicted, and when the memo is disabled or the client closed. Keep the
+// ttl short: a memoized result won't reflect changes made to the data pool
+// during the ttl. Enabling it again replaces the memo, wiping the results of
+// the previous one.
+func (c *Client) EnableVerifyMemo(ttl time.Duration, maxEntries int) {
+	c.Lock()
+	if c.memo != nil {
+		c.memo.wipe()
+	}
+	c.memo = newVerifyMemo(ttl, maxEntries)
+	c.Unlock()
+}
+
+// DisableVerifyMemo disables the memoization of VerifyPassword results and
+// wipes any memoized results.
+func (c *Client) DisableVerifyMemo() {
+	c.Lock()
+	if c.memo != nil {
+		c.memo.wipe()
+	}
+	c.memo = nil
+	c.Unlock()
+}
+
 // VerifyPassword verifies a password for an existing user which was stored using blind hashing.
 // 'hash'         - hash of the user's password
 // 'expected' - expected value of hash2
@@ -46,21 +99,75 @@ func (c *Client) Config() Configuration {
 // If a new 'versionId' and 'hash2' value are returned, they can either be ignored, or both must be updated in the data store together which
 // will cause the latest data pool settings to be used when blind hashing for this user in the future.
 // If the versionID is 0, the default version will be used
+// The versionID is a Version as an int64: VersionLatest is 0, and ParseVersion
+// parses one as stored.
+// Invalid requests are rejected as by GetSalt, without making a request.
 func (c *Client) VerifyPassword(hash []byte, expected []byte, versionID int64) (*VerifyPassword, error) {
-	salt, err := c.getSalt(hash, versionID)
+	return c.VerifyPasswordContext(context.Background(), hash, expected, versionID)
+}
+
+// VerifyPasswordContext is like VerifyPassword, but requests to the API are
+// made with ctx, so they (and the delay between retries) can be cancelled.
+func (c *Client) VerifyPasswordContext(ctx context.Context, hash []byte, expected []byte, versionID int64) (*VerifyPassword, error) {
+	if c.ShutdownState() != StateRunning {
+		return nil, ErrClientClosed
+	}
+	if versionID == 0 {
+		versionID = c.Config().DefaultVersion()
+	}
+	if err := validateRequest(c.Config().AppID(), hash, versionID); err != nil {
+		return nil, err
+	}
+	c.RLock()
+	memo, fb := c.memo, c.fallback
+	c.RUnlock()
+
+	// The minimum version may have been raised since a result was memoized, so
+	// check it before the memo, and check the version of a memoized result too.
+	if err := c.Config().RejectVersion(versionID); err != nil {
+		return nil, err
+	}
+	var key [sha256.Size]byte
+	if memo != nil {
+		key = verifyMemoKey(hash, expected, versionID)
+		if vp, ok := memo.get(key); ok {
+			if err := c.Config().RejectVersion(vp.VersionID); err != nil {
+				return nil, err
+			}
+			return vp, nil
+		}
+	}
+	vp, err := c.verifyPassword(ctx, hash, expected, versionID)
+	if err != nil {
+		return c.verifyFallback(ctx, hash, expected, versionID, err)
+	}
+	if fb != nil {
+		fb.recover()
+	}
+	if memo != nil {
+		memo.set(key, vp)
+	}
+	return vp, nil
+}
+
+func (c *Client) verifyPassword(ctx context.Context, hash []byte, expected []byte, versionID int64) (*VerifyPassword, error) {
+	salt, err := c.GetSaltContext(ctx, hash, versionID)
 	if err != nil {
 		return nil, err
 	}
-	sum := hmac.New(sha512.New, salt.Salt)
-	sum.Write(hash)
-	vp := &VerifyPassword{Hash: sum.Sum(nil), NewVersionID: salt.NewVersionID, VersionID: salt.VersionID}
+	return verifyWithSalt(salt, hash, expected), nil
+}
+
+// verifyWithSalt hashes hash with salt and compares it to expected. The salt
+// is the caller's own copy, so it's wiped once the hashes are made.
+func verifyWithSalt(salt *Salt, hash []byte, expected []byte) *VerifyPassword {
+	defer salt.Wipe()
+	vp := &VerifyPassword{Hash: hmacSHA512(salt.Salt, hash), NewVersionID: salt.NewVersionID, VersionID: salt.VersionID, ResponseInfo: salt.ResponseInfo}
 	vp.Matched = bytes.Equal(vp.Hash, expected)
 	if vp.Matched && salt.VersionID != salt.NewVersionID && salt.NewSalt != nil {
-		sum2 := hmac.New(sha512.New, salt.NewSalt)
-		sum2.Write(hash)
-		vp.NewHash = sum2.Sum(nil)
+		vp.NewHash = hmacSHA512(salt.NewSalt, hash)
 	}
-	return vp, nil
+	return vp
 }
 
 // NewPassword calculates 'salt1' and 'hash2' for a new password, using the latest data pool settings.
@@ -71,107 +178,494 @@ func (c *Client) VerifyPassword(hash []byte, expected []byte, versionID int64) (
 //       o err       : 'err' from request, or null if request succeeded
 //       o hash2Hex  : value of 'hash2' as a hex string
 //       o versionId : version id of the current data pool settings used for this request
+// Store the VersionID with the hash: Version(VersionID).String() and
+// ParseVersion convert it to and from a string.
 func (c *Client) NewPassword(hash1 []byte) (*NewPassword, error) {
-	salt, err := c.getSalt(hash1, 0)
+	return c.NewPasswordContext(context.Background(), hash1)
+}
+
+// NewPasswordContext is like NewPassword, but requests to the API are made
+// with ctx, so they (and the delay between retries) can be cancelled.
+func (c *Client) NewPasswordContext(ctx context.Context, hash1 []byte) (*NewPassword, error) {
+	if c.ShutdownState() != StateRunning {
+		return nil, ErrClientClosed
+	}
+	salt, err := c.GetSaltContext(ctx, hash1, c.Config().DefaultVersion())
 	if err != nil {
 		return nil, err
 	}
+	return newPasswordWithSalt(salt, hash1), nil
+}
+
+// newPasswordWithSalt hashes hash1 with salt. Like verifyWithSalt, it wipes
+// the salt once the hash is made.
+func newPasswordWithSalt(salt *Salt, hash1 []byte) *NewPassword {
+	defer salt.Wipe()
 
 	// Calculate the hash of the new salt
-	sum := hmac.New(sha512.New, salt.Salt)
-	sum.Write(hash1)
+	np := &NewPassword{VersionID: salt.VersionID, Hash: hmacSHA512(salt.Salt, hash1), ResponseInfo: salt.ResponseInfo}
+	if salt.NewVersionID > salt.VersionID {
+		np.NewVersionID = salt.NewVersionID
+	}
+	return np
+}
 
-	return &NewPassword{VersionID: salt.VersionID, Hash: sum.Sum(nil)}, nil
+func (c *Client) getFromAPI(segments ...string) (*apiResponse, error) {
+	return c.getFromAPIContext(context.Background(), segments...)
 }
 
-func (c *Client) getFromAPI(path string) (respBody []byte, err error) {
+// getFromAPIContext makes a GET request for the API path made up of segments
+func (c *Client) getFromAPIContext(ctx context.Context, segments ...string) (*apiResponse, error) {
+	return c.send(ctx, &apiRequest{segments: segments})
+}
+
+// apiRequest is a GET request to the API
+type apiRequest struct {
+	// segments make up the path
+	segments []string
+	// header is sent as well as the configured headers
+	header http.Header
+	// firstHost, if set, is the host the first attempt is made to, rather
+	// than the first active server
+	firstHost string
+	// untracked requests aren't waited for by Shutdown, and are made after
+	// it too, e.g. the automatic reloads, which it cancels instead
+	untracked bool
+}
+
+// send makes the request r, retrying it as the config allows
+func (c *Client) send(ctx context.Context, r *apiRequest) (resp *apiResponse, err error) {
 
 	var attempts int
-	var resp *http.Response
+
+	if err = c.globals.check(c.Config().Logger()); err != nil {
+		return nil, err
+	}
+	if !r.untracked {
+		if err = c.lc.begin(); err != nil {
+			return nil, err
+		}
+		defer c.lc.end()
+	}
+	if cs, ok := c.Stats().(callStats); ok {
+		done := cs.startCall()
+		defer func() { done(attempts, err != nil) }()
+	}
+
+	// The operation timeout covers every attempt and the delays between them
+	caller, opTimeout := ctx, c.Config().OperationTimeout()
+	if opTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opTimeout)
+		defer cancel()
+	}
+	// stopped returns the error once ctx is done: the caller's if they gave
+	// up, and otherwise one saying the operation timeout ran out, which wraps
+	// the error from the last attempt
+	stopped := func(last error) error {
+		if opTimeout <= 0 || caller.Err() != nil {
+			return caller.Err()
+		}
+		return &budgetError{budget: ErrOperationTimeout, timeout: opTimeout, attempts: attempts, err: last}
+	}
+
+	// Retries stop once the retry deadline has passed, however many attempts
+	// are left, without cutting short an attempt already made
+	clk := c.clock()
+	began, maxElapsed := clk.Now(), c.Config().MaxElapsed()
+	expired := func(delay time.Duration) bool {
+		return maxElapsed > 0 && clk.Now().Sub(began)+delay >= maxElapsed
+	}
+
+	limit, backoff := c.Config().RetryLimit(), c.Config().Backoff()
+	client, release := requestClient(ctx, c.affinityClient(ctx, httpClientFor(ctx, c.baseHTTPClient())))
+	defer release()
+
+	start := c.Config().HostStart()
+	log := newRequestLog(c.Config().Logger(), r.segments)
+	maxRetryAfter := c.Config().MaxRetryAfter()
+	hedgeDelay := c.Config().HedgeDelay()
+
+	// wait is the delay a Retry-After header asked for, which replaces the
+	// backoff before the next attempt
+	var wait time.Duration
+
+	// failed is the host the previous attempt failed on, which retries avoid
+	var failed string
+
+	// mismatched are the hosts whose certificate matched no pin, which
+	// aren't tried again
+	mismatched := map[string]bool{}
+
+	// tried are the attempts which failed, for the error once none succeed
+	var tried []Attempt
 
 	// Attempt to connect until the attempt limit has been reached.
 	// Reset the timer in each loop so the final result will have the proper
 	// latency value.
-	for attempts < RetryLimit {
+	for attempts < limit {
 
-		// For each subsequent attempt after the first add the RetryDelay
+		// For each subsequent attempt after the first wait for the backoff
 		if attempts > 0 {
-			time.Sleep(RetryDelay)
+			delay := backoff.NextDelay(attempts)
+			if wait > 0 {
+				delay, wait = wait, 0
+			}
+			if expired(delay) {
+				log.failed(ctx, attempts, err)
+				return nil, &budgetError{budget: ErrDeadlineExceeded, timeout: maxElapsed, attempts: attempts, err: err}
+			}
+			// Once the retry budget is spent, fail fast rather than add to
+			// the load on the API
+			if !c.withdrawRetry(clk.Now()) {
+				log.failed(ctx, attempts, err)
+				return nil, &retryBudgetError{attempts: attempts, err: err}
+			}
+			c.observe(func(o RequestObserver) { o.OnRetry(failed, delay) })
+			if sleepClock(ctx, clk, delay) != nil {
+				return nil, stopped(err)
+			}
+			if expired(0) {
+				log.failed(ctx, attempts, err)
+				return nil, &budgetError{budget: ErrDeadlineExceeded, timeout: maxElapsed, attempts: attempts, err: err}
+			}
+		} else if ctx.Err() != nil {
+			return nil, stopped(nil)
 		}
 
-		t := time.Now()
-		host := c.Config().Host(attempts)
+		host := c.Config().RetryHost(start, attempts, failed)
+		if attempts == 0 && r.firstHost != "" {
+			host = r.firstHost
+		}
+		if mismatched[host] {
+			if host = c.otherHost(mismatched); host == "" {
+				log.failed(ctx, attempts, err)
+				return nil, err
+			}
+		}
+		if qerr := c.waitQueue(ctx, host); qerr != nil {
+			if ctx.Err() != nil {
+				return nil, stopped(err)
+			}
+			return nil, qerr
+		}
 
 		attempts++
-		urlStr := fmt.Sprintf("https://%s/%s", host, strings.TrimPrefix(path, "/"))
-		req, _ := http.NewRequest("GET", urlStr, nil)
-		for k, v := range c.Config().Headers() {
-			req.Header.Set(k, v)
-		}
-
-		resp, err = HTTPClient.Do(req)
-
-		// Check for a timeout, if so record it accordingly.
-		netErr, isNetErr := err.(net.Error)
-		urlErr, isURLErr := err.(*url.Error)
-		switch {
-		// Check if it's a timeout, if so record it.
-		case err != nil && ((isNetErr && netErr.Timeout()) || (isURLErr && urlErr.Timeout())):
-			c.Stats().AddTimeout(host)
-			continue
-		// For other errors, we'll add an "unknown" code since there won't
-		// be any response to get the code from.
-		case resp == nil:
-			c.Stats().AddError(host, 999)
-			continue
-		}
-
-		// If have a response to work with, get the body and determine the
-		// status code. If it's non-200 then it's an error, and try again.
-		latency := time.Since(t)
-		defer resp.Body.Close()
-		respBody, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
-		if err != nil || len(respBody) == 0 {
-			c.Stats().AddError(host, 999)
-			err = io.ErrUnexpectedEOF
-			continue
-		}
-
-		switch {
-		// If it's a server error, then record it and if this is the last
-		// attempt, the message will be returned. Otherwise another attempt will be made.
-		case resp.StatusCode >= 500:
-			c.Stats().AddError(host, resp.StatusCode)
-			err = errors.New(strings.TrimSpace(string(respBody)))
-		// If it's a client error, then return the error, don't attempt again.
-		case resp.StatusCode >= 400:
-			c.Stats().AddError(host, resp.StatusCode)
-			return nil, errors.New(strings.TrimSpace(string(respBody)))
-		// Otherwise redirects 3xx or success 2xx are okay
-		default:
-			c.Stats().AddSuccess(host, latency)
-			return
+		var o outcome
+		if hedge := c.hedgeHost(host, hedgeDelay); hedge != "" {
+			o = c.hedge(ctx, client, host, hedge, hedgeDelay, r, attempts, maxRetryAfter)
+		} else {
+			o = c.do(ctx, client, host, r, attempts, maxRetryAfter)
+		}
+
+		// If the caller gave up there's no point in retrying.
+		if ctx.Err() != nil {
+			return nil, stopped(err)
+		}
+
+		err, wait, failed = o.err, o.wait, o.host
+		if err != nil {
+			tried = append(tried, Attempt{Host: o.host, StatusCode: o.status, Err: err, Latency: o.latency})
+		}
+		if errors.Is(err, ErrPinMismatch) {
+			mismatched[o.host] = true
+		}
+		log.attempt(ctx, o.host, attempts, o.status, o.latency, err, o.retry && attempts < limit)
+		if !o.retry {
+			if err != nil {
+				log.failed(ctx, attempts, err)
+				return nil, err
+			}
+			return &apiResponse{StatusCode: o.status, Header: o.header, Body: o.body}, nil
 		}
 	}
 
+	// A retry limit below 1, as the RetryLimit global or another
+	// Configuration may give, allows no attempt at all
+	if attempts == 0 {
+		return nil, fmt.Errorf("%w: retry limit %d allows no attempts", ErrRetriesExhausted, limit)
+	}
+	if err != nil {
+		log.failed(ctx, attempts, err)
+		err = &MultiAttemptError{Attempts: tried}
+	}
 	return
 }
 
+// otherHost returns the first active server which isn't in skip, or "" if
+// there's none
+func (c *Client) otherHost(skip map[string]bool) string {
+	for _, h := range c.Config().ActiveServers() {
+		if !skip[h] {
+			return h
+		}
+	}
+	return ""
+}
+
+// outcome is the result of a single request to a host
+type outcome struct {
+	host    string
+	status  int
+	latency time.Duration
+	header  http.Header
+	body    []byte
+	err     error
+
+	// retry is whether another attempt might succeed, which is false for a
+	// success or a client error
+	retry bool
+
+	// wait is the delay a Retry-After header asked for, which replaces the
+	// backoff before the next attempt
+	wait time.Duration
+}
+
+// do makes a single request to host and records its result in the stats.
+// If ctx is done the result isn't recorded, and err is ctx.Err().
+func (c *Client) do(ctx context.Context, client *http.Client, host string, r *apiRequest, attempts int, maxRetryAfter time.Duration) outcome {
+	o := outcome{host: host, retry: true}
+
+	// The request timeout applies to this attempt only
+	rctx, timeout := ctx, c.Config().RequestTimeout()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		rctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// failed decides whether the failed attempt is retried, which doesn't
+	// change how it's recorded
+	policy := c.Config().RetryPolicy()
+	failed := func() outcome {
+		o.retry = policy.ShouldRetry(o.status, o.err, attempts)
+		c.observe(func(ob RequestObserver) { ob.OnError(host, o.status, o.err) })
+		return o
+	}
+	// timedOut returns whether the request timeout of the attempt ran out
+	timedOut := func() bool {
+		return timeout > 0 && rctx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+	}
+
+	// The attempt is traced if the stats or an observer want it, and the
+	// trace is finished once the outcome is known, unless the caller gave up
+	tr, rctx := c.startTrace(rctx, host, attempts)
+	defer func() {
+		if ctx.Err() == nil {
+			c.finishTrace(tr, &o)
+		}
+	}()
+
+	c.observe(func(ob RequestObserver) { ob.OnAttempt(host, attempts) })
+	// A StatsTransport records the request for host, not its URL's host
+	stats := c.attemptStats()
+	rctx = context.WithValue(rctx, statsHostKey{}, host)
+	t := time.Now()
+	req, _ := http.NewRequestWithContext(rctx, "GET", apiURL(host, r.segments...), nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	for k, v := range c.Config().Headers() {
+		req.Header.Set(k, v)
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+
+	// The request is in flight until its body has been read
+	if fs, ok := c.Stats().(inFlightStats); ok {
+		defer fs.startRequest(host)()
+	}
+	resp, err := client.Do(req)
+	if ctx.Err() != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		o.err = ctx.Err()
+		return o
+	}
+
+	switch {
+	// The request timeout ran out, so record it as a timeout.
+	case err != nil && timedOut():
+		o.latency = time.Since(t)
+		stats.AddTimeout(host, o.latency)
+		o.err = &budgetError{budget: ErrRequestTimeout, timeout: timeout, attempts: attempts, err: err}
+		return failed()
+	// Check if it's a timeout, if so record it.
+	case err != nil && isTimeout(err):
+		o.latency, o.err = time.Since(t), err
+		stats.AddTimeout(host, o.latency)
+		return failed()
+	// For other errors, we'll add an "unknown" code since there won't
+	// be any response to get the code from.
+	case resp == nil:
+		code := TransportErrorCode
+		if proxyFailed(err) {
+			code = ProxyErrorCode
+		}
+		o.latency, o.err = time.Since(t), err
+		stats.AddError(host, code, o.latency)
+		return failed()
+	}
+
+	// If have a response to work with, get the body and determine the
+	// status code. If it's non-200 then it's an error, and try again.
+	// The body is closed before the next attempt, so that the connection
+	// can be reused for it.
+	o.latency, o.status, o.header = time.Since(t), resp.StatusCode, resp.Header
+	// One byte more than the max is read to tell a body which is too large
+	// from one which is exactly the max. The max applies to the body once
+	// it's decompressed.
+	maxSize := c.Config().MaxResponseSize()
+	readStart := time.Now()
+	body, err := readBody(resp, maxSize+1)
+	resp.Body.Close()
+	tr.bodyRead(time.Since(readStart))
+	switch {
+	case err != nil && timedOut():
+		stats.AddTimeout(host, time.Since(t))
+		o.err = &budgetError{budget: ErrRequestTimeout, timeout: timeout, attempts: attempts, err: err}
+		return failed()
+	case err != nil && isTimeout(err):
+		stats.AddTimeout(host, time.Since(t))
+		o.err = fmt.Errorf("reading response from %s: %w", host, err)
+		return failed()
+	case err != nil:
+		stats.AddError(host, TransportErrorCode, o.latency)
+		o.err = fmt.Errorf("reading response from %s: %w", host, err)
+		return failed()
+	// An error response is used as far as it was read, but a successful
+	// one can't be decoded if it was cut off
+	case int64(len(body)) > maxSize && resp.StatusCode < 400:
+		stats.AddError(host, ResponseTooLargeCode, o.latency)
+		o.err = fmt.Errorf("%w: %s sent more than %d bytes", ErrResponseTooLarge, host, maxSize)
+		return failed()
+	case int64(len(body)) > maxSize:
+		body = body[:maxSize]
+	// A 304 Not Modified has no body, and an error response may not have
+	// one, but any other response needs one
+	case len(body) == 0 && resp.StatusCode != http.StatusNotModified && resp.StatusCode < 400:
+		stats.AddError(host, EmptyResponseCode, o.latency)
+		o.err = fmt.Errorf("%w from %s: %d %s", ErrEmptyResponse, host, resp.StatusCode, http.StatusText(resp.StatusCode))
+		return failed()
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		o.wait = retryAfter(resp, time.Now(), maxRetryAfter)
+	}
+
+	switch {
+	// If it's an error response, then record it. The retry policy decides
+	// whether another attempt is made, e.g. for server errors and
+	// throttling, or the error is returned, e.g. for client errors.
+	case resp.StatusCode >= 400:
+		stats.AddError(host, resp.StatusCode, o.latency)
+		o.err = newAPIError(resp.StatusCode, resp.Header, body, host, attempts)
+		return failed()
+	// A success which isn't the expected content type, e.g. an HTML error
+	// page from a proxy, can't be decoded, so try another host.
+	case resp.StatusCode < 300 && !matchContentType(resp.Header.Get("Content-Type"), c.Config().ExpectedContentType()):
+		stats.AddError(host, TransportErrorCode, o.latency)
+		o.err = fmt.Errorf("%w: %q", ErrUnexpectedContentType, resp.Header.Get("Content-Type"))
+		return failed()
+	// Otherwise redirects 3xx or success 2xx are okay
+	default:
+		stats.AddSuccess(host, o.latency)
+		o.body, o.retry = body, false
+		c.observe(func(ob RequestObserver) { ob.OnSuccess(host, o.latency, o.status) })
+	}
+	return o
+}
+
+// matchContentType returns whether contentType has the media type expected,
+// ignoring parameters like charset. An empty expected type matches anything.
+func matchContentType(contentType, expected string) bool {
+	if expected == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && strings.EqualFold(mediaType, expected)
+}
+
+// sleepContext sleeps for d, or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	return sleepClock(ctx, realClock{}, d)
+}
+
 // GetSalt retreives a salt value from the data pool, given a 'hash1' value and optionally, a version id
 // If requested versionId is undefined or the latest, then only a single 'salt2' value is returned with the same version id as requested
 // If the requested versionId is not the latest, also returns an additional 'salt2' value along with the latest version id
 // Inputs:
 //    'hash1Hex'  - hex string containing value of hash1
-//    'versionId' - version identifier for data pool settings to use, or 0/null/undefined to use latest settings
+//    'versionId' - version identifier for data pool settings to use, or 0 (VersionLatest) to use latest settings
 //    'callback'  - function(salt2Hex, versionId, newSalt2Hex, newVersionId)
 //       o salt2Hex     : hex string containing value of 'salt2'
 //       o versionId    : version id corresponding to the provided 'salt2Hex' value (will always match requested version, if one was specified)
 //       o newSalt2Hex  : hex string containing a new value of 'salt2' if newer data pool settings are available, otherwise undefined
 //       o newVersionId : a new version id, if newer data pool settings are available, otherwise undefined
-func (c *Client) getSalt(hash []byte, versionID int64) (s *Salt, err error) {
+//
+// A hash which isn't HashSize bytes, a malformed AppID or a negative version
+// is rejected with ErrInvalidHash, ErrInvalidAppID or ErrInvalidVersion
+// without making a request.
+// A response whose salts aren't SaltSize bytes, or whose versions are
+// invalid, fails with an error matching ErrMalformedSalt.
+func (c *Client) GetSalt(hash []byte, versionID int64) (s *Salt, err error) {
+	return c.GetSaltContext(context.Background(), hash, versionID)
+}
+
+// GetSaltContext is like GetSalt, but requests to the API are made with ctx.
+func (c *Client) GetSaltContext(ctx context.Context, hash []byte, versionID int64) (s *Salt, err error) {
+
+	// A cached or offline salt doesn't need a request, so check here
+	if c.ShutdownState() != StateRunning {
+		return nil, ErrClientClosed
+	}
+
+	if err = validateRequest(c.Config().AppID(), hash, versionID); err != nil {
+		return
+	}
+
+	// Don't bother the API with a version which would be rejected anyway.
+	if err = c.Config().RejectVersion(versionID); err != nil {
+		return
+	}
+
+	c.RLock()
+	cache, flights := c.salts, c.flights
+	c.RUnlock()
+	var key [sha256.Size]byte
+	if cache != nil {
+		key = saltCacheKey(hash, versionID)
+		if cached, ok := cache.get(key); ok {
+			if err = c.Config().RejectVersion(cached.VersionID); err != nil {
+				return nil, err
+			}
+			return cached, nil
+		}
+	}
 
-	uri := fmt.Sprintf("%s/%s/%s", c.Config().AppID(), hex.EncodeToString(hash), Version(versionID))
-	bodyBytes, err := c.getFromAPI(uri)
+	fetch := func() (*Salt, error) {
+		return c.fetchSalt(ctx, cache, key, hash, versionID)
+	}
+	if flights == nil {
+		return fetch()
+	}
+	return flights.do(ctx, saltGroupKey(hash, versionID), fetch)
+}
+
+// fetchSalt gets a salt from the API, or makes it in offline mode, and caches
+// it if there's a cache
+func (c *Client) fetchSalt(ctx context.Context, cache *saltCache, key [sha256.Size]byte, hash []byte, versionID int64) (s *Salt, err error) {
+	if secret := c.offlineSecret(); secret != nil {
+		s = offlineSalt(secret, hash, versionID)
+		if err = c.Config().RejectVersion(s.VersionID); err != nil {
+			return nil, err
+		}
+		if cache != nil {
+			cache.set(key, s)
+		}
+		return s, nil
+	}
+
+	resp, err := c.getFromAPIContext(ctx, c.Config().AppID(), hex.EncodeToString(hash), Version(versionID).String())
 
 	// If request error, fail now.
 	if err != nil {
@@ -179,24 +673,28 @@ func (c *Client) getSalt(hash []byte, versionID int64) (s *Salt, err error) {
 	}
 
 	var sr saltResponse
-	err = json.Unmarshal(bodyBytes, &sr)
+	err = decodeJSON(resp.Body, &sr, c.Config().StrictDecoding())
 	if err != nil {
 		return
 	}
-
-	// Use the values from the request in the return value
-	s = &Salt{NewVersionID: sr.NewVersionID, VersionID: sr.VersionID}
-
-	// Hex encoding is used over the wire, so decode here.
-	s.Salt, err = hex.DecodeString(sr.Salt2Hex)
+	salt, newSalt, err := decodeSaltResponse(&sr, c.Config().SaltEncoding())
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	if sr.NewSalt2Hex == "" {
+	// The API can answer a request for the latest version with an older one,
+	// for example if the data pool was rolled back.
+	if err = c.Config().RejectVersion(sr.VersionID); err != nil {
 		return
 	}
 
-	s.NewSalt, err = hex.DecodeString(sr.NewSalt2Hex)
+	c.observeVersions(versionID, &sr)
+
+	// Use the values from the request in the return value
+	s = &Salt{Salt: salt, NewSalt: newSalt, NewVersionID: sr.NewVersionID, VersionID: sr.VersionID, ResponseInfo: responseInfo(resp.Header)}
+
+	if cache != nil {
+		cache.set(key, s)
+	}
 	return
 }
@@ -1,55 +1,70 @@
 package taplink
 
 import (
+	"context"
+	"errors"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
-)
-
-type testNetTOErr string
 
-func (e testNetTOErr) Error() string {
-	return string(e)
-}
+	"github.com/TapLink/taplink-go/taplinktest"
+)
 
-func (e testNetTOErr) Timeout() bool {
-	return true
+// useScript points the HTTPClient at a new ScriptedTransport, and returns
+// it along with a func which restores the original transport
+func useScript() (*taplinktest.ScriptedTransport, func()) {
+	st := &taplinktest.ScriptedTransport{}
+	HTTPClient.Transport = st
+	return st, func() {
+		HTTPClient.Transport = origTransport
+	}
 }
 
-func (e testNetTOErr) Temporary() bool {
-	return true
+// useFake makes requests to a fake API whose latest version is 3
+func useFake() (*taplinktest.Fake, func()) {
+	f := taplinktest.NewFake(3)
+	HTTPClient.Transport = f
+	return f, func() {
+		HTTPClient.Transport = origTransport
+	}
 }
 
 func TestGetFromClientTimeoutError(t *testing.T) {
-	HTTPClient.Transport = &testRoundTripper{200, 0, nil, []byte("foobar"), testNetTOErr("test timeout")}
-	defer func() {
-		HTTPClient.Transport = origTransport
-	}()
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Repeat(RetryLimit, taplinktest.Timeout(0))...)
 	c := New(testAppID).(*Client)
 	c.Stats().Enable()
 
-	_, err := c.getFromAPI("/foobar")
+	_, err := c.getFromAPI("foobar")
 	assert.Error(t, err)
-	ne, ok := err.(net.Error)
-	if assert.True(t, ok) {
+	var ne net.Error
+	ok := errors.As(err, &ne)
+	assert.True(t, errors.Is(err, ErrRetriesExhausted))
+	if !assert.True(t, ok) {
 		return
 	}
 	assert.True(t, ne.Timeout())
 	assert.True(t, ne.Temporary())
 	assert.Equal(t, int(RetryLimit), c.Stats().Get(DefaultHost).Timeouts())
+	assert.Equal(t, int(RetryLimit), st.Attempts(DefaultHost))
 }
 
 func TestGetFromClientServerErr(t *testing.T) {
-	HTTPClient.Transport = &testRoundTripper{500, 0, nil, []byte(http.StatusText(http.StatusInternalServerError)), nil}
-	defer func() {
-		HTTPClient.Transport = origTransport
-	}()
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(500, http.StatusText(http.StatusInternalServerError)))
 	c := New(testAppID).(*Client)
 	c.Stats().Enable()
 
-	_, err := c.getFromAPI("/foobar")
+	_, err := c.getFromAPI("foobar")
 	assert.Error(t, err)
 	assert.Equal(t, int(RetryLimit), c.Stats().Get(DefaultHost).Errors().Count(500))
 	assert.Equal(t, int(RetryLimit), c.Stats().Get(DefaultHost).Errors().Len())
@@ -57,15 +72,242 @@ func TestGetFromClientServerErr(t *testing.T) {
 
 func TestGetFromClientClientErr(t *testing.T) {
 	code := http.StatusUnauthorized
-	HTTPClient.Transport = &testRoundTripper{code, 0, nil, []byte(http.StatusText(code)), nil}
-	defer func() {
-		HTTPClient.Transport = origTransport
-	}()
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(code, http.StatusText(code)))
 	c := New(testAppID).(*Client)
 	c.Stats().Enable()
 
-	_, err := c.getFromAPI("/foobar")
+	_, err := c.getFromAPI("foobar")
 	assert.EqualError(t, err, http.StatusText(code))
 	assert.Equal(t, int(1), c.Stats().Get(DefaultHost).Errors().Count(code))
 	assert.Equal(t, int(1), c.Stats().Get(DefaultHost).Errors().Len())
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+}
+
+func TestGetFromClientRecovers(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(
+		taplinktest.Timeout(0),
+		taplinktest.Respond(503, http.StatusText(503)),
+		taplinktest.Respond(200, "foobar"),
+	)
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	c.Stats().Enable()
+
+	b, err := c.getFromAPI("foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, "foobar", string(b.Body))
+	assert.Equal(t, 1, c.Stats().Get(DefaultHost).Timeouts())
+	assert.Equal(t, 1, c.Stats().Get(DefaultHost).Errors().Count(503))
+	assert.Equal(t, 1, c.Stats().Get(DefaultHost).Requests())
+	assert.Equal(t, 0, st.Remaining())
+}
+
+// testLimiter allows one request per interval
+type testLimiter struct {
+	interval time.Duration
+	next     time.Time
+	mu       sync.Mutex
+}
+
+func (l *testLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestSharedRateLimiter(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+
+	l := &testLimiter{interval: 20 * time.Millisecond}
+	c1 := New(testAppID).(*Client)
+	c2 := New(testAppID).(*Client)
+	for _, c := range []*Client{c1, c2} {
+		c.Stats().Enable()
+		c.Config().SetSharedRateLimiter(l)
+		// Every request has to wait its turn, rather than share one
+		c.SetCoalescing(false)
+	}
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			_, err := c.GetSalt(testHashBytes, 0)
+			assert.NoError(t, err)
+		}([]*Client{c1, c2}[i%2])
+	}
+	wg.Wait()
+
+	// The first request isn't delayed, the other 9 are spread over the interval
+	assert.True(t, time.Since(start) >= 9*l.interval)
+	assert.Equal(t, 5, c1.Stats().Get(DefaultHost).QueueWait().Len())
+	assert.Equal(t, 5, c2.Stats().Get(DefaultHost).QueueWait().Len())
+	for _, c := range []*Client{c1, c2} {
+		assert.True(t, c.Stats().Get(DefaultHost).Latency().Avg() < l.interval)
+	}
+}
+
+type testCancelledLimiter struct{}
+
+func (testCancelledLimiter) Wait(ctx context.Context) error {
+	return context.Canceled
+}
+
+func TestSharedRateLimiterError(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := New(testAppID).(*Client)
+	c.Config().SetSharedRateLimiter(testCancelledLimiter{})
+	_, err := c.getFromAPI("foobar")
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, context.Canceled, c.Config().Load())
+	assert.Len(t, st.Hosts(), 0)
+}
+
+func TestGetFromAPICancelledContext(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, "foobar"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := New(testAppID).(*Client)
+	c.Config().SetSharedRateLimiter(&testLimiter{interval: time.Hour})
+	_, err := c.getFromAPIContext(ctx, "/foobar")
+	assert.Equal(t, context.Canceled, err)
+	_, err = c.VerifyPasswordContext(ctx, testHashBytes, nil, 0)
+	assert.Equal(t, context.Canceled, err)
+	_, err = c.NewPasswordContext(ctx, testHashBytes)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, context.Canceled, c.Config().LoadContext(ctx))
+	assert.Len(t, st.Hosts(), 0)
+}
+
+func TestGetFromAPICancelledDuringRetry(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(503, http.StatusText(503)))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(time.Hour))
+	start := time.Now()
+	_, err := c.getFromAPIContext(ctx, "/foobar")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+}
+
+func TestContentType(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	body := `{"s2":"` + testHashExpectedSalt + `","vid":3}`
+	html := taplinktest.Outcome{StatusCode: 200, Body: []byte("<html></html>"), Header: http.Header{"Content-Type": {"text/html"}}}
+	missing := taplinktest.Outcome{StatusCode: 200, Body: []byte(body)}
+	charset := taplinktest.Respond(200, body)
+	charset.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	// A mismatch is retried.
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	c.Stats().Enable()
+	st.Enqueue(html, charset)
+	s, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, testHashExpectedSaltBytes, s.Salt)
+	assert.Equal(t, 1, c.Stats().Get(DefaultHost).Errors().Count(999))
+
+	st.Enqueue(taplinktest.Repeat(RetryLimit, html)...)
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.True(t, errors.Is(err, ErrUnexpectedContentType))
+	assert.True(t, errors.Is(err, ErrRetriesExhausted))
+	assert.EqualError(t, errors.Unwrap(err), `unexpected content type: "text/html"`)
+
+	st.Enqueue(taplinktest.Repeat(RetryLimit, missing)...)
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.EqualError(t, errors.Unwrap(err), `unexpected content type: ""`)
+
+	// Without an expected content type, anything is accepted.
+	c.Config().SetExpectedContentType("")
+	st.Enqueue(missing)
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, st.Remaining())
+}
+
+// checkClosedBackoff fails the test if any response body is still open when
+// a retry is about to be made
+type checkClosedBackoff struct {
+	t  *testing.T
+	st *taplinktest.ScriptedTransport
+}
+
+func (b checkClosedBackoff) NextDelay(attempt int) time.Duration {
+	assert.Equal(b.t, 0, b.st.Unclosed(), "before attempt", attempt+1)
+	return 0
+}
+
+func TestResponseBodiesClosedEachAttempt(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(503, "unavailable"))
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(checkClosedBackoff{t, st})
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.Error(t, err)
+	assert.Equal(t, RetryLimit, st.Attempts(DefaultHost))
+	assert.Equal(t, 0, st.Unclosed())
+}
+
+func TestRetriesReuseConnection(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	prevHost := DefaultHost
+	DefaultHost = u.Host
+	HTTPClient.Transport = srv.Client().Transport
+	defer func() {
+		DefaultHost = prevHost
+		HTTPClient.Transport = origTransport
+	}()
+
+	var conns, reused int
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			conns++
+			if info.Reused {
+				reused++
+			}
+		},
+	}
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	_, err := c.getFromAPIContext(httptrace.WithClientTrace(context.Background(), trace), "foobar")
+	assert.Error(t, err)
+	assert.Equal(t, RetryLimit, conns)
+	assert.Equal(t, RetryLimit-1, reused)
 }
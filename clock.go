@@ -0,0 +1,89 @@
+package taplink
+
+import (
+	"context"
+	"time"
+)
+
+// clock tells the time for the stats' timestamps and windows, and waits out
+// the delays between retries. It's the real clock, except in tests which
+// advance a fake one rather than sleeping.
+type clock interface {
+	Now() time.Time
+	// NewTimer returns a channel the time is sent on once d has passed, and
+	// a func which stops the timer
+	NewTimer(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+// realClock is the clock of the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	t := time.NewTimer(d)
+	return t.C, t.Stop
+}
+
+// clockNow returns the time of clk, or of the real clock if it's nil
+func clockNow(clk clock) time.Time {
+	if clk == nil {
+		return time.Now()
+	}
+	return clk.Now()
+}
+
+// statsNow returns the time of the clock of the built-in stats, or the real
+// time for other stats
+func statsNow(stats Statistics) time.Time {
+	if s, ok := stats.(*statistics); ok {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// withClock makes the client and its built-in stats tell the time with clk.
+// It's for tests, which advance a fake clock instead of sleeping.
+func withClock(clk clock) Option {
+	return func(c *Config) {
+		c.clock = clk
+		if s, ok := c.stats.(*statistics); ok {
+			s.setClock(clk)
+		}
+	}
+}
+
+// clockConfig is implemented by Config, whose clock may be replaced
+type clockConfig interface {
+	clockOf() clock
+}
+
+// clockOf returns the config's clock
+func (c *Config) clockOf() clock {
+	if c.clock == nil {
+		return realClock{}
+	}
+	return c.clock
+}
+
+// clock returns the clock of the client's config, or the real clock
+func (c *Client) clock() clock {
+	if cc, ok := c.Config().(clockConfig); ok {
+		return cc.clockOf()
+	}
+	return realClock{}
+}
+
+// sleepClock sleeps for d on clk, or until ctx is done
+func sleepClock(ctx context.Context, clk clock, d time.Duration) error {
+	ch, stop := clk.NewTimer(d)
+	defer stop()
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,158 @@
+package taplink
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a clock which only moves when it's advanced. Its timers fire
+// once it has been advanced past them, or straight away if autoAdvance is
+// set, which moves the clock on to when they fire, so sleeping on it takes
+// no time.
+type fakeClock struct {
+	mu          sync.Mutex
+	now         time.Time
+	timers      []*fakeTimer
+	autoAdvance bool
+}
+
+type fakeTimer struct {
+	at      time.Time
+	ch      chan time.Time
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	c.mu.Lock()
+	t := &fakeTimer{at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	auto := c.autoAdvance
+	c.mu.Unlock()
+	if auto && d > 0 {
+		c.Advance(d)
+	} else if d <= 0 {
+		c.Advance(0)
+	}
+	return t.ch, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		was := !t.stopped
+		t.stopped = true
+		return was
+	}
+}
+
+// Advance moves the clock on by d, firing the timers which are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		switch {
+		case t.stopped:
+		case !t.at.After(c.now):
+			t.stopped = true
+			t.ch <- c.now
+		default:
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+}
+
+// Timers returns the number of timers which haven't fired or been stopped
+func (c *fakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if !t.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+// useClock gives c a fake clock
+func useClock(c *Client) *fakeClock {
+	clk := newFakeClock()
+	withClock(clk)(c.Config().(*Config))
+	return clk
+}
+
+func TestRealClock(t *testing.T) {
+	start := time.Now()
+	assert.NoError(t, sleepClock(context.Background(), realClock{}, 10*time.Millisecond))
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, sleepClock(ctx, realClock{}, time.Hour))
+	assert.Equal(t, realClock{}, New(testAppID).(*Client).clock())
+}
+
+func TestFakeClockRetryDelay(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(503, "unavailable"), taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(time.Hour))
+	clk := useClock(c)
+	start := clk.Now()
+
+	// The retry waits for the clock rather than sleeping
+	done := make(chan error)
+	go func() {
+		_, err := c.GetSalt(testHashBytes, 0)
+		done <- err
+	}()
+	assert.Eventually(t, func() bool { return clk.Timers() == 1 }, time.Second, time.Millisecond)
+	clk.Advance(59 * time.Minute)
+	select {
+	case err := <-done:
+		t.Fatalf("retried before the delay: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	clk.Advance(time.Minute)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+	assert.Equal(t, time.Hour, clk.Now().Sub(start))
+}
+
+func TestFakeClockStats(t *testing.T) {
+	c := New(testAppID, WithStatsEnabled()).(*Client)
+	clk := useClock(c)
+	c.Stats().AddSuccess("foo.com", time.Millisecond)
+	c.Stats().AddError("foo.com", http.StatusServiceUnavailable, 0)
+	clk.Advance(time.Hour)
+	c.Stats().AddTimeout("foo.com", 0)
+
+	hs := c.Stats().Get("foo.com")
+	assert.Equal(t, 1, hs.Last(time.Minute).Timeouts())
+	assert.Equal(t, 0, hs.Last(time.Minute).Requests())
+	assert.Equal(t, 1, hs.Last(2*time.Hour).Requests())
+	assert.Equal(t, float64(1)/60, hs.Rate(time.Minute))
+	assert.Equal(t, clk.Now(), c.Stats().Snapshot().Time)
+
+	// Hosts added later get the clock too
+	c.Stats().AddSuccess("bar.com", time.Millisecond)
+	clk.Advance(time.Hour)
+	assert.Equal(t, 0, c.Stats().Get("bar.com").Last(time.Minute).Requests())
+}
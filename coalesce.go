@@ -0,0 +1,100 @@
+package taplink
+
+import (
+	"context"
+	"encoding/hex"
+	"strconv"
+	"sync"
+)
+
+// saltCall is a salt request which callers are waiting on
+type saltCall struct {
+	done chan struct{}
+	salt *Salt
+	err  error
+
+	// callers is the number of callers, including the one making the
+	// request, which haven't yet got their copy of salt. It's guarded by the
+	// group's mu, and the last of them wipes salt.
+	callers int
+}
+
+// saltGroup coalesces concurrent salt requests for the same hash and
+// version into a single request, like golang.org/x/sync/singleflight.
+type saltGroup struct {
+	calls map[string]*saltCall
+	mu    sync.Mutex
+}
+
+func newSaltGroup() *saltGroup {
+	return &saltGroup{calls: make(map[string]*saltCall)}
+}
+
+func saltGroupKey(hash []byte, versionID int64) string {
+	return hex.EncodeToString(hash) + "/" + strconv.FormatInt(versionID, 10)
+}
+
+// do calls fn for key, unless a call for key is already in flight, in which
+// case it waits for that call's result instead, or for ctx to be done. Each
+// caller gets its own copy of the salt, and the shared one is wiped once they
+// all have.
+func (g *saltGroup) do(ctx context.Context, key string, fn func() (*Salt, error)) (*Salt, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		call.callers++
+		g.mu.Unlock()
+		defer g.release(call)
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if call.salt == nil {
+			return nil, call.err
+		}
+		return copySalt(call.salt), call.err
+	}
+	call := &saltCall{done: make(chan struct{}), callers: 1}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	s, err := fn()
+	if s != nil {
+		call.salt = copySalt(s)
+	}
+	call.err = err
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+	g.release(call)
+	return s, err
+}
+
+// release is called by each caller of call once it's done with it, and
+// wipes the shared salt after the last one
+func (g *saltGroup) release(call *saltCall) {
+	g.mu.Lock()
+	call.callers--
+	last := call.callers == 0
+	g.mu.Unlock()
+	if last && call.salt != nil {
+		call.salt.Wipe()
+	}
+}
+
+// SetCoalescing sets whether concurrent GetSalt calls for the same hash and
+// version share a single request to the API, which is enabled by default.
+// Every caller gets the same result, or the same error. As the shared request
+// is made with the first caller's context, cancelling it fails the request for
+// the callers waiting on it too.
+func (c *Client) SetCoalescing(enabled bool) {
+	c.Lock()
+	if enabled {
+		c.flights = newSaltGroup()
+	} else {
+		c.flights = nil
+	}
+	c.Unlock()
+}
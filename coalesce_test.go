@@ -0,0 +1,145 @@
+package taplink
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+// getSalts gets the test salt from n goroutines at once, and returns the
+// results
+func getSalts(c *Client, n int) ([]*Salt, []error) {
+	salts, errs := make([]*Salt, n), make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			salts[i], errs[i] = c.GetSalt(testHashBytes, 0)
+		}(i)
+	}
+	wg.Wait()
+	return salts, errs
+}
+
+func TestCoalescing(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.RespondAfter(50*time.Millisecond, 200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID).(*Client)
+
+	salts, errs := getSalts(c, 10)
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+	for i := range salts {
+		assert.NoError(t, errs[i])
+		assert.Equal(t, testHashExpectedSaltBytes, salts[i].Salt)
+	}
+
+	// Each caller has its own copy.
+	salts[0].Salt[0]++
+	assert.Equal(t, testHashExpectedSaltBytes, salts[1].Salt)
+
+	c.SetCoalescing(false)
+	getSalts(c, 10)
+	assert.Equal(t, 11, st.Attempts(DefaultHost))
+}
+
+func TestCoalescingSharesErrors(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.RespondAfter(50*time.Millisecond, 400, "bad request"))
+	c := New(testAppID).(*Client)
+
+	_, errs := getSalts(c, 10)
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+	for _, err := range errs {
+		assert.True(t, IsClientError(err))
+	}
+}
+
+func TestCoalescingDifferentKeys(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.RespondAfter(20*time.Millisecond, 200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID).(*Client)
+
+	var wg sync.WaitGroup
+	for _, v := range []int64{0, 3} {
+		wg.Add(1)
+		go func(v int64) {
+			defer wg.Done()
+			_, err := c.GetSalt(testHashBytes, v)
+			assert.NoError(t, err)
+		}(v)
+	}
+	wg.Wait()
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+}
+
+func TestCoalescingWaiterContext(t *testing.T) {
+	g := newSaltGroup()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go g.do(context.Background(), "key", func() (*Salt, error) {
+		close(started)
+		<-release
+		return &Salt{}, nil
+	})
+	<-started
+
+	// A waiter whose context is done stops waiting, without affecting the call.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := g.do(ctx, "key", func() (*Salt, error) {
+		t.Error("call shouldn't be made")
+		return nil, nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	close(release)
+}
+
+func TestCoalescingWipesSharedSalt(t *testing.T) {
+	g := newSaltGroup()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	salts := make([]*Salt, 3)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		salts[0], _ = g.do(context.Background(), "key", func() (*Salt, error) {
+			close(started)
+			<-release
+			return &Salt{Salt: append([]byte(nil), testHashExpectedSaltBytes...)}, nil
+		})
+	}()
+	<-started
+	g.mu.Lock()
+	call := g.calls["key"]
+	g.mu.Unlock()
+	for i := 1; i < len(salts); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			salts[i], _ = g.do(context.Background(), "key", nil)
+		}(i)
+	}
+	assert.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return call.callers == len(salts)
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// Every caller has the salt, and the shared copy is zeroed
+	for _, s := range salts {
+		assert.Equal(t, testHashExpectedSaltBytes, s.Salt)
+	}
+	assert.Equal(t, make([]byte, len(testHashExpectedSaltBytes)), call.salt.Salt)
+	assert.Equal(t, 0, call.callers)
+}
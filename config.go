@@ -1,9 +1,17 @@
 package taplink
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,7 +21,16 @@ var (
 
 	userAgent = fmt.Sprintf("TapLink/1.0 Go/%s", goVersion)
 
-	// DefaultHost is the default API host
+	// HostSelectionMethod is the host selection method used by configs which
+	// don't set their own with SetHostSelection
+	HostSelectionMethod = HostSelectPrimary
+
+	// DefaultHost is the default API host. Like the servers in the loaded
+	// config, it can include a scheme and port such as "http://localhost:8443",
+	// and https is used if it has no scheme.
+	//
+	// Deprecated: changing DefaultHost affects every client, including ones
+	// already in use. See StrictGlobals.
 	DefaultHost = "api.taplink.co"
 )
 
@@ -21,18 +38,131 @@ var (
 type Configuration interface {
 	AppID() string
 	Host(attempts int) string
+	RetryHost(start, attempts int, failed string) string
+	HostStart() int
+	HostSelection() int
+	SetHostSelection(method int)
+	HostSelector() HostSelector
+	SetHostSelector(s HostSelector)
 	Headers() map[string]string
 	LastModified() time.Time
 	Servers() []string
+	SetServers(servers []string) error
+	ServerEntries() []ServerEntry
+	SetServerEntries(entries []ServerEntry) error
 	Load() error
+	LoadContext(ctx context.Context) error
+	LoadResult() (*LoadInfo, error)
+	LoadResultContext(ctx context.Context) (*LoadInfo, error)
+	LoadFromSRV(name string) error
+	LoadFromSRVContext(ctx context.Context, name string) error
+	Resolver() SRVResolver
+	SetResolver(r SRVResolver)
+	SaveTo(w io.Writer) error
+	LoadFrom(r io.Reader) error
+	MaxConfigAge() time.Duration
+	SetMaxConfigAge(d time.Duration)
+	AutoReload(interval time.Duration) error
+	StopAutoReload()
+	OnReload(fn func(info *LoadInfo))
+	OnReloadError(fn func(err error))
+	EnableHealthChecks(interval time.Duration, hosts ...string) error
+	StopHealthChecks()
+
+	MinimumVersion() int64
+	SetMinimumVersion(v int64) error
+	DefaultVersion() int64
+	SetDefaultVersion(v int64)
+	OnVersionRejected(fn func(versionID, minimum int64))
+	OnRequestComplete(fn func(RequestTrace))
+	RequestObserver() func(RequestTrace)
+	AddObserver(o RequestObserver)
+	Observers() []RequestObserver
+	RejectVersion(versionID int64) error
+
+	ExpectedContentType() string
+	SetExpectedContentType(mediaType string)
+	SaltEncoding() SaltEncoding
+	SetSaltEncoding(enc SaltEncoding)
+	StrictDecoding() bool
+	SetStrictDecoding(strict bool)
+
+	Backoff() Backoff
+	SetBackoff(b Backoff)
+	RetryLimit() int
+	SetRetryPolicy(limit int, delay time.Duration)
+	RetryPolicy() RetryPolicy
+	UseRetryPolicy(p RetryPolicy)
+	MaxResponseSize() int64
+	SetMaxResponseSize(n int64)
+	MaxRetryAfter() time.Duration
+	RequestTimeout() time.Duration
+	SetRequestTimeout(d time.Duration)
+	OperationTimeout() time.Duration
+	SetOperationTimeout(d time.Duration)
+	MaxElapsed() time.Duration
+	SetMaxElapsed(d time.Duration)
+	SetMaxRetryAfter(d time.Duration)
+	HedgeDelay() time.Duration
+	EnableHedging(delay time.Duration)
+	DisableHedging()
+	LatestVersionTTL() time.Duration
+	SetLatestVersionTTL(ttl time.Duration)
+
+	Logger() *slog.Logger
+	SetLogger(l *slog.Logger)
+
+	SharedRateLimiter() RateLimiter
+	SetSharedRateLimiter(l RateLimiter)
+	RateLimit() (rps float64, burst int)
+	SetRateLimit(rps float64, burst int)
+	RateLimitWaitTimeout() time.Duration
+	SetRateLimitWaitTimeout(d time.Duration)
+	RetryBudget() (ratio float64, minRetries int, window time.Duration)
+	SetRetryBudget(ratio float64, minRetries int, window time.Duration)
+
+	MinTLSVersion() uint16
+	SetMinTLSVersion(v uint16) error
+	RootCAs() *x509.CertPool
+	SetRootCAs(pool *x509.CertPool) error
+	PinnedSPKIHashes() []string
+	SetPinnedSPKIHashes(pins []string) error
+	TransportOptions() TransportOptions
+	SetTransportOptions(o TransportOptions) error
+	TransportStats() bool
+	SetTransportStats(enabled bool)
+
+	ActiveServers() []string
+	EnableAutoPrune(threshold float64, minSamples int, window, cooldown time.Duration)
+	DisableAutoPrune()
+	OnPruneChange(fn func(host string, pruned bool))
+
+	EnableCircuitBreaker(failures int, window, cooldown time.Duration)
+	DisableCircuitBreaker()
+
+	EnableEWMAScoring(halfLife time.Duration)
+	DisableEWMAScoring()
 
 	Stats() Statistics
 }
 
-// Options is the options API response
+// RateLimiter limits the rate of requests to the API. It's satisfied by
+// *rate.Limiter from golang.org/x/time/rate, so a single limiter can be shared
+// by multiple clients.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// Options is the options API response. Its servers are either a list of
+// hosts or a list of ServerEntry objects, see ServerEntries.
 type Options struct {
-	LastModified int64    `json:"lastModified"`
-	Servers      []string `json:"servers"`
+	LastModified int64 `json:"lastModified"`
+	// Servers are host names with an optional port, or URLs with an http or
+	// https scheme and no path such as "http://localhost:8443"
+	Servers []string `json:"servers"`
+	// Entries are the servers with their priorities and weights, in the same
+	// order as Servers, if the configuration gave them
+	Entries []ServerEntry `json:"-"`
 }
 
 // Config defines basic configuration for connecting to the API
@@ -42,32 +172,299 @@ type Config struct {
 	options   *Options
 	timeout   time.Duration
 	keepAlive time.Duration
-	client    API
+	// httpClient is the HTTP client given with WithHTTPClient, if any
+	httpClient *http.Client
+	// proxy is the proxy given with WithProxy or WithProxyFunc, if any
+	proxy func(*http.Request) (*url.URL, error)
+	// minTLSVersion, rootCAs and pins are the TLS settings of the transport
+	minTLSVersion uint16
+	rootCAs       *x509.CertPool
+	pins          []string
+	// transportOpts are the options given with WithTransportOptions or
+	// SetTransportOptions, if any
+	transportOpts *TransportOptions
+	// transportStats is whether the transport records the requests, see
+	// SetTransportStats
+	transportStats bool
+	// transport is the HTTP client with a copy of the transport the proxy
+	// and TLS settings are set on, if there are any
+	transport atomic.Pointer[http.Client]
+	// optionErrs are the invalid options given to New, and statsEnabled
+	// whether WithStatsEnabled was
+	optionErrs   []error
+	statsEnabled bool
+	// clientOpts are the options given to New which set up the client
+	// rather than its config, applied once the client is created
+	clientOpts []func(*Client)
+	// client is the client the config belongs to, if any
+	client *Client
+	// loading is held while the configuration is loaded
+	loading sync.Mutex
+
+	minVersion      int64
+	defaultVersion  int64
+	versionRejected func(versionID, minimum int64)
+	requestComplete func(RequestTrace)
+	observers       []RequestObserver
+
+	limiter RateLimiter
+	// bucket is the client's own rate limiter, and bucketWait how long
+	// requests wait for it
+	bucket     *tokenBucket
+	bucketWait time.Duration
+	// budget is the retry budget, if there's one
+	budget *retryBudget
+	// clock is the clock of the client and its stats, or nil for the real
+	// one. It's only set when the config is created.
+	clock clock
+
+	backoff Backoff
+	logger  *slog.Logger
+
+	retryPolicy RetryPolicy
+
+	retryLimit      int
+	maxResponseSize int64
+
+	maxRetryAfter    time.Duration
+	maxRetryAfterSet bool
+
+	hedgeDelay time.Duration
+
+	latestTTL time.Duration
+
+	requestTimeout   time.Duration
+	operationTimeout time.Duration
+	maxElapsed       time.Duration
+
+	contentType    string
+	saltEncoding   SaltEncoding
+	strictDecoding bool
+
+	selection    int
+	selectionSet bool
+	roundRobin   uint32
+	selector     HostSelector
 
-	stats *statistics
+	prune        *autoPrune
+	pruneChanged func(host string, pruned bool)
+
+	reload      *autoReload
+	reloadDone  func(info *LoadInfo)
+	reloadError func(err error)
+
+	// srvName is the SRV record the servers were loaded from, if any
+	srvName  string
+	resolver SRVResolver
+
+	// loadedAt is when the options were loaded or set, and maxConfigAge the
+	// oldest saved configuration LoadFrom accepts
+	loadedAt     time.Time
+	maxConfigAge time.Duration
+
+	health *healthChecks
+
+	// offline is the secret salts are made with in offline mode, if it's on
+	offline []byte
+
+	globals *globals
+
+	stats Statistics
 
 	sync.RWMutex
 }
 
+// newConfig returns a Config for appID with the default headers and the
+// built-in stats, and applies opts to it
+func newConfig(appID string, opts ...Option) *Config {
+	g := snapshotGlobals()
+	c := &Config{
+		appID:           appID,
+		stats:           newStatistics(),
+		globals:         g,
+		retryLimit:      g.retryLimit(),
+		maxResponseSize: DefaultMaxResponseSize,
+		latestTTL:       DefaultLatestVersionTTL,
+		maxConfigAge:    DefaultMaxConfigAge,
+		contentType:     "application/json",
+		headers: map[string]string{
+			"User-Agent": userAgent,
+			"Accept":     "application/json",
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.proxy != nil {
+		if err := c.applyTransport(); err != nil {
+			c.proxy = nil
+			c.invalidOption("WithProxy", err)
+		}
+	}
+	if c.transportOpts != nil {
+		if err := c.applyTransport(); err != nil {
+			c.transportOpts = nil
+			c.invalidOption("WithTransportOptions", err)
+		}
+	}
+	if c.options != nil {
+		c.stats.SetServers(c.options.Servers)
+	}
+	if c.statsEnabled {
+		c.stats.Enable()
+	}
+	return c
+}
+
+// LoadInfo describes the result of loading the configuration
+type LoadInfo struct {
+	// Servers is the number of servers in the loaded configuration
+	Servers int
+	// Added and Removed are the servers which differ from the previous list
+	Added   []string
+	Removed []string
+	// LastModified is when the TapLink configuration was last modified
+	LastModified time.Time
+	// NotModified is true if the API reported the configuration unchanged
+	NotModified bool
+	// Duration is how long the request to the API took
+	Duration time.Duration
+}
+
 // Load gets the configuration options from the API for the given app ID.
 func (c *Config) Load() error {
+	_, err := c.LoadResultContext(context.Background())
+	return err
+}
+
+// LoadContext is like Load, but the request to the API is made with ctx.
+func (c *Config) LoadContext(ctx context.Context) error {
+	_, err := c.LoadResultContext(ctx)
+	return err
+}
+
+// LoadResult is like Load, but also reports what was loaded.
+func (c *Config) LoadResult() (*LoadInfo, error) {
+	return c.LoadResultContext(context.Background())
+}
+
+// LoadResultContext is like LoadResult, but the request to the API is made
+// with ctx. If a configuration has already been loaded, the API is asked
+// whether it has been modified since, and if not it's kept as is.
+//
+// The request is made to DefaultHost like other requests are made: with the
+// configured headers, recorded in the stats, and retried, on the loaded
+// servers if there are any. A response with an error status, e.g. for an
+// unknown app ID, fails with an error wrapping the *APIError. Loads are made
+// one at a time, and aren't waited for by the client's Shutdown.
+func (c *Config) LoadResultContext(ctx context.Context) (*LoadInfo, error) {
+	c.loading.Lock()
+	defer c.loading.Unlock()
+	c.Lock()
 	if c.options == nil {
-		// c.Lock()
 		c.options = &Options{Servers: make([]string, 0)}
-		// c.Unlock()
 	}
-	resp, err := HTTPClient.Get(fmt.Sprintf("https://%s/%s", DefaultHost, c.appID))
-	if err != nil || resp.StatusCode != 200 {
-		return fmt.Errorf("Could not get configuration: %v", err)
+	prev := *c.options
+	c.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	// c.Lock()
-	// defer c.Unlock()
-	if err := json.NewDecoder(resp.Body).Decode(c.options); err != nil {
-		return err
+	if err := c.globals.check(c.Logger()); err != nil {
+		return nil, err
+	}
+	r := &apiRequest{segments: []string{c.appID}, firstHost: c.globals.defaultHost(), untracked: true}
+	if prev.LastModified > 0 {
+		r.header = http.Header{"If-Modified-Since": {time.Unix(prev.LastModified, 0).UTC().Format(http.TimeFormat)}}
+	}
+	t := time.Now()
+	resp, err := c.requester().send(ctx, r)
+	if err != nil {
+		return nil, loadError(err)
+	}
+	info := &LoadInfo{Duration: time.Since(t)}
+	if resp.StatusCode == http.StatusNotModified {
+		c.Lock()
+		c.loadedAt = time.Now()
+		c.Unlock()
+		info.NotModified = true
+		info.Servers = len(prev.Servers)
+		info.LastModified = time.Unix(prev.LastModified, 0)
+		return info, nil
+	}
+	opts := &Options{}
+	if err := decodeJSON(resp.Body, opts, c.StrictDecoding()); err != nil {
+		return nil, err
+	}
+	if opts.Servers == nil {
+		opts.Servers = make([]string, 0)
+	}
+	for _, host := range opts.Servers {
+		if !validRemoteHost(host, c.globals.defaultHost()) {
+			return nil, fmt.Errorf("Could not get configuration: %w: %q", ErrInvalidHost, host)
+		}
+	}
+	c.Lock()
+	c.options = opts
+	c.loadedAt = time.Now()
+	c.srvName = ""
+	client := c.client
+	c.Unlock()
+	// The data pool may have changed along with the configuration.
+	if client != nil {
+		client.latest.reset()
 	}
 	// Init stats for each server.
-	c.Stats().SetServers(c.options.Servers)
-	return nil
+	c.Stats().SetServers(opts.Servers)
+
+	info.Servers = len(opts.Servers)
+	info.Added = diffHosts(opts.Servers, prev.Servers)
+	info.Removed = diffHosts(prev.Servers, opts.Servers)
+	info.LastModified = time.Unix(opts.LastModified, 0)
+	return info, nil
+}
+
+// loadError adds context to an error from a failed load. Errors from before
+// the request was made, like a cancelled context, are returned as they are.
+func loadError(err error) error {
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr):
+		return fmt.Errorf("Could not get configuration: %d %s: %w", apiErr.StatusCode, http.StatusText(apiErr.StatusCode), err)
+	case errors.Is(err, ErrRetriesExhausted):
+		return fmt.Errorf("Could not get configuration: %w", err)
+	}
+	return err
+}
+
+// requester returns the client the config belongs to, which requests for
+// the configuration are made with. A Config made on its own gets a client of
+// its own.
+func (c *Config) requester() *Client {
+	c.RLock()
+	client := c.client
+	c.RUnlock()
+	if client == nil {
+		client = &Client{cfg: c, globals: c.globals, lc: newLifecycle()}
+	}
+	return client
+}
+
+// diffHosts returns the hosts in a which aren't in b
+func diffHosts(a, b []string) []string {
+	var diff []string
+	for _, host := range a {
+		found := false
+		for _, h := range b {
+			if h == host {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, host)
+		}
+	}
+	return diff
 }
 
 // AppID returns the app ID
@@ -77,11 +474,6 @@ func (c *Config) AppID() string {
 
 // Stats returns a statistics interface for enabling/disabling/managing statistics.
 func (c *Config) Stats() Statistics {
-	c.Lock()
-	defer c.Unlock()
-	if c.stats == nil {
-		c.stats = newStatistics()
-	}
 	return c.stats
 }
 
@@ -90,19 +482,116 @@ type hostStats struct {
 	latency time.Duration
 }
 
-// Host returns the API server to connect to based on the available servers
-// and the host selection algorithm
+// Host returns the API server to connect to for the given attempt of a
+// request which starts at the first active server, as RetryHost(0, attempts, "").
 func (c *Config) Host(attempts int) string {
+	return c.RetryHost(0, attempts, "")
+}
 
-	hosts := c.Servers()
+// RetryHost returns the API server to connect to for the given attempt of a
+// request which started at offset start into the active servers, from
+// HostStart, and whose previous attempt failed on failed ("" if none).
+//
+// The first attempt is made to the server at start. Retries go through the
+// active servers in the order ranked by Stats().Hosts(), leaving out failed
+// unless it's the only one, so that a retry doesn't land back on the server
+// which just failed. While stats are disabled, or there are none for the
+// last minute, retries move on to the next of the active servers instead.
+// Hosts whose circuit breaker is open are skipped either way.
+//
+// If a HostSelector is set, it picks the server instead, and otherwise if the
+// servers have priorities and weights, see ServerEntries, they decide.
+func (c *Config) RetryHost(start, attempts int, failed string) string {
+	hosts := c.ActiveServers()
 	if len(hosts) == 0 {
-		return DefaultHost
+		return c.globals.defaultHost()
+	}
+	if s := c.HostSelector(); s != nil {
+		return selectHost(s, c.Stats(), hosts, attempts, failed)
+	}
+	if entries := c.weightedEntries(); entries != nil {
+		return weightedHost(c.Stats(), entries, hosts, attempts, failed)
+	}
+	if attempts == 0 {
+		return pickHost(c.Stats(), hosts, start)
+	}
+	ranked := rankedHosts(c.Stats(), hosts)
+	if ranked == nil {
+		return pickHost(c.Stats(), hosts, start+attempts)
+	}
+	return pickHost(c.Stats(), withoutHost(ranked, failed), attempts-1)
+}
+
+// rankedHosts returns hosts in the order of stats.Hosts(), or nil if stats
+// are disabled or have no events for any of hosts in the last minute
+func rankedHosts(stats Statistics, hosts []string) []string {
+	if s, ok := stats.(*statistics); ok && !s.isEnabled() {
+		return nil
+	}
+	active := make(map[string]bool, len(hosts))
+	recent := false
+	for _, h := range hosts {
+		active[h] = true
+		if !recent {
+			m := stats.Get(h).Last(time.Minute)
+			recent = m.Requests()+m.Errors().Len()+m.Timeouts()+m.Probes().Healthy+m.Probes().Unhealthy > 0
+		}
+	}
+	if !recent {
+		return nil
+	}
+	ranked := make([]string, 0, len(hosts))
+	for _, h := range stats.Hosts() {
+		if active[h] {
+			ranked = append(ranked, h)
+			delete(active, h)
+		}
+	}
+	// Hosts the stats don't know of yet go last, in their configured order
+	for _, h := range hosts {
+		if active[h] {
+			ranked = append(ranked, h)
+		}
+	}
+	return ranked
+}
+
+// HostStart returns the offset into the active servers of the server a new
+// request should try first, according to the host selection method.
+func (c *Config) HostStart() int {
+	n := len(c.ActiveServers())
+	if n < 2 {
+		return 0
+	}
+	switch c.HostSelection() {
+	case HostSelectRandom:
+		return rand.Intn(n)
+	case HostSelectRoundRobin:
+		return int((atomic.AddUint32(&c.roundRobin, 1) - 1) % uint32(n))
+	default:
+		return 0
 	}
-	// For the first attempt, return the first (primary) host
-	if len(hosts) == 1 {
-		return hosts[0]
+}
+
+// HostSelection returns the host selection method of the config, which is
+// HostSelectionMethod unless one has been set with SetHostSelection
+func (c *Config) HostSelection() int {
+	c.RLock()
+	defer c.RUnlock()
+	if !c.selectionSet {
+		return HostSelectionMethod
 	}
-	return hosts[attempts%len(hosts)]
+	return c.selection
+}
+
+// SetHostSelection sets the host selection method for the config, one of
+// HostSelectPrimary, HostSelectRandom or HostSelectRoundRobin. Retries move on
+// to the next server whichever method is used.
+func (c *Config) SetHostSelection(method int) {
+	c.Lock()
+	c.selection = method
+	c.selectionSet = true
+	c.Unlock()
 }
 
 // Headers returns the headers to be added to each request
@@ -127,10 +616,238 @@ func (c *Config) LastModified() time.Time {
 
 // Servers returns the API servers available to connect to
 func (c *Config) Servers() []string {
+	return append([]string{}, c.servers()...)
+}
+
+// servers returns the server list without copying it. The list is replaced
+// rather than changed, so it can be read without holding the lock, but it
+// must not be modified.
+func (c *Config) servers() []string {
 	c.RLock()
 	defer c.RUnlock()
 	if c.options == nil {
-		return []string{}
+		return nil
 	}
 	return c.options.Servers
 }
+
+// SetServers replaces the server list with a copy of servers, e.g. to use a
+// fixed list of servers rather than loading it. Stats are initialized for
+// each server, as with Load. If any of the servers isn't a valid host, the
+// list is left as it was and ErrInvalidHost is returned.
+//
+// The next Load gets the whole configuration from the API, replacing the
+// list, and AutoReload loads from the API even if LoadFromSRV was used.
+func (c *Config) SetServers(servers []string) error {
+	for _, host := range servers {
+		if !validHost(host) {
+			return fmt.Errorf("%w: %q", ErrInvalidHost, host)
+		}
+	}
+	servers = append(make([]string, 0, len(servers)), servers...)
+	c.Lock()
+	c.options = &Options{Servers: servers}
+	c.loadedAt = time.Now()
+	c.srvName = ""
+	c.Unlock()
+	c.Stats().SetServers(servers)
+	return nil
+}
+
+// MinimumVersion returns the lowest data pool version the client will accept
+func (c *Config) MinimumVersion() int64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.minVersion
+}
+
+// SetMinimumVersion sets the lowest data pool version the client will accept.
+// Requests for an older version, or responses from the API using an older
+// version, are rejected with ErrVersionBelowMinimum. A value of 0 disables
+// the check, and a negative one is rejected with ErrInvalidVersion.
+func (c *Config) SetMinimumVersion(v int64) error {
+	if err := checkMinimumVersion(v); err != nil {
+		return err
+	}
+	c.Lock()
+	c.minVersion = v
+	c.Unlock()
+	return nil
+}
+
+// DefaultVersion returns the data pool version used by NewPassword, and by
+// VerifyPassword for a versionID of 0, or 0 for the latest
+func (c *Config) DefaultVersion() int64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.defaultVersion
+}
+
+// SetDefaultVersion pins the data pool version NewPassword hashes with, and
+// VerifyPassword uses for a versionID of 0, e.g. to lag a version behind
+// during a migration. The API still offers the newer version when there is
+// one, as NewPassword's NewVersionID and VerifyPassword's NewHash. A value of
+// 0 or less restores the latest version.
+func (c *Config) SetDefaultVersion(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	c.Lock()
+	c.defaultVersion = v
+	c.Unlock()
+}
+
+// OnVersionRejected sets a func which is called each time a version is
+// rejected for being below the minimum version. This makes it possible to
+// find callers which are still using stale version IDs.
+func (c *Config) OnVersionRejected(fn func(versionID, minimum int64)) {
+	c.Lock()
+	c.versionRejected = fn
+	c.Unlock()
+}
+
+// RejectVersion returns ErrVersionBelowMinimum if versionID is older than the
+// minimum version, and notifies the OnVersionRejected func if it's set.
+// Version 0 means "latest" so it is never rejected.
+func (c *Config) RejectVersion(versionID int64) error {
+	c.RLock()
+	min, fn := c.minVersion, c.versionRejected
+	c.RUnlock()
+	if versionID == 0 || versionID >= min {
+		return nil
+	}
+	if fn != nil {
+		fn(versionID, min)
+	}
+	return ErrVersionBelowMinimum
+}
+
+// ExpectedContentType returns the media type successful responses from the
+// API must have
+func (c *Config) ExpectedContentType() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.contentType
+}
+
+// SetExpectedContentType sets the media type successful responses from the
+// API must have, which is "application/json" by default. Parameters such as
+// charset are ignored when comparing. Responses with another content type are
+// retried against another host, and fail with ErrUnexpectedContentType.
+// An empty mediaType accepts any content type.
+func (c *Config) SetExpectedContentType(mediaType string) {
+	c.Lock()
+	c.contentType = mediaType
+	c.Unlock()
+}
+
+// Backoff returns the Backoff deciding how long to wait between attempts
+func (c *Config) Backoff() Backoff {
+	c.RLock()
+	defer c.RUnlock()
+	if c.backoff == nil {
+		return defaultBackoff
+	}
+	return c.backoff
+}
+
+// RetryLimit returns how many attempts a request makes before failing
+func (c *Config) RetryLimit() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.retryLimit
+}
+
+// SetRetryPolicy sets how many attempts a request makes before failing, and
+// waits delay between them, as SetBackoff(ConstantBackoff(delay)). The limit
+// is seeded from RetryLimit when the client is created, and a limit below 1
+// is 1, as every request makes at least one attempt.
+func (c *Config) SetRetryPolicy(limit int, delay time.Duration) {
+	if limit < 1 {
+		limit = 1
+	}
+	c.Lock()
+	c.retryLimit, c.backoff = limit, ConstantBackoff(delay)
+	c.Unlock()
+}
+
+// MaxResponseSize returns the largest response body which is read from the
+// API
+func (c *Config) MaxResponseSize() int64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.maxResponseSize
+}
+
+// SetMaxResponseSize sets the largest response body which is read from the
+// API, including the configuration loaded by Load. A successful response
+// which is larger fails with ErrResponseTooLarge, which names the host and
+// the limit, and isn't retried by DefaultRetryPolicy; an error response is
+// cut off. Responses are requested gzipped, and the size applies to the
+// decompressed body. A size of 0 or less restores DefaultMaxResponseSize.
+func (c *Config) SetMaxResponseSize(n int64) {
+	if n <= 0 {
+		n = DefaultMaxResponseSize
+	}
+	c.Lock()
+	c.maxResponseSize = n
+	c.Unlock()
+}
+
+// SetBackoff sets how long to wait between attempts. By default it's an
+// exponential backoff starting at 250ms and doubling up to 10s, with ±20%
+// jitter. A nil Backoff restores the default.
+func (c *Config) SetBackoff(b Backoff) {
+	c.Lock()
+	c.backoff = b
+	c.Unlock()
+}
+
+// Logger returns the logger requests are logged to, if any
+func (c *Config) Logger() *slog.Logger {
+	c.RLock()
+	defer c.RUnlock()
+	return c.logger
+}
+
+// SetLogger logs each attempt of a request to l at debug level, retries and
+// failed requests at warn level, and automatic reloads at info level. The
+// hash and AppID in the request path are logged as fingerprints, and salts
+// aren't logged. A nil l, the default, disables logging.
+func (c *Config) SetLogger(l *slog.Logger) {
+	c.Lock()
+	c.logger = l
+	c.Unlock()
+}
+
+// SharedRateLimiter returns the rate limiter requests reserve from, if any
+func (c *Config) SharedRateLimiter() RateLimiter {
+	c.RLock()
+	defer c.RUnlock()
+	return c.limiter
+}
+
+// SetSharedRateLimiter sets a rate limiter which every request to the API,
+// including loading the config and health check probes, waits on. The same
+// limiter can be given to multiple clients so that their combined requests
+// stay within the limit. The time spent waiting is recorded in stats as
+// queue wait, not latency.
+func (c *Config) SetSharedRateLimiter(l RateLimiter) {
+	c.Lock()
+	c.limiter = l
+	c.Unlock()
+}
+
+// waitRateLimit waits for the config's rate limiter, if any, and returns how
+// long it waited
+func waitRateLimit(ctx context.Context, c Configuration) (time.Duration, error) {
+	l := c.SharedRateLimiter()
+	if l == nil {
+		return 0, nil
+	}
+	t := time.Now()
+	if err := l.Wait(ctx); err != nil {
+		return 0, err
+	}
+	return time.Since(t), nil
+}
@@ -3,7 +3,9 @@
 package taplink
 
 import (
-	"golang.org/x/net/context"
+	"context"
+	"net/http"
+
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/urlfetch"
 )
@@ -18,9 +20,27 @@ var (
 	HTTPClient = urlfetch.New(appengine.BackgroundContext())
 )
 
+func init() {
+	ClientFromContext = appEngineClient
+}
+
+// appEngineClient returns an urlfetch client for ctx. The root contexts which
+// calls without a context are made with aren't App Engine contexts, so those
+// use the HTTPClient set by UseContext.
+func appEngineClient(ctx context.Context) *http.Client {
+	if ctx == context.Background() || ctx == context.TODO() {
+		return nil
+	}
+	return urlfetch.Client(ctx)
+}
+
 // UseContext updates the underlying HTTP client to an App Engine valid HTTP
 // client which uses the given context. The HTTPClient is the result of a
 // urlfetch.New() call.
+//
+// Deprecated: UseContext changes the client of every request, so concurrent
+// requests with different contexts interfere. Pass each request's context to
+// the Context methods, such as VerifyPasswordContext, instead.
 func UseContext(ctx context.Context) {
 	HTTPClient = urlfetch.New(ctx)
 }
@@ -3,7 +3,6 @@
 package taplink
 
 import (
-	"net"
 	"net/http"
 	"runtime"
 )
@@ -11,14 +10,14 @@ import (
 var (
 	goVersion = runtime.Version()
 
-	// HTTPClient defines the HTTP client used for HTTP connections
+	// HTTPClient defines the HTTP client used for HTTP connections. Its
+	// transport has the DefaultTransportOptions, and uses the proxy from the
+	// environment.
+	//
+	// Deprecated: changing HTTPClient affects every client, including ones
+	// already in use. See StrictGlobals.
 	HTTPClient = &http.Client{
-		Timeout: DefaultTimeout,
-		Transport: &http.Transport{
-			Dial: (&net.Dialer{
-				Timeout:   DefaultTimeout,
-				KeepAlive: DefaultKeepAlive,
-			}).Dial,
-		},
+		Timeout:   DefaultTimeout,
+		Transport: newTransport(DefaultTransportOptions),
 	}
 )
@@ -1,49 +1,145 @@
 package taplink
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/TapLink/taplink-go/taplinktest"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestLoad(t *testing.T) {
-	c := &Config{appID: testAppID}
+	f, restore := useFake()
+	defer restore()
+	f.Servers = []string{"foo.com", "bar.com"}
+	c := newConfig(testAppID)
 	assert.NoError(t, c.Load())
+	assert.Equal(t, []string{"foo.com", "bar.com"}, c.Servers())
 }
 
 func TestLoadInvalidApp(t *testing.T) {
-	c := &Config{appID: "foobar"}
+	_, restore := useFake()
+	defer restore()
+	c := newConfig("foobar")
 	assert.Error(t, c.Load())
 	assert.NotNil(t, c.options)
 }
 
 func TestLoadMalformatted(t *testing.T) {
-	HTTPClient.Transport = &testRoundTripper{200, 0, nil, []byte("foobar"), nil}
-	defer func() {
-		HTTPClient.Transport = origTransport
-	}()
-	c := &Config{appID: "foobar"}
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(200, "foobar"))
+	c := newConfig("foobar")
 	assert.Error(t, c.Load())
 }
 
+func TestLoadResult(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(
+		taplinktest.Respond(200, `{"lastModified":100,"servers":["foo.com","bar.com"]}`),
+		taplinktest.Respond(200, `{"lastModified":200,"servers":["bar.com","foobar.com"]}`),
+		taplinktest.Respond(304, ""),
+	)
+	c := newConfig("foobar")
+
+	info, err := c.LoadResult()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 2, info.Servers)
+	assert.Equal(t, []string{"foo.com", "bar.com"}, info.Added)
+	assert.Len(t, info.Removed, 0)
+	assert.Equal(t, time.Unix(100, 0), info.LastModified)
+	assert.False(t, info.NotModified)
+
+	info, err = c.LoadResult()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"foobar.com"}, info.Added)
+	assert.Equal(t, []string{"foo.com"}, info.Removed)
+	assert.Equal(t, time.Unix(200, 0), info.LastModified)
+
+	info, err = c.LoadResult()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, info.NotModified)
+	assert.Equal(t, 2, info.Servers)
+	assert.Len(t, info.Added, 0)
+	assert.Equal(t, []string{"bar.com", "foobar.com"}, c.Servers())
+}
+
+func TestLoadRejectsInvalidHosts(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(
+		taplinktest.Respond(200, `{"servers":["foo.com"]}`),
+		taplinktest.Respond(200, `{"servers":["bar.com","evil.com/#@api.taplink.co"]}`),
+	)
+	c := newConfig("foobar")
+	assert.NoError(t, c.Load())
+
+	// The whole response is rejected, leaving the previous servers in place.
+	err := c.Load()
+	assert.True(t, errors.Is(err, ErrInvalidHost))
+	assert.Equal(t, []string{"foo.com"}, c.Servers())
+}
+
+func TestLoadRejectsSchemeDowngrade(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(
+		taplinktest.Respond(200, `{"servers":["foo.com"]}`),
+		taplinktest.Respond(200, `{"servers":["bar.com","http://evil.com"]}`),
+		taplinktest.Respond(200, `{"servers":[{"host":"http://evil.com:8080","priority":1}]}`),
+		taplinktest.Respond(200, `{"servers":["HTTP://evil.com"]}`),
+	)
+	c := newConfig("foobar")
+	assert.NoError(t, c.Load())
+
+	// A payload can't move the requests, with the hash in their paths, off
+	// TLS, whether it gives hosts or entries
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, c.Load(), ErrInvalidHost)
+		assert.Equal(t, []string{"foo.com"}, c.Servers())
+	}
+
+	// Servers set locally can use http, as can those loaded through an http
+	// DefaultHost
+	assert.NoError(t, c.SetServers([]string{"http://localhost:8080"}))
+	assert.NoError(t, c.SetServerEntries([]ServerEntry{{Host: "http://localhost:8080"}}))
+	prevHost := DefaultHost
+	DefaultHost = "http://localhost:8443"
+	defer func() { DefaultHost = prevHost }()
+	st.Enqueue(taplinktest.Respond(200, `{"servers":["http://localhost:8444"]}`))
+	assert.NoError(t, c.Load())
+	assert.Equal(t, []string{"http://localhost:8444"}, c.Servers())
+}
+
 func TestCfgAppID(t *testing.T) {
-	c := &Config{appID: "foobar"}
+	c := newConfig("foobar")
 	assert.Equal(t, "foobar", c.AppID())
 }
 
 func TestCfgHost(t *testing.T) {
-	c := &Config{}
+	c := newConfig("")
 	assert.Equal(t, DefaultHost, c.Host(0))
 }
 
 func TestCfgHeaders(t *testing.T) {
-	c := &Config{}
+	c := newConfig("")
 	assert.NotNil(t, c.Headers())
 }
 
 func TestCfgLastModified(t *testing.T) {
-	c := &Config{}
+	c := newConfig("")
 	now := time.Now()
 	now = time.Unix(now.Unix(), 0)
 	assert.True(t, c.LastModified().IsZero())
@@ -52,21 +148,22 @@ func TestCfgLastModified(t *testing.T) {
 }
 
 func TestCfgServers(t *testing.T) {
-	c := &Config{}
+	c := newConfig("")
 	assert.Len(t, c.Servers(), 0)
 	c.options = &Options{Servers: []string{"foobar", "foobar2"}}
 	assert.Equal(t, c.options.Servers, c.Servers())
 }
 
 func TestClientCfg(t *testing.T) {
-	c := &Config{}
+	c := newConfig("")
 	client := HTTPClient
 	assert.NotNil(t, c, client)
 	assert.Equal(t, DefaultTimeout, client.Timeout)
 }
 
 func TestConfigHost(t *testing.T) {
-	c := &Config{options: &Options{Servers: []string{}}}
+	c := newConfig("")
+	c.options = &Options{Servers: []string{}}
 
 	// Test default host
 	assert.Equal(t, DefaultHost, c.Host(0))
@@ -82,3 +179,359 @@ func TestConfigHost(t *testing.T) {
 		assert.Equal(t, c.options.Servers[i%2], c.Host(i))
 	}
 }
+
+func TestConfigRetryHost(t *testing.T) {
+	c := newConfig("")
+	c.options = &Options{Servers: []string{"a.com", "b.com", "c.com"}}
+
+	// Without stats, retries move on from the start.
+	assert.Equal(t, "b.com", c.RetryHost(1, 0, ""))
+	assert.Equal(t, "c.com", c.RetryHost(1, 1, "b.com"))
+	assert.Equal(t, "a.com", c.RetryHost(1, 2, "c.com"))
+
+	// Enabled but empty stats are no better.
+	c.Stats().Enable()
+	assert.Equal(t, "c.com", c.RetryHost(1, 1, "b.com"))
+
+	// With stats, retries go by rank, skipping the host which just failed.
+	c.Stats().AddError("c.com", 503, 0)
+	c.Stats().AddSuccess("b.com", 2*time.Millisecond)
+	c.Stats().AddSuccess("a.com", time.Millisecond)
+	assert.Equal(t, "b.com", c.RetryHost(1, 0, ""))
+	assert.Equal(t, "a.com", c.RetryHost(1, 1, "b.com"))
+	assert.Equal(t, "c.com", c.RetryHost(1, 2, "b.com"))
+	assert.Equal(t, "b.com", c.RetryHost(1, 1, "a.com"))
+
+	// Unless it's the only one
+	c.options.Servers = []string{"c.com"}
+	assert.Equal(t, "c.com", c.RetryHost(0, 1, "c.com"))
+}
+
+func TestRetriesAvoidFailedHost(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"servers":["a.com","b.com","c.com"]}`))
+	st.EnqueueFor("a.com", taplinktest.Respond(503, "down"))
+	st.EnqueueFor("c.com", taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID).(*Client)
+	c.Config().SetRetryPolicy(3, 0)
+	c.Stats().Enable()
+	assert.NoError(t, c.Config().Load())
+	// b.com has been erroring, so the retry after a.com fails skips it.
+	c.Stats().AddError("b.com", 503, 0)
+	c.Stats().AddSuccess("c.com", time.Millisecond)
+
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{DefaultHost, "a.com", "c.com"}, st.Hosts())
+	assert.Equal(t, 1, c.Stats().Get("a.com").Errors().Count(503))
+	assert.Equal(t, 2, c.Stats().Get("c.com").Requests())
+}
+
+func TestCfgMinimumVersion(t *testing.T) {
+	c := newConfig("")
+	assert.NoError(t, c.RejectVersion(1))
+
+	var rejected []int64
+	c.OnVersionRejected(func(versionID, minimum int64) {
+		rejected = append(rejected, versionID, minimum)
+	})
+	assert.NoError(t, c.SetMinimumVersion(3))
+	assert.Equal(t, int64(3), c.MinimumVersion())
+	assert.ErrorIs(t, c.SetMinimumVersion(-1), ErrInvalidVersion)
+	assert.Equal(t, int64(3), c.MinimumVersion())
+	assert.NoError(t, c.RejectVersion(0))
+	assert.NoError(t, c.RejectVersion(3))
+	assert.NoError(t, c.RejectVersion(4))
+	assert.Equal(t, ErrVersionBelowMinimum, c.RejectVersion(2))
+	assert.Equal(t, []int64{2, 3}, rejected)
+}
+
+func TestHostSelection(t *testing.T) {
+	c := newConfig("")
+	c.options = &Options{Servers: []string{"a.com", "b.com", "c.com"}}
+	assert.Equal(t, HostSelectPrimary, c.HostSelection())
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 0, c.HostStart())
+	}
+
+	c.SetHostSelection(HostSelectRoundRobin)
+	var starts []int
+	for i := 0; i < 4; i++ {
+		starts = append(starts, c.HostStart())
+	}
+	assert.Equal(t, []int{0, 1, 2, 0}, starts)
+
+	c.SetHostSelection(HostSelectRandom)
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		counts[c.Host(c.HostStart())]++
+	}
+	assert.Len(t, counts, 3)
+	for host, n := range counts {
+		assert.True(t, n > 50, host)
+	}
+
+	// The package default only applies to configs which haven't set their own.
+	origMethod := HostSelectionMethod
+	HostSelectionMethod = HostSelectRoundRobin
+	defer func() {
+		HostSelectionMethod = origMethod
+	}()
+	assert.Equal(t, HostSelectRandom, c.HostSelection())
+	assert.Equal(t, HostSelectRoundRobin, newConfig("").HostSelection())
+}
+
+func TestHostSelectionRetries(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	cfg := c.Config().(*Config)
+	cfg.options = &Options{Servers: []string{"a.com", "b.com", "c.com"}}
+	cfg.SetHostSelection(HostSelectRoundRobin)
+
+	// Retries move on to the next host, and the next request starts at the
+	// host after the one the previous request started at.
+	st.Enqueue(taplinktest.Respond(503, "error"), taplinktest.Respond(503, "error"), taplinktest.Respond(200, "ok"))
+	_, err := c.getFromAPI("foobar")
+	assert.NoError(t, err)
+	st.Enqueue(taplinktest.Respond(503, "error"), taplinktest.Respond(200, "ok"))
+	_, err = c.getFromAPI("foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.com", "b.com", "c.com", "b.com", "c.com"}, st.Hosts())
+}
+
+func TestRetryPolicy(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := New(testAppID).(*Client)
+	assert.Equal(t, RetryLimit, c.Config().RetryLimit())
+
+	// The global only seeds new clients.
+	origLimit := RetryLimit
+	RetryLimit = 5
+	defer func() {
+		RetryLimit = origLimit
+	}()
+	assert.Equal(t, origLimit, c.Config().RetryLimit())
+	assert.Equal(t, 5, New(testAppID).Config().RetryLimit())
+
+	c.Config().SetRetryPolicy(2, 0)
+	assert.Equal(t, 2, c.Config().RetryLimit())
+	assert.Equal(t, ConstantBackoff(0), c.Config().Backoff())
+	st.SetDefault(taplinktest.Respond(503, "error"))
+	_, err := c.getFromAPI("foobar")
+	assert.Error(t, err)
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+
+	// A request makes at least one attempt
+	c.Config().SetRetryPolicy(0, 0)
+	assert.Equal(t, 1, c.Config().RetryLimit())
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.ErrorIs(t, err, ErrRetriesExhausted)
+	assert.Equal(t, 3, st.Attempts(DefaultHost))
+
+	// A global limit of 0 fails without any attempt rather than panicking
+	RetryLimit = 0
+	_, err = New(testAppID).GetSalt(testHashBytes, 0)
+	assert.ErrorIs(t, err, ErrRetriesExhausted)
+	assert.EqualError(t, err, "retries exhausted: retry limit 0 allows no attempts")
+	assert.Equal(t, 3, st.Attempts(DefaultHost))
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := New(testAppID).(*Client)
+	assert.Equal(t, DefaultMaxResponseSize, c.Config().MaxResponseSize())
+
+	// A body larger than the max isn't returned truncated
+	c.Config().SetRetryPolicy(1, 0)
+	st.Enqueue(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c.Config().SetMaxResponseSize(16)
+	_, err := c.getFromAPI("foobar")
+	assert.ErrorIs(t, err, ErrResponseTooLarge)
+
+	// A body of exactly the max is fine
+	st.Enqueue(taplinktest.Respond(200, `{"vid":12345678}`))
+	body, err := c.getFromAPI("foobar")
+	assert.NoError(t, err)
+	assert.Len(t, body.Body, 16)
+
+	c.Config().SetMaxResponseSize(0)
+	assert.Equal(t, DefaultMaxResponseSize, c.Config().MaxResponseSize())
+}
+
+func TestResponseTooLargeNotRetried(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	huge := `{"s2":"` + strings.Repeat("0", int(DefaultMaxResponseSize)) + `","vid":3}`
+	st.SetDefault(taplinktest.Respond(200, huge))
+	c := New(testAppID, WithStatsEnabled()).(*Client)
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.ErrorIs(t, err, ErrResponseTooLarge)
+	assert.EqualError(t, err, fmt.Sprintf("response too large: %s sent more than %d bytes", DefaultHost, DefaultMaxResponseSize))
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+	assert.Equal(t, Errors{ResponseTooLargeCode: 1}, c.Stats().Get(DefaultHost).Errors())
+
+	// The configuration is limited too
+	c.Config().SetMaxResponseSize(1024)
+	st.SetDefault(taplinktest.Respond(200, `{"servers":["`+strings.Repeat("a", 2048)+`.com"]}`))
+	err = c.Config().Load()
+	assert.ErrorIs(t, err, ErrResponseTooLarge)
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+}
+
+func TestLoadRetries(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.EnqueueFor(DefaultHost,
+		taplinktest.Respond(200, `{"servers":["foo.com"]}`),
+		taplinktest.Respond(503, "down"),
+	)
+	st.EnqueueFor("foo.com", taplinktest.Respond(200, `{"servers":["foo.com","bar.com"]}`))
+	c := New(testAppID).(*Client)
+	c.Stats().Enable()
+	c.Config().SetBackoff(ConstantBackoff(0))
+	assert.NoError(t, c.Config().Load())
+	assert.Equal(t, 1, c.Stats().Get(DefaultHost).Requests())
+
+	// The first attempt is made to DefaultHost, and the retry to a server
+	assert.NoError(t, c.Config().Load())
+	assert.Equal(t, []string{"foo.com", "bar.com"}, c.Config().Servers())
+	assert.Equal(t, Errors{503: 1}, c.Stats().Get(DefaultHost).Errors())
+	assert.Equal(t, 1, c.Stats().Get("foo.com").Requests())
+}
+
+func TestLoadRejectedAppID(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(401, "Invalid AppID"))
+	c := newConfig(testAppID)
+	err := c.Load()
+	assert.EqualError(t, err, "Could not get configuration: 401 Unauthorized: Invalid AppID")
+	var apiErr *APIError
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, 401, apiErr.StatusCode)
+		assert.Equal(t, []byte("Invalid AppID"), apiErr.Body)
+	}
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+}
+
+// headerTransport records the headers of each request made with it
+type headerTransport struct {
+	http.RoundTripper
+	headers []http.Header
+	mu      sync.Mutex
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.headers = append(t.headers, req.Header.Clone())
+	t.mu.Unlock()
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestLoadHeaders(t *testing.T) {
+	f := taplinktest.NewFake(3)
+	f.LastModified = 100
+	ht := &headerTransport{RoundTripper: f}
+	HTTPClient.Transport = ht
+	defer func() { HTTPClient.Transport = origTransport }()
+
+	c := newConfig(testAppID)
+	c.Headers()["X-Test"] = "yes"
+	assert.NoError(t, c.Load())
+	assert.NoError(t, c.Load())
+	if assert.Len(t, ht.headers, 2) {
+		assert.Equal(t, userAgent, ht.headers[0].Get("User-Agent"))
+		assert.Equal(t, "yes", ht.headers[0].Get("X-Test"))
+		assert.Equal(t, "", ht.headers[0].Get("If-Modified-Since"))
+		assert.Equal(t, time.Unix(100, 0).UTC().Format(http.TimeFormat), ht.headers[1].Get("If-Modified-Since"))
+		assert.Equal(t, userAgent, ht.headers[1].Get("User-Agent"))
+	}
+}
+
+func TestLoadConcurrent(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	f.Servers = []string{"foo.com", "bar.com"}
+	c := newConfig(testAppID)
+	var wg sync.WaitGroup
+	infos := make([]*LoadInfo, 5)
+	for i := range infos {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			info, err := c.LoadResult()
+			assert.NoError(t, err)
+			infos[i] = info
+		}(i)
+	}
+	wg.Wait()
+	// Loads are made one at a time, so only the first adds the servers
+	var added int
+	for _, info := range infos {
+		added += len(info.Added)
+	}
+	assert.Equal(t, 2, added)
+	assert.Equal(t, []string{"foo.com", "bar.com"}, c.Servers())
+}
+
+func TestSetServers(t *testing.T) {
+	c := New(testAppID).(*Client)
+	cfg := c.Config()
+	servers := []string{"foo.com", "bar.com"}
+	assert.NoError(t, cfg.SetServers(servers))
+	assert.Equal(t, []string{"foo.com", "bar.com"}, cfg.Servers())
+	assert.ElementsMatch(t, servers, c.Stats().Hosts())
+
+	// Neither the given slice nor the returned one are the config's own
+	servers[0] = "changed.com"
+	got := cfg.Servers()
+	got[1] = "changed.com"
+	assert.Equal(t, []string{"foo.com", "bar.com"}, cfg.Servers())
+	active := cfg.ActiveServers()
+	active[0] = "changed.com"
+	assert.Equal(t, []string{"foo.com", "bar.com"}, cfg.ActiveServers())
+
+	err := cfg.SetServers([]string{"baz.com", "evil.com/#@api.taplink.co"})
+	assert.ErrorIs(t, err, ErrInvalidHost)
+	assert.Equal(t, []string{"foo.com", "bar.com"}, cfg.Servers())
+
+	assert.NoError(t, cfg.SetServers(nil))
+	assert.Equal(t, []string{}, cfg.Servers())
+	assert.Equal(t, DefaultHost, cfg.Host(0))
+}
+
+func TestSetServersConcurrent(t *testing.T) {
+	cfg := newConfig(testAppID)
+	lists := [][]string{{"a.com", "b.com"}, {"c.com"}, {"d.com", "e.com", "f.com"}}
+	valid := map[string]bool{}
+	for _, l := range lists {
+		for _, h := range l {
+			valid[h] = true
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			cfg.SetServers(lists[i%len(lists)])
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			host := cfg.Host(i)
+			assert.True(t, valid[host] || host == DefaultHost, host)
+			for _, h := range cfg.Servers() {
+				assert.True(t, valid[h], h)
+			}
+		}
+	}()
+	wg.Wait()
+}
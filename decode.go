@@ -0,0 +1,87 @@
+package taplink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrMalformedResponse is matched by the error returned when the body of a
+// successful response from the API isn't the JSON expected, including salt
+// responses matching ErrMalformedSalt
+var ErrMalformedResponse = errors.New("malformed response")
+
+// malformedError is a sentinel error which also matches ErrMalformedResponse
+type malformedError struct {
+	msg string
+}
+
+func (e *malformedError) Error() string { return e.msg }
+
+func (e *malformedError) Unwrap() error { return ErrMalformedResponse }
+
+// decodeJSON decodes the JSON document body into v. In strict mode fields v
+// doesn't have, and anything after the document, are rejected as well.
+// Errors match ErrMalformedResponse.
+func decodeJSON(body []byte, v any, strict bool) error {
+	var err error
+	if u, ok := v.(strictUnmarshaler); ok {
+		err = u.unmarshalJSON(body, strict)
+	} else {
+		err = unmarshalJSON(body, v, strict)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedResponse, err)
+	}
+	return nil
+}
+
+// strictUnmarshaler is implemented by types with an UnmarshalJSON method,
+// which a decoder's DisallowUnknownFields doesn't reach, so they're given
+// the mode themselves
+type strictUnmarshaler interface {
+	unmarshalJSON(data []byte, strict bool) error
+}
+
+// unmarshalJSON decodes the JSON document body into v like decodeJSON,
+// without wrapping the error
+func unmarshalJSON(body []byte, v any, strict bool) error {
+	if !strict {
+		return json.Unmarshal(body, v)
+	}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	if d.More() {
+		return errors.New("data after the JSON document")
+	}
+	return nil
+}
+
+// StrictDecoding returns whether responses with unknown fields are rejected
+func (c *Config) StrictDecoding() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.strictDecoding
+}
+
+// SetStrictDecoding sets whether salt and configuration responses with fields
+// the client doesn't know of are rejected with ErrMalformedResponse. It's off
+// by default, so the API can add fields without breaking older clients; turn
+// it on to notice when the API, or a proxy in front of it, changes what it
+// sends. Either way a salt response without s2 or vid is rejected.
+func (c *Config) SetStrictDecoding(strict bool) {
+	c.Lock()
+	c.strictDecoding = strict
+	c.Unlock()
+}
+
+// WithStrictDecoding turns on strict decoding, see SetStrictDecoding
+func WithStrictDecoding() Option {
+	return func(c *Config) {
+		c.strictDecoding = true
+	}
+}
@@ -0,0 +1,67 @@
+package taplink
+
+import (
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMalformedResponses(t *testing.T) {
+	tests := []struct {
+		name, body string
+		strictOnly bool
+	}{
+		{"empty object", `{}`, false},
+		{"HTML", `<html><body>Bad Gateway</body></html>`, false},
+		{"missing s2", `{"vid":3}`, false},
+		{"zero vid", `{"s2":"` + testHashExpectedSalt + `","vid":0}`, false},
+		{"extra field", `{"s2":"` + testHashExpectedSalt + `","vid":3,"extra":true}`, true},
+		{"trailing data", `{"s2":"` + testHashExpectedSalt + `","vid":3} {}`, false},
+	}
+	for _, strict := range []bool{false, true} {
+		for _, tt := range tests {
+			st, restore := useScript()
+			st.Enqueue(taplinktest.Respond(200, tt.body))
+			c := New(testAppID).(*Client)
+			c.Config().SetStrictDecoding(strict)
+			s, err := c.GetSalt(testHashBytes, 0)
+			if strict || !tt.strictOnly {
+				assert.Nil(t, s, tt.name)
+				assert.ErrorIs(t, err, ErrMalformedResponse, tt.name)
+			} else {
+				assert.NoError(t, err, tt.name)
+			}
+			restore()
+		}
+	}
+}
+
+func TestStrictDecodingConfig(t *testing.T) {
+	body := `{"servers":["a.example.com"],"lastModified":1,"extra":1}`
+	for _, strict := range []bool{false, true} {
+		st, restore := useScript()
+		st.Enqueue(taplinktest.Respond(200, body))
+		var opts []Option
+		if strict {
+			opts = append(opts, WithStrictDecoding())
+		}
+		c := New(testAppID, opts...)
+		assert.Equal(t, strict, c.Config().StrictDecoding())
+		err := c.Config().Load()
+		if strict {
+			assert.ErrorIs(t, err, ErrMalformedResponse)
+			assert.ErrorContains(t, err, `unknown field "extra"`)
+			assert.Empty(t, c.Config().Servers())
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"a.example.com"}, c.Config().Servers())
+		}
+		restore()
+	}
+}
+
+func TestMalformedSaltIsMalformedResponse(t *testing.T) {
+	assert.ErrorIs(t, ErrMalformedSalt, ErrMalformedResponse)
+	assert.EqualError(t, ErrMalformedSalt, "malformed salt response")
+}
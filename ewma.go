@@ -0,0 +1,104 @@
+package taplink
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultScoreHalfLife is how long it takes a host's score to halve while
+// nothing is recorded for it, unless set with EnableEWMAScoring
+var DefaultScoreHalfLife = 30 * time.Second
+
+// scoreWeight is how far each request moves a host's score towards 1 if it
+// failed, or towards 0 if it didn't
+const scoreWeight = 0.3
+
+// ewma is an exponentially weighted moving average of a host's failures,
+// which also decays towards 0 over time, so a single failure on a host with
+// little traffic is forgotten gradually rather than all at once
+type ewma struct {
+	value float64
+	at    time.Time
+}
+
+// get returns the score at now, decayed since it was last updated
+func (e ewma) get(now time.Time, halfLife time.Duration) float64 {
+	if e.at.IsZero() || e.value == 0 {
+		return 0
+	}
+	if halfLife <= 0 {
+		halfLife = DefaultScoreHalfLife
+	}
+	dt := now.Sub(e.at)
+	if dt <= 0 {
+		return e.value
+	}
+	return e.value * math.Exp2(-float64(dt)/float64(halfLife))
+}
+
+// add records a request at now, which failed or not
+func (e *ewma) add(now time.Time, halfLife time.Duration, failed bool) {
+	v := e.get(now, halfLife)
+	x := 0.0
+	if failed {
+		x = 1
+	}
+	e.value, e.at = v+scoreWeight*(x-v), now
+}
+
+// Score returns the host's failure score, an exponentially weighted moving
+// average of its requests which failed with a server error, a transport
+// error or a timeout. It's from 0 for a healthy host up to 1 for one whose
+// requests all fail, and decays towards 0 with the half-life set by
+// EnableEWMAScoring while nothing is recorded. Copies and the results of Last
+// have the host's score when they were taken, which still decays.
+func (s *hostStatistics) Score() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.score.get(s.now(), s.halfLife)
+}
+
+// setHalfLife sets the half-life of the host's score
+func (s *hostStatistics) setHalfLife(halfLife time.Duration) {
+	s.mu.Lock()
+	s.halfLife = halfLife
+	s.mu.Unlock()
+}
+
+// setScoring sets whether hosts are ranked by their score, and its half-life
+func (s *statistics) setScoring(enabled bool, halfLife time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scoring, s.halfLife = enabled, halfLife
+	for _, hs := range s.stats {
+		hs.setHalfLife(halfLife)
+	}
+}
+
+// EnableEWMAScoring ranks hosts by their Score, an exponentially weighted
+// moving average of their failures with the given half-life, rather than by
+// their server error rate over the last minute. A host with little traffic
+// is then avoided less after a single failure, and traffic returns to it
+// gradually rather than when the failure leaves the window. A half-life of 0
+// or less uses DefaultScoreHalfLife.
+//
+// It only applies to the built-in stats, and doesn't change the stats
+// returned by Last.
+func (c *Config) EnableEWMAScoring(halfLife time.Duration) {
+	if halfLife <= 0 {
+		halfLife = DefaultScoreHalfLife
+	}
+	if s, ok := c.Stats().(*statistics); ok {
+		s.setScoring(true, halfLife)
+	}
+}
+
+// DisableEWMAScoring ranks hosts by their server error rate over the last
+// minute again, which is the default
+func (c *Config) DisableEWMAScoring() {
+	if s, ok := c.Stats().(*statistics); ok {
+		s.mu.Lock()
+		s.scoring = false
+		s.mu.Unlock()
+	}
+}
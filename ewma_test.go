@@ -0,0 +1,70 @@
+package taplink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEWMA(t *testing.T) {
+	now := time.Now()
+	var e ewma
+	assert.Equal(t, 0.0, e.get(now, time.Second))
+
+	// A failure moves the score towards 1, and it halves every half-life
+	e.add(now, time.Second, true)
+	assert.InDelta(t, scoreWeight, e.get(now, time.Second), 1e-9)
+	assert.InDelta(t, scoreWeight/2, e.get(now.Add(time.Second), time.Second), 1e-9)
+	assert.InDelta(t, scoreWeight/4, e.get(now.Add(2*time.Second), time.Second), 1e-9)
+
+	// A success moves it towards 0 as well
+	e.add(now.Add(time.Second), time.Second, false)
+	assert.InDelta(t, scoreWeight/2*(1-scoreWeight), e.get(now.Add(time.Second), time.Second), 1e-9)
+
+	// Failures in a row approach 1
+	for i := 0; i < 50; i++ {
+		e.add(now.Add(time.Second), time.Second, true)
+	}
+	assert.InDelta(t, 1, e.get(now.Add(time.Second), time.Second), 1e-6)
+}
+
+func TestHostStatisticsScore(t *testing.T) {
+	s := newHostStatistics("foo.com")
+	assert.Equal(t, 0.0, s.Score())
+
+	// Client errors don't count against the host
+	s.addError(401, 0)
+	s.addSuccess(time.Millisecond)
+	assert.Equal(t, 0.0, s.Score())
+	s.addError(503, 0)
+	s.addTimeout(0)
+	score := s.Score()
+	assert.Greater(t, score, 0.0)
+	assert.LessOrEqual(t, score, 1.0)
+	assert.InDelta(t, score, s.Last(time.Minute).Score(), 1e-3)
+
+	s.reset()
+	assert.Equal(t, 0.0, s.Score())
+}
+
+func TestEWMAScoring(t *testing.T) {
+	c := New(testAppID, WithStatsEnabled())
+	c.Config().EnableEWMAScoring(time.Millisecond)
+	stats := c.Stats()
+
+	// quiet.com failed once a while ago, and busy.com has just failed after
+	// many successes
+	stats.AddError("quiet.com", 503, 0)
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 9; i++ {
+		stats.AddSuccess("busy.com", time.Millisecond)
+	}
+	stats.AddError("busy.com", 503, 0)
+	assert.Less(t, stats.Get("quiet.com").Score(), stats.Get("busy.com").Score())
+	assert.Equal(t, []string{"quiet.com", "busy.com"}, stats.Hosts())
+
+	// Over the last minute, quiet.com's error rate is still 100%
+	c.Config().DisableEWMAScoring()
+	assert.Equal(t, []string{"busy.com", "quiet.com"}, stats.Hosts())
+}
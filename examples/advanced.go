@@ -9,16 +9,38 @@ import (
 
 func mainAlt() {
 
-	// You can update the RetryLimit and RetryDelay for failed HTTP requests, too.
-	// The API client will adhere to these settings.
-	taplink.RetryLimit = 10
-	taplink.RetryDelay = 30 * time.Second
+	// Options configure the client before it makes any request: how many
+	// attempts failed HTTP requests make and the delay between them, a
+	// timeout for each attempt, extra headers and whether to collect stats.
+	// NewWithError reports invalid options, which New leaves out.
+	api, err := taplink.NewWithError("my-api-key",
+		taplink.WithRetry(10, time.Second),
+		taplink.WithTimeout(5*time.Second),
+		taplink.WithHeaders(map[string]string{"X-Service": "auth"}),
+		taplink.WithStatsEnabled(),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Responses larger than 500KB fail with taplink.ErrResponseTooLarge, which
+	// can be changed too.
+	api.Config().SetMaxResponseSize(64 * 1024)
+
+	// Retries back off exponentially with jitter by default. To wait the same
+	// time between each attempt instead, use a ConstantBackoff.
+	api.Config().SetBackoff(taplink.ConstantBackoff(30 * time.Second))
+
+	// Ten attempts 30 seconds apart could keep a login waiting for minutes,
+	// so cap the time spent retrying. Retries whose delay would end past the
+	// cap aren't made. It's unlimited by default.
+	api.Config().SetMaxElapsed(time.Minute)
 
-	api := taplink.New("my-api-key")
+	// Limit the retries of all requests together to a tenth of the attempts
+	// made in the last 10 seconds, so an outage doesn't multiply the load.
+	api.Config().SetRetryBudget(0.1, 10, 10*time.Second)
 
-	// To enable the collection of stats for the API client, use Stats().Enable()
-	// By default the stats are disabled.
-	api.Stats().Enable()
+	// The stats were enabled by WithStatsEnabled. By default they're disabled.
 	api.VerifyPassword([]byte("my-password-hash"), []byte("expected"), 0)
 
 	// If you want to load config from the TapLink api and use servers other than the the taplink.DefaultHost, then load config
@@ -35,12 +57,18 @@ func mainAlt() {
 	// To change the connection strategy to use a round robin selection stragegy:
 	taplink.HostSelectionMethod = taplink.HostSelectRoundRobin
 
-	// To get the stats, use these funcs...
-	log.Println("total number of requests made", api.Stats().Get(taplink.DefaultHost).Requests())
-	log.Println("history of latency for each successful request", api.Stats().Get(taplink.DefaultHost).Latency())
-	log.Println("average time of requests", api.Stats().Get(taplink.DefaultHost).Latency().Avg())
-	log.Println("num requests which had errors", api.Stats().Get(taplink.DefaultHost).Errors())
+	logStats(api)
 
 	// To disable the collection of stats, use DisableStats()
 	api.Stats().Disable()
 }
+
+// logStats only needs the client config and stats, so it accepts an Inspector
+// rather than the whole API.
+func logStats(i taplink.Inspector) {
+	// To get the stats, use these funcs...
+	log.Println("total number of requests made", i.Stats().Get(taplink.DefaultHost).Requests())
+	log.Println("history of latency for each successful request", i.Stats().Get(taplink.DefaultHost).Latency())
+	log.Println("average time of requests", i.Stats().Get(taplink.DefaultHost).Latency().Avg())
+	log.Println("num requests which had errors", i.Stats().Get(taplink.DefaultHost).Errors())
+}
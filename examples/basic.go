@@ -9,17 +9,34 @@ import (
 func main() {
 
 	api := taplink.New("my-api-key")
-	pwd, err := api.NewPassword([]byte("my-password-hash"))
+	defer api.Close()
+	pwd, err := register(api, []byte("my-password-hash"))
 	if err != nil {
 		log.Println("NewPassword error", err)
 		return
 	}
 
-	verify, err := api.VerifyPassword([]byte("my-password-hash"), pwd.Hash, pwd.VersionID)
+	matched, err := login(api, []byte("my-password-hash"), pwd.Hash, pwd.VersionID)
 	if err != nil {
 		log.Println("VerifyPassword error", err)
 		return
 	}
 
-	log.Println("Did it match?", verify.Matched)
+	log.Println("Did it match?", matched)
+}
+
+// register only needs to create new passwords, so it accepts a Provisioner
+// rather than the whole API.
+func register(p taplink.Provisioner, hash []byte) (*taplink.NewPassword, error) {
+	return p.NewPassword(hash)
+}
+
+// login only needs to verify passwords, so it accepts a Verifier rather than
+// the whole API.
+func login(v taplink.Verifier, hash, expected []byte, versionID int64) (bool, error) {
+	verify, err := v.VerifyPassword(hash, expected, versionID)
+	if err != nil {
+		return false, err
+	}
+	return verify.Matched, nil
 }
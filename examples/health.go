@@ -0,0 +1,22 @@
+package examples
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bradberger/taplink-go"
+)
+
+// statsHandler serves the client's stats as JSON, e.g. for a health endpoint
+// which is scraped by monitoring.
+func statsHandler(api taplink.Inspector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(api.Stats().Snapshot())
+	}
+}
+
+func serveStats() {
+	api := taplink.New("my-api-key", taplink.WithStatsEnabled())
+	http.Handle("/health/taplink", statsHandler(api))
+}
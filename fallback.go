@@ -0,0 +1,130 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// FallbackVerifier verifies passwords without the TapLink API, for example
+// against a locally cached "last known good" hash. It's only used when the
+// API can't be reached, so results from it trade security for availability.
+// It's given the context of the VerifyPasswordContext call.
+type FallbackVerifier interface {
+	VerifyPasswordContext(ctx context.Context, hash []byte, expected []byte, versionID int64) (*VerifyPassword, error)
+}
+
+// ErrFallbackNoResult is returned if the fallback verifier returns neither a
+// result nor an error
+var ErrFallbackNoResult = errors.New("fallback verifier returned no result")
+
+type fallback struct {
+	verifier     FallbackVerifier
+	maxPerMinute int
+	onActivate   func(err error)
+
+	window time.Time
+	used   int
+	active bool
+
+	mu sync.Mutex
+}
+
+// allow reports whether the fallback can be used now, and whether this use
+// activates it after a period of the API working normally
+func (f *fallback) allow() (ok bool, activated bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	if now.Sub(f.window) >= time.Minute {
+		f.window = now
+		f.used = 0
+	}
+	if f.used >= f.maxPerMinute {
+		return false, false
+	}
+	f.used++
+	activated = !f.active
+	f.active = true
+	return true, activated
+}
+
+// recover deactivates the fallback after a real verification succeeds, so the
+// next outage activates it again
+func (f *fallback) recover() {
+	f.mu.Lock()
+	f.active = false
+	f.mu.Unlock()
+}
+
+// SetFallback sets a verifier to use when VerifyPassword fails because the API
+// couldn't be reached: every attempt failed (ErrRetriesExhausted), or the
+// retries were cut short by the retry deadline (ErrDeadlineExceeded), the
+// operation timeout (ErrOperationTimeout) or the retry budget
+// (ErrRetryBudgetExhausted). It's never used for mismatches, 4xx errors,
+// including retried ones such as 429, or a cancelled context. Results from
+// it have Degraded set to true.
+//
+// The fallback is used at most maxPerMinute times per minute, after which the
+// API error is returned. onActivate, if not nil, is called with the API error
+// the first time the fallback is used after the API was working. Once a real
+// verification succeeds the fallback is deactivated until the next failure.
+func (c *Client) SetFallback(fb FallbackVerifier, maxPerMinute int, onActivate func(err error)) {
+	c.Lock()
+	defer c.Unlock()
+	if fb == nil {
+		c.fallback = nil
+		return
+	}
+	c.fallback = &fallback{verifier: fb, maxPerMinute: maxPerMinute, onActivate: onActivate}
+}
+
+// unreachable reports whether err means the API couldn't be reached, so the
+// fallback may be used. Retried 4xx responses, such as 429 or 408, mean the
+// API was reached, so a request whose attempts all got one doesn't count,
+// and nor does one cut short after a 4xx.
+func unreachable(err error) bool {
+	var mae *MultiAttemptError
+	if errors.As(err, &mae) {
+		for _, a := range mae.Attempts {
+			if !IsClientError(a.Err) {
+				return true
+			}
+		}
+		return false
+	}
+	if IsClientError(err) {
+		return false
+	}
+	return errors.Is(err, ErrRetriesExhausted) || errors.Is(err, ErrDeadlineExceeded) ||
+		errors.Is(err, ErrOperationTimeout) || errors.Is(err, ErrRetryBudgetExhausted)
+}
+
+// verifyFallback uses the fallback verifier, if there is one and err allows
+// it. Otherwise err is returned.
+func (c *Client) verifyFallback(ctx context.Context, hash []byte, expected []byte, versionID int64, err error) (*VerifyPassword, error) {
+	c.RLock()
+	f := c.fallback
+	c.RUnlock()
+	if f == nil || !unreachable(err) {
+		return nil, err
+	}
+	ok, activated := f.allow()
+	if !ok {
+		return nil, err
+	}
+	if activated && f.onActivate != nil {
+		f.onActivate(err)
+	}
+	c.Stats().AddFallback()
+	vp, fbErr := f.verifier.VerifyPasswordContext(ctx, hash, expected, versionID)
+	if fbErr != nil {
+		return nil, fbErr
+	}
+	if vp == nil {
+		return nil, ErrFallbackNoResult
+	}
+	vp.Degraded = true
+	return vp, nil
+}
@@ -0,0 +1,160 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+type testFallbackVerifier struct {
+	calls int
+}
+
+func (fb *testFallbackVerifier) VerifyPasswordContext(ctx context.Context, hash []byte, expected []byte, versionID int64) (*VerifyPassword, error) {
+	fb.calls++
+	return &VerifyPassword{Matched: true, VersionID: versionID}, nil
+}
+
+func TestFallback(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(503, http.StatusText(503)))
+
+	var activations []error
+	fb := &testFallbackVerifier{}
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	c.Stats().Enable()
+	c.SetFallback(fb, 2, func(err error) {
+		activations = append(activations, err)
+	})
+
+	v, err := c.VerifyPassword(testHashBytes, []byte("foobar"), 2)
+	assert.NoError(t, err)
+	assert.True(t, v.Matched)
+	assert.True(t, v.Degraded)
+	assert.Equal(t, int64(2), v.VersionID)
+	_, err = c.VerifyPassword(testHashBytes, []byte("foobar"), 2)
+	assert.NoError(t, err)
+
+	// The fallback is rate limited, after which the API error is returned.
+	_, err = c.VerifyPassword(testHashBytes, []byte("foobar"), 2)
+	assert.True(t, errors.Is(err, ErrRetriesExhausted))
+	assert.EqualError(t, errors.Unwrap(err), http.StatusText(503))
+	assert.Equal(t, 2, fb.calls)
+	assert.Equal(t, 2, c.Stats().Fallbacks())
+
+	// Only the first use alerts, until a real verification succeeds.
+	if assert.Len(t, activations, 1) {
+		assert.True(t, errors.Is(activations[0], ErrRetriesExhausted))
+	}
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	v, err = c.VerifyPassword(testHashBytes, []byte("foobar"), 0)
+	assert.NoError(t, err)
+	assert.False(t, v.Matched)
+	assert.False(t, v.Degraded)
+	assert.False(t, c.fallback.active)
+}
+
+func TestFallbackNotUsedForClientErrors(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(401, http.StatusText(401)))
+	fb := &testFallbackVerifier{}
+	c := New(testAppID).(*Client)
+	c.SetFallback(fb, 10, nil)
+	_, err := c.VerifyPassword(testHashBytes, []byte("foobar"), 0)
+	assert.EqualError(t, err, http.StatusText(401))
+	assert.False(t, errors.Is(err, ErrRetriesExhausted))
+	assert.Equal(t, 0, fb.calls)
+
+	c.SetFallback(nil, 0, nil)
+	assert.Nil(t, c.fallback)
+}
+
+func TestFallbackWhenRetriesCutShort(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(Configuration)
+		err   error
+	}{
+		{"max elapsed", func(cfg Configuration) {
+			cfg.SetBackoff(ConstantBackoff(10 * time.Millisecond))
+			cfg.SetMaxElapsed(time.Millisecond)
+		}, ErrDeadlineExceeded},
+		{"operation timeout", func(cfg Configuration) {
+			cfg.SetRequestTimeout(20 * time.Millisecond)
+			cfg.SetOperationTimeout(30 * time.Millisecond)
+		}, ErrOperationTimeout},
+		{"retry budget", func(cfg Configuration) {
+			cfg.SetRetryBudget(0.1, 0, 0)
+		}, ErrRetryBudgetExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, restore := useScript()
+			defer restore()
+			st.SetDefault(taplinktest.RespondAfter(50*time.Millisecond, 503, http.StatusText(503)))
+			var activations []error
+			fb := &testFallbackVerifier{}
+			c := New(testAppID, WithFallback(fb, 10, func(err error) {
+				activations = append(activations, err)
+			})).(*Client)
+			c.Config().SetBackoff(ConstantBackoff(0))
+			tt.setup(c.Config())
+
+			v, err := c.VerifyPassword(testHashBytes, []byte("foobar"), 2)
+			if assert.NoError(t, err) {
+				assert.True(t, v.Degraded)
+			}
+			assert.Equal(t, 1, fb.calls)
+			if assert.Len(t, activations, 1) {
+				assert.ErrorIs(t, activations[0], tt.err)
+			}
+		})
+	}
+}
+
+func TestFallbackNotUsedForRetriedClientErrors(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(429, http.StatusText(429)))
+	fb := &testFallbackVerifier{}
+	c := New(testAppID, WithRetry(3, 0), WithFallback(fb, 10, nil)).(*Client)
+
+	// Every attempt was throttled, so the API was reached
+	_, err := c.VerifyPassword(testHashBytes, []byte("foobar"), 0)
+	assert.ErrorIs(t, err, ErrRetriesExhausted)
+	assert.True(t, IsClientError(err))
+	assert.Equal(t, 3, st.Attempts(DefaultHost))
+	assert.Equal(t, 0, fb.calls)
+
+	// Once an attempt got a server error, the fallback is used
+	st.Enqueue(taplinktest.Respond(503, http.StatusText(503)))
+	_, err = c.VerifyPassword(testHashBytes, []byte("foobar"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fb.calls)
+}
+
+type testNilFallbackVerifier struct{}
+
+func (testNilFallbackVerifier) VerifyPasswordContext(ctx context.Context, hash []byte, expected []byte, versionID int64) (*VerifyPassword, error) {
+	return nil, nil
+}
+
+func TestFallbackNoResult(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(503, http.StatusText(503)))
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	c.SetFallback(testNilFallbackVerifier{}, 10, nil)
+	v, err := c.VerifyPassword(testHashBytes, []byte("foobar"), 0)
+	assert.Nil(t, v)
+	assert.Equal(t, ErrFallbackNoResult, err)
+}
@@ -0,0 +1,144 @@
+package taplink
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// ErrGlobalsMutated is matched by errors returned by clients created with
+// StrictGlobals enabled, once a package global they snapshot has changed.
+var ErrGlobalsMutated = errors.New("package global changed after the client was created")
+
+var strictGlobals int32
+
+// StrictGlobals sets whether clients created from now on snapshot RetryLimit,
+// RetryDelay, DefaultHost and HTTPClient when they're created. Those clients
+// ignore later changes to the globals, and their requests fail with
+// ErrGlobalsMutated once a change is detected, so that code which still
+// relies on mutating the globals can be found, e.g. in CI. Other clients keep
+// reading the globals, and log a warning to their Logger the first time a
+// request finds one has changed since the client was created.
+//
+// On App Engine, UseContext replaces HTTPClient, so it counts as a change.
+func StrictGlobals(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&strictGlobals, v)
+}
+
+// globals holds the package globals a client uses. A nil *globals reads the
+// live package globals without checking them.
+type globals struct {
+	limit int
+	delay time.Duration
+	host  string
+
+	// client is a copy of the HTTPClient, and the orig fields are what it was
+	// copied from, to detect changes
+	client        *http.Client
+	origClient    *http.Client
+	origTransport http.RoundTripper
+	origTimeout   time.Duration
+
+	// strict is whether the snapshot is used rather than the live globals,
+	// and warned is set once a change has been logged. warned is accessed
+	// atomically.
+	strict bool
+	warned int32
+}
+
+// snapshotGlobals returns a snapshot of the package globals, which is used
+// in their place if StrictGlobals is enabled, and otherwise only to detect
+// changes
+func snapshotGlobals() *globals {
+	client := *HTTPClient
+	return &globals{
+		limit:         RetryLimit,
+		delay:         RetryDelay,
+		host:          DefaultHost,
+		client:        &client,
+		origClient:    HTTPClient,
+		origTransport: HTTPClient.Transport,
+		origTimeout:   HTTPClient.Timeout,
+		strict:        atomic.LoadInt32(&strictGlobals) != 0,
+	}
+}
+
+// check returns an error naming the first global which has changed since the
+// snapshot was taken, if the snapshot is strict. Otherwise the first change
+// is logged to l as a warning, and nil is returned.
+func (g *globals) check(l *slog.Logger) error {
+	if g == nil {
+		return nil
+	}
+	var name string
+	switch {
+	case RetryLimit != g.limit:
+		name = "RetryLimit"
+	case RetryDelay != g.delay:
+		name = "RetryDelay"
+	case DefaultHost != g.host:
+		name = "DefaultHost"
+	case HTTPClient != g.origClient || !sameTransport(HTTPClient.Transport, g.origTransport) || HTTPClient.Timeout != g.origTimeout:
+		name = "HTTPClient"
+	default:
+		return nil
+	}
+	if g.strict {
+		return fmt.Errorf("%w: %s", ErrGlobalsMutated, name)
+	}
+	if l != nil && atomic.CompareAndSwapInt32(&g.warned, 0, 1) {
+		l.Warn("taplink package global changed after the client was created, use options instead", "global", name)
+	}
+	return nil
+}
+
+// sameTransport reports whether a and b are the same transport. Transports
+// whose type can't be compared with ==, such as funcs, are compared by the
+// pointer they hold, so a change is still detected without a panic.
+func sameTransport(a, b http.RoundTripper) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Type() != vb.Type() {
+		return false
+	}
+	if va.Comparable() {
+		return a == b
+	}
+	switch va.Kind() {
+	case reflect.Func, reflect.Map, reflect.Slice, reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		return va.Pointer() == vb.Pointer()
+	}
+	// A struct holding a func can't be told apart from a copy of it
+	return true
+}
+
+func (g *globals) retryLimit() int {
+	if g == nil || !g.strict {
+		return RetryLimit
+	}
+	return g.limit
+}
+
+func (g *globals) defaultHost() string {
+	if g == nil || !g.strict {
+		return DefaultHost
+	}
+	return g.host
+}
+
+func (g *globals) httpClient() *http.Client {
+	if g == nil || !g.strict {
+		return HTTPClient
+	}
+	return g.client
+}
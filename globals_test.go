@@ -0,0 +1,103 @@
+package taplink
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrictGlobals(t *testing.T) {
+	st, restore := useScript()
+	origHost := DefaultHost
+	defer func() {
+		restore()
+		DefaultHost = origHost
+		StrictGlobals(false)
+	}()
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+
+	DefaultHost = "foo.com"
+	StrictGlobals(true)
+	strict := New(testAppID).(*Client)
+	StrictGlobals(false)
+	loose := New(testAppID).(*Client)
+
+	// Changing a global mid-run only affects the client which isn't strict.
+	DefaultHost = "bar.com"
+	assert.Equal(t, "foo.com", strict.Config().Host(0))
+	assert.Equal(t, "bar.com", loose.Config().Host(0))
+	_, err := strict.GetSalt(testHashBytes, 0)
+	assert.True(t, errors.Is(err, ErrGlobalsMutated))
+	assert.EqualError(t, err, ErrGlobalsMutated.Error()+": DefaultHost")
+	assert.Equal(t, ErrGlobalsMutated, errors.Unwrap(strict.Config().Load()))
+	_, err = loose.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, st.Attempts("foo.com"))
+	assert.Equal(t, 1, st.Attempts("bar.com"))
+
+	// Changing the transport counts as changing the HTTPClient.
+	DefaultHost = "foo.com"
+	HTTPClient.Transport = origTransport
+	_, err = strict.GetSalt(testHashBytes, 0)
+	assert.EqualError(t, err, ErrGlobalsMutated.Error()+": HTTPClient")
+
+	// Once the globals match the snapshot again, the strict client works.
+	HTTPClient.Transport = st
+	_, err = strict.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, st.Attempts("foo.com"))
+}
+
+// funcTransport is a RoundTripper whose type can't be compared with ==
+type funcTransport func(*http.Request) (*http.Response, error)
+
+func (f funcTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestStrictGlobalsFuncTransport(t *testing.T) {
+	st, restore := useScript()
+	defer func() {
+		restore()
+		StrictGlobals(false)
+	}()
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	HTTPClient.Transport = funcTransport(st.RoundTrip)
+	StrictGlobals(true)
+	c := New(testAppID).(*Client)
+
+	// The same func isn't a change, and another one is
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	HTTPClient.Transport = funcTransport(func(r *http.Request) (*http.Response, error) { return st.RoundTrip(r) })
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.EqualError(t, err, ErrGlobalsMutated.Error()+": HTTPClient")
+}
+
+func TestGlobalsMutatedWarning(t *testing.T) {
+	st, restore := useScript()
+	origHost := DefaultHost
+	defer func() {
+		restore()
+		DefaultHost = origHost
+	}()
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	logger, buf := newTestLogger()
+	c := New(testAppID, WithLogger(logger)).(*Client)
+
+	// A client which isn't strict uses the changed global, and warns about
+	// it once
+	DefaultHost = "bar.com"
+	for i := 0; i < 2; i++ {
+		_, err := c.GetSalt(bytes.Repeat([]byte{byte(i)}, len(testHashBytes)), 0)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, st.Attempts("bar.com"))
+	assert.Equal(t, 1, strings.Count(buf.String(), "package global changed"))
+	assert.Contains(t, buf.String(), "global=DefaultHost")
+}
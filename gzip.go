@@ -0,0 +1,30 @@
+package taplink
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// readBody reads up to n bytes of the body of resp. A gzipped body is
+// decompressed, as requests ask for gzip themselves so the transport
+// doesn't, and n limits the decompressed bytes so a small body can't expand
+// to use too much memory.
+func readBody(resp *http.Response, n int64) ([]byte, error) {
+	var r io.Reader = resp.Body
+	if !resp.Uncompressed && strings.EqualFold(strings.TrimSpace(resp.Header.Get("Content-Encoding")), "gzip") {
+		zr, err := gzip.NewReader(resp.Body)
+		// An empty body, e.g. of a 304, isn't a gzip stream
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return ioutil.ReadAll(io.LimitReader(r, n))
+}
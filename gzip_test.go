@@ -0,0 +1,83 @@
+package taplink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestGzipResponse(t *testing.T) {
+	// The transport leaves decompressing to the client, as it asks for gzip
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			http.Error(w, "gzip not accepted", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipped(`{"s2":"` + testHashExpectedSalt + `","vid":3}`))
+	}))
+	defer srv.Close()
+
+	c := New(testAppID, WithServers([]string{srv.URL}))
+	s, err := c.GetSalt(testHashBytes, 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, testHashExpectedSaltBytes, s.Salt)
+	}
+}
+
+func TestGzipResponseCustomTransport(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	o := taplinktest.Respond(200, string(gzipped(`{"s2":"`+testHashExpectedSalt+`","vid":3}`)))
+	o.Header.Set("Content-Encoding", "gzip")
+	st.Enqueue(o)
+
+	s, err := New(testAppID).GetSalt(testHashBytes, 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, testHashExpectedSaltBytes, s.Salt)
+	}
+}
+
+func TestGzipResponseTooLarge(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	// 10MB of padding compresses to a few KB
+	o := taplinktest.Respond(200, string(gzipped(`{"s2":"`+strings.Repeat(" ", 10<<20)+`"}`)))
+	o.Header.Set("Content-Encoding", "gzip")
+	st.SetDefault(o)
+	assert.Less(t, len(o.Body), 64*1024)
+
+	c := New(testAppID, WithRetry(1, 0), WithStatsEnabled())
+	c.Config().SetMaxResponseSize(64 * 1024)
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.ErrorIs(t, err, ErrResponseTooLarge)
+	assert.Equal(t, Errors{ResponseTooLargeCode: 1}, c.Stats().Get(DefaultHost).Errors())
+}
+
+func TestGzipResponseCorrupt(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	o := taplinktest.Respond(200, `{"s2":"not gzip","vid":3}`)
+	o.Header.Set("Content-Encoding", "gzip")
+	st.SetDefault(o)
+
+	c := New(testAppID, WithRetry(1, 0), WithStatsEnabled())
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.ErrorIs(t, err, gzip.ErrHeader)
+	assert.Equal(t, Errors{TransportErrorCode: 1}, c.Stats().Get(DefaultHost).Errors())
+}
@@ -0,0 +1,137 @@
+package taplink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type healthChecks struct {
+	// hosts are the hosts to probe, or nil to probe Servers()
+	hosts []string
+
+	// cancel stops the probe loop, and done is closed once it has stopped
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// EnableHealthChecks enables the stats, which the probes are recorded by,
+// and probes every server in Servers() every interval in the background,
+// with a HEAD request for the app's configuration. The results are recorded
+// with Statistics.AddProbe, apart from requests, and a host whose latest
+// probe failed is ranked after the others by Stats().Hosts(), so it's
+// deprioritized before a request fails on it.
+//
+// While no servers are loaded nothing is probed, so DefaultHost is only
+// probed if it's given in hosts. Given hosts are probed instead of Servers().
+//
+// Calling EnableHealthChecks again replaces the previous checks. Stop them
+// with StopHealthChecks, which the client's Shutdown also calls. An interval
+// of 0 or less returns ErrInvalidInterval, leaving any checks as they were,
+// and once the client has started shutting down ErrClientClosed is returned.
+func (c *Config) EnableHealthChecks(interval time.Duration, hosts ...string) error {
+	if interval <= 0 {
+		return fmt.Errorf("%w: health check interval %s", ErrInvalidInterval, interval)
+	}
+	ctx, cancel := context.WithCancel(c.rootContext())
+	h := &healthChecks{cancel: cancel, done: make(chan struct{})}
+	if len(hosts) > 0 {
+		h.hosts = append([]string(nil), hosts...)
+	}
+	c.Lock()
+	if err := c.closed(); err != nil {
+		c.Unlock()
+		cancel()
+		return err
+	}
+	prev := c.health
+	c.health = h
+	c.Unlock()
+	if prev != nil {
+		prev.stop()
+	}
+	c.Stats().Enable()
+	go c.healthLoop(ctx, h, interval)
+	return nil
+}
+
+// StopHealthChecks stops the health checks, cancelling any probes in
+// progress, and waits for the background goroutine to exit. It's safe to
+// call when health checks aren't running.
+func (c *Config) StopHealthChecks() {
+	c.Lock()
+	h := c.health
+	c.health = nil
+	c.Unlock()
+	if h != nil {
+		h.stop()
+	}
+}
+
+// stop stops the probe loop and waits for it to return
+func (h *healthChecks) stop() {
+	h.cancel()
+	<-h.done
+}
+
+func (c *Config) healthLoop(ctx context.Context, h *healthChecks, interval time.Duration) {
+	defer close(h.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		hosts := h.hosts
+		if hosts == nil {
+			hosts = c.Servers()
+		}
+		c.probeAll(ctx, hosts, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// probeAll probes each of hosts concurrently, giving each probe up to
+// timeout, and waits for them to finish
+func (c *Config) probeAll(ctx context.Context, hosts []string, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			c.probe(pctx, host)
+		}(host)
+	}
+	wg.Wait()
+}
+
+// probe makes a HEAD request for the app's configuration to host, and
+// records the result. Any response other than a server error or throttling
+// counts as healthy. Probes wait on the rate limiters like requests do, and
+// one which doesn't get its turn isn't made. A probe cancelled by ctx, other
+// than by its timeout, isn't recorded.
+func (c *Config) probe(ctx context.Context, host string) {
+	if err := c.requester().waitQueue(ctx, host); err != nil {
+		return
+	}
+	req, _ := http.NewRequestWithContext(ctx, "HEAD", apiURL(host, c.appID), nil)
+	for k, v := range c.Headers() {
+		req.Header.Set(k, v)
+	}
+	t := time.Now()
+	resp, err := c.baseHTTPClient().Do(req)
+	latency := time.Since(t)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if err != nil && ctx.Err() == context.Canceled {
+		return
+	}
+	healthy := err == nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests
+	c.Stats().AddProbe(host, latency, healthy)
+}
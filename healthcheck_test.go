@@ -0,0 +1,117 @@
+package taplink
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthChecks(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"servers":["a.com","b.com"]}`))
+	st.EnqueueFor("a.com", taplinktest.Repeat(1000, taplinktest.Respond(503, ""))...)
+	st.SetDefault(taplinktest.Respond(200, ""))
+	c := New(testAppID).(*Client)
+	assert.NoError(t, c.Config().Load())
+	assert.Equal(t, []string{"a.com", "b.com"}, c.Stats().Hosts())
+
+	c.Config().EnableHealthChecks(5 * time.Millisecond)
+	defer c.Config().StopHealthChecks()
+	assert.Eventually(t, func() bool {
+		return c.Stats().Get("a.com").Probes().Failing && c.Stats().Get("b.com").Probes().Healthy > 0
+	}, time.Second, 5*time.Millisecond)
+
+	// The failing host is ranked last, but its probes aren't requests.
+	assert.Equal(t, []string{"b.com", "a.com"}, c.Stats().Hosts())
+	hs := c.Stats().Get("a.com")
+	assert.Equal(t, 0, hs.Requests())
+	assert.Equal(t, 0, hs.Errors().Len())
+	assert.Equal(t, float64(0), hs.ErrorRate())
+	assert.Equal(t, 0, hs.Probes().Healthy)
+	assert.Equal(t, 1, st.Attempts(DefaultHost), "only the config load")
+}
+
+func TestHealthChecksDefaultHost(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, ""))
+	c := New(testAppID).(*Client)
+
+	// An interval which can't tick is refused rather than panicking, without
+	// enabling the stats
+	assert.ErrorIs(t, c.Config().EnableHealthChecks(0, DefaultHost), ErrInvalidInterval)
+	assert.Nil(t, c.Config().(*Config).health)
+	assert.False(t, c.Stats().(*statistics).isEnabled())
+
+	// Without servers loaded, DefaultHost isn't probed unless it's asked for.
+	assert.NoError(t, c.Config().EnableHealthChecks(5*time.Millisecond))
+	assert.True(t, c.Stats().(*statistics).isEnabled())
+	time.Sleep(30 * time.Millisecond)
+	c.Config().StopHealthChecks()
+	assert.Equal(t, 0, st.Attempts(DefaultHost))
+
+	c.Config().EnableHealthChecks(5*time.Millisecond, DefaultHost)
+	defer c.Config().StopHealthChecks()
+	assert.Eventually(t, func() bool { return c.Stats().Get(DefaultHost).Probes().Healthy > 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 0, c.Stats().Get(DefaultHost).Requests())
+}
+
+func TestHealthChecksStoppedByShutdown(t *testing.T) {
+	defer checkGoroutines(t)()
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, ""))
+	c := New(testAppID).(*Client)
+	c.Config().EnableHealthChecks(time.Millisecond, "a.com")
+	// Enabling again replaces the running checks.
+	c.Config().EnableHealthChecks(time.Millisecond, "a.com")
+	assert.NoError(t, c.Shutdown(context.Background()))
+
+	n := st.Attempts("a.com")
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, n, st.Attempts("a.com"))
+}
+
+// countingLimiter counts the requests which wait on it, without making them
+// wait
+type countingLimiter struct {
+	waits atomic.Int32
+}
+
+func (l *countingLimiter) Wait(ctx context.Context) error {
+	l.waits.Add(1)
+	return nil
+}
+
+func TestHealthChecksRateLimited(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"servers":["a.com","b.com"]}`))
+	st.SetDefault(taplinktest.Respond(200, ""))
+	l := &countingLimiter{}
+	c := New(testAppID).(*Client)
+	c.Config().SetSharedRateLimiter(l)
+	assert.NoError(t, c.Config().Load())
+
+	// Each probe waits on the shared limiter, as the load did
+	assert.NoError(t, c.Config().EnableHealthChecks(5*time.Millisecond))
+	assert.Eventually(t, func() bool { return st.Attempts("a.com") >= 2 }, time.Second, time.Millisecond)
+	c.Config().StopHealthChecks()
+	assert.Equal(t, 1+st.Attempts("a.com")+st.Attempts("b.com"), int(l.waits.Load()))
+	assert.Equal(t, st.Attempts("a.com"), c.Stats().Get("a.com").QueueWait().Len())
+
+	// A probe which doesn't get its turn isn't made or recorded
+	c.Config().SetSharedRateLimiter(testCancelledLimiter{})
+	probes := c.Stats().Get("a.com").Probes()
+	n := st.Attempts("a.com")
+	assert.NoError(t, c.Config().EnableHealthChecks(5*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	c.Config().StopHealthChecks()
+	assert.Equal(t, n, st.Attempts("a.com"))
+	assert.Equal(t, probes, c.Stats().Get("a.com").Probes())
+}
@@ -0,0 +1,105 @@
+package taplink
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// EnableHedging hedges each attempt: if the host hasn't answered within delay,
+// the same request is made to the best ranked other server by Stats().Hosts(),
+// and whichever succeeds first is used. The other request is cancelled, and
+// recorded in the stats as a timeout, as it was slower than the one used.
+// Hedging is skipped while there's only one active server.
+func (c *Config) EnableHedging(delay time.Duration) {
+	c.Lock()
+	c.hedgeDelay = delay
+	c.Unlock()
+}
+
+// DisableHedging stops hedging requests
+func (c *Config) DisableHedging() {
+	c.EnableHedging(0)
+}
+
+// HedgeDelay returns how long a request waits for an answer before it's
+// hedged, or 0 if hedging is disabled
+func (c *Config) HedgeDelay() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.hedgeDelay
+}
+
+// hedgeHost returns the host to hedge a request to host with, or "" if the
+// request shouldn't be hedged
+func (c *Client) hedgeHost(host string, delay time.Duration) string {
+	if delay <= 0 {
+		return ""
+	}
+	active := c.Config().ActiveServers()
+	if len(active) < 2 {
+		return ""
+	}
+	isActive := make(map[string]bool, len(active))
+	for _, h := range active {
+		isActive[h] = true
+	}
+	cs, _ := c.Stats().(circuitStats)
+	now := time.Now()
+	for _, h := range c.Stats().Hosts() {
+		if h == host || !isActive[h] || (cs != nil && !cs.allowHost(h, now)) {
+			continue
+		}
+		return h
+	}
+	return ""
+}
+
+// hedge makes the request to host, and to hedgeHost too if host hasn't
+// answered within delay. The first outcome which needn't be retried is
+// returned, or if both requests fail, the last to.
+func (c *Client) hedge(ctx context.Context, client *http.Client, host, hedgeHost string, delay time.Duration, r *apiRequest, attempts int, maxRetryAfter time.Duration) outcome {
+	hctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The channel is big enough for both, so the loser doesn't block
+	results := make(chan outcome, 2)
+	send := func(host string) {
+		t := time.Now()
+		o := c.do(hctx, client, host, r, attempts, maxRetryAfter)
+		// A request cancelled for the other one is recorded as a timeout
+		if o.err != nil && hctx.Err() != nil && ctx.Err() == nil {
+			c.attemptStats().AddTimeout(host, time.Since(t))
+		}
+		results <- o
+	}
+	go send(host)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending, hedged := 1, false
+	var o outcome
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			hedged = true
+			pending++
+			go func() {
+				if err := c.waitQueue(hctx, hedgeHost); err != nil {
+					results <- outcome{host: hedgeHost, err: err, retry: true}
+					return
+				}
+				send(hedgeHost)
+			}()
+		case o = <-results:
+			pending--
+			// A failure before the hedge is made is retried as usual
+			if !o.retry || !hedged {
+				return o
+			}
+		case <-ctx.Done():
+			return outcome{host: host, err: ctx.Err()}
+		}
+	}
+	return o
+}
@@ -0,0 +1,89 @@
+package taplink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+var hedgeSaltBody = `{"s2":"` + testHashExpectedSalt + `","vid":3}`
+
+func newHedgeClient(t *testing.T, st *taplinktest.ScriptedTransport, servers string) *Client {
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"servers":`+servers+`}`))
+	c := New(testAppID).(*Client)
+	assert.NoError(t, c.Config().Load())
+	c.Config().SetHostSelection(HostSelectPrimary)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	c.Config().EnableHedging(10 * time.Millisecond)
+	c.Stats().Enable()
+	return c
+}
+
+func TestHedgeSlowPrimary(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := newHedgeClient(t, st, `["foo.com","bar.com"]`)
+	st.EnqueueFor("foo.com", taplinktest.RespondAfter(time.Second, 200, hedgeSaltBody))
+	st.EnqueueFor("bar.com", taplinktest.Respond(200, hedgeSaltBody))
+
+	start := time.Now()
+	s, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, testHashExpectedSaltBytes, s.Salt)
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "the hedge should answer first")
+	assert.Equal(t, 1, st.Attempts("foo.com"))
+	assert.Equal(t, 1, st.Attempts("bar.com"))
+
+	// Both requests are recorded, the cancelled one as a timeout.
+	assert.Equal(t, 1, c.Stats().Get("bar.com").Requests())
+	assert.Eventually(t, func() bool { return c.Stats().Get("foo.com").Timeouts() == 1 }, time.Second, time.Millisecond)
+}
+
+func TestHedgeFastPrimary(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := newHedgeClient(t, st, `["foo.com","bar.com"]`)
+	st.EnqueueFor("foo.com", taplinktest.Respond(200, hedgeSaltBody))
+
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, st.Attempts("foo.com"))
+	assert.Equal(t, 0, st.Attempts("bar.com"))
+}
+
+func TestHedgeFailedHedge(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := newHedgeClient(t, st, `["foo.com","bar.com"]`)
+	st.EnqueueFor("foo.com", taplinktest.RespondAfter(50*time.Millisecond, 200, hedgeSaltBody))
+	st.EnqueueFor("bar.com", taplinktest.Respond(503, "unavailable"))
+
+	// The primary is waited for once the hedge fails.
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, c.Stats().Get("foo.com").Requests())
+	assert.Equal(t, 1, c.Stats().Get("bar.com").Errors().Count(503))
+}
+
+func TestHedgeSingleServer(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := newHedgeClient(t, st, `["foo.com"]`)
+	st.EnqueueFor("foo.com", taplinktest.RespondAfter(50*time.Millisecond, 200, hedgeSaltBody))
+
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, st.Attempts("foo.com"))
+	assert.Equal(t, 0, c.Stats().Get("foo.com").Timeouts())
+}
+
+func TestHedgeDisabled(t *testing.T) {
+	c := newConfig("")
+	assert.Equal(t, time.Duration(0), c.HedgeDelay())
+	c.EnableHedging(time.Millisecond)
+	assert.Equal(t, time.Millisecond, c.HedgeDelay())
+	c.DisableHedging()
+	assert.Equal(t, time.Duration(0), c.HedgeDelay())
+}
@@ -0,0 +1,74 @@
+package taplink
+
+import (
+	"context"
+	"encoding/hex"
+)
+
+// decodeHash decodes a hex-encoded hash, which must be HashSize bytes long
+func decodeHash(s string) ([]byte, error) {
+	if len(s) != HashSize*2 {
+		return nil, ErrInvalidHash
+	}
+	hash, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidHash
+	}
+	return hash, nil
+}
+
+// HashHex returns the hex-encoded value of Hash
+func (vp VerifyPassword) HashHex() string {
+	return hex.EncodeToString(vp.Hash)
+}
+
+// NewHashHex returns the hex-encoded value of NewHash, or "" if there's no
+// upgrade
+func (vp VerifyPassword) NewHashHex() string {
+	if vp.NewHash == nil {
+		return ""
+	}
+	return hex.EncodeToString(vp.NewHash)
+}
+
+// NewPasswordHex is like NewPassword, for a hash1 hex-encoded and returning
+// hash2 hex-encoded. A hash1 which isn't HashSize bytes of hex is rejected
+// with ErrInvalidHash.
+func (c *Client) NewPasswordHex(hash1Hex string) (hash2Hex string, versionID int64, err error) {
+	return c.NewPasswordHexContext(context.Background(), hash1Hex)
+}
+
+// NewPasswordHexContext is like NewPasswordHex, but requests to the API are
+// made with ctx.
+func (c *Client) NewPasswordHexContext(ctx context.Context, hash1Hex string) (hash2Hex string, versionID int64, err error) {
+	hash1, err := decodeHash(hash1Hex)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := c.NewPasswordContext(ctx, hash1)
+	if err != nil {
+		return "", 0, err
+	}
+	return p.String(), p.VersionID, nil
+}
+
+// VerifyPasswordHex is like VerifyPassword, for hash1 and expected
+// hex-encoded. Either one which isn't HashSize bytes of hex is rejected with
+// ErrInvalidHash. Use HashHex and NewHashHex for the hashes in the result.
+func (c *Client) VerifyPasswordHex(hash1Hex, expectedHex string, versionID int64) (*VerifyPassword, error) {
+	return c.VerifyPasswordHexContext(context.Background(), hash1Hex, expectedHex, versionID)
+}
+
+// VerifyPasswordHexContext is like VerifyPasswordHex, but requests to the API
+// are made with ctx.
+func (c *Client) VerifyPasswordHexContext(ctx context.Context, hash1Hex, expectedHex string, versionID int64) (*VerifyPassword, error) {
+	hash1, err := decodeHash(hash1Hex)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := decodeHash(expectedHex)
+	if err != nil {
+		return nil, err
+	}
+	return c.VerifyPasswordContext(ctx, hash1, expected, versionID)
+}
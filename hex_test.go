@@ -0,0 +1,60 @@
+package taplink
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPasswordHex(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"s2":"`+vectorSaltV3+`","vid":3}`))
+	c := New(testAppID).(*Client)
+
+	hash2, v, err := c.NewPasswordHex(hex.EncodeToString(vectorHash1()))
+	assert.NoError(t, err)
+	assert.Equal(t, vectorHashV3, hash2)
+	assert.Equal(t, int64(3), v)
+}
+
+func TestVerifyPasswordHex(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"s2":"`+vectorSaltV2+`","vid":2,"new_s2":"`+vectorSaltV3+`","new_vid":3}`))
+	c := New(testAppID).(*Client)
+
+	// Upper case hex is accepted too.
+	vp, err := c.VerifyPasswordHex(hex.EncodeToString(vectorHash1()), strings.ToUpper(vectorHashV2), 2)
+	assert.NoError(t, err)
+	assert.True(t, vp.Matched)
+	assert.Equal(t, vectorHashV2, vp.HashHex())
+	assert.Equal(t, int64(3), vp.NewVersionID)
+	assert.Equal(t, vectorHashV3, vp.NewHashHex())
+
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"s2":"`+vectorSaltV3+`","vid":3}`))
+	vp, err = c.VerifyPasswordHex(hex.EncodeToString(vectorHash1()), vectorHashV3, 3)
+	assert.NoError(t, err)
+	assert.True(t, vp.Matched)
+	assert.Equal(t, "", vp.NewHashHex())
+}
+
+func TestHexInvalidHash(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := New(testAppID).(*Client)
+
+	hash1 := hex.EncodeToString(vectorHash1())
+	for _, bad := range []string{"", hash1[:126], hash1 + "00", strings.Repeat("z", 128)} {
+		_, _, err := c.NewPasswordHex(bad)
+		assert.Equal(t, ErrInvalidHash, err, bad)
+		_, err = c.VerifyPasswordHex(bad, vectorHashV3, 3)
+		assert.Equal(t, ErrInvalidHash, err, bad)
+		_, err = c.VerifyPasswordHex(hash1, bad, 3)
+		assert.Equal(t, ErrInvalidHash, err, bad)
+	}
+	assert.Empty(t, st.Hosts())
+}
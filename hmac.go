@@ -0,0 +1,60 @@
+package taplink
+
+import (
+	"crypto/sha512"
+	"hash"
+	"sync"
+)
+
+// hmacState is the state of an HMAC-SHA512 computation. The key is different
+// for each salt, so keyed MACs can't be reused; instead the SHA-512 digests
+// and buffers are pooled, and keyed for each computation.
+type hmacState struct {
+	inner, outer hash.Hash
+	pad          [sha512.BlockSize]byte
+	sum          [sha512.Size]byte
+}
+
+var hmacPool = sync.Pool{
+	New: func() any {
+		return &hmacState{inner: sha512.New(), outer: sha512.New()}
+	},
+}
+
+// hmacSHA512 returns HMAC-SHA512(key, msg), the same as hmac.New(sha512.New,
+// key) would, but allocating only the result
+func hmacSHA512(key, msg []byte) []byte {
+	s := hmacPool.Get().(*hmacState)
+	defer func() {
+		s.inner.Reset()
+		s.outer.Reset()
+		wipeBytes(s.pad[:])
+		wipeBytes(s.sum[:])
+		hmacPool.Put(s)
+	}()
+
+	// Keys longer than a block are hashed first, as RFC 2104 says
+	if len(key) > sha512.BlockSize {
+		s.inner.Reset()
+		s.inner.Write(key)
+		key = s.inner.Sum(s.sum[:0])
+	}
+	n := copy(s.pad[:], key)
+	wipeBytes(s.pad[n:])
+
+	for i := range s.pad {
+		s.pad[i] ^= 0x36
+	}
+	s.inner.Reset()
+	s.inner.Write(s.pad[:])
+	s.inner.Write(msg)
+	s.inner.Sum(s.sum[:0])
+
+	for i := range s.pad {
+		s.pad[i] ^= 0x36 ^ 0x5c
+	}
+	s.outer.Reset()
+	s.outer.Write(s.pad[:])
+	s.outer.Write(s.sum[:])
+	return s.outer.Sum(make([]byte, 0, sha512.Size))
+}
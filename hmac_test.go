@@ -0,0 +1,64 @@
+package taplink
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHMACSHA512(t *testing.T) {
+	msg := []byte("message")
+	for _, n := range []int{0, 1, SaltSize, sha512.BlockSize, sha512.BlockSize + 1, 300} {
+		key := bytes.Repeat([]byte{byte(n)}, n)
+		mac := hmac.New(sha512.New, key)
+		mac.Write(msg)
+		assert.Equal(t, mac.Sum(nil), hmacSHA512(key, msg), n)
+	}
+
+	// The published test vectors still match
+	salt := &Salt{Salt: hexString(vectorSaltV2).Bytes(), VersionID: 2, NewSalt: hexString(vectorSaltV3).Bytes(), NewVersionID: 3}
+	vp := verifyWithSalt(salt, vectorHash1(), hexString(vectorHashV2).Bytes())
+	assert.True(t, vp.Matched)
+	assert.Equal(t, vectorHashV3, vp.NewHashHex())
+	np := newPasswordWithSalt(&Salt{Salt: hexString(vectorSaltV3).Bytes(), VersionID: 3}, vectorHash1())
+	assert.Equal(t, vectorHashV3, np.String())
+}
+
+func TestHMACSHA512Allocs(t *testing.T) {
+	key, msg := hexString(vectorSaltV3).Bytes(), vectorHash1()
+	hmacSHA512(key, msg)
+	allocs := testing.AllocsPerRun(100, func() { hmacSHA512(key, msg) })
+	assert.LessOrEqual(t, allocs, float64(1))
+}
+
+// BenchmarkVerifyWithSalt compares the allocations of verifying a password
+// with an upgrade pending using hmac.New and the pooled HMACs
+func BenchmarkVerifyWithSalt(b *testing.B) {
+	salt2, salt3 := hexString(vectorSaltV2).Bytes(), hexString(vectorSaltV3).Bytes()
+	hash1, expected := vectorHash1(), hexString(vectorHashV2).Bytes()
+	b.Run("hmac.New", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sum := hmac.New(sha512.New, salt2)
+			sum.Write(hash1)
+			vp := &VerifyPassword{Hash: sum.Sum(nil), VersionID: 2, NewVersionID: 3}
+			vp.Matched = bytes.Equal(vp.Hash, expected)
+			sum2 := hmac.New(sha512.New, salt3)
+			sum2.Write(hash1)
+			vp.NewHash = sum2.Sum(nil)
+		}
+	})
+	b.Run("Pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			// verifyWithSalt wipes the salt, so it gets copies
+			s := &Salt{Salt: append([]byte(nil), salt2...), VersionID: 2, NewSalt: append([]byte(nil), salt3...), NewVersionID: 3}
+			if !verifyWithSalt(s, hash1, expected).Matched {
+				b.Fatal("didn't match")
+			}
+		}
+	})
+}
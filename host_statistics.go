@@ -1,13 +1,22 @@
 package taplink
 
 import (
+	"math"
+	"sort"
 	"sync"
 	"time"
 )
 
+// DefaultStatsCapacity is the number of events of each kind (successes,
+// errors, timeouts, queue waits, probes and request phases) the built-in
+// stats keep for each host.
+// Once a host has that many, the oldest is dropped for each new one.
+var DefaultStatsCapacity = 10000
+
 var (
-	_ HostStats  = (*hostStatistics)(nil)
-	_ Statistics = (*statistics)(nil)
+	_ HostStats     = (*hostStatistics)(nil)
+	_ HostStatsView = (*hostStatistics)(nil)
+	_ Statistics    = (*statistics)(nil)
 )
 
 // Latency is a slice of duration of the requests.
@@ -30,6 +39,96 @@ func (l Latency) Len() int {
 	return len([]time.Duration(l))
 }
 
+// sorted returns a sorted copy of the slice, so the receiver isn't changed
+func (l Latency) sorted() Latency {
+	s := append(Latency(nil), l...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	return s
+}
+
+// Min returns the lowest latency, or 0 for an empty slice
+func (l Latency) Min() time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	min := l[0]
+	for _, d := range l[1:] {
+		if d < min {
+			min = d
+		}
+	}
+	return min
+}
+
+// Max returns the highest latency, or 0 for an empty slice
+func (l Latency) Max() time.Duration {
+	var max time.Duration
+	for i, d := range l {
+		if i == 0 || d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+// Median returns the median latency, which for an even length is the
+// average of the middle two
+func (l Latency) Median() time.Duration {
+	return l.Percentile(0.5)
+}
+
+// StdDev returns the population standard deviation of the latency
+func (l Latency) StdDev() time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	avg := float64(l.Avg())
+	var sum float64
+	for _, d := range l {
+		diff := float64(d) - avg
+		sum += diff * diff
+	}
+	return time.Duration(math.Sqrt(sum / float64(len(l))))
+}
+
+// Percentile returns the pth percentile of the latency, where p is between 0
+// and 1, e.g. Percentile(0.99) for p99. Values between two latencies are
+// interpolated linearly. A p outside of [0, 1] is clamped, and an empty slice
+// returns 0.
+func (l Latency) Percentile(p float64) time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	if p < 0 || math.IsNaN(p) {
+		p = 0
+	} else if p > 1 {
+		p = 1
+	}
+	s := l.sorted()
+	rank := p * float64(len(s)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	frac := rank - float64(lo)
+	return s[lo] + time.Duration(frac*float64(s[hi]-s[lo]))
+}
+
+// TransportErrorCode is the code errors without a usable response are
+// recorded with, e.g. a connection reset or an empty or unexpected body
+const TransportErrorCode = 999
+
+// ProxyErrorCode is the code requests which failed to reach the host through
+// the proxy are recorded with, so a broken proxy can be told apart from a
+// host which is down
+const ProxyErrorCode = 998
+
+// EmptyResponseCode and ResponseTooLargeCode are the codes successful
+// responses which can't be used are recorded with: ones with no body, and
+// ones larger than the max response size
+const (
+	EmptyResponseCode    = 997
+	ResponseTooLargeCode = 996
+)
+
 // Errors is a map of how error codes (key) and count of those codes (value)
 type Errors map[int]int
 
@@ -43,28 +142,117 @@ func (e Errors) Len() (l int) {
 
 // Count returns the number of errors for the given code.
 func (e Errors) Count(code int) int {
-	for i, ct := range e {
-		if code == i {
-			return ct
-		}
+	return e[code]
+}
+
+// ErrorClasses are the errors of a host counted by their class
+type ErrorClasses struct {
+	// Client is the number of 4xx responses, which are usually caused by the
+	// request rather than the host, apart from 429 (throttling)
+	Client int
+	// Server is the number of 5xx responses
+	Server int
+	// Timeouts is the number of requests which timed out
+	Timeouts int
+	// Transport is the number of errors without a usable response, such as
+	// those recorded with TransportErrorCode or ProxyErrorCode
+	Transport int
+}
+
+// Len returns the total number of errors, including timeouts
+func (c ErrorClasses) Len() int {
+	return c.Client + c.Server + c.Timeouts + c.Transport
+}
+
+// classify adds ct errors with code to c
+func (c *ErrorClasses) classify(code, ct int) {
+	switch {
+	case code >= 400 && code < 500:
+		c.Client += ct
+	case code >= 500 && code < 600:
+		c.Server += ct
+	default:
+		c.Transport += ct
 	}
-	return 0
 }
 
-// HostStats defines an interface which provides detailed information about the
-// statistics related to connections to the given host.
-type HostStats interface {
+// HostStatsView defines every read accessor for the statistics of a host.
+// Every view of the stats implements it: the live stats from Statistics.Get,
+// copies, and the results of Last. An accessor a view can't support returns
+// its zero value.
+type HostStatsView interface {
+	Host() string
 	Errors() Errors
 	Requests() int
 	Timeouts() int
 	Latency() Latency
+	ErrorLatency() Latency
+	TimeoutLatency() Latency
+	AllLatency() Latency
+	QueueWait() Latency
 	ErrorRate() float64
+	Rate(window time.Duration) float64
+	InFlight() int
+	Score() float64
+	ErrorsByClass() ErrorClasses
+	ServerErrorRate() float64
+	CircuitState() CircuitState
+	Capacity() int
+	Retention() time.Duration
+	Totals() HostTotals
+	Probes() Probes
+	Phases() Phases
+}
+
+// Probes are the results of the health check probes to a host. They're kept
+// apart from requests, so they don't count towards Requests or ErrorRate.
+type Probes struct {
+	Healthy   int
+	Unhealthy int
+	// Latency is the latency of each probe, healthy or not
+	Latency Latency
+	// Failing is whether the latest probe was unhealthy
+	Failing bool
+}
+
+// HostTotals are the number of events recorded for a host since its stats
+// were created or last reset, including events which have since been dropped
+// because of the capacity or retention. They only go up between resets, so
+// they suit counters in metrics systems.
+type HostTotals struct {
+	// Requests is the number of successful requests, and Latency their total
+	// latency
+	Requests int64
+	Latency  time.Duration
+	Timeouts int64
+	// Errors is the number of errors for each code
+	Errors map[int]int64
+	// Delayed is the number of requests which waited on a rate limiter, and
+	// QueueWait the time they spent waiting in all
+	Delayed   int64
+	QueueWait time.Duration
+}
+
+func (t HostTotals) copyOf() HostTotals {
+	cp := t
+	cp.Errors = make(map[int]int64, len(t.Errors))
+	for code, ct := range t.Errors {
+		cp.Errors[code] = ct
+	}
+	return cp
+}
+
+// HostStats defines an interface which provides detailed information about the
+// statistics related to connections to the given host.
+type HostStats interface {
+	HostStatsView
 	Last(time.Duration) HostStats
 }
 
 type errorResp struct {
-	ts   time.Time
-	code int
+	ts      time.Time
+	code    int
+	latency time.Duration
 }
 
 type successResp struct {
@@ -73,35 +261,249 @@ type successResp struct {
 }
 
 type timeoutResp struct {
-	ts time.Time
+	ts      time.Time
+	latency time.Duration
+}
+
+type probeResp struct {
+	ts      time.Time
+	latency time.Duration
+	healthy bool
+}
+
+type phaseResp struct {
+	ts                            time.Time
+	dns, connect, tls, ttfb, body time.Duration
+	reused                        bool
 }
 
 type hostStatistics struct {
-	errors   []errorResp
-	timeouts []timeoutResp
-	latency  []successResp
-	host     string
+	errors     []errorResp
+	timeouts   []timeoutResp
+	latency    []successResp
+	queueWaits []successResp
+	probes     []probeResp
+	phases     []phaseResp
+	host       string
+
+	// errorCounts is the number of errors for each code, maintained as errors
+	// are added so that Errors() doesn't need to walk every error
+	errorCounts map[int]int64
+
+	circuit circuit
+
+	// capacity is the number of events of each kind which are kept, and
+	// retention how long they're kept for. Zero means no limit.
+	capacity  int
+	retention time.Duration
+
+	totals HostTotals
+
+	// window keeps the aggregates the host is ranked by
+	window rollingWindow
+
+	// score is the host's failure score, and halfLife its half-life, or 0
+	// for DefaultScoreHalfLife
+	score    ewma
+	halfLife time.Duration
+
+	// inFlight is the number of requests to the host in progress when the
+	// view was taken. The built-in stats count them apart from the events, so
+	// a host isn't added by a request until its outcome is recorded.
+	inFlight int
+
+	// clock is the clock events are timestamped and windowed with, or nil
+	// for the real one. It's set when the stats are created.
+	clock clock
 
 	mu sync.RWMutex
 }
 
+// now returns the time of the stats' clock
+func (s *hostStatistics) now() time.Time {
+	return clockNow(s.clock)
+}
+
 func newHostStatistics(host string) *hostStatistics {
 	return &hostStatistics{
-		host:     host,
-		errors:   make([]errorResp, 0),
-		latency:  make([]successResp, 0),
-		timeouts: make([]timeoutResp, 0),
+		host:        host,
+		capacity:    DefaultStatsCapacity,
+		errors:      make([]errorResp, 0),
+		latency:     make([]successResp, 0),
+		timeouts:    make([]timeoutResp, 0),
+		queueWaits:  make([]successResp, 0),
+		probes:      make([]probeResp, 0),
+		phases:      make([]phaseResp, 0),
+		errorCounts: make(map[int]int64),
 	}
 }
 
-// CopyOf returns a copy of the hostStatistics without copying the lock
+// CopyOf returns a copy of the hostStatistics without copying the lock. The
+// copy doesn't change as more events are recorded.
 func (s *hostStatistics) CopyOf() hostStatistics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := make(map[int]int64, len(s.errorCounts))
+	for code, ct := range s.errorCounts {
+		counts[code] = ct
+	}
 	return hostStatistics{
-		errors:   s.errors,
-		timeouts: s.timeouts,
-		latency:  s.latency,
-		host:     s.host,
+		errors:      s.errors,
+		timeouts:    s.timeouts,
+		latency:     s.latency,
+		queueWaits:  s.queueWaits,
+		probes:      s.probes,
+		phases:      s.phases,
+		host:        s.host,
+		errorCounts: counts,
+		circuit:     s.circuit,
+		capacity:    s.capacity,
+		retention:   s.retention,
+		totals:      s.totals.copyOf(),
+		window:      s.window,
+		score:       s.score,
+		halfLife:    s.halfLife,
+		inFlight:    s.inFlight,
+		clock:       s.clock,
+	}
+}
+
+// dropCount returns how many of the n oldest events to drop so that at most
+// capacity are left, none of which are older than cutoff
+func dropCount(n, capacity int, cutoff time.Time, ts func(i int) time.Time) int {
+	drop := 0
+	if capacity > 0 && n > capacity {
+		drop = n - capacity
+	}
+	if !cutoff.IsZero() {
+		for drop < n && ts(drop).Before(cutoff) {
+			drop++
+		}
+	}
+	return drop
+}
+
+// trim drops the events which are over capacity or older than the retention.
+// It must be called with s.mu held.
+func (s *hostStatistics) trim(now time.Time) {
+	var cutoff time.Time
+	if s.retention > 0 {
+		cutoff = now.Add(-s.retention)
+	}
+	n := dropCount(len(s.errors), s.capacity, cutoff, func(i int) time.Time { return s.errors[i].ts })
+	for _, e := range s.errors[:n] {
+		if s.errorCounts[e.code]--; s.errorCounts[e.code] <= 0 {
+			delete(s.errorCounts, e.code)
+		}
+	}
+	s.window.dropErrors(s.errors[:n])
+	s.errors = s.errors[n:]
+	n = dropCount(len(s.timeouts), s.capacity, cutoff, func(i int) time.Time { return s.timeouts[i].ts })
+	s.window.dropTimeouts(s.timeouts[:n])
+	s.timeouts = s.timeouts[n:]
+	n = dropCount(len(s.latency), s.capacity, cutoff, func(i int) time.Time { return s.latency[i].ts })
+	s.window.dropLatency(s.latency[:n])
+	s.latency = s.latency[n:]
+	s.queueWaits = s.queueWaits[dropCount(len(s.queueWaits), s.capacity, cutoff, func(i int) time.Time { return s.queueWaits[i].ts }):]
+	s.probes = s.probes[dropCount(len(s.probes), s.capacity, cutoff, func(i int) time.Time { return s.probes[i].ts }):]
+	s.phases = s.phases[dropCount(len(s.phases), s.capacity, cutoff, func(i int) time.Time { return s.phases[i].ts }):]
+}
+
+// reset clears the recorded events. The limits and the circuit breaker state
+// are kept, as they aren't stats.
+func (s *hostStatistics) reset() {
+	s.mu.Lock()
+	s.errors = make([]errorResp, 0)
+	s.timeouts = make([]timeoutResp, 0)
+	s.latency = make([]successResp, 0)
+	s.queueWaits = make([]successResp, 0)
+	s.probes = make([]probeResp, 0)
+	s.phases = make([]phaseResp, 0)
+	s.errorCounts = make(map[int]int64)
+	s.totals = HostTotals{}
+	s.window = rollingWindow{}
+	s.score = ewma{}
+	s.mu.Unlock()
+}
+
+// setLimits sets the capacity and retention, and drops any events outside
+// of them
+func (s *hostStatistics) setLimits(capacity int, retention time.Duration) {
+	s.mu.Lock()
+	s.capacity, s.retention = capacity, retention
+	s.trim(s.now())
+	s.mu.Unlock()
+}
+
+// Capacity returns the number of events of each kind which are kept, or 0
+// if there's no limit
+func (s *hostStatistics) Capacity() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.capacity
+}
+
+// Totals returns the number of events recorded since the stats were created
+// or reset. For the results of Last, they're the events in the window.
+func (s *hostStatistics) Totals() HostTotals {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.totals.copyOf()
+}
+
+// Retention returns how long events are kept for, or 0 if there's no limit
+func (s *hostStatistics) Retention() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.retention
+}
+
+// addSuccess records a successful response with the given latency
+func (s *hostStatistics) addSuccess(latency time.Duration) {
+	s.mu.Lock()
+	now := s.now()
+	s.latency = append(s.latency, successResp{now, latency})
+	s.window.latencySum += latency
+	s.score.add(now, s.halfLife, false)
+	s.totals.Requests++
+	s.totals.Latency += latency
+	s.trim(now)
+	s.mu.Unlock()
+}
+
+// addTimeout records a request which timed out after latency
+func (s *hostStatistics) addTimeout(latency time.Duration) {
+	s.mu.Lock()
+	now := s.now()
+	s.timeouts = append(s.timeouts, timeoutResp{now, latency})
+	s.window.addPenalty(latency)
+	s.score.add(now, s.halfLife, true)
+	s.totals.Timeouts++
+	s.trim(now)
+	s.mu.Unlock()
+}
+
+// addError records an error response with the given code, which took
+// latency
+func (s *hostStatistics) addError(code int, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.errorCounts == nil {
+		s.errorCounts = make(map[int]int64)
 	}
+	now := s.now()
+	s.errors = append(s.errors, errorResp{now, code, latency})
+	if serverFailure(code) {
+		s.window.failed++
+	}
+	s.window.addPenalty(latency)
+	s.score.add(now, s.halfLife, serverFailure(code))
+	s.errorCounts[code]++
+	if s.totals.Errors == nil {
+		s.totals.Errors = make(map[int]int64)
+	}
+	s.totals.Errors[code]++
+	s.trim(now)
 }
 
 func (s *hostStatistics) Host() string {
@@ -111,11 +513,11 @@ func (s *hostStatistics) Host() string {
 }
 
 func (s *hostStatistics) Errors() Errors {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	errs := make(map[int]int, 0)
-	for i := range s.errors {
-		errs[s.errors[i].code]++
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	errs := make(map[int]int, len(s.errorCounts))
+	for code, ct := range s.errorCounts {
+		errs[code] = int(ct)
 	}
 	return Errors(errs)
 }
@@ -136,6 +538,142 @@ func (s *hostStatistics) Latency() Latency {
 	return Latency(lat)
 }
 
+// ErrorLatency returns the latency of each error, which is 0 for errors
+// recorded without one
+func (s *hostStatistics) ErrorLatency() Latency {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lat := make([]time.Duration, len(s.errors))
+	for i := range s.errors {
+		lat[i] = s.errors[i].latency
+	}
+	return Latency(lat)
+}
+
+// TimeoutLatency returns how long each request which timed out took to, which
+// is 0 for timeouts recorded without it
+func (s *hostStatistics) TimeoutLatency() Latency {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lat := make([]time.Duration, len(s.timeouts))
+	for i := range s.timeouts {
+		lat[i] = s.timeouts[i].latency
+	}
+	return Latency(lat)
+}
+
+// AllLatency returns the latency of each success, error and timeout, in the
+// order they were recorded
+func (s *hostStatistics) AllLatency() Latency {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := make([]successResp, 0, len(s.latency)+len(s.errors)+len(s.timeouts))
+	all = append(all, s.latency...)
+	for _, e := range s.errors {
+		all = append(all, successResp{e.ts, e.latency})
+	}
+	for _, t := range s.timeouts {
+		all = append(all, successResp{t.ts, t.latency})
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].ts.Before(all[j].ts) })
+	lat := make([]time.Duration, len(all))
+	for i := range all {
+		lat[i] = all[i].latency
+	}
+	return Latency(lat)
+}
+
+// addQueueWait records time spent waiting on a rate limiter
+func (s *hostStatistics) addQueueWait(wait time.Duration) {
+	s.mu.Lock()
+	now := s.now()
+	s.queueWaits = append(s.queueWaits, successResp{now, wait})
+	if wait > 0 {
+		s.totals.Delayed++
+		s.totals.QueueWait += wait
+	}
+	s.trim(now)
+	s.mu.Unlock()
+}
+
+// QueueWait returns the time each request spent waiting on a rate limiter
+func (s *hostStatistics) QueueWait() Latency {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lat := make([]time.Duration, len(s.queueWaits))
+	for i := range s.queueWaits {
+		lat[i] = s.queueWaits[i].latency
+	}
+	return Latency(lat)
+}
+
+// addProbe records a health check probe
+func (s *hostStatistics) addProbe(latency time.Duration, healthy bool) {
+	s.mu.Lock()
+	now := s.now()
+	s.probes = append(s.probes, probeResp{now, latency, healthy})
+	s.trim(now)
+	s.mu.Unlock()
+}
+
+// Probes returns the results of the health check probes to the host
+func (s *hostStatistics) Probes() Probes {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p := Probes{Latency: make(Latency, len(s.probes))}
+	for i, pr := range s.probes {
+		p.Latency[i] = pr.latency
+		if pr.healthy {
+			p.Healthy++
+		} else {
+			p.Unhealthy++
+		}
+	}
+	if n := len(s.probes); n > 0 {
+		p.Failing = !s.probes[n-1].healthy
+	}
+	return p
+}
+
+// addPhases records the phase timings of a request
+func (s *hostStatistics) addPhases(t *RequestTrace) {
+	s.mu.Lock()
+	now := s.now()
+	s.phases = append(s.phases, phaseResp{now, t.DNS, t.Connect, t.TLS, t.TimeToFirstByte, t.BodyRead, t.Reused})
+	s.trim(now)
+	s.mu.Unlock()
+}
+
+// Phases returns the timings of the phases of the requests to the host
+func (s *hostStatistics) Phases() Phases {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var p Phases
+	for _, ph := range s.phases {
+		if ph.reused {
+			p.Reused++
+		} else {
+			p.New++
+		}
+		if ph.dns > 0 {
+			p.DNS = append(p.DNS, ph.dns)
+		}
+		if ph.connect > 0 {
+			p.Connect = append(p.Connect, ph.connect)
+		}
+		if ph.tls > 0 {
+			p.TLS = append(p.TLS, ph.tls)
+		}
+		if ph.ttfb > 0 {
+			p.TimeToFirstByte = append(p.TimeToFirstByte, ph.ttfb)
+		}
+		if ph.body > 0 {
+			p.BodyRead = append(p.BodyRead, ph.body)
+		}
+	}
+	return p
+}
+
 func (s *hostStatistics) Timeouts() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -145,6 +683,11 @@ func (s *hostStatistics) Timeouts() int {
 func (s *hostStatistics) ErrorRate() float64 {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.errorRate()
+}
+
+// errorRate must be called with s.mu held
+func (s *hostStatistics) errorRate() float64 {
 	errCt := len(s.timeouts) + len(s.errors)
 	totalCt := len(s.latency) + len(s.timeouts) + len(s.errors)
 	if errCt == 0 {
@@ -153,6 +696,89 @@ func (s *hostStatistics) ErrorRate() float64 {
 	return float64(errCt) / float64(totalCt)
 }
 
+// ErrorsByClass returns the errors and timeouts by class. For a breakdown
+// over time, call it on the result of Last.
+func (s *hostStatistics) ErrorsByClass() ErrorClasses {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.errorClasses()
+}
+
+// errorClasses must be called with s.mu held
+func (s *hostStatistics) errorClasses() ErrorClasses {
+	c := ErrorClasses{Timeouts: len(s.timeouts)}
+	for code, ct := range s.errorCounts {
+		c.classify(code, int(ct))
+	}
+	return c
+}
+
+// ServerErrorRate is like ErrorRate, but counts client errors (4xx) as
+// answered requests rather than failures, like the circuit breaker does. A
+// host answering requests with a bad AppID with 401 is working fine, so
+// hosts are ranked and pruned by this rate.
+func (s *hostStatistics) ServerErrorRate() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.serverErrorRate()
+}
+
+// serverErrorRate must be called with s.mu held
+func (s *hostStatistics) serverErrorRate() float64 {
+	c := s.errorClasses()
+	failed := c.Server + c.Timeouts + c.Transport
+	if failed == 0 {
+		return 0
+	}
+	return float64(failed) / float64(len(s.latency)+c.Len())
+}
+
+// Rate returns the number of requests per second over the last window,
+// counting successes, errors and timeouts alike
+func (s *hostStatistics) Rate(window time.Duration) float64 {
+	if window <= 0 {
+		return 0
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u := s.now().Add(-window)
+	n := 0
+	for i := range s.latency {
+		if !s.latency[i].ts.Before(u) {
+			n++
+		}
+	}
+	for i := range s.errors {
+		if !s.errors[i].ts.Before(u) {
+			n++
+		}
+	}
+	for i := range s.timeouts {
+		if !s.timeouts[i].ts.Before(u) {
+			n++
+		}
+	}
+	return float64(n) / window.Seconds()
+}
+
+// InFlight returns the number of requests to the host which were in
+// progress when the stats were taken from Statistics.Get, whether or not
+// stats are enabled
+func (s *hostStatistics) InFlight() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.inFlight
+}
+
+// CircuitState returns the state of the host's circuit breaker, which is
+// always closed if the breaker isn't enabled. The results of Last don't
+// carry the state.
+func (s *hostStatistics) CircuitState() CircuitState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.circuit.state
+}
+
 // Since returns a subset of the host statistics for events which happened between now and since.
 func (s *hostStatistics) Last(last time.Duration) HostStats {
 
@@ -160,33 +786,69 @@ func (s *hostStatistics) Last(last time.Duration) HostStats {
 	lat := s.latency
 	errs := s.errors
 	tos := s.timeouts
+	qws := s.queueWaits
+	prs := s.probes
+	phs := s.phases
+	om := hostStatistics{host: s.host, errorCounts: make(map[int]int64), capacity: s.capacity, retention: s.retention, inFlight: s.inFlight, score: s.score, halfLife: s.halfLife, clock: s.clock}
 	s.mu.RUnlock()
 
-	var om hostStatistics
 	if last > 0 {
 		last *= -1
 	}
-	u := time.Now().Add(last)
+	u := s.now().Add(last)
 	for i := range lat {
-		if s.latency[i].ts.Before(u) {
+		if lat[i].ts.Before(u) {
 			continue
 		}
 		om.latency = append(om.latency, lat[i])
 	}
 
 	for i := range errs {
-		if s.errors[i].ts.Before(u) {
+		if errs[i].ts.Before(u) {
 			continue
 		}
 		om.errors = append(om.errors, errs[i])
+		om.errorCounts[errs[i].code]++
 	}
 
 	for i := range tos {
-		if s.timeouts[i].ts.Before(u) {
+		if tos[i].ts.Before(u) {
 			continue
 		}
 		om.timeouts = append(om.timeouts, tos[i])
 	}
 
+	for i := range qws {
+		if qws[i].ts.Before(u) {
+			continue
+		}
+		om.queueWaits = append(om.queueWaits, qws[i])
+	}
+
+	for i := range prs {
+		if prs[i].ts.Before(u) {
+			continue
+		}
+		om.probes = append(om.probes, prs[i])
+	}
+
+	for i := range phs {
+		if phs[i].ts.Before(u) {
+			continue
+		}
+		om.phases = append(om.phases, phs[i])
+	}
+
+	om.totals = HostTotals{Requests: int64(len(om.latency)), Timeouts: int64(len(om.timeouts)), Errors: om.errorCounts}
+	for _, l := range om.latency {
+		om.totals.Latency += l.latency
+	}
+	for _, qw := range om.queueWaits {
+		if qw.latency > 0 {
+			om.totals.Delayed++
+			om.totals.QueueWait += qw.latency
+		}
+	}
+	om.totals = om.totals.copyOf()
 	return &om
 }
@@ -14,18 +14,18 @@ func TestHostStatisticsHost(t *testing.T) {
 
 func TestHostStatisticsTimeouts(t *testing.T) {
 	c := New(testAppID).(*Client)
-	c.Stats().AddTimeout("foobar.com")
+	c.Stats().AddTimeout("foobar.com", 0)
 	assert.Equal(t, int(0), c.Stats().Get("foobar.com").Timeouts())
 	c.Stats().Enable()
-	c.Stats().AddTimeout("foobar.com")
+	c.Stats().AddTimeout("foobar.com", 0)
 	assert.Equal(t, int(1), c.Stats().Get("foobar.com").Timeouts())
 }
 
 func TestHostStatisticsErrors(t *testing.T) {
 	c := New(testAppID).(*Client)
 	c.Stats().Enable()
-	c.Stats().AddError("foobar.com", 503)
-	c.Stats().AddError("foobar.com", 500)
+	c.Stats().AddError("foobar.com", 503, 0)
+	c.Stats().AddError("foobar.com", 500, 0)
 	assert.Equal(t, 2, c.Stats().Get("foobar.com").Errors().Len())
 	assert.Equal(t, 1, c.Stats().Get("foobar.com").Errors().Count(503))
 	assert.Equal(t, 1, c.Stats().Get("foobar.com").Errors().Count(500))
@@ -34,15 +34,16 @@ func TestHostStatisticsErrors(t *testing.T) {
 
 func TestHostStatisticsLast(t *testing.T) {
 	c := New(testAppID).(*Client)
+	clk := useClock(c)
 	c.Stats().Enable()
-	c.Stats().AddError("foobar.com", 503)
+	c.Stats().AddError("foobar.com", 503, 0)
 	c.Stats().AddSuccess("foobar.com", time.Millisecond)
 	c.Stats().AddSuccess("foobar.com", time.Millisecond*3)
-	c.Stats().AddTimeout("foobar.com")
-	time.Sleep(2 * time.Second)
-	c.Stats().AddError("foobar.com", 503)
+	c.Stats().AddTimeout("foobar.com", 0)
+	clk.Advance(2 * time.Second)
+	c.Stats().AddError("foobar.com", 503, 0)
 	c.Stats().AddSuccess("foobar.com", time.Millisecond)
-	c.Stats().AddTimeout("foobar.com")
+	c.Stats().AddTimeout("foobar.com", 0)
 	assert.Equal(t, int(3), c.Stats().Get("foobar.com").Latency().Len())
 	assert.Equal(t, int(1), c.Stats().Get("foobar.com").Last(time.Second).Latency().Len())
 	assert.Equal(t, int(2), c.Stats().Get("foobar.com").Errors().Len())
@@ -53,3 +54,222 @@ func TestHostStatisticsLast(t *testing.T) {
 	assert.Equal(t, float64(4)/float64(7), c.Stats().Get("foobar.com").ErrorRate())
 
 }
+
+func TestHostStatisticsErrorCounts(t *testing.T) {
+	s := newHostStatistics("foobar.com")
+	s.addError(503, 0)
+	s.addError(503, 0)
+	s.addError(500, 0)
+	assert.Equal(t, Errors{503: 2, 500: 1}, s.Errors())
+
+	// Errors() returns a copy, changing it doesn't change the stats.
+	s.Errors()[503] = 10
+	assert.Equal(t, 2, s.Errors().Count(503))
+
+	cp := s.CopyOf()
+	s.addError(500, 0)
+	assert.Equal(t, 1, cp.Errors().Count(500))
+	assert.Equal(t, 2, s.Errors().Count(500))
+	assert.Equal(t, Errors{503: 2, 500: 2}, s.Last(time.Minute).Errors())
+}
+
+func newBenchHostStatistics(n int) *hostStatistics {
+	s := newHostStatistics("foobar.com")
+	codes := []int{500, 502, 503, 504, 999}
+	for i := 0; i < n; i++ {
+		s.addError(codes[i%len(codes)], 0)
+	}
+	return s
+}
+
+// BenchmarkHostStatisticsErrors is the access pattern of an exporter, which
+// gets the error counts for each host on every scrape.
+func BenchmarkHostStatisticsErrors(b *testing.B) {
+	s := newBenchHostStatistics(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		errs := s.Errors()
+		_ = errs.Count(503)
+		_ = errs.Len()
+	}
+}
+
+// scanErrors is how Errors() used to count errors, by walking every recorded
+// error. It's kept to compare against in BenchmarkHostStatisticsErrorsScan.
+func scanErrors(s *hostStatistics) Errors {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	errs := make(map[int]int, 0)
+	for i := range s.errors {
+		errs[s.errors[i].code]++
+	}
+	return Errors(errs)
+}
+
+// BenchmarkHostStatisticsErrorsScan is the exporter access pattern of
+// BenchmarkHostStatisticsErrors, using the previous scan based counting.
+func BenchmarkHostStatisticsErrorsScan(b *testing.B) {
+	s := newBenchHostStatistics(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		errs := scanErrors(s)
+		_ = errs.Count(503)
+		_ = errs.Len()
+	}
+}
+
+// BenchmarkHostStatisticsLastErrors gets the error counts for a window, which
+// still needs to walk the timestamped errors.
+func BenchmarkHostStatisticsLastErrors(b *testing.B) {
+	s := newBenchHostStatistics(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = s.Last(time.Minute).Errors().Count(503)
+	}
+}
+
+func TestHostStatsViewConformance(t *testing.T) {
+	s := newHostStatistics("foo.com")
+	s.addSuccess(10 * time.Millisecond)
+	s.addSuccess(30 * time.Millisecond)
+	s.addError(503, 0)
+	s.addError(503, 0)
+	s.addError(500, 0)
+	s.addError(401, 0)
+	s.addError(TransportErrorCode, 0)
+	s.addTimeout(0)
+	s.addQueueWait(5 * time.Millisecond)
+	s.addProbe(2*time.Millisecond, true)
+	s.addProbe(3*time.Millisecond, false)
+	s.addPhases(&RequestTrace{DNS: time.Millisecond, Connect: 2 * time.Millisecond, TimeToFirstByte: 4 * time.Millisecond})
+	s.addPhases(&RequestTrace{TimeToFirstByte: 5 * time.Millisecond, BodyRead: time.Millisecond, Reused: true})
+	cp := s.CopyOf()
+
+	views := []struct {
+		name string
+		view HostStatsView
+	}{
+		{"live", s},
+		{"copy", &cp},
+		{"last", s.Last(time.Hour)},
+	}
+	for _, v := range views {
+		assert.Equal(t, "foo.com", v.view.Host(), v.name)
+		assert.Equal(t, Errors{503: 2, 500: 1, 401: 1, TransportErrorCode: 1}, v.view.Errors(), v.name)
+		assert.Equal(t, 2, v.view.Requests(), v.name)
+		assert.Equal(t, 1, v.view.Timeouts(), v.name)
+		assert.Equal(t, Latency{10 * time.Millisecond, 30 * time.Millisecond}, v.view.Latency(), v.name)
+		assert.Equal(t, Latency{5 * time.Millisecond}, v.view.QueueWait(), v.name)
+		assert.Equal(t, float64(6)/float64(8), v.view.ErrorRate(), v.name)
+		assert.Equal(t, ErrorClasses{Client: 1, Server: 3, Timeouts: 1, Transport: 1}, v.view.ErrorsByClass(), v.name)
+		assert.Equal(t, float64(5)/float64(8), v.view.ServerErrorRate(), v.name)
+		assert.Equal(t, float64(8)/60, v.view.Rate(time.Minute), v.name)
+		assert.Equal(t, 0, v.view.InFlight(), v.name)
+		assert.InDelta(t, s.Score(), v.view.Score(), 1e-3, v.name)
+		assert.Equal(t, CircuitClosed, v.view.CircuitState(), v.name)
+		assert.Equal(t, DefaultStatsCapacity, v.view.Capacity(), v.name)
+		assert.Equal(t, time.Duration(0), v.view.Retention(), v.name)
+		assert.Equal(t, HostTotals{Requests: 2, Latency: 40 * time.Millisecond, Timeouts: 1, Errors: map[int]int64{503: 2, 500: 1, 401: 1, TransportErrorCode: 1}, Delayed: 1, QueueWait: 5 * time.Millisecond}, v.view.Totals(), v.name)
+		assert.Equal(t, Probes{Healthy: 1, Unhealthy: 1, Latency: Latency{2 * time.Millisecond, 3 * time.Millisecond}, Failing: true}, v.view.Probes(), v.name)
+		assert.Equal(t, Phases{
+			DNS:             Latency{time.Millisecond},
+			Connect:         Latency{2 * time.Millisecond},
+			TimeToFirstByte: Latency{4 * time.Millisecond, 5 * time.Millisecond},
+			BodyRead:        Latency{time.Millisecond},
+			Reused:          1,
+			New:             1,
+		}, v.view.Phases(), v.name)
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	ms := time.Millisecond
+	var empty Latency
+	assert.Equal(t, time.Duration(0), empty.Min())
+	assert.Equal(t, time.Duration(0), empty.Max())
+	assert.Equal(t, time.Duration(0), empty.Median())
+	assert.Equal(t, time.Duration(0), empty.StdDev())
+	assert.Equal(t, time.Duration(0), empty.Percentile(0.99))
+
+	odd := Latency{5 * ms, 1 * ms, 3 * ms, 2 * ms, 4 * ms}
+	assert.Equal(t, 1*ms, odd.Min())
+	assert.Equal(t, 5*ms, odd.Max())
+	assert.Equal(t, 3*ms, odd.Median())
+	// The receiver isn't sorted in place.
+	assert.Equal(t, Latency{5 * ms, 1 * ms, 3 * ms, 2 * ms, 4 * ms}, odd)
+
+	even := Latency{4 * ms, 1 * ms, 3 * ms, 2 * ms}
+	assert.Equal(t, 2500*time.Microsecond, even.Median())
+
+	// 1ms to 100ms
+	var uniform Latency
+	for i := 100; i > 0; i-- {
+		uniform = append(uniform, time.Duration(i)*ms)
+	}
+	assert.Equal(t, 1*ms, uniform.Percentile(0))
+	assert.Equal(t, 100*ms, uniform.Percentile(1))
+	assert.Equal(t, 99010*time.Microsecond, uniform.Percentile(0.99))
+	assert.Equal(t, 50500*time.Microsecond, uniform.Median())
+
+	// Out of range values are clamped.
+	assert.Equal(t, 1*ms, uniform.Percentile(-1))
+	assert.Equal(t, 100*ms, uniform.Percentile(2))
+
+	constant := Latency{2 * ms, 2 * ms, 2 * ms}
+	assert.Equal(t, time.Duration(0), constant.StdDev())
+	assert.Equal(t, 1*ms, Latency{1 * ms, 3 * ms, 1 * ms, 3 * ms}.StdDev())
+}
+
+func TestHostStatisticsCapacity(t *testing.T) {
+	c := New(testAppID, WithStatsLimits(3, 0)).(*Client)
+	c.Stats().Enable()
+	for i := 1; i <= 5; i++ {
+		c.Stats().AddSuccess("foo.com", time.Duration(i)*time.Millisecond)
+	}
+	c.Stats().AddError("foo.com", 500, 0)
+	for i := 0; i < 3; i++ {
+		c.Stats().AddError("foo.com", 503, 0)
+	}
+	hs := c.Stats().Get("foo.com")
+	assert.Equal(t, 3, hs.Capacity())
+	assert.Equal(t, time.Duration(0), hs.Retention())
+	assert.Equal(t, Latency{3 * time.Millisecond, 4 * time.Millisecond, 5 * time.Millisecond}, hs.Latency())
+	assert.Equal(t, 3, hs.Requests())
+	// The dropped error isn't counted any more.
+	assert.Equal(t, Errors{503: 3}, hs.Errors())
+	assert.Equal(t, 0.5, hs.ErrorRate())
+	assert.Equal(t, 3, hs.Last(time.Hour).Requests())
+	assert.Equal(t, 3, hs.Last(time.Hour).Capacity())
+
+	// The totals include the dropped events.
+	assert.Equal(t, HostTotals{Requests: 5, Latency: 15 * time.Millisecond, Errors: map[int]int64{500: 1, 503: 3}}, hs.Totals())
+	assert.Equal(t, int64(3), hs.Last(time.Hour).Totals().Requests)
+	c.Stats().ResetHost("foo.com")
+	assert.Equal(t, int64(0), c.Stats().Get("foo.com").Totals().Requests)
+}
+
+func TestHostStatisticsRetention(t *testing.T) {
+	c := New(testAppID, WithStatsLimits(0, 20*time.Millisecond)).(*Client)
+	c.Stats().Enable()
+	c.Stats().AddSuccess("foo.com", time.Millisecond)
+	c.Stats().AddError("foo.com", 503, 0)
+	c.Stats().AddTimeout("foo.com", 0)
+	time.Sleep(30 * time.Millisecond)
+	c.Stats().AddSuccess("foo.com", 2*time.Millisecond)
+
+	hs := c.Stats().Get("foo.com")
+	assert.Equal(t, 0, hs.Capacity())
+	assert.Equal(t, 20*time.Millisecond, hs.Retention())
+	assert.Equal(t, Latency{2 * time.Millisecond}, hs.Latency())
+	assert.Equal(t, 0, hs.Errors().Len())
+	assert.Equal(t, 0, hs.Timeouts())
+	assert.Equal(t, float64(0), hs.ErrorRate())
+}
+
+func TestHostStatisticsDefaultCapacity(t *testing.T) {
+	c := New(testAppID).(*Client)
+	assert.Equal(t, DefaultStatsCapacity, c.Stats().Get("foo.com").Capacity())
+}
@@ -0,0 +1,55 @@
+package taplink
+
+import (
+	"context"
+	"net/http"
+)
+
+// ClientFromContext, if set, returns the HTTP client to make the requests of
+// a call with ctx on, in place of HTTPClient. Returning nil uses HTTPClient.
+// As the client comes from each call's context, concurrent calls with
+// different contexts don't share it, unlike with a global HTTPClient.
+//
+// On App Engine it's set to return an urlfetch client for the context given
+// to the Context methods, such as VerifyPasswordContext, so there's no need
+// to call UseContext for each request. Calls without a context keep using
+// HTTPClient. Concurrent GetSalt calls which are coalesced share the first
+// caller's client, see SetCoalescing.
+var ClientFromContext func(ctx context.Context) *http.Client
+
+// httpClientFor returns the HTTP client to make requests with ctx on: the
+// one from ClientFromContext if there is one, and base otherwise
+func httpClientFor(ctx context.Context, base *http.Client) *http.Client {
+	if fn := ClientFromContext; fn != nil {
+		if client := fn(ctx); client != nil {
+			return client
+		}
+	}
+	return base
+}
+
+// baseHTTPClient returns the HTTP client requests are made on: the one given
+// with WithHTTPClient, or the HTTPClient, with the proxy and TLS settings
+func (c *Config) baseHTTPClient() *http.Client {
+	if client := c.transport.Load(); client != nil {
+		return client
+	}
+	return c.givenHTTPClient()
+}
+
+// givenHTTPClient returns the HTTP client given with WithHTTPClient, or the
+// HTTPClient, without the proxy and TLS settings
+func (c *Config) givenHTTPClient() *http.Client {
+	if c.httpClient != nil {
+		return c.httpClient
+	}
+	return c.globals.httpClient()
+}
+
+// baseHTTPClient returns the HTTP client of the client's config
+func (c *Client) baseHTTPClient() *http.Client {
+	if cfg, ok := c.cfg.(*Config); ok {
+		return cfg.baseHTTPClient()
+	}
+	return c.globals.httpClient()
+}
@@ -0,0 +1,54 @@
+package taplink
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeKey struct{}
+
+func TestClientFromContext(t *testing.T) {
+	_, restore := useScript()
+	defer restore()
+
+	// Each call's context carries its own fake, as each App Engine request
+	// context gives its own urlfetch client.
+	ClientFromContext = func(ctx context.Context) *http.Client {
+		if f, ok := ctx.Value(fakeKey{}).(*taplinktest.Fake); ok {
+			return &http.Client{Transport: f}
+		}
+		return nil
+	}
+	defer func() { ClientFromContext = nil }()
+
+	fakes := []*taplinktest.Fake{taplinktest.NewFake(2), taplinktest.NewFake(3)}
+	// Coalesced calls would share the first caller's client.
+	c := New(testAppID).(*Client)
+	c.SetCoalescing(false)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		f := fakes[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := context.WithValue(context.Background(), fakeKey{}, f)
+			p, err := c.NewPasswordContext(ctx, testHashBytes)
+			if assert.NoError(t, err) {
+				assert.Equal(t, f.LatestVersion(), p.VersionID)
+				assert.Equal(t, f.Hash(testHashBytes, f.LatestVersion()), p.Hash)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 10, fakes[0].Requests())
+	assert.Equal(t, 10, fakes[1].Requests())
+
+	// Without a client from the context, HTTPClient is used.
+	_, err := c.NewPassword(testHashBytes)
+	assert.ErrorIs(t, err, taplinktest.ErrNoOutcome)
+}
@@ -0,0 +1,159 @@
+package taplink
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// DefaultLatestVersionTTL is how long LatestVersion caches the latest data
+// pool version, unless it's set with SetLatestVersionTTL
+const DefaultLatestVersionTTL = time.Minute
+
+// latestVersionProbe is the hash salts are requested for to find the latest
+// version. It's fixed so the probe doesn't depend on any password.
+var latestVersionProbe = sha512.Sum512([]byte("taplink latest version probe"))
+
+// latestVersion caches the latest data pool version
+type latestVersion struct {
+	versionID int64
+	expires   time.Time
+
+	// fetching holds a token while the version is requested, so concurrent
+	// callers make one request, and the others can give up waiting when
+	// their context is done
+	fetching chan struct{}
+	mu       sync.Mutex
+}
+
+func newLatestVersion() *latestVersion {
+	return &latestVersion{fetching: make(chan struct{}, 1)}
+}
+
+// get returns the cached version, if it hasn't expired
+func (l *latestVersion) get() (int64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.versionID == 0 || time.Now().After(l.expires) {
+		return 0, false
+	}
+	return l.versionID, true
+}
+
+// set caches versionID for ttl. A ttl of 0 or less doesn't cache it.
+func (l *latestVersion) set(versionID int64, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	l.mu.Lock()
+	l.versionID, l.expires = versionID, time.Now().Add(ttl)
+	l.mu.Unlock()
+}
+
+// reset discards the cached version
+func (l *latestVersion) reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.versionID, l.expires = 0, time.Time{}
+	l.mu.Unlock()
+}
+
+// LatestVersion returns the latest data pool version, e.g. to decide whether
+// stored hashes need to be upgraded, without a password hash.
+func (c *Client) LatestVersion() (int64, error) {
+	return c.LatestVersionContext(context.Background())
+}
+
+// LatestVersionContext is like LatestVersion, but requests to the API are
+// made with ctx, and waiting for another caller's request gives up when ctx
+// is done.
+//
+// The version is found by requesting the salt for a fixed probe hash, and is
+// cached for the config's LatestVersionTTL. Salts requested for the latest
+// version, or offered as upgrades, update the cache too, and it's discarded
+// when a modified configuration is loaded.
+func (c *Client) LatestVersionContext(ctx context.Context) (int64, error) {
+	if c.ShutdownState() != StateRunning {
+		return 0, ErrClientClosed
+	}
+	if !validAppID(c.Config().AppID()) {
+		return 0, ErrInvalidAppID
+	}
+	if c.offlineSecret() != nil {
+		return OfflineVersionID, nil
+	}
+	if v, ok := c.latest.get(); ok {
+		return v, nil
+	}
+	select {
+	case c.latest.fetching <- struct{}{}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	defer func() { <-c.latest.fetching }()
+	// Another caller may have fetched it while this one waited.
+	if v, ok := c.latest.get(); ok {
+		return v, nil
+	}
+
+	resp, err := c.getFromAPIContext(ctx, c.Config().AppID(), hex.EncodeToString(latestVersionProbe[:]), Version(0).String())
+	if err != nil {
+		return 0, err
+	}
+	var sr saltResponse
+	if err := decodeJSON(resp.Body, &sr, c.Config().StrictDecoding()); err != nil {
+		return 0, err
+	}
+	salt, newSalt, err := decodeSaltResponse(&sr, c.Config().SaltEncoding())
+	if err != nil {
+		return 0, err
+	}
+	wipeBytes(salt)
+	wipeBytes(newSalt)
+
+	v := sr.VersionID
+	if sr.NewVersionID > v {
+		v = sr.NewVersionID
+	}
+	c.latest.set(v, c.Config().LatestVersionTTL())
+	return v, nil
+}
+
+// observeVersions updates the cached latest version from a salt response to
+// a request for versionID
+func (c *Client) observeVersions(versionID int64, sr *saltResponse) {
+	if c.latest == nil {
+		return
+	}
+	switch {
+	case sr.NewVersionID > sr.VersionID:
+		c.latest.set(sr.NewVersionID, c.Config().LatestVersionTTL())
+	case versionID == 0:
+		c.latest.set(sr.VersionID, c.Config().LatestVersionTTL())
+	}
+}
+
+// LatestVersionTTL returns how long the client caches the latest data pool
+// version
+func (c *Config) LatestVersionTTL() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.latestTTL
+}
+
+// SetLatestVersionTTL sets how long the client caches the latest data pool
+// version. A ttl of 0 disables the cache, so each LatestVersion makes a
+// request.
+func (c *Config) SetLatestVersionTTL(ttl time.Duration) {
+	c.Lock()
+	c.latestTTL = ttl
+	client := c.client
+	c.Unlock()
+	if client != nil && ttl <= 0 {
+		client.latest.reset()
+	}
+}
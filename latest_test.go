@@ -0,0 +1,125 @@
+package taplink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatestVersion(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	c := New(testAppID).(*Client)
+
+	v, err := c.LatestVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), v)
+	assert.Equal(t, 1, f.Requests())
+
+	// It's cached, so a new version isn't seen until the config is reloaded.
+	f.SetLatestVersion(4)
+	v, err = c.LatestVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), v)
+	assert.Equal(t, 1, f.Requests())
+
+	assert.NoError(t, c.Config().Load())
+	v, err = c.LatestVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), v)
+	assert.Equal(t, 3, f.Requests())
+}
+
+func TestLatestVersionTTL(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	c := New(testAppID).(*Client)
+	assert.Equal(t, DefaultLatestVersionTTL, c.Config().LatestVersionTTL())
+
+	c.Config().SetLatestVersionTTL(0)
+	for i := 0; i < 2; i++ {
+		v, err := c.LatestVersion()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), v)
+	}
+	assert.Equal(t, 2, f.Requests())
+
+	c.Config().SetLatestVersionTTL(20 * time.Millisecond)
+	c.LatestVersion()
+	f.SetLatestVersion(4)
+	time.Sleep(30 * time.Millisecond)
+	v, err := c.LatestVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), v)
+	assert.Equal(t, 4, f.Requests())
+}
+
+func TestLatestVersionFromSalts(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	c := New(testAppID).(*Client)
+
+	// A new password is made with the latest version.
+	_, err := c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+	v, err := c.LatestVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), v)
+	assert.Equal(t, 1, f.Requests())
+
+	// And a verification with an older one is offered an upgrade to it.
+	f.SetLatestVersion(4)
+	_, err = c.VerifyPassword(testHashBytes, f.Hash(testHashBytes, 2), 2)
+	assert.NoError(t, err)
+	v, err = c.LatestVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), v)
+	assert.Equal(t, 2, f.Requests())
+}
+
+func TestLatestVersionErrors(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(200, `{"s2":"00","vid":3}`))
+	c := New(testAppID).(*Client)
+
+	_, err := c.LatestVersion()
+	assert.ErrorIs(t, err, ErrMalformedSalt)
+
+	_, err = New("nope").LatestVersion()
+	assert.Equal(t, ErrInvalidAppID, err)
+
+	c.Shutdown(context.Background())
+	_, err = c.LatestVersion()
+	assert.Equal(t, ErrClientClosed, err)
+}
+
+func TestLatestVersionWaitCancelled(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.RespondAfter(200*time.Millisecond, 200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID).(*Client)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		v, err := c.LatestVersion()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), v)
+	}()
+	assert.Eventually(t, func() bool { return st.Attempts(DefaultHost) == 1 }, time.Second, time.Millisecond)
+
+	// A caller waiting for the request in flight gives up when its context
+	// is done, rather than when the request finishes
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.LatestVersionContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+	<-done
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+}
@@ -0,0 +1,164 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+)
+
+// checkGoroutines returns a func which fails the test if there are more
+// goroutines running than when checkGoroutines was called. Goroutines can
+// take a moment to exit, so it waits up to a second for them.
+func checkGoroutines(t *testing.T) func() {
+	before := runtime.NumGoroutine()
+	return func() {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := runtime.NumGoroutine(); n > before {
+			buf := make([]byte, 1<<16)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Errorf("%d goroutines leaked:\n%s", n-before, buf)
+		}
+	}
+}
+
+// withHangingServer points the package at a local TLS server whose handler
+// doesn't respond until the request is cancelled
+func withHangingServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	u, _ := url.Parse(srv.URL)
+	prevHost := DefaultHost
+	DefaultHost = u.Host
+	tr := srv.Client().Transport.(*http.Transport)
+	HTTPClient.Transport = tr
+	t.Cleanup(func() {
+		DefaultHost = prevHost
+		HTTPClient.Transport = origTransport
+		tr.CloseIdleConnections()
+		srv.Close()
+	})
+	return srv
+}
+
+func TestNoLeakCancelledDuringRetryDelay(t *testing.T) {
+	defer checkGoroutines(t)()
+	st := &taplinktest.ScriptedTransport{}
+	st.SetDefault(taplinktest.Respond(503, "Service Unavailable"))
+	HTTPClient.Transport = st
+	defer func() {
+		HTTPClient.Transport = origTransport
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	c := New(testAppID)
+	c.Config().SetBackoff(ConstantBackoff(time.Hour))
+	_, err := c.VerifyPasswordContext(ctx, testHashBytes, nil, 0)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestNoLeakCancelledDuringRequest(t *testing.T) {
+	// Cleanups run last in first out, so register the check before the server
+	// is started for it to run after the server is closed.
+	t.Cleanup(checkGoroutines(t))
+	withHangingServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c := New(testAppID)
+	_, err := c.NewPasswordContext(ctx, testHashBytes)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel2()
+	err = c.Config().LoadContext(ctx2)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestNoLeakCancelledRateLimiterWait(t *testing.T) {
+	defer checkGoroutines(t)()
+	st := &taplinktest.ScriptedTransport{}
+	st.Enqueue(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	HTTPClient.Transport = st
+	defer func() {
+		HTTPClient.Transport = origTransport
+	}()
+
+	l := &testLimiter{interval: time.Hour}
+	c := New(testAppID)
+	c.Config().SetSharedRateLimiter(l)
+
+	// The first request isn't limited, the second waits on the limiter until
+	// the context is done.
+	_, err := c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c.NewPasswordContext(ctx, testHashBytes)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+}
+
+func TestRootContextStopsBackgroundWork(t *testing.T) {
+	t.Cleanup(checkGoroutines(t))
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"servers":["` + srv.Listener.Addr().String() + `"]}`))
+	}))
+	u, _ := url.Parse(srv.URL)
+	prevHost := DefaultHost
+	DefaultHost = u.Host
+	t.Cleanup(func() {
+		DefaultHost = prevHost
+		srv.Close()
+	})
+
+	// Every request dials, so the dials are the requests made
+	var dials atomic.Int32
+	tr := srv.Client().Transport.(*http.Transport).Clone()
+	tr.DisableKeepAlives = true
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials.Add(1)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	c := New(testAppID, WithHTTPClient(&http.Client{Transport: tr})).(*Client)
+	cfg := c.Config().(*Config)
+	assert.NoError(t, cfg.AutoReload(time.Millisecond))
+	// Each probe is given the interval, so it's long enough to dial
+	assert.NoError(t, cfg.EnableHealthChecks(20*time.Millisecond))
+	assert.Eventually(t, func() bool {
+		return c.Stats().Get(u.Host).Probes().Healthy > 0 && dials.Load() > 3
+	}, time.Second, time.Millisecond)
+
+	// Cancelling the root context stops the reloads and probes, and any
+	// started later, without Shutdown stopping them
+	c.lc.cancel()
+	cfg.RLock()
+	reload, health := cfg.reload, cfg.health
+	cfg.RUnlock()
+	<-reload.done
+	<-health.done
+	n := dials.Load()
+	assert.NoError(t, cfg.AutoReload(time.Millisecond))
+	assert.NoError(t, cfg.EnableHealthChecks(time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, n, dials.Load())
+	assert.NoError(t, c.Close())
+}
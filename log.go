@@ -0,0 +1,90 @@
+package taplink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// requestLog logs the attempts of a request to the API. A nil *requestLog
+// logs nothing, so requests made without a logger don't pay for it.
+type requestLog struct {
+	logger *slog.Logger
+	path   string
+	// redact replaces the sensitive path segments, which can also appear in
+	// error messages, with their fingerprints
+	redact *strings.Replacer
+}
+
+// newRequestLog returns a requestLog for the API path made up of segments,
+// or nil if logger is nil
+func newRequestLog(logger *slog.Logger, segments []string) *requestLog {
+	if logger == nil {
+		return nil
+	}
+	var path strings.Builder
+	var pairs []string
+	for _, seg := range segments {
+		path.WriteByte('/')
+		if seg == "" || isDigits(seg) {
+			path.WriteString(seg)
+			continue
+		}
+		fp := fingerprint(seg)
+		path.WriteString(fp)
+		pairs = append(pairs, seg, fp)
+	}
+	return &requestLog{logger: logger, path: path.String(), redact: strings.NewReplacer(pairs...)}
+}
+
+// fingerprint identifies a sensitive value in logs without revealing it
+func fingerprint(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:4])
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// attempt logs the outcome of an attempt at debug level, and a failed
+// attempt which will be retried at warn level as well
+func (l *requestLog) attempt(ctx context.Context, host string, attempt, status int, latency time.Duration, err error, retrying bool) {
+	if l == nil {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("host", host),
+		slog.String("path", l.path),
+		slog.Int("attempt", attempt),
+		slog.Int("status", status),
+		slog.Duration("latency", latency),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", l.redact.Replace(err.Error())))
+	}
+	l.logger.LogAttrs(ctx, slog.LevelDebug, "taplink request attempt", attrs...)
+	if err != nil && retrying {
+		l.logger.LogAttrs(ctx, slog.LevelWarn, "taplink request failed, retrying", attrs...)
+	}
+}
+
+// failed logs a request which failed after attempts at warn level
+func (l *requestLog) failed(ctx context.Context, attempts int, err error) {
+	if l == nil || err == nil {
+		return
+	}
+	l.logger.LogAttrs(ctx, slog.LevelWarn, "taplink request failed",
+		slog.String("path", l.path),
+		slog.Int("attempts", attempts),
+		slog.String("error", l.redact.Replace(err.Error())),
+	)
+}
@@ -0,0 +1,86 @@
+package taplink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLogger() (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})), &buf
+}
+
+func TestLogger(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(
+		taplinktest.Respond(503, "unavailable"),
+		taplinktest.TransportError(errors.New("connection reset")),
+		taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`),
+	)
+	logger, buf := newTestLogger()
+	c := New(testAppID, WithLogger(logger)).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !assert.Len(t, lines, 5) {
+		return
+	}
+	path := "/" + fingerprint(testAppID) + "/" + fingerprint(testHashString) + "/"
+	assert.Contains(t, lines[0], "level=DEBUG")
+	assert.Contains(t, lines[0], "host="+DefaultHost)
+	assert.Contains(t, lines[0], "path="+path)
+	assert.Contains(t, lines[0], "attempt=1 status=503")
+	assert.Contains(t, lines[1], "level=WARN")
+	assert.Contains(t, lines[1], "retrying")
+	assert.Contains(t, lines[2], "attempt=2 status=0")
+	assert.Contains(t, lines[2], "connection reset")
+	assert.Contains(t, lines[3], "level=WARN")
+	assert.Contains(t, lines[4], "level=DEBUG")
+	assert.Contains(t, lines[4], "attempt=3 status=200")
+
+	// The hash, AppID and salt never appear, even in transport errors which
+	// include the URL.
+	assert.NotContains(t, buf.String(), testHashString)
+	assert.NotContains(t, buf.String(), testAppID[:16])
+	assert.NotContains(t, buf.String(), testHashExpectedSalt[:16])
+}
+
+func TestLoggerFailure(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(401, "unauthorized"))
+	logger, buf := newTestLogger()
+	c := New(testAppID).(*Client)
+	c.Config().SetLogger(logger)
+	_, err := c.GetSalt(testHashBytes, 2)
+	assert.Error(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	assert.Contains(t, lines[0], "attempt=1 status=401")
+	assert.NotContains(t, lines[0], "retrying")
+	assert.Contains(t, lines[1], "level=WARN")
+	assert.Contains(t, lines[1], `msg="taplink request failed"`)
+	assert.Contains(t, lines[1], "attempts=1")
+	assert.Contains(t, lines[1], "error=unauthorized")
+	assert.Contains(t, lines[1], "/"+fingerprint(testHashString)+"/2")
+}
+
+func TestLoggerNil(t *testing.T) {
+	assert.Nil(t, newRequestLog(nil, []string{testAppID}))
+	var l *requestLog
+	l.attempt(context.Background(), DefaultHost, 1, 200, 0, nil, false)
+	l.failed(context.Background(), 1, errors.New("failed"))
+}
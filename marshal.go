@@ -0,0 +1,118 @@
+package taplink
+
+import (
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Salt, NewPassword and VerifyPassword are marshalled with their salts and
+// hashes hex encoded, as the API sends them and as their String methods
+// return them, so results can be passed between services and stored as they
+// are. ResponseInfo and, for VerifyPassword, the outcome of storing an
+// upgrade aren't included.
+
+// MarshalJSON encodes the salt as the API's salt response
+func (s Salt) MarshalJSON() ([]byte, error) {
+	sr := saltResponse{Salt2Hex: hex.EncodeToString(s.Salt), VersionID: s.VersionID, NewVersionID: s.NewVersionID}
+	if s.NewSalt != nil {
+		sr.NewSalt2Hex = hex.EncodeToString(s.NewSalt)
+	}
+	return json.Marshal(sr)
+}
+
+// UnmarshalJSON decodes a salt encoded by MarshalJSON, or a salt response
+// from the API. It's checked as responses are, so errors match
+// ErrMalformedSalt.
+func (s *Salt) UnmarshalJSON(b []byte) error {
+	var sr saltResponse
+	if err := json.Unmarshal(b, &sr); err != nil {
+		return err
+	}
+	salt, newSalt, err := decodeSaltResponse(&sr, SaltEncodingHex)
+	if err != nil {
+		return err
+	}
+	*s = Salt{Salt: salt, VersionID: sr.VersionID, NewSalt: newSalt, NewVersionID: sr.NewVersionID}
+	return nil
+}
+
+// newPasswordJSON is how a NewPassword is encoded
+type newPasswordJSON struct {
+	Hash         string `json:"hash"`
+	VersionID    int64  `json:"vid"`
+	NewVersionID int64  `json:"new_vid,omitempty"`
+}
+
+// MarshalJSON encodes the hash as hex, with its versions
+func (p NewPassword) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newPasswordJSON{Hash: p.String(), VersionID: p.VersionID, NewVersionID: p.NewVersionID})
+}
+
+// UnmarshalJSON decodes a NewPassword encoded by MarshalJSON. A hash which
+// isn't HashSize bytes of hex is rejected with ErrInvalidHash.
+func (p *NewPassword) UnmarshalJSON(b []byte) error {
+	var v newPasswordJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	hash, err := decodeHash(v.Hash)
+	if err != nil {
+		return err
+	}
+	*p = NewPassword{Hash: hash, VersionID: v.VersionID, NewVersionID: v.NewVersionID}
+	return nil
+}
+
+// verifyPasswordJSON is how a VerifyPassword is encoded
+type verifyPasswordJSON struct {
+	Matched      bool   `json:"matched"`
+	Hash         string `json:"hash"`
+	VersionID    int64  `json:"vid"`
+	NewHash      string `json:"new_hash,omitempty"`
+	NewVersionID int64  `json:"new_vid,omitempty"`
+	Degraded     bool   `json:"degraded,omitempty"`
+	NewEncoded   string `json:"new_encoded,omitempty"`
+}
+
+// MarshalJSON encodes the hashes as hex, with the versions and whether the
+// password matched
+func (v VerifyPassword) MarshalJSON() ([]byte, error) {
+	return json.Marshal(verifyPasswordJSON{
+		Matched:      v.Matched,
+		Hash:         v.String(),
+		VersionID:    v.VersionID,
+		NewHash:      v.NewHashHex(),
+		NewVersionID: v.NewVersionID,
+		Degraded:     v.Degraded,
+		NewEncoded:   v.NewEncoded,
+	})
+}
+
+// UnmarshalJSON decodes a VerifyPassword encoded by MarshalJSON. Hashes which
+// aren't HashSize bytes of hex are rejected with ErrInvalidHash.
+func (v *VerifyPassword) UnmarshalJSON(b []byte) error {
+	var vj verifyPasswordJSON
+	if err := json.Unmarshal(b, &vj); err != nil {
+		return err
+	}
+	hash, err := decodeHash(vj.Hash)
+	if err != nil {
+		return err
+	}
+	var newHash []byte
+	if vj.NewHash != "" {
+		if newHash, err = decodeHash(vj.NewHash); err != nil {
+			return err
+		}
+	}
+	*v = VerifyPassword{
+		Matched:      vj.Matched,
+		Hash:         hash,
+		VersionID:    vj.VersionID,
+		NewHash:      newHash,
+		NewVersionID: vj.NewVersionID,
+		Degraded:     vj.Degraded,
+		NewEncoded:   vj.NewEncoded,
+	}
+	return nil
+}
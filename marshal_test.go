@@ -0,0 +1,100 @@
+package taplink
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaltJSON(t *testing.T) {
+	s := Salt{Salt: hexString(vectorSaltV2).Bytes(), VersionID: 2, NewSalt: hexString(vectorSaltV3).Bytes(), NewVersionID: 3, ResponseInfo: ResponseInfo{RequestID: "req"}}
+	b, err := json.Marshal(s)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"s2":"`+vectorSaltV2+`","vid":2,"new_s2":"`+vectorSaltV3+`","new_vid":3}`, string(b))
+
+	var got Salt
+	assert.NoError(t, json.Unmarshal(b, &got))
+	s.ResponseInfo = ResponseInfo{}
+	assert.Equal(t, s, got)
+
+	// Without an upgrade
+	b, err = json.Marshal(Salt{Salt: hexString(vectorSaltV3).Bytes(), VersionID: 3})
+	assert.NoError(t, err)
+	got = Salt{}
+	assert.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, Salt{Salt: hexString(vectorSaltV3).Bytes(), VersionID: 3}, got)
+
+	for _, body := range []string{
+		`{"s2":"` + vectorSaltV3[:64] + `","vid":3}`,
+		`{"s2":"` + vectorSaltV3[:127] + `z","vid":3}`,
+		`{"s2":"` + vectorSaltV3 + `"}`,
+		`{"s2":"` + vectorSaltV3 + `","vid":3,"new_s2":"00","new_vid":4}`,
+	} {
+		assert.ErrorIs(t, json.Unmarshal([]byte(body), &got), ErrMalformedSalt, body)
+	}
+}
+
+func TestNewPasswordJSON(t *testing.T) {
+	p := NewPassword{Hash: hexString(vectorHashV2).Bytes(), VersionID: 2, NewVersionID: 3}
+	b, err := json.Marshal(p)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"hash":"`+vectorHashV2+`","vid":2,"new_vid":3}`, string(b))
+
+	var got NewPassword
+	assert.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, p, got)
+
+	for _, body := range []string{`{"vid":3}`, `{"hash":"abc","vid":3}`, `{"hash":"` + vectorHashV2[:126] + `zz","vid":3}`} {
+		assert.Equal(t, ErrInvalidHash, json.Unmarshal([]byte(body), &got), body)
+	}
+}
+
+func TestVerifyPasswordJSON(t *testing.T) {
+	v := VerifyPassword{Matched: true, Hash: hexString(vectorHashV2).Bytes(), VersionID: 2, NewHash: hexString(vectorHashV3).Bytes(), NewVersionID: 3, Upgraded: true}
+	b, err := json.Marshal(&v)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"matched":true,"hash":"`+vectorHashV2+`","vid":2,"new_hash":"`+vectorHashV3+`","new_vid":3}`, string(b))
+
+	var got VerifyPassword
+	assert.NoError(t, json.Unmarshal(b, &got))
+	v.Upgraded = false
+	assert.Equal(t, v, got)
+
+	// Without an upgrade
+	b, err = json.Marshal(VerifyPassword{Hash: hexString(vectorHashV3).Bytes(), VersionID: 3})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"matched":false,"hash":"`+vectorHashV3+`","vid":3}`, string(b))
+	got = VerifyPassword{}
+	assert.NoError(t, json.Unmarshal(b, &got))
+	assert.Nil(t, got.NewHash)
+
+	assert.Equal(t, ErrInvalidHash, json.Unmarshal([]byte(`{"hash":"`+vectorHashV3+`","new_hash":"00"}`), &got))
+}
+
+// TestSaltJSONWirePayloads checks salts from the API marshal back to the
+// responses they came from
+func TestSaltJSONWirePayloads(t *testing.T) {
+	for _, body := range []string{
+		`{"s2":"` + testHashExpectedSalt + `","vid":3}`,
+		`{"s2":"` + vectorSaltV3 + `","vid":3}`,
+		`{"s2":"` + vectorSaltV2 + `","vid":2,"new_s2":"` + vectorSaltV3 + `","new_vid":3}`,
+	} {
+		st, restore := useScript()
+		st.Enqueue(taplinktest.Respond(200, body))
+		s, err := New(testAppID).GetSalt(testHashBytes, 0)
+		restore()
+		if !assert.NoError(t, err, body) {
+			continue
+		}
+		b, err := json.Marshal(s)
+		assert.NoError(t, err)
+		assert.JSONEq(t, body, string(b))
+
+		var got Salt
+		assert.NoError(t, json.Unmarshal([]byte(body), &got))
+		s.ResponseInfo = ResponseInfo{}
+		assert.Equal(t, *s, got)
+	}
+}
@@ -0,0 +1,154 @@
+package taplink
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultMigrateProgressEvery is how many items a Migrator handles between
+// calls to its Progress func, unless ProgressEvery is set
+const DefaultMigrateProgressEvery = 1000
+
+// MigrateItem is a stored password to verify, and upgrade if a newer data pool
+// version is available. The password's hash is only known when the user logs
+// in, so items come from logins, e.g. queued by the login handler, rather
+// than from the stored rows alone.
+type MigrateItem struct {
+	// Key identifies the row, and is passed to the apply func
+	Key       string
+	Hash      []byte
+	Expected  []byte
+	VersionID int64
+}
+
+// MigrateReport counts the items a migration handled. Matched includes the
+// items which were upgraded, and Failed those whose verification or upgrade
+// failed.
+type MigrateReport struct {
+	Processed int
+	Matched   int
+	Unmatched int
+	Upgraded  int
+	Failed    int
+}
+
+// Migrator verifies items as VerifyPasswordWithUpgrade does, calling Apply to
+// store the upgrade of each item which matched and has one
+type Migrator struct {
+	// Apply stores the new hash and version of the row with key. Both must be
+	// stored together, or neither.
+	Apply func(key string, newHash []byte, newVersionID int64) error
+	// Workers is the number of items verified at a time, at least 1
+	Workers int
+
+	// Progress, if set, is called with the report so far every ProgressEvery
+	// items, DefaultMigrateProgressEvery if it's 0, and OnError with the key
+	// and error of each item which failed. They're called one at a time.
+	Progress      func(MigrateReport)
+	ProgressEvery int
+	OnError       func(key string, err error)
+}
+
+// Run verifies the items from src with api until src is closed or ctx is
+// done, and returns what it did. Requests are made as any others, so they're
+// limited by the client's rate limiter if it has one, and retried.
+//
+// If ctx is done, the items which weren't handled aren't counted and
+// ctx.Err() is returned with the report so far.
+func (m *Migrator) Run(ctx context.Context, api VerifierContext, src <-chan MigrateItem) (MigrateReport, error) {
+	workers := m.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	every := m.ProgressEvery
+	if every <= 0 {
+		every = DefaultMigrateProgressEvery
+	}
+
+	var report MigrateReport
+	var mu sync.Mutex
+	// done records the result of an item, and reports progress
+	done := func(item *MigrateItem, vp *VerifyPassword, upgraded bool, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		report.Processed++
+		switch {
+		case vp == nil:
+		case vp.Matched:
+			report.Matched++
+		default:
+			report.Unmatched++
+		}
+		if upgraded {
+			report.Upgraded++
+		}
+		if err != nil {
+			report.Failed++
+			if m.OnError != nil {
+				m.OnError(item.Key, err)
+			}
+		}
+		if m.Progress != nil && report.Processed%every == 0 {
+			m.Progress(report)
+		}
+	}
+
+	items := make(chan MigrateItem)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				vp, upgraded, err := m.migrate(ctx, api, &item)
+				// An item cut short by ctx wasn't handled
+				if ctx.Err() != nil && err != nil {
+					continue
+				}
+				done(&item, vp, upgraded, err)
+			}
+		}()
+	}
+feed:
+	for {
+		select {
+		case <-ctx.Done():
+			break feed
+		case item, ok := <-src:
+			if !ok {
+				break feed
+			}
+			select {
+			case items <- item:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+	}
+	close(items)
+	wg.Wait()
+	return report, ctx.Err()
+}
+
+// migrate verifies item, and applies its upgrade if it matched and has one
+func (m *Migrator) migrate(ctx context.Context, api VerifierContext, item *MigrateItem) (*VerifyPassword, bool, error) {
+	vp, err := api.VerifyPasswordContext(ctx, item.Hash, item.Expected, item.VersionID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !vp.Matched || vp.NewHash == nil || vp.NewVersionID == vp.VersionID || m.Apply == nil {
+		return vp, false, nil
+	}
+	if err := m.Apply(item.Key, vp.NewHash, vp.NewVersionID); err != nil {
+		return vp, false, err
+	}
+	return vp, true, nil
+}
+
+// Migrate verifies the items from src with up to workers requests at a time,
+// calling apply to store the upgrade of each item which matched and has one.
+// See Migrator for reporting progress as it goes.
+func (c *Client) Migrate(ctx context.Context, src <-chan MigrateItem, apply func(key string, newHash []byte, newVersionID int64) error, workers int) (MigrateReport, error) {
+	m := &Migrator{Apply: apply, Workers: workers}
+	return m.Run(ctx, c, src)
+}
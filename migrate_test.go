@@ -0,0 +1,112 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// migrateHash returns a distinct password hash for i
+func migrateHash(i int) []byte {
+	h := make([]byte, HashSize)
+	copy(h, fmt.Sprintf("password %d", i))
+	return h
+}
+
+func TestMigrate(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	c := New(testAppID).(*Client)
+
+	src := make(chan MigrateItem)
+	go func() {
+		defer close(src)
+		for i := 0; i < 10; i++ {
+			item := MigrateItem{Key: fmt.Sprint(i), Hash: migrateHash(i), Expected: f.Hash(migrateHash(i), 2), VersionID: 2}
+			switch i {
+			case 3:
+				// Already on the latest version
+				item.Expected, item.VersionID = f.Hash(migrateHash(i), 3), 3
+			case 5:
+				item.Expected = []byte("wrong")
+			case 7:
+				item.Hash = nil
+			}
+			src <- item
+		}
+	}()
+
+	var mu sync.Mutex
+	stored := map[string]int64{}
+	apply := func(key string, newHash []byte, newVersionID int64) error {
+		if key == "8" {
+			return errors.New("store failed")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		i, _ := strconv.Atoi(key)
+		assert.Equal(t, f.Hash(migrateHash(i), 3), newHash)
+		stored[key] = newVersionID
+		return nil
+	}
+
+	report, err := c.Migrate(context.Background(), src, apply, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, MigrateReport{Processed: 10, Matched: 8, Unmatched: 1, Upgraded: 6, Failed: 2}, report)
+	assert.Len(t, stored, 6)
+	for _, key := range []string{"3", "5", "7", "8"} {
+		_, ok := stored[key]
+		assert.False(t, ok, key)
+	}
+}
+
+func TestMigratorProgress(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	c := New(testAppID).(*Client)
+
+	src := make(chan MigrateItem, 6)
+	for i := 0; i < 5; i++ {
+		src <- MigrateItem{Key: fmt.Sprint(i), Hash: migrateHash(i), Expected: f.Hash(migrateHash(i), 3), VersionID: 3}
+	}
+	src <- MigrateItem{Key: "bad"}
+	close(src)
+
+	var progress []int
+	var failed []string
+	m := &Migrator{
+		Workers:       2,
+		ProgressEvery: 2,
+		Progress:      func(r MigrateReport) { progress = append(progress, r.Processed) },
+		OnError:       func(key string, err error) { failed = append(failed, key) },
+	}
+	report, err := m.Run(context.Background(), c, src)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 4, 6}, progress)
+	assert.Equal(t, []string{"bad"}, failed)
+	assert.Equal(t, 5, report.Matched)
+	assert.Equal(t, 0, report.Upgraded)
+}
+
+func TestMigrateCancel(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	c := New(testAppID).(*Client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	src := make(chan MigrateItem)
+	go func() {
+		src <- MigrateItem{Key: "0", Hash: migrateHash(0), Expected: f.Hash(migrateHash(0), 3)}
+		cancel()
+		// src is never closed, so only ctx stops the migration
+	}()
+	report, err := c.Migrate(ctx, src, nil, 1)
+	assert.Equal(t, context.Canceled, err)
+	assert.LessOrEqual(t, report.Processed, 1)
+	assert.Equal(t, 0, report.Failed)
+}
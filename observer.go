@@ -0,0 +1,109 @@
+package taplink
+
+import (
+	"log/slog"
+	"time"
+)
+
+// RequestObserver is told about each attempt of each request to the API, to
+// feed other metrics or tracing systems. Its methods are called
+// synchronously from the request, so they must be quick and safe to call
+// concurrently. A panic in one is recovered, and logged if there's a logger,
+// so it doesn't fail the request.
+type RequestObserver interface {
+	// OnAttempt is called before an attempt is made to host. attempt is the
+	// number of attempts made so far, including this one.
+	OnAttempt(host string, attempt int)
+	// OnSuccess is called when an attempt to host succeeds, with how long
+	// the response took to arrive and its status
+	OnSuccess(host string, latency time.Duration, status int)
+	// OnError is called when an attempt to host fails, with the status of the
+	// response, or 0 if there wasn't one, e.g. for a timeout
+	OnError(host string, status int, err error)
+	// OnRetry is called before the delay ahead of the next attempt, with the
+	// host the previous attempt failed on
+	OnRetry(host string, nextDelay time.Duration)
+}
+
+// ObserverFuncs adapts funcs to the RequestObserver interface. A nil func is
+// skipped.
+type ObserverFuncs struct {
+	Attempt func(host string, attempt int)
+	Success func(host string, latency time.Duration, status int)
+	Error   func(host string, status int, err error)
+	Retry   func(host string, nextDelay time.Duration)
+}
+
+// OnAttempt implements the RequestObserver interface
+func (f ObserverFuncs) OnAttempt(host string, attempt int) {
+	if f.Attempt != nil {
+		f.Attempt(host, attempt)
+	}
+}
+
+// OnSuccess implements the RequestObserver interface
+func (f ObserverFuncs) OnSuccess(host string, latency time.Duration, status int) {
+	if f.Success != nil {
+		f.Success(host, latency, status)
+	}
+}
+
+// OnError implements the RequestObserver interface
+func (f ObserverFuncs) OnError(host string, status int, err error) {
+	if f.Error != nil {
+		f.Error(host, status, err)
+	}
+}
+
+// OnRetry implements the RequestObserver interface
+func (f ObserverFuncs) OnRetry(host string, nextDelay time.Duration) {
+	if f.Retry != nil {
+		f.Retry(host, nextDelay)
+	}
+}
+
+// AddObserver adds an observer which is told about each attempt of each
+// request, after those already added. Observers are called whether or not
+// stats are enabled. A nil observer is ignored.
+func (c *Config) AddObserver(o RequestObserver) {
+	if o == nil {
+		return
+	}
+	c.Lock()
+	c.observers = append(c.observers, o)
+	c.Unlock()
+}
+
+// Observers returns the observers added by AddObserver, in order
+func (c *Config) Observers() []RequestObserver {
+	c.RLock()
+	defer c.RUnlock()
+	return append([]RequestObserver(nil), c.observers...)
+}
+
+// WithObserver adds an observer, see Config.AddObserver
+func WithObserver(o RequestObserver) Option {
+	return func(c *Config) {
+		c.AddObserver(o)
+	}
+}
+
+// observe calls fn with each observer in turn
+func (c *Client) observe(fn func(RequestObserver)) {
+	for _, o := range c.Config().Observers() {
+		c.callObserver(o, fn)
+	}
+}
+
+// callObserver calls fn with o, recovering from a panic so it doesn't fail
+// the request or stop the other observers from being called
+func (c *Client) callObserver(o RequestObserver, fn func(RequestObserver)) {
+	defer func() {
+		if p := recover(); p != nil {
+			if l := c.Config().Logger(); l != nil {
+				l.Error("taplink observer panicked", slog.Any("panic", p))
+			}
+		}
+	}()
+	fn(o)
+}
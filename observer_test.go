@@ -0,0 +1,98 @@
+package taplink
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+// eventObserver records the events it's told about as strings
+type eventObserver struct {
+	events []string
+	mu     sync.Mutex
+}
+
+func (o *eventObserver) add(format string, args ...interface{}) {
+	o.mu.Lock()
+	o.events = append(o.events, fmt.Sprintf(format, args...))
+	o.mu.Unlock()
+}
+
+func (o *eventObserver) OnAttempt(host string, attempt int) {
+	o.add("attempt %s %d", host, attempt)
+}
+
+func (o *eventObserver) OnSuccess(host string, latency time.Duration, status int) {
+	o.add("success %s %d", host, status)
+}
+
+func (o *eventObserver) OnError(host string, status int, err error) {
+	o.add("error %s %d", host, status)
+}
+
+func (o *eventObserver) OnRetry(host string, nextDelay time.Duration) {
+	o.add("retry %s %s", host, nextDelay)
+}
+
+func (o *eventObserver) Events() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string(nil), o.events...)
+}
+
+func TestObserver(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(503, "unavailable"), taplinktest.Respond(200, `{"servers":[]}`))
+	obs := &eventObserver{}
+	c := New(testAppID, WithObserver(obs)).(*Client)
+	c.Config().SetRetryPolicy(3, time.Millisecond)
+
+	_, err := c.getFromAPI(testAppID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"attempt " + DefaultHost + " 1",
+		"error " + DefaultHost + " 503",
+		"retry " + DefaultHost + " 1ms",
+		"attempt " + DefaultHost + " 2",
+		"success " + DefaultHost + " 200",
+	}, obs.Events())
+}
+
+func TestObserverNoResponse(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Timeout(0))
+	obs := &eventObserver{}
+	c := New(testAppID).(*Client)
+	c.Config().AddObserver(obs)
+	c.Config().SetRetryPolicy(1, 0)
+
+	_, err := c.getFromAPI(testAppID)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"attempt " + DefaultHost + " 1", "error " + DefaultHost + " 0"}, obs.Events())
+}
+
+func TestObserverPanic(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(200, `{"servers":[]}`))
+	logger, buf := newTestLogger()
+	obs := &eventObserver{}
+	c := New(testAppID, WithLogger(logger)).(*Client)
+	c.Config().AddObserver(ObserverFuncs{Success: func(string, time.Duration, int) { panic("boom") }})
+	c.Config().AddObserver(obs)
+	c.Config().AddObserver(nil)
+	assert.Len(t, c.Config().Observers(), 2)
+
+	// The panic doesn't fail the request, or stop the next observer.
+	var err error
+	assert.NotPanics(t, func() { _, err = c.getFromAPI(testAppID) })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"attempt " + DefaultHost + " 1", "success " + DefaultHost + " 200"}, obs.Events())
+	assert.Contains(t, buf.String(), "taplink observer panicked")
+}
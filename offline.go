@@ -0,0 +1,76 @@
+package taplink
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+)
+
+// OfflineVersionID is the data pool version of the salts made in offline
+// mode for the latest version, see WithOfflineSecret
+const OfflineVersionID int64 = 1
+
+// WithOfflineSecret puts the client in offline mode, for development and CI
+// where the API can't be reached. Salts are derived locally as
+// HMAC-SHA512(secret, hash || versionID), with the version as 8 big-endian
+// bytes, instead of being requested from the API. Requests for the latest
+// version get OfflineVersionID, and there are never upgrades. NewPassword,
+// VerifyPassword, GetSalt and the methods built on them work as they do
+// online, caching and memoization included.
+//
+// Offline mode DOESN'T provide the security of blind hashing: anyone with the
+// secret can compute every salt, so hashes made with it must never be stored
+// in production. It can only be enabled with this option, and an empty
+// secret is an invalid option. The configuration isn't loaded offline, so
+// don't call Load or AutoReload.
+func WithOfflineSecret(secret []byte) Option {
+	return func(c *Config) {
+		if len(secret) == 0 {
+			c.invalidOption("WithOfflineSecret", errors.New("empty secret"))
+			return
+		}
+		c.offline = append([]byte(nil), secret...)
+	}
+}
+
+// Offline returns whether the client is in offline mode, see
+// WithOfflineSecret
+func (c *Config) Offline() bool {
+	return c.offline != nil
+}
+
+// offlineConfig is implemented by Config, whose salts are made locally in
+// offline mode
+type offlineConfig interface {
+	offlineSecret() []byte
+}
+
+// offlineSecret returns the secret of offline mode, or nil if the client
+// isn't offline. It's only set when the config is created.
+func (c *Config) offlineSecret() []byte {
+	return c.offline
+}
+
+// offlineSecret returns the secret salts are made with if the client is in
+// offline mode, or nil
+func (c *Client) offlineSecret() []byte {
+	if oc, ok := c.Config().(offlineConfig); ok {
+		return oc.offlineSecret()
+	}
+	return nil
+}
+
+// offlineSalt returns the salt for hash and versionID, or the latest version
+// for 0, made with secret
+func offlineSalt(secret, hash []byte, versionID int64) *Salt {
+	if versionID == 0 {
+		versionID = OfflineVersionID
+	}
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], uint64(versionID))
+	mac := hmac.New(sha512.New, secret)
+	mac.Write(hash)
+	mac.Write(v[:])
+	return &Salt{Salt: mac.Sum(nil), VersionID: versionID}
+}
@@ -0,0 +1,107 @@
+package taplink
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+var testOfflineSecret = []byte("offline test secret")
+
+func TestOffline(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := New(testAppID, WithOfflineSecret(testOfflineSecret)).(*Client)
+	assert.True(t, c.Config().(*Config).Offline())
+
+	// The salt is HMAC-SHA512(secret, hash || version).
+	s, err := c.GetSalt(testHashBytes, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	mac := hmac.New(sha512.New, testOfflineSecret)
+	mac.Write(testHashBytes)
+	mac.Write([]byte{0, 0, 0, 0, 0, 0, 0, 1})
+	assert.Equal(t, mac.Sum(nil), s.Salt)
+	assert.Equal(t, OfflineVersionID, s.VersionID)
+
+	np, err := c.NewPassword(testHashBytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, OfflineVersionID, np.VersionID)
+	v, err := c.VerifyPassword(testHashBytes, np.Hash, np.VersionID)
+	assert.NoError(t, err)
+	assert.True(t, v.Matched)
+	v, err = c.VerifyPassword(testHashBytes, []byte("wrong"), 0)
+	assert.NoError(t, err)
+	assert.False(t, v.Matched)
+
+	latest, err := c.LatestVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, OfflineVersionID, latest)
+
+	// Nothing was requested from the API.
+	assert.Empty(t, st.Paths())
+
+	// Another secret makes other hashes.
+	other := New(testAppID, WithOfflineSecret([]byte("another secret"))).(*Client)
+	np2, err := other.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+	assert.NotEqual(t, np.Hash, np2.Hash)
+
+	// A closed client refuses offline requests too
+	assert.NoError(t, c.Close())
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.Equal(t, ErrClientClosed, err)
+	_, err = c.NewPassword(testHashBytes)
+	assert.Equal(t, ErrClientClosed, err)
+}
+
+func TestOfflineSecretRequired(t *testing.T) {
+	_, err := NewWithError(testAppID, WithOfflineSecret(nil))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	assert.False(t, New(testAppID).Config().(*Config).Offline())
+}
+
+// TestOfflineMatchesOnline checks results made offline have the same shape
+// as ones made with the API
+func TestOfflineMatchesOnline(t *testing.T) {
+	f := taplinktest.NewFake(OfflineVersionID)
+	HTTPClient.Transport = f
+	defer func() { HTTPClient.Transport = origTransport }()
+	online := New(testAppID).(*Client)
+	offline := New(testAppID, WithOfflineSecret(testOfflineSecret)).(*Client)
+
+	for _, c := range []*Client{online, offline} {
+		s, err := c.GetSalt(testHashBytes, 0)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, s.Salt, SaltSize)
+		assert.Equal(t, OfflineVersionID, s.VersionID)
+		assert.Equal(t, int64(0), s.NewVersionID)
+		assert.Nil(t, s.NewSalt)
+
+		np, err := c.NewPassword(testHashBytes)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, np.Hash, HashSize)
+		assert.Equal(t, OfflineVersionID, np.VersionID)
+		assert.Equal(t, int64(0), np.NewVersionID)
+
+		v, err := c.VerifyPassword(testHashBytes, np.Hash, np.VersionID)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, v.Matched)
+		assert.Equal(t, OfflineVersionID, v.VersionID)
+		assert.Equal(t, int64(0), v.NewVersionID)
+		assert.Nil(t, v.NewHash)
+	}
+	assert.Greater(t, f.Requests(), 0)
+}
@@ -0,0 +1,202 @@
+package taplink
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ErrInvalidOption is matched by the errors NewWithError returns for invalid
+// options
+var ErrInvalidOption = errors.New("invalid option")
+
+// Option configures a client when it's created with New. Options are applied
+// in order, before the client makes any request. An invalid option is left
+// out, and reported by NewWithError.
+type Option func(*Config)
+
+// invalidOption records that the option named name is invalid because of
+// err, and was left out
+func (c *Config) invalidOption(name string, err error) {
+	c.optionErrs = append(c.optionErrs, fmt.Errorf("%w: %s: %w", ErrInvalidOption, name, err))
+}
+
+// WithStatistics makes the client record its stats to s rather than the
+// built-in implementation. A nil s is ignored.
+func WithStatistics(s Statistics) Option {
+	return func(c *Config) {
+		if s != nil {
+			c.stats = s
+		}
+	}
+}
+
+// WithStatsLimits limits the built-in stats to the latest capacity events of
+// each kind for each host, and to events from the last retention. Zero means
+// no limit, and the default is DefaultStatsCapacity events with no retention
+// limit. It has no effect on stats given with WithStatistics.
+func WithStatsLimits(capacity int, retention time.Duration) Option {
+	return func(c *Config) {
+		if s, ok := c.stats.(*statistics); ok {
+			s.setLimits(capacity, retention)
+		}
+	}
+}
+
+// WithLogger makes the client log requests to l, see Config.SetLogger
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Config) {
+		c.logger = l
+	}
+}
+
+// WithServers sets the servers to make requests to, as SetServers, until the
+// configuration is loaded
+func WithServers(servers []string) Option {
+	return func(c *Config) {
+		for _, host := range servers {
+			if !validHost(host) {
+				c.invalidOption("WithServers", fmt.Errorf("%w: %q", ErrInvalidHost, host))
+				return
+			}
+		}
+		c.options = &Options{Servers: append([]string{}, servers...)}
+		c.loadedAt = time.Now()
+	}
+}
+
+// WithTimeout limits each attempt of a request to d, as SetRequestTimeout
+func WithTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		if d < 0 {
+			c.invalidOption("WithTimeout", fmt.Errorf("negative timeout %s", d))
+			return
+		}
+		c.requestTimeout = d
+	}
+}
+
+// WithRetry sets how many attempts a request makes before failing, and the
+// delay between them, as SetRetryPolicy. The limit must be at least 1.
+func WithRetry(limit int, delay time.Duration) Option {
+	return func(c *Config) {
+		if limit < 1 || delay < 0 {
+			c.invalidOption("WithRetry", fmt.Errorf("limit %d and delay %s", limit, delay))
+			return
+		}
+		c.retryLimit, c.backoff = limit, ConstantBackoff(delay)
+	}
+}
+
+// WithHeaders adds headers to each request, replacing any default header of
+// the same name such as the User-Agent
+func WithHeaders(headers map[string]string) Option {
+	return func(c *Config) {
+		for k := range headers {
+			if k == "" {
+				c.invalidOption("WithHeaders", errors.New("empty header name"))
+				return
+			}
+		}
+		for k, v := range headers {
+			c.headers[k] = v
+		}
+	}
+}
+
+// WithHTTPClient makes the client's requests on client rather than on the
+// HTTPClient global. ClientFromContext still takes precedence for calls
+// whose context it returns a client for.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Config) {
+		if client == nil {
+			c.invalidOption("WithHTTPClient", errors.New("nil client"))
+			return
+		}
+		c.httpClient = client
+	}
+}
+
+// WithStatsEnabled enables the stats, including ones given with
+// WithStatistics whichever order the options are in
+func WithStatsEnabled() Option {
+	return func(c *Config) {
+		c.statsEnabled = true
+	}
+}
+
+// WithVerifyMemo memoizes VerifyPassword results for ttl, keeping at most
+// maxEntries of them, as EnableVerifyMemo. The ttl is capped at
+// MaxVerifyMemoTTL, and both must be more than 0.
+func WithVerifyMemo(ttl time.Duration, maxEntries int) Option {
+	return func(c *Config) {
+		if ttl <= 0 || maxEntries < 1 {
+			c.invalidOption("WithVerifyMemo", fmt.Errorf("ttl %s and max entries %d", ttl, maxEntries))
+			return
+		}
+		c.clientOpts = append(c.clientOpts, func(cl *Client) {
+			cl.memo = newVerifyMemo(ttl, maxEntries)
+		})
+	}
+}
+
+// WithMinimumVersion rejects data pool versions below v, as
+// SetMinimumVersion. v must not be negative, and 0 disables the check.
+func WithMinimumVersion(v int64) Option {
+	return func(c *Config) {
+		if err := checkMinimumVersion(v); err != nil {
+			c.invalidOption("WithMinimumVersion", err)
+			return
+		}
+		c.minVersion = v
+	}
+}
+
+// WithSharedRateLimiter makes every request to the API wait on l, as
+// SetSharedRateLimiter, so that clients given the same limiter stay within
+// its limit together
+func WithSharedRateLimiter(l RateLimiter) Option {
+	return func(c *Config) {
+		if l == nil {
+			c.invalidOption("WithSharedRateLimiter", errors.New("nil limiter"))
+			return
+		}
+		c.limiter = l
+	}
+}
+
+// WithFallback sets a verifier to use when VerifyPassword can't reach the
+// API, at most maxPerMinute times per minute, as Client.SetFallback. fb
+// must not be nil, and maxPerMinute must be at least 1.
+func WithFallback(fb FallbackVerifier, maxPerMinute int, onActivate func(err error)) Option {
+	return func(c *Config) {
+		if fb == nil {
+			c.invalidOption("WithFallback", errors.New("nil verifier"))
+			return
+		}
+		if maxPerMinute < 1 {
+			c.invalidOption("WithFallback", fmt.Errorf("max per minute %d", maxPerMinute))
+			return
+		}
+		c.clientOpts = append(c.clientOpts, func(cl *Client) {
+			cl.SetFallback(fb, maxPerMinute, onActivate)
+		})
+	}
+}
+
+// WithAutoPrune takes hosts out of rotation while their server error rate
+// over window exceeds threshold, as EnableAutoPrune, and enables the stats.
+// The threshold must be from 0 to 1, minSamples not negative, and the window
+// and cooldown more than 0.
+func WithAutoPrune(threshold float64, minSamples int, window, cooldown time.Duration) Option {
+	return func(c *Config) {
+		if threshold < 0 || threshold > 1 || minSamples < 0 || window <= 0 || cooldown <= 0 {
+			c.invalidOption("WithAutoPrune", fmt.Errorf("threshold %g, min samples %d, window %s and cooldown %s", threshold, minSamples, window, cooldown))
+			return
+		}
+		c.prune = newAutoPrune(threshold, minSamples, window, cooldown)
+		c.statsEnabled = true
+	}
+}
@@ -0,0 +1,99 @@
+package taplink
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions(t *testing.T) {
+	st := &taplinktest.ScriptedTransport{}
+	st.EnqueueFor("a.com", taplinktest.Respond(503, "error"))
+	st.EnqueueFor("b.com", taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	limiter := &countingLimiter{}
+
+	api, err := NewWithError(testAppID,
+		WithServers([]string{"a.com", "b.com"}),
+		WithTimeout(5*time.Second),
+		WithRetry(2, 0),
+		WithHeaders(map[string]string{"X-Team": "auth"}),
+		WithHTTPClient(&http.Client{Transport: st}),
+		WithStatsEnabled(),
+		// Stats given after enabling them are enabled too.
+		WithStatistics(newStatistics()),
+		WithMinimumVersion(2),
+		WithSharedRateLimiter(limiter),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cfg := api.Config()
+	assert.Equal(t, []string{"a.com", "b.com"}, cfg.Servers())
+	assert.Equal(t, 5*time.Second, cfg.RequestTimeout())
+	assert.Equal(t, 2, cfg.RetryLimit())
+	assert.Equal(t, ConstantBackoff(0), cfg.Backoff())
+	assert.Equal(t, "auth", cfg.Headers()["X-Team"])
+	assert.Equal(t, userAgent, cfg.Headers()["User-Agent"])
+	assert.Equal(t, int64(2), cfg.MinimumVersion())
+	assert.Equal(t, limiter, cfg.SharedRateLimiter())
+
+	// The requests are made on the given HTTP client, to the given servers,
+	// without loading the configuration.
+	_, err = api.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.com", "b.com"}, st.Hosts())
+	assert.Equal(t, 1, api.Stats().Get("a.com").Errors().Len())
+	assert.Equal(t, 1, api.Stats().Get("b.com").Requests())
+	assert.ElementsMatch(t, []string{"a.com", "b.com"}, api.Stats().Hosts())
+	assert.Equal(t, int32(2), limiter.waits.Load())
+
+	// Auto pruning enables the stats it's based on
+	c := New(testAppID, WithAutoPrune(0.5, 1, time.Minute, time.Minute))
+	assert.NotNil(t, c.Config().(*Config).prune)
+	assert.True(t, c.Stats().(*statistics).isEnabled())
+}
+
+func TestInvalidOptions(t *testing.T) {
+	opts := []Option{
+		WithServers([]string{"a.com", "https://b.com/path"}),
+		WithTimeout(-time.Second),
+		WithRetry(0, time.Second),
+		WithRetry(3, -time.Second),
+		WithHeaders(map[string]string{"": "x"}),
+		WithHTTPClient(nil),
+		WithVerifyMemo(0, 10),
+		WithVerifyMemo(time.Second, 0),
+		WithMinimumVersion(-1),
+		WithSharedRateLimiter(nil),
+		WithFallback(nil, 10, nil),
+		WithFallback(&testFallbackVerifier{}, 0, nil),
+		WithAutoPrune(1.5, 5, time.Minute, time.Minute),
+		WithAutoPrune(0.5, 5, 0, time.Minute),
+	}
+	_, err := NewWithError(testAppID, opts...)
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	assert.ErrorIs(t, err, ErrInvalidHost)
+	for _, opt := range opts {
+		_, err := NewWithError(testAppID, opt)
+		assert.ErrorIs(t, err, ErrInvalidOption)
+	}
+
+	_, err = NewWithError(testAppID, WithMinimumVersion(-1))
+	assert.ErrorIs(t, err, ErrInvalidVersion)
+
+	_, err = NewWithError("nope", WithRetry(3, 0))
+	assert.Equal(t, ErrInvalidAppID, err)
+
+	// New leaves the invalid options out, keeping the defaults.
+	c := New(testAppID, opts...)
+	assert.Empty(t, c.Config().Servers())
+	assert.Equal(t, time.Duration(0), c.Config().RequestTimeout())
+	assert.Equal(t, RetryLimit, c.Config().RetryLimit())
+	assert.NotContains(t, c.Config().Headers(), "")
+	assert.Equal(t, int64(0), c.Config().MinimumVersion())
+	assert.Nil(t, c.(*Client).fallback)
+	assert.Nil(t, c.Config().(*Config).prune)
+}
@@ -0,0 +1,122 @@
+package taplink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	// ErrStaleConfig is returned by LoadFrom for a saved configuration older
+	// than MaxConfigAge
+	ErrStaleConfig = errors.New("saved configuration is stale")
+
+	// ErrInvalidConfig is matched by the errors LoadFrom returns for a saved
+	// configuration which can't be used, e.g. a corrupted file
+	ErrInvalidConfig = errors.New("invalid saved configuration")
+
+	// DefaultMaxConfigAge is the oldest saved configuration LoadFrom accepts,
+	// unless set with SetMaxConfigAge
+	DefaultMaxConfigAge = 7 * 24 * time.Hour
+)
+
+// savedConfig is the JSON document SaveTo writes
+type savedConfig struct {
+	SchemaVersion string `json:"schemaVersion"`
+	// App is the fingerprint of the AppID, so a configuration isn't loaded by
+	// the wrong app without the AppID being written out
+	App       string    `json:"app"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Options   *Options  `json:"options"`
+}
+
+// SaveTo writes the configuration as JSON, with when it was loaded, so it can
+// be restored with LoadFrom when the process restarts, e.g. if the API can't
+// be reached then. The AppID isn't written, only a fingerprint of it.
+//
+// It fails if no configuration has been loaded or set.
+func (c *Config) SaveTo(w io.Writer) error {
+	c.RLock()
+	saved := savedConfig{SchemaVersion: configSchemaVersion, App: fingerprint(c.appID), FetchedAt: c.loadedAt}
+	if c.options != nil {
+		opts := *c.options
+		opts.Servers = append(make([]string, 0, len(opts.Servers)), opts.Servers...)
+		opts.Entries = append([]ServerEntry(nil), opts.Entries...)
+		saved.Options = &opts
+	}
+	c.RUnlock()
+	if saved.Options == nil || saved.FetchedAt.IsZero() {
+		return errors.New("no configuration has been loaded")
+	}
+	return json.NewEncoder(w).Encode(saved)
+}
+
+// LoadFrom restores a configuration written by SaveTo, as if it had just been
+// loaded from the API, so requests go to its servers while the API can't be
+// reached. Load can be called afterwards to refresh it, and only gets a new
+// configuration from the API if it changed.
+//
+// A saved configuration which is malformed, was saved for another AppID or
+// has invalid servers fails with an error matching ErrInvalidConfig, and one
+// written by an incompatible version of the package with an ErrSchemaVersion.
+// One older than MaxConfigAge fails with an error matching ErrStaleConfig; to
+// use it anyway, load it again with the max age set to 0. The configuration
+// is unchanged if it fails.
+func (c *Config) LoadFrom(r io.Reader) error {
+	var saved savedConfig
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if err := checkSchemaVersion(saved.SchemaVersion, configSchemaVersion); err != nil {
+		return err
+	}
+	switch {
+	case saved.Options == nil || saved.FetchedAt.IsZero():
+		return fmt.Errorf("%w: missing options or fetchedAt", ErrInvalidConfig)
+	case saved.App != fingerprint(c.appID):
+		return fmt.Errorf("%w: saved for another AppID", ErrInvalidConfig)
+	}
+	opts := saved.Options
+	if opts.Servers == nil {
+		opts.Servers = make([]string, 0)
+	}
+	for _, host := range opts.Servers {
+		if !validHost(host) {
+			return fmt.Errorf("%w: %w: %q", ErrInvalidConfig, ErrInvalidHost, host)
+		}
+	}
+	if age, maxAge := time.Since(saved.FetchedAt), c.MaxConfigAge(); maxAge > 0 && age > maxAge {
+		return fmt.Errorf("%w: fetched %s ago, more than %s", ErrStaleConfig, age.Round(time.Second), maxAge)
+	}
+
+	c.loading.Lock()
+	defer c.loading.Unlock()
+	c.Lock()
+	c.options = opts
+	c.loadedAt = saved.FetchedAt
+	c.srvName = ""
+	client := c.client
+	c.Unlock()
+	if client != nil {
+		client.latest.reset()
+	}
+	c.Stats().SetServers(opts.Servers)
+	return nil
+}
+
+// MaxConfigAge returns the oldest saved configuration LoadFrom accepts
+func (c *Config) MaxConfigAge() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.maxConfigAge
+}
+
+// SetMaxConfigAge sets the oldest saved configuration LoadFrom accepts,
+// measured from when it was loaded from the API. 0 accepts any age.
+func (c *Config) SetMaxConfigAge(d time.Duration) {
+	c.Lock()
+	c.maxConfigAge = d
+	c.Unlock()
+}
@@ -0,0 +1,117 @@
+package taplink
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveToLoadFrom(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(
+		taplinktest.Respond(200, `{"lastModified":100,"servers":["foo.com","bar.com"]}`),
+		taplinktest.Respond(304, ""),
+	)
+	c := newConfig(testAppID)
+	assert.NoError(t, c.Load())
+
+	var buf bytes.Buffer
+	if !assert.NoError(t, c.SaveTo(&buf)) {
+		return
+	}
+	assert.NotContains(t, buf.String(), testAppID)
+
+	restored := newConfig(testAppID)
+	assert.NoError(t, restored.LoadFrom(bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, []string{"foo.com", "bar.com"}, restored.Servers())
+	assert.Equal(t, time.Unix(100, 0), restored.LastModified())
+	assert.ElementsMatch(t, []string{"foo.com", "bar.com"}, restored.Stats().Hosts())
+
+	// Refreshing it asks the API whether it changed since, and keeps it if not.
+	info, err := restored.LoadResult()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, info.NotModified)
+	assert.Equal(t, []string{"foo.com", "bar.com"}, restored.Servers())
+
+	// Saving it again keeps when it was fetched.
+	var again bytes.Buffer
+	assert.NoError(t, restored.SaveTo(&again))
+	var first, second savedConfig
+	json.Unmarshal(buf.Bytes(), &first)
+	json.Unmarshal(again.Bytes(), &second)
+	assert.False(t, second.FetchedAt.Before(first.FetchedAt))
+}
+
+func TestSaveToNothingLoaded(t *testing.T) {
+	_, restore := useScript()
+	defer restore()
+	c := newConfig(testAppID)
+	assert.Error(t, c.SaveTo(&bytes.Buffer{}))
+
+	// Set servers can be saved too.
+	assert.NoError(t, c.SetServers([]string{"foo.com"}))
+	assert.NoError(t, c.SaveTo(&bytes.Buffer{}))
+}
+
+// savedJSON returns a saved configuration for testAppID fetched at fetchedAt
+func savedJSON(fetchedAt time.Time, servers ...string) string {
+	b, _ := json.Marshal(savedConfig{
+		SchemaVersion: configSchemaVersion,
+		App:           fingerprint(testAppID),
+		FetchedAt:     fetchedAt,
+		Options:       &Options{LastModified: 100, Servers: servers},
+	})
+	return string(b)
+}
+
+func TestLoadFromInvalid(t *testing.T) {
+	valid := savedJSON(time.Now(), "foo.com")
+	tests := []struct {
+		name  string
+		saved string
+	}{
+		{"empty", ""},
+		{"truncated", valid[:len(valid)/2]},
+		{"garbage", "\x00\x01not json"},
+		{"wrong type", `{"schemaVersion":"1.0","options":[]}`},
+		{"missing options", `{"schemaVersion":"1.0","app":"` + fingerprint(testAppID) + `","fetchedAt":"2020-01-01T00:00:00Z"}`},
+		{"missing fetchedAt", `{"schemaVersion":"1.0","app":"` + fingerprint(testAppID) + `","options":{"servers":[]}}`},
+		{"other app", strings.Replace(valid, fingerprint(testAppID), fingerprint("other"), 1)},
+		{"invalid host", savedJSON(time.Now(), "foo.com/path")},
+	}
+	for _, tt := range tests {
+		c := newConfig(testAppID)
+		assert.NoError(t, c.SetServers([]string{"bar.com"}))
+		err := c.LoadFrom(strings.NewReader(tt.saved))
+		assert.ErrorIs(t, err, ErrInvalidConfig, tt.name)
+		// The configuration is unchanged.
+		assert.Equal(t, []string{"bar.com"}, c.Servers(), tt.name)
+	}
+
+	c := newConfig(testAppID)
+	err := c.LoadFrom(strings.NewReader(strings.Replace(valid, `"1.0"`, `"2.0"`, 1)))
+	assert.Equal(t, ErrSchemaVersion{Got: "2.0", Want: configSchemaVersion}, err)
+}
+
+func TestLoadFromStale(t *testing.T) {
+	c := newConfig(testAppID)
+	assert.Equal(t, DefaultMaxConfigAge, c.MaxConfigAge())
+	saved := savedJSON(time.Now().Add(-DefaultMaxConfigAge-time.Hour), "foo.com")
+
+	err := c.LoadFrom(strings.NewReader(saved))
+	assert.ErrorIs(t, err, ErrStaleConfig)
+	assert.Empty(t, c.Servers())
+
+	// It can be used anyway without a max age.
+	c.SetMaxConfigAge(0)
+	assert.NoError(t, c.LoadFrom(strings.NewReader(saved)))
+	assert.Equal(t, []string{"foo.com"}, c.Servers())
+}
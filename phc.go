@@ -0,0 +1,99 @@
+package taplink
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// hashPrefix starts every encoded hash, see FormatHash
+const hashPrefix = "$taplink$v="
+
+var (
+	// ErrMalformedHash is returned by ParseHash for a string which isn't an
+	// encoded hash
+	ErrMalformedHash = errors.New("malformed encoded hash")
+	// ErrUnknownVersion is returned by ParseHash for an encoded hash whose
+	// version isn't a data pool version
+	ErrUnknownVersion = errors.New("unknown version in encoded hash")
+	// ErrHashLength is returned by ParseHash for an encoded hash which isn't
+	// HashSize bytes long
+	ErrHashLength = errors.New("wrong length of encoded hash")
+)
+
+// FormatHash encodes a hash and the data pool version it was made with as a
+// single string, like "$taplink$v=3$<hex hash>", to store in one column
+func FormatHash(versionID int64, hash []byte) string {
+	return hashPrefix + strconv.FormatInt(versionID, 10) + "$" + hex.EncodeToString(hash)
+}
+
+// ParseHash decodes a string made by FormatHash
+func ParseHash(s string) (versionID int64, hash []byte, err error) {
+	if !strings.HasPrefix(s, hashPrefix) {
+		return 0, nil, ErrMalformedHash
+	}
+	parts := strings.Split(s[len(hashPrefix):], "$")
+	if len(parts) != 2 {
+		return 0, nil, ErrMalformedHash
+	}
+	if versionID, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return 0, nil, ErrMalformedHash
+	}
+	if versionID <= 0 {
+		return 0, nil, ErrUnknownVersion
+	}
+	if hash, err = hex.DecodeString(parts[1]); err != nil {
+		return 0, nil, ErrMalformedHash
+	}
+	if len(hash) != HashSize {
+		return 0, nil, ErrHashLength
+	}
+	return versionID, hash, nil
+}
+
+// Encoded returns the hash and version encoded by FormatHash
+func (p NewPassword) Encoded() string {
+	return FormatHash(p.VersionID, p.Hash)
+}
+
+// NewPasswordString is like NewPassword, but returns the hash encoded by
+// FormatHash
+func (c *Client) NewPasswordString(hash1 []byte) (string, error) {
+	return c.NewPasswordStringContext(context.Background(), hash1)
+}
+
+// NewPasswordStringContext is like NewPasswordString, but requests to the API
+// are made with ctx.
+func (c *Client) NewPasswordStringContext(ctx context.Context, hash1 []byte) (string, error) {
+	p, err := c.NewPasswordContext(ctx, hash1)
+	if err != nil {
+		return "", err
+	}
+	return p.Encoded(), nil
+}
+
+// VerifyPasswordString is like VerifyPassword, for a hash encoded by
+// FormatHash. When an upgrade is available, NewEncoded is the encoded new
+// hash to store instead.
+func (c *Client) VerifyPasswordString(hash1 []byte, encoded string) (*VerifyPassword, error) {
+	return c.VerifyPasswordStringContext(context.Background(), hash1, encoded)
+}
+
+// VerifyPasswordStringContext is like VerifyPasswordString, but requests to
+// the API are made with ctx.
+func (c *Client) VerifyPasswordStringContext(ctx context.Context, hash1 []byte, encoded string) (*VerifyPassword, error) {
+	versionID, expected, err := ParseHash(encoded)
+	if err != nil {
+		return nil, err
+	}
+	vp, err := c.VerifyPasswordContext(ctx, hash1, expected, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if vp.NewHash != nil && vp.NewVersionID != vp.VersionID {
+		vp.NewEncoded = FormatHash(vp.NewVersionID, vp.NewHash)
+	}
+	return vp, nil
+}
@@ -0,0 +1,142 @@
+package taplink
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	vectorSaltV2 = "6190928f03b4ca59aed71614876857679e1edcf9b03ce3443a006713bcb2a305d33ee250c327df00f946041ca435a2cf72dd421e02f1e0d8de3efd5406674f6f"
+	vectorSaltV3 = "080b64a980fe49664e6e29e7532ce4dab19a070da0618e32b20d7d0578e120458c1fcf7f3de0a9da7bbf7ba49cacabc05230c605f7032ab51323992ff3c35895"
+	vectorHashV2 = "d883c376526904dd90bd69709d259e7d4ac4fe1ee3ff65a2b6ed2920c8baad326b0c2043c6bb7750c6ad02284c2365d3c61298649107924cc44e60450031fbd2"
+	vectorHashV3 = "9a4893d65a8eec23e520d0c7abe9c170ba61548c754b4805226e48d7519c55ed7f0daec920c5a99019042745007b99822e6853b8620be67955610b6d25f4b2f9"
+)
+
+func vectorHash1() []byte {
+	sum := hmac.New(sha512.New, hexString("4cb78a1a60599df9c3bd9e4ac741a5f15feec1812b22a5f15bbad978039f2765f00dd82d97272eb3674cd164a0cc7024bbfd3704c6df6e2cb17a6562bd96ecb7").Bytes())
+	sum.Write([]byte("secret"))
+	return sum.Sum(nil)
+}
+
+func TestParseHash(t *testing.T) {
+	v, hash, err := ParseHash("$taplink$v=3$" + vectorHashV3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), v)
+	assert.Equal(t, hexString(vectorHashV3).Bytes(), hash)
+	assert.Equal(t, "$taplink$v=3$"+vectorHashV3, FormatHash(v, hash))
+
+	tests := []struct {
+		encoded string
+		err     error
+	}{
+		{"", ErrMalformedHash},
+		{vectorHashV3, ErrMalformedHash},
+		{"$bcrypt$v=3$" + vectorHashV3, ErrMalformedHash},
+		{"$taplink$v=3", ErrMalformedHash},
+		{"$taplink$v=x$" + vectorHashV3, ErrMalformedHash},
+		{"$taplink$v=3$" + vectorHashV3 + "$", ErrMalformedHash},
+		{"$taplink$v=3$" + strings.Repeat("z", 128), ErrMalformedHash},
+		{"$taplink$v=0$" + vectorHashV3, ErrUnknownVersion},
+		{"$taplink$v=-2$" + vectorHashV3, ErrUnknownVersion},
+		{"$taplink$v=3$" + vectorHashV3[:126], ErrHashLength},
+		{"$taplink$v=3$", ErrHashLength},
+	}
+	for _, tt := range tests {
+		_, _, err := ParseHash(tt.encoded)
+		assert.Equal(t, tt.err, err, tt.encoded)
+	}
+}
+
+func TestNewPasswordString(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"s2":"`+vectorSaltV3+`","vid":3}`))
+	c := New(testAppID).(*Client)
+
+	encoded, err := c.NewPasswordString(vectorHash1())
+	assert.NoError(t, err)
+	assert.Equal(t, "$taplink$v=3$"+vectorHashV3, encoded)
+
+	// And it verifies, without an upgrade.
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"s2":"`+vectorSaltV3+`","vid":3}`))
+	v, err := c.VerifyPasswordString(vectorHash1(), encoded)
+	assert.NoError(t, err)
+	assert.True(t, v.Matched)
+	assert.Equal(t, "", v.NewEncoded)
+}
+
+func TestVerifyPasswordString(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"s2":"`+vectorSaltV2+`","vid":2,"new_s2":"`+vectorSaltV3+`","new_vid":3}`))
+	c := New(testAppID).(*Client)
+
+	v, err := c.VerifyPasswordString(vectorHash1(), "$taplink$v=2$"+vectorHashV2)
+	assert.NoError(t, err)
+	assert.True(t, v.Matched)
+	assert.Equal(t, "$taplink$v=3$"+vectorHashV3, v.NewEncoded)
+
+	// A malformed string doesn't make a request.
+	_, err = c.VerifyPasswordString(vectorHash1(), "$taplink$v=2$")
+	assert.Equal(t, ErrHashLength, err)
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+}
+
+func TestDefaultVersion(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := New(testAppID).(*Client)
+	c.Config().SetDefaultVersion(2)
+	assert.Equal(t, int64(2), c.Config().DefaultVersion())
+
+	// NewPassword makes the V2 hash, and says a newer version is available.
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"s2":"`+vectorSaltV2+`","vid":2,"new_s2":"`+vectorSaltV3+`","new_vid":3}`))
+	np, err := c.NewPassword(vectorHash1())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(2), np.VersionID)
+	assert.Equal(t, int64(3), np.NewVersionID)
+	assert.Equal(t, hexString(vectorHashV2).Bytes(), np.Hash)
+	assert.Equal(t, []string{"/" + testAppID + "/" + hex.EncodeToString(vectorHash1()) + "/2"}, st.Paths())
+
+	// VerifyPassword without a version verifies against V2, with the upgrade.
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"s2":"`+vectorSaltV2+`","vid":2,"new_s2":"`+vectorSaltV3+`","new_vid":3}`))
+	v, err := c.VerifyPassword(vectorHash1(), hexString(vectorHashV2).Bytes(), 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, v.Matched)
+	assert.Equal(t, int64(2), v.VersionID)
+	assert.Equal(t, int64(3), v.NewVersionID)
+	assert.Equal(t, hexString(vectorHashV3).Bytes(), v.NewHash)
+}
+
+func TestDefaultVersionLatest(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	c := New(testAppID).(*Client)
+
+	c.Config().SetDefaultVersion(2)
+	np, err := c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), np.VersionID)
+	assert.Equal(t, f.Hash(testHashBytes, 2), np.Hash)
+
+	// Back to 0, new passwords use the latest version, with no upgrade.
+	c.Config().SetDefaultVersion(0)
+	np, err = c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), np.VersionID)
+	assert.Equal(t, int64(0), np.NewVersionID)
+	assert.Equal(t, f.Hash(testHashBytes, 3), np.Hash)
+
+	c.Config().SetDefaultVersion(-1)
+	assert.Equal(t, int64(0), c.Config().DefaultVersion())
+}
@@ -0,0 +1,141 @@
+// Package promstats exports the stats of a TapLink client to Prometheus.
+//
+//	prometheus.MustRegister(promstats.NewCollector(api.Stats()))
+package promstats
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/TapLink/taplink-go"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// Collector is a prometheus.Collector for the stats of a TapLink client. It
+// exports, for each host:
+//
+//	taplink_requests_total{host}                successful requests
+//	taplink_errors_total{host,code}             error responses, by status code
+//	taplink_timeouts_total{host}                timed out requests
+//	taplink_request_duration_seconds{host}      histogram of successful requests
+//
+// The counters come from HostStats.Totals, so they include events the stats
+// have since dropped. The histogram is built from the latencies recorded
+// between collections, so if more requests are made between two collections
+// than the stats keep, the oldest of them are missing from it.
+type Collector struct {
+	stats   taplink.Statistics
+	buckets []float64
+
+	requests *prometheus.Desc
+	errors   *prometheus.Desc
+	timeouts *prometheus.Desc
+	duration *prometheus.Desc
+
+	// hosts holds the histogram of each host, guarded by mu so concurrent
+	// collections don't observe the same latencies twice
+	hosts map[string]*histogram
+	mu    sync.Mutex
+}
+
+type histogram struct {
+	// requests is the total number of requests at the last collection
+	requests int64
+	counts   []uint64
+	count    uint64
+	sum      float64
+}
+
+// NewCollector returns a Collector for stats, with the latency histogram
+// using buckets, or prometheus.DefBuckets if none are given. Hosts are read
+// from stats.Hosts on every collection, so hosts added later are exported too.
+// Stats are only recorded once they're enabled with stats.Enable().
+func NewCollector(stats taplink.Statistics, buckets ...float64) *Collector {
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Collector{
+		stats:    stats,
+		buckets:  buckets,
+		requests: prometheus.NewDesc("taplink_requests_total", "Successful requests to the TapLink API.", []string{"host"}, nil),
+		errors:   prometheus.NewDesc("taplink_errors_total", "Error responses from the TapLink API, by status code.", []string{"host", "code"}, nil),
+		timeouts: prometheus.NewDesc("taplink_timeouts_total", "Requests to the TapLink API which timed out.", []string{"host"}, nil),
+		duration: prometheus.NewDesc("taplink_request_duration_seconds", "Latency of successful requests to the TapLink API.", []string{"host"}, nil),
+		hosts:    make(map[string]*histogram),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.requests
+	ch <- c.errors
+	ch <- c.timeouts
+	ch <- c.duration
+}
+
+// Collect implements prometheus.Collector. The stats are copied before being
+// exported, so requests aren't blocked while metrics are written to ch.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, host := range c.stats.Hosts() {
+		hs := c.stats.Get(host)
+		latency := hs.Latency()
+		totals := hs.Totals()
+
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(totals.Requests), host)
+		ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(totals.Timeouts), host)
+		codes := make([]int, 0, len(totals.Errors))
+		for code := range totals.Errors {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(totals.Errors[code]), host, strconv.Itoa(code))
+		}
+
+		count, sum, buckets := c.observe(host, totals.Requests, latency)
+		ch <- prometheus.MustNewConstHistogram(c.duration, count, sum, buckets, host)
+	}
+}
+
+// observe adds the latencies of the requests since the last collection to
+// the histogram of host, and returns its count, sum and cumulative buckets
+func (c *Collector) observe(host string, requests int64, latency taplink.Latency) (uint64, float64, map[float64]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok := c.hosts[host]
+	// Fewer requests than last time means the stats were reset.
+	if !ok || requests < h.requests {
+		h = &histogram{counts: make([]uint64, len(c.buckets))}
+		c.hosts[host] = h
+	}
+	n := requests - h.requests
+	if n > int64(len(latency)) {
+		n = int64(len(latency))
+	}
+	for _, d := range latency[int64(len(latency))-n:] {
+		secs := d.Seconds()
+		for i, upper := range c.buckets {
+			if secs <= upper {
+				h.counts[i]++
+				break
+			}
+		}
+		h.count++
+		h.sum += secs
+	}
+	h.requests = requests
+
+	buckets := make(map[float64]uint64, len(c.buckets))
+	var cumulative uint64
+	for i, upper := range c.buckets {
+		cumulative += h.counts[i]
+		buckets[upper] = cumulative
+	}
+	return h.count, h.sum, buckets
+}
@@ -0,0 +1,103 @@
+package promstats
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TapLink/taplink-go"
+)
+
+func newStats() taplink.Statistics {
+	stats := taplink.New("").Stats()
+	stats.Enable()
+	return stats
+}
+
+func TestCollector(t *testing.T) {
+	stats := newStats()
+	stats.AddSuccess("foo.com", 5*time.Millisecond)
+	stats.AddSuccess("foo.com", 50*time.Millisecond)
+	stats.AddError("foo.com", 503, 0)
+	stats.AddError("foo.com", 503, 0)
+	stats.AddError("foo.com", 401, 0)
+	stats.AddTimeout("foo.com", 0)
+
+	c := NewCollector(stats, 0.01, 0.1)
+	expected := `
+# HELP taplink_requests_total Successful requests to the TapLink API.
+# TYPE taplink_requests_total counter
+taplink_requests_total{host="foo.com"} 2
+# HELP taplink_errors_total Error responses from the TapLink API, by status code.
+# TYPE taplink_errors_total counter
+taplink_errors_total{code="401",host="foo.com"} 1
+taplink_errors_total{code="503",host="foo.com"} 2
+# HELP taplink_timeouts_total Requests to the TapLink API which timed out.
+# TYPE taplink_timeouts_total counter
+taplink_timeouts_total{host="foo.com"} 1
+# HELP taplink_request_duration_seconds Latency of successful requests to the TapLink API.
+# TYPE taplink_request_duration_seconds histogram
+taplink_request_duration_seconds_bucket{host="foo.com",le="0.01"} 1
+taplink_request_duration_seconds_bucket{host="foo.com",le="0.1"} 2
+taplink_request_duration_seconds_bucket{host="foo.com",le="+Inf"} 2
+taplink_request_duration_seconds_sum{host="foo.com"} 0.055
+taplink_request_duration_seconds_count{host="foo.com"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected)))
+
+	// Latencies are only observed once, and later hosts are included.
+	stats.AddSuccess("foo.com", time.Second)
+	stats.AddSuccess("bar.com", time.Millisecond)
+	expected = `
+# HELP taplink_request_duration_seconds Latency of successful requests to the TapLink API.
+# TYPE taplink_request_duration_seconds histogram
+taplink_request_duration_seconds_bucket{host="foo.com",le="0.01"} 1
+taplink_request_duration_seconds_bucket{host="foo.com",le="0.1"} 2
+taplink_request_duration_seconds_bucket{host="foo.com",le="+Inf"} 3
+taplink_request_duration_seconds_sum{host="foo.com"} 1.055
+taplink_request_duration_seconds_count{host="foo.com"} 3
+taplink_request_duration_seconds_bucket{host="bar.com",le="0.01"} 1
+taplink_request_duration_seconds_bucket{host="bar.com",le="0.1"} 1
+taplink_request_duration_seconds_bucket{host="bar.com",le="+Inf"} 1
+taplink_request_duration_seconds_sum{host="bar.com"} 0.001
+taplink_request_duration_seconds_count{host="bar.com"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "taplink_request_duration_seconds"))
+}
+
+func TestCollectorServers(t *testing.T) {
+	stats := newStats()
+	c := NewCollector(stats)
+	assert.Equal(t, 0, testutil.CollectAndCount(c, "taplink_requests_total"))
+	stats.SetServers([]string{"foo.com", "bar.com"})
+	assert.Equal(t, 2, testutil.CollectAndCount(c, "taplink_requests_total"))
+	assert.Equal(t, 2, testutil.CollectAndCount(c, "taplink_request_duration_seconds"))
+}
+
+func TestCollectorReset(t *testing.T) {
+	stats := newStats()
+	c := NewCollector(stats, 1)
+	stats.AddSuccess("foo.com", time.Millisecond)
+	stats.AddSuccess("foo.com", time.Millisecond)
+	testutil.CollectAndCount(c)
+	stats.Reset()
+	stats.AddSuccess("foo.com", 2*time.Second)
+	expected := `
+# HELP taplink_request_duration_seconds Latency of successful requests to the TapLink API.
+# TYPE taplink_request_duration_seconds histogram
+taplink_request_duration_seconds_bucket{host="foo.com",le="1"} 0
+taplink_request_duration_seconds_bucket{host="foo.com",le="+Inf"} 1
+taplink_request_duration_seconds_sum{host="foo.com"} 2
+taplink_request_duration_seconds_count{host="foo.com"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "taplink_request_duration_seconds"))
+}
+
+func TestCollectorRegister(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	assert.NoError(t, reg.Register(NewCollector(newStats())))
+}
@@ -0,0 +1,89 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// ErrProxy is matched by the errors of requests which failed to reach the
+// host through the proxy
+var ErrProxy = errors.New("proxy error")
+
+// ProxyError is the error of a request the proxy refused to tunnel to the
+// host, by answering its CONNECT with a status other than 200
+type ProxyError struct {
+	// Proxy is the URL of the proxy
+	Proxy string
+	// StatusCode is the status the proxy answered the CONNECT with
+	StatusCode int
+}
+
+func (e *ProxyError) Error() string {
+	return fmt.Sprintf("proxy %s refused CONNECT: %d %s", e.Proxy, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Is makes a ProxyError match ErrProxy
+func (e *ProxyError) Is(target error) bool {
+	return target == ErrProxy
+}
+
+// onProxyConnectResponse turns a refused CONNECT into a ProxyError, as the
+// transport's own error for it is untyped
+func onProxyConnectResponse(_ context.Context, proxyURL *url.URL, _ *http.Request, resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	return &ProxyError{Proxy: proxyURL.Redacted(), StatusCode: resp.StatusCode}
+}
+
+// proxyFailed returns whether err is the error of a request which failed to
+// reach the host through the proxy: either connecting to the proxy failed, or
+// it refused the CONNECT
+func proxyFailed(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "proxyconnect" {
+		return true
+	}
+	return errors.Is(err, ErrProxy)
+}
+
+// WithProxy makes the client's requests through the proxy at urlStr, in
+// place of any from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+// variables. The scheme must be http, https or socks5.
+//
+// The proxy is set on a copy of the transport of the HTTP client, which must
+// be an *http.Transport, so it doesn't affect other clients.
+func WithProxy(urlStr string) Option {
+	return func(c *Config) {
+		u, err := url.Parse(urlStr)
+		if err != nil {
+			c.invalidOption("WithProxy", err)
+			return
+		}
+		switch {
+		case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5":
+			c.invalidOption("WithProxy", fmt.Errorf("unsupported scheme %q", u.Scheme))
+		case u.Host == "":
+			c.invalidOption("WithProxy", fmt.Errorf("no host in %q", urlStr))
+		default:
+			c.proxy = http.ProxyURL(u)
+		}
+	}
+}
+
+// WithProxyFunc makes the client's requests through the proxy fn returns for
+// each, as http.Transport.Proxy. A nil URL makes the request directly. See
+// WithProxy.
+func WithProxyFunc(fn func(*http.Request) (*url.URL, error)) Option {
+	return func(c *Config) {
+		if fn == nil {
+			c.invalidOption("WithProxyFunc", errors.New("nil func"))
+			return
+		}
+		c.proxy = fn
+	}
+}
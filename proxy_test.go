@@ -0,0 +1,92 @@
+package taplink
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithProxy(t *testing.T) {
+	// The Fake serves the absolute-form requests made to a plain HTTP proxy
+	// as if it were the host itself
+	f := taplinktest.NewFake(3)
+	proxy := httptest.NewServer(f)
+	defer proxy.Close()
+
+	c := New(testAppID, WithProxy(proxy.URL), WithServers([]string{"http://api.example.test"}), WithStatsEnabled())
+	defer c.Close()
+	_, err := c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, f.Attempts("api.example.test"))
+	assert.Equal(t, 1, c.Stats().Get("http://api.example.test").Requests())
+}
+
+func TestWithProxyFunc(t *testing.T) {
+	f := taplinktest.NewFake(3)
+	proxy := httptest.NewServer(f)
+	defer proxy.Close()
+
+	var proxied int
+	c := New(testAppID, WithProxyFunc(func(r *http.Request) (*url.URL, error) {
+		proxied++
+		return url.Parse(proxy.URL)
+	}), WithServers([]string{"http://api.example.test"}))
+	defer c.Close()
+	_, err := c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, proxied)
+	assert.Equal(t, 1, f.Requests())
+}
+
+func TestProxyConnectRefused(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer proxy.Close()
+
+	host := "https://api.example.test"
+	c := New(testAppID, WithProxy(proxy.URL), WithServers([]string{host}), WithRetry(1, 0), WithStatsEnabled())
+	defer c.Close()
+	_, err := c.NewPassword(testHashBytes)
+	assert.ErrorIs(t, err, ErrProxy)
+	var perr *ProxyError
+	if assert.True(t, errors.As(err, &perr)) {
+		assert.Equal(t, http.StatusForbidden, perr.StatusCode)
+		assert.Equal(t, proxy.URL, perr.Proxy)
+	}
+	assert.Equal(t, Errors{ProxyErrorCode: 1}, c.Stats().Get(host).Errors())
+	assert.Equal(t, 1, c.Stats().Get(host).ErrorsByClass().Transport)
+}
+
+func TestProxyUnreachable(t *testing.T) {
+	proxy := httptest.NewServer(http.NotFoundHandler())
+	proxy.Close()
+
+	host := "http://api.example.test"
+	c := New(testAppID, WithProxy(proxy.URL), WithServers([]string{host}), WithRetry(1, 0), WithStatsEnabled())
+	defer c.Close()
+	_, err := c.NewPassword(testHashBytes)
+	assert.Error(t, err)
+	assert.Equal(t, Errors{ProxyErrorCode: 1}, c.Stats().Get(host).Errors())
+}
+
+func TestWithProxyInvalid(t *testing.T) {
+	for _, opt := range []Option{
+		WithProxy("ftp://proxy.example.test"),
+		WithProxy("http://"),
+		WithProxy("http://proxy example"),
+		WithProxyFunc(nil),
+	} {
+		_, err := NewWithError(testAppID, opt)
+		assert.ErrorIs(t, err, ErrInvalidOption)
+	}
+
+	// The proxy can't be set on a transport which isn't an *http.Transport
+	_, err := NewWithError(testAppID, WithProxy("http://proxy.example.test"), WithHTTPClient(&http.Client{Transport: taplinktest.NewFake(3)}))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
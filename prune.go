@@ -0,0 +1,162 @@
+package taplink
+
+import (
+	"sync"
+	"time"
+)
+
+// PruneEvalInterval is how often auto pruning re-evaluates which hosts are in
+// rotation. Between evaluations the previous decision is used, so that the
+// stats history isn't walked on every request.
+var PruneEvalInterval = time.Second
+
+type autoPrune struct {
+	threshold  float64
+	minSamples int
+	window     time.Duration
+	cooldown   time.Duration
+
+	// pruned holds when each pruned host is re-added, and readded holds when
+	// each host was last re-added, so older events don't prune it straight away
+	pruned  map[string]time.Time
+	readded map[string]time.Time
+
+	// The result of the last evaluation, and the servers it was made for
+	servers   []string
+	active    []string
+	evaluated time.Time
+
+	mu sync.Mutex
+}
+
+type pruneChange struct {
+	host   string
+	pruned bool
+}
+
+// EnableAutoPrune temporarily removes hosts from the active rotation when
+// their server error rate over window exceeds threshold, as long as there are at
+// least minSamples requests in the window. A pruned host is re-added after
+// cooldown. The configured server list returned by Servers() isn't changed,
+// use ActiveServers() for the hosts currently in rotation.
+//
+// Pruning is based on the collected stats, so this enables stats too.
+func (c *Config) EnableAutoPrune(threshold float64, minSamples int, window, cooldown time.Duration) {
+	c.Stats().Enable()
+	c.Lock()
+	c.prune = newAutoPrune(threshold, minSamples, window, cooldown)
+	c.Unlock()
+}
+
+func newAutoPrune(threshold float64, minSamples int, window, cooldown time.Duration) *autoPrune {
+	return &autoPrune{
+		threshold:  threshold,
+		minSamples: minSamples,
+		window:     window,
+		cooldown:   cooldown,
+		pruned:     make(map[string]time.Time),
+		readded:    make(map[string]time.Time),
+	}
+}
+
+// DisableAutoPrune disables pruning and returns every server to the rotation
+func (c *Config) DisableAutoPrune() {
+	c.Lock()
+	c.prune = nil
+	c.Unlock()
+}
+
+// OnPruneChange sets a func which is called when a host is pruned from or
+// re-added to the active rotation
+func (c *Config) OnPruneChange(fn func(host string, pruned bool)) {
+	c.Lock()
+	c.pruneChanged = fn
+	c.Unlock()
+}
+
+// ActiveServers returns the servers which are currently in rotation. Without
+// auto pruning, or if every server would be pruned, it's the same as Servers().
+func (c *Config) ActiveServers() []string {
+	servers := c.servers()
+
+	c.RLock()
+	p, fn := c.prune, c.pruneChanged
+	c.RUnlock()
+	if p == nil || len(servers) == 0 {
+		return append([]string{}, servers...)
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	if now.Sub(p.evaluated) < PruneEvalInterval && equalHosts(p.servers, servers) {
+		active := append([]string(nil), p.active...)
+		p.mu.Unlock()
+		return active
+	}
+	changes := p.evaluate(c.Stats(), servers, now)
+	active := append([]string(nil), p.active...)
+	p.mu.Unlock()
+
+	if fn != nil {
+		for _, ch := range changes {
+			fn(ch.host, ch.pruned)
+		}
+	}
+	return active
+}
+
+// evaluate decides which of servers are in rotation, and returns the hosts
+// which were pruned or re-added. It must be called with p.mu held.
+func (p *autoPrune) evaluate(stats Statistics, servers []string, now time.Time) []pruneChange {
+	var changes, prunes []pruneChange
+	active := make([]string, 0, len(servers))
+	for _, host := range servers {
+		until, isPruned := p.pruned[host]
+		if isPruned && now.Before(until) {
+			continue
+		}
+		if isPruned {
+			delete(p.pruned, host)
+			p.readded[host] = now
+			changes = append(changes, pruneChange{host, false})
+		}
+		window := p.window
+		if since := now.Sub(p.readded[host]); since < window {
+			window = since
+		}
+		hs := stats.Get(host).Last(window)
+		samples := hs.Requests() + hs.Errors().Len() + hs.Timeouts()
+		if samples >= p.minSamples && hs.ServerErrorRate() > p.threshold {
+			prunes = append(prunes, pruneChange{host, true})
+			continue
+		}
+		active = append(active, host)
+	}
+
+	// Rather than leave nothing in rotation, keep every server. Hosts which
+	// would have been pruned aren't, so they can be pruned once others recover.
+	if len(active) == 0 {
+		active = servers
+		prunes = nil
+	}
+	for _, ch := range prunes {
+		p.pruned[ch.host] = now.Add(p.cooldown)
+	}
+
+	p.servers = append([]string(nil), servers...)
+	p.active = active
+	p.evaluated = now
+	return append(changes, prunes...)
+}
+
+func equalHosts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package taplink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoPrune(t *testing.T) {
+	origInterval := PruneEvalInterval
+	PruneEvalInterval = 0
+	defer func() {
+		PruneEvalInterval = origInterval
+	}()
+	c := newConfig("")
+	c.options = &Options{Servers: []string{"foo.com", "bar.com"}}
+
+	type change struct {
+		host   string
+		pruned bool
+	}
+	var changes []change
+	c.OnPruneChange(func(host string, pruned bool) {
+		changes = append(changes, change{host, pruned})
+	})
+	c.EnableAutoPrune(0.5, 4, time.Minute, 50*time.Millisecond)
+
+	// Not enough samples yet to prune.
+	for i := 0; i < 3; i++ {
+		c.Stats().AddError("foo.com", 503, 0)
+	}
+	assert.Equal(t, []string{"foo.com", "bar.com"}, c.ActiveServers())
+
+	c.Stats().AddTimeout("foo.com", 0)
+	c.Stats().AddSuccess("bar.com", time.Millisecond)
+	assert.Equal(t, []string{"bar.com"}, c.ActiveServers())
+	assert.Equal(t, []string{"foo.com", "bar.com"}, c.Servers())
+	assert.Equal(t, "bar.com", c.Host(0))
+	assert.Equal(t, "bar.com", c.Host(1))
+	assert.Equal(t, []change{{"foo.com", true}}, changes)
+
+	// After the cooldown the host is re-added, and the errors from before it was
+	// pruned don't count against it.
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, []string{"foo.com", "bar.com"}, c.ActiveServers())
+	assert.Equal(t, []change{{"foo.com", true}, {"foo.com", false}}, changes)
+	assert.Equal(t, []string{"foo.com", "bar.com"}, c.ActiveServers())
+
+	c.DisableAutoPrune()
+	assert.Equal(t, c.Servers(), c.ActiveServers())
+}
+
+func TestAutoPruneKeepsAllServers(t *testing.T) {
+	origInterval := PruneEvalInterval
+	PruneEvalInterval = 0
+	defer func() {
+		PruneEvalInterval = origInterval
+	}()
+	c := newConfig("")
+	c.options = &Options{Servers: []string{"foo.com", "bar.com"}}
+	var changes int
+	c.OnPruneChange(func(host string, pruned bool) {
+		changes++
+	})
+	c.EnableAutoPrune(0.5, 1, time.Minute, time.Minute)
+	c.Stats().AddError("foo.com", 503, 0)
+	c.Stats().AddError("bar.com", 503, 0)
+	assert.Equal(t, []string{"foo.com", "bar.com"}, c.ActiveServers())
+	assert.Equal(t, 0, changes)
+	assert.Len(t, c.prune.pruned, 0)
+
+	// Once one recovers, the other can be pruned.
+	for i := 0; i < 3; i++ {
+		c.Stats().AddSuccess("bar.com", time.Millisecond)
+	}
+	assert.Equal(t, []string{"bar.com"}, c.ActiveServers())
+	assert.Equal(t, 1, changes)
+}
+
+func TestAutoPruneCachesDecision(t *testing.T) {
+	origInterval := PruneEvalInterval
+	PruneEvalInterval = time.Hour
+	defer func() {
+		PruneEvalInterval = origInterval
+	}()
+	c := newConfig("")
+	c.options = &Options{Servers: []string{"foo.com", "bar.com"}}
+	c.EnableAutoPrune(0.5, 1, time.Minute, time.Minute)
+	assert.True(t, c.Stats().(*statistics).enabled)
+	assert.Equal(t, []string{"foo.com", "bar.com"}, c.ActiveServers())
+
+	// The errors aren't seen until the next evaluation.
+	c.Stats().AddError("foo.com", 503, 0)
+	assert.Equal(t, []string{"foo.com", "bar.com"}, c.ActiveServers())
+
+	// Unless the server list changes.
+	c.options.Servers = []string{"foo.com", "bar.com", "foobar.com"}
+	assert.Equal(t, []string{"bar.com", "foobar.com"}, c.ActiveServers())
+}
+
+func TestAutoPruneNoServers(t *testing.T) {
+	c := newConfig("")
+	c.EnableAutoPrune(0.5, 1, time.Minute, time.Minute)
+	assert.Len(t, c.ActiveServers(), 0)
+	assert.Equal(t, DefaultHost, c.Host(0))
+}
@@ -0,0 +1,145 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned for a request which would have to wait longer
+// than the rate limit wait timeout, or than its context allows, for the
+// client's rate limiter
+var ErrRateLimited = errors.New("rate limited")
+
+// tokenBucket is the client's own rate limiter. Tokens are added at rate per
+// second, up to burst, and each request takes one.
+type tokenBucket struct {
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+
+	mu sync.Mutex
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token and returns how long to wait until it's available.
+// If that's longer than maxWait, when maxWait is positive, the token isn't
+// taken and ok is false.
+func (b *tokenBucket) reserve(maxWait time.Duration) (wait time.Duration, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(float64(b.burst), b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	if maxWait > 0 && wait > maxWait {
+		return wait, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// release gives back a token reserved by a request which stopped waiting
+func (b *tokenBucket) release() {
+	b.mu.Lock()
+	b.tokens = math.Min(float64(b.burst), b.tokens+1)
+	b.mu.Unlock()
+}
+
+// wait waits for a token, for up to maxWait if it's positive, and returns
+// how long it waited. A wait which would outlast maxWait or ctx's deadline
+// fails straight away with ErrRateLimited.
+func (b *tokenBucket) wait(ctx context.Context, maxWait time.Duration) (time.Duration, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); maxWait <= 0 || left < maxWait {
+			maxWait = left
+			if maxWait <= 0 {
+				return 0, ErrRateLimited
+			}
+		}
+	}
+	d, ok := b.reserve(maxWait)
+	if !ok {
+		return 0, ErrRateLimited
+	}
+	if d <= 0 {
+		return 0, nil
+	}
+	if err := sleepContext(ctx, d); err != nil {
+		b.release()
+		return 0, err
+	}
+	return d, nil
+}
+
+// clientLimiter is implemented by Config, whose own rate limiter requests
+// wait on
+type clientLimiter interface {
+	rateLimiter() (b *tokenBucket, maxWait time.Duration)
+}
+
+// RateLimit returns the rate, in requests per second, and the burst the
+// client limits its requests to. A rate of 0 means unlimited.
+func (c *Config) RateLimit() (rps float64, burst int) {
+	c.RLock()
+	defer c.RUnlock()
+	if c.bucket == nil {
+		return 0, 0
+	}
+	return c.bucket.rate, c.bucket.burst
+}
+
+// SetRateLimit limits the client's requests to the API, including retries,
+// loading the config and health check probes, to rps per second on average,
+// with bursts of up to burst requests. Requests over the limit wait their
+// turn before each attempt, for up to the RateLimitWaitTimeout. A rate of 0
+// or less removes the limit.
+//
+// Unlike SetSharedRateLimiter, the limit is the client's own. If both are
+// set, requests wait for this one first. The time spent waiting is recorded
+// in stats as queue wait, not latency.
+func (c *Config) SetRateLimit(rps float64, burst int) {
+	var b *tokenBucket
+	if rps > 0 {
+		b = newTokenBucket(rps, burst)
+	}
+	c.Lock()
+	c.bucket = b
+	c.Unlock()
+}
+
+// RateLimitWaitTimeout returns how long a request waits for the rate limit
+// set with SetRateLimit before failing with ErrRateLimited, or 0 if it waits
+// for as long as its context allows
+func (c *Config) RateLimitWaitTimeout() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.bucketWait
+}
+
+// SetRateLimitWaitTimeout sets how long a request waits for the rate limit
+// set with SetRateLimit. A request which would wait longer fails straight
+// away with ErrRateLimited, rather than queueing behind a burst. A timeout
+// of 0 waits for as long as the request's context allows.
+func (c *Config) SetRateLimitWaitTimeout(d time.Duration) {
+	c.Lock()
+	c.bucketWait = d
+	c.Unlock()
+}
+
+func (c *Config) rateLimiter() (*tokenBucket, time.Duration) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.bucket, c.bucketWait
+}
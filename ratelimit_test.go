@@ -0,0 +1,133 @@
+package taplink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 3)
+	for i := 0; i < 3; i++ {
+		wait, ok := b.reserve(0)
+		assert.True(t, ok)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+	// Once the burst is used up, each token takes 100ms to come in.
+	wait, ok := b.reserve(0)
+	assert.True(t, ok)
+	assert.InDelta(t, float64(100*time.Millisecond), float64(wait), float64(5*time.Millisecond))
+	wait, ok = b.reserve(0)
+	assert.True(t, ok)
+	assert.InDelta(t, float64(200*time.Millisecond), float64(wait), float64(5*time.Millisecond))
+
+	// A wait longer than the limit doesn't take a token.
+	_, ok = b.reserve(250 * time.Millisecond)
+	assert.False(t, ok)
+	wait, _ = b.reserve(0)
+	assert.InDelta(t, float64(300*time.Millisecond), float64(wait), float64(5*time.Millisecond))
+}
+
+func TestRateLimit(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	c := New(testAppID).(*Client)
+	c.Stats().Enable()
+	rps, burst := c.Config().RateLimit()
+	assert.Equal(t, float64(0), rps)
+	assert.Equal(t, 0, burst)
+
+	c.Config().SetRateLimit(20, 2)
+	rps, burst = c.Config().RateLimit()
+	assert.Equal(t, float64(20), rps)
+	assert.Equal(t, 2, burst)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := c.GetSalt(testHashBytes, 0)
+		assert.NoError(t, err)
+	}
+	assert.True(t, time.Since(start) >= 140*time.Millisecond, time.Since(start))
+	assert.Equal(t, 5, f.Requests())
+
+	// The requests after the burst were delayed, and the waits recorded.
+	totals := c.Stats().Get(DefaultHost).Totals()
+	assert.Equal(t, int64(3), totals.Delayed)
+	assert.True(t, totals.QueueWait >= 100*time.Millisecond, totals.QueueWait)
+	assert.Equal(t, int64(5), totals.Requests)
+
+	// A rate of 0 removes the limit.
+	c.Config().SetRateLimit(0, 0)
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		c.GetSalt(testHashBytes, 0)
+	}
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+	assert.Equal(t, int64(3), c.Stats().Get(DefaultHost).Totals().Delayed)
+}
+
+func TestRateLimitAndSharedRateLimiter(t *testing.T) {
+	_, restore := useFake()
+	defer restore()
+	c := New(testAppID).(*Client)
+	c.Stats().Enable()
+	c.Config().SetRateLimit(20, 2)
+	c.Config().SetSharedRateLimiter(&testLimiter{})
+	for i := 0; i < 5; i++ {
+		_, err := c.GetSalt(testHashBytes, 0)
+		assert.NoError(t, err)
+	}
+
+	// Each request waited on both limiters, but its wait is recorded once
+	hs := c.Stats().Get(DefaultHost)
+	assert.Equal(t, 5, hs.QueueWait().Len())
+	assert.Equal(t, int64(5), hs.Totals().Delayed)
+	assert.True(t, hs.Totals().QueueWait >= 100*time.Millisecond, hs.Totals().QueueWait)
+}
+
+func TestRateLimitWaitTimeout(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	c := New(testAppID).(*Client)
+	c.Config().SetRateLimit(1, 1)
+	c.Config().SetRateLimitWaitTimeout(10 * time.Millisecond)
+	assert.Equal(t, 10*time.Millisecond, c.Config().RateLimitWaitTimeout())
+
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+
+	// The next token is a second away, so the request fails fast.
+	start := time.Now()
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.Equal(t, ErrRateLimited, err)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+	assert.Equal(t, 1, f.Requests())
+}
+
+func TestRateLimitContext(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	c := New(testAppID).(*Client)
+	c.Config().SetRateLimit(1, 1)
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+
+	// A caller which gives up stops waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = c.GetSaltContext(ctx, testHashBytes, 0)
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+
+	// And a caller whose deadline comes before its turn fails straight away.
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = c.GetSaltContext(ctx, testHashBytes, 0)
+	assert.Equal(t, ErrRateLimited, err)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+	assert.Equal(t, 1, f.Requests())
+}
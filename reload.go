@@ -0,0 +1,130 @@
+package taplink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+type autoReload struct {
+	// cancel stops the reload loop, and done is closed once it has stopped
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// AutoReload starts reloading the configuration every interval in the
+// background, so that changes to the server list are picked up. If the
+// servers were loaded with LoadFromSRV, the SRV record is looked up again
+// instead of loading from the API. Stats are initialized for any new
+// servers, as with Load. What each reload loaded is logged, if there's a
+// logger, and passed to the OnReload func, if set. Reload errors are passed to
+// the OnReloadError func, if set, and the previous configuration is kept.
+//
+// Calling AutoReload again replaces the previous interval. Stop reloading
+// with StopAutoReload, which the client's Shutdown also calls. An interval of
+// 0 or less returns ErrInvalidInterval, leaving any reloading as it was, and
+// once the client has started shutting down ErrClientClosed is returned.
+func (c *Config) AutoReload(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("%w: reload interval %s", ErrInvalidInterval, interval)
+	}
+	ctx, cancel := context.WithCancel(c.rootContext())
+	r := &autoReload{cancel: cancel, done: make(chan struct{})}
+	c.Lock()
+	if err := c.closed(); err != nil {
+		c.Unlock()
+		cancel()
+		return err
+	}
+	prev := c.reload
+	c.reload = r
+	c.Unlock()
+	if prev != nil {
+		prev.stop()
+	}
+	go c.reloadLoop(ctx, r, interval)
+	return nil
+}
+
+func (c *Config) reloadLoop(ctx context.Context, r *autoReload, interval time.Duration) {
+	defer close(r.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		info, err := c.reloadContext(ctx)
+		if ctx.Err() == nil {
+			c.reloaded(ctx, info, err)
+		}
+	}
+}
+
+// reloaded reports the result of an automatic reload: a failure to the
+// OnReloadError func, and what a successful reload loaded to the logger at
+// info level and to the OnReload func. A failed reload's request is logged
+// already.
+func (c *Config) reloaded(ctx context.Context, info *LoadInfo, err error) {
+	c.RLock()
+	logger, onReload, onError := c.logger, c.reloadDone, c.reloadError
+	c.RUnlock()
+	if err != nil {
+		if onError != nil {
+			onError(err)
+		}
+		return
+	}
+	if logger != nil {
+		logger.LogAttrs(ctx, slog.LevelInfo, "taplink configuration reloaded",
+			slog.Int("servers", info.Servers),
+			slog.Any("added", info.Added),
+			slog.Any("removed", info.Removed),
+			slog.Time("lastModified", info.LastModified),
+			slog.Bool("notModified", info.NotModified),
+			slog.Duration("duration", info.Duration),
+		)
+	}
+	if onReload != nil {
+		onReload(info)
+	}
+}
+
+// stop stops the reload loop and waits for it to return
+func (r *autoReload) stop() {
+	r.cancel()
+	<-r.done
+}
+
+// StopAutoReload stops reloading the configuration, cancelling a reload in
+// progress, and waits for the background goroutine to exit. It's safe to call
+// when auto reload isn't running.
+func (c *Config) StopAutoReload() {
+	c.Lock()
+	r := c.reload
+	c.reload = nil
+	c.Unlock()
+	if r != nil {
+		r.stop()
+	}
+}
+
+// OnReload sets a func which is called with what was loaded each time an
+// automatic reload succeeds, including when the configuration wasn't
+// modified
+func (c *Config) OnReload(fn func(info *LoadInfo)) {
+	c.Lock()
+	c.reloadDone = fn
+	c.Unlock()
+}
+
+// OnReloadError sets a func which is called with the error each time an
+// automatic reload fails
+func (c *Config) OnReloadError(fn func(err error)) {
+	c.Lock()
+	c.reloadError = fn
+	c.Unlock()
+}
@@ -0,0 +1,134 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoReload(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(200, `{"lastModified":1,"servers":["a.com"]}`))
+	st.SetDefault(taplinktest.Respond(200, `{"lastModified":2,"servers":["a.com","b.com"]}`))
+	c := New(testAppID).(*Client)
+	cfg := c.Config()
+
+	cfg.AutoReload(10 * time.Millisecond)
+	defer cfg.StopAutoReload()
+
+	// Host is called concurrently with the reloads swapping the servers.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			cfg.Host(i)
+		}
+	}()
+	assert.Eventually(t, func() bool { return len(cfg.Servers()) == 2 }, time.Second, 5*time.Millisecond)
+	wg.Wait()
+	assert.Equal(t, []string{"a.com", "b.com"}, cfg.Servers())
+	assert.ElementsMatch(t, []string{"a.com", "b.com"}, c.Stats().Hosts())
+}
+
+func TestAutoReloadReportsLoad(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(
+		taplinktest.Respond(200, `{"lastModified":1,"servers":["a.com"]}`),
+		taplinktest.Respond(200, `{"lastModified":2,"servers":["a.com","b.com"]}`),
+	)
+	st.SetDefault(taplinktest.Respond(304, ""))
+	logger, buf := newTestLogger()
+	c := New(testAppID, WithLogger(logger)).(*Client)
+	assert.NoError(t, c.Config().Load())
+	infos := make(chan *LoadInfo, 10)
+	c.Config().OnReload(func(info *LoadInfo) {
+		select {
+		case infos <- info:
+		default:
+		}
+	})
+	assert.NoError(t, c.Config().AutoReload(5*time.Millisecond))
+
+	info := <-infos
+	assert.Equal(t, 2, info.Servers)
+	assert.Equal(t, []string{"b.com"}, info.Added)
+	assert.Equal(t, time.Unix(2, 0), info.LastModified)
+	info = <-infos
+	assert.True(t, info.NotModified)
+	c.Config().StopAutoReload()
+	assert.Contains(t, buf.String(), `level=INFO msg="taplink configuration reloaded" servers=2 added=[b.com] removed=[]`)
+	assert.Contains(t, buf.String(), "notModified=true")
+}
+
+func TestAutoReloadError(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(200, `{"servers":["a.com"]}`))
+	st.SetDefault(taplinktest.TransportError(errors.New("unreachable")))
+	c := New(testAppID).(*Client)
+	errs := make(chan error, 10)
+	c.Config().OnReloadError(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	c.Config().AutoReload(5 * time.Millisecond)
+	defer c.Config().StopAutoReload()
+
+	select {
+	case err := <-errs:
+		assert.Contains(t, err.Error(), "unreachable")
+	case <-time.After(time.Second):
+		t.Fatal("no reload error")
+	}
+	// The previous configuration is kept.
+	assert.Equal(t, []string{"a.com"}, c.Config().Servers())
+}
+
+func TestStopAutoReload(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, `{"servers":["a.com"]}`))
+	c := New(testAppID).(*Client)
+
+	// Stopping without auto reload is fine.
+	c.Config().StopAutoReload()
+
+	// An interval which can't tick is refused rather than panicking
+	assert.ErrorIs(t, c.Config().AutoReload(0), ErrInvalidInterval)
+	assert.ErrorIs(t, c.Config().AutoReload(-time.Second), ErrInvalidInterval)
+	assert.Nil(t, c.Config().(*Config).reload)
+
+	assert.NoError(t, c.Config().AutoReload(5*time.Millisecond))
+	assert.Eventually(t, func() bool { return st.Attempts(DefaultHost) > 0 }, time.Second, time.Millisecond)
+	c.Config().StopAutoReload()
+	n := st.Attempts(DefaultHost)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, n, st.Attempts(DefaultHost))
+}
+
+func TestShutdownStopsAutoReload(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.RespondAfter(time.Hour, 200, `{"servers":["a.com"]}`))
+	c := New(testAppID).(*Client)
+	c.Config().AutoReload(time.Millisecond)
+	assert.Eventually(t, func() bool { return st.Attempts(DefaultHost) > 0 }, time.Second, time.Millisecond)
+
+	// The reload in progress is cancelled, rather than waited for.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, c.Shutdown(ctx))
+	n := st.Attempts(DefaultHost)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, n, st.Attempts(DefaultHost))
+}
@@ -0,0 +1,36 @@
+package taplink
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// apiResponse is a successful response from the API
+type apiResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// ResponseInfo is what the headers of the API response said, which is left
+// empty for headers which weren't sent
+type ResponseInfo struct {
+	// RequestID is the X-Request-Id header, to quote to TapLink support
+	RequestID string
+	// RateLimitRemaining is the X-RateLimit-Remaining header, the number of
+	// requests left before being throttled. HasRateLimit is whether it was
+	// sent, as 0 is a valid value.
+	RateLimitRemaining int
+	HasRateLimit       bool
+}
+
+// responseInfo returns the ResponseInfo from the headers h
+func responseInfo(h http.Header) ResponseInfo {
+	info := ResponseInfo{RequestID: h.Get("X-Request-Id")}
+	if v := h.Get("X-RateLimit-Remaining"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			info.RateLimitRemaining, info.HasRateLimit = n, true
+		}
+	}
+	return info
+}
@@ -0,0 +1,47 @@
+package taplink
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseInfo(t *testing.T) {
+	assert.Equal(t, ResponseInfo{}, responseInfo(http.Header{}))
+	assert.Equal(t, ResponseInfo{}, responseInfo(http.Header{"X-Ratelimit-Remaining": {"lots"}}))
+
+	h := http.Header{}
+	h.Set("X-Request-Id", "req-1")
+	h.Set("X-RateLimit-Remaining", "0")
+	assert.Equal(t, ResponseInfo{RequestID: "req-1", RateLimitRemaining: 0, HasRateLimit: true}, responseInfo(h))
+}
+
+func TestResponseInfoInResults(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	o := taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`)
+	o.Header.Set("X-Request-Id", "req-1")
+	o.Header.Set("X-RateLimit-Remaining", "42")
+	st.SetDefault(o)
+	c := New(testAppID).(*Client)
+	c.SetCoalescing(false)
+	want := ResponseInfo{RequestID: "req-1", RateLimitRemaining: 42, HasRateLimit: true}
+
+	s, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, want, s.ResponseInfo)
+	p, err := c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, want, p.ResponseInfo)
+	v, err := c.VerifyPassword(testHashBytes, p.Hash, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, want, v.ResponseInfo)
+
+	// Absent headers leave the zero values.
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	p, err = c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, ResponseInfo{}, p.ResponseInfo)
+}
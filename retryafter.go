@@ -0,0 +1,60 @@
+package taplink
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxRetryAfter is the longest Retry-After delay honored by configs
+// which don't set their own with SetMaxRetryAfter
+var DefaultMaxRetryAfter = 30 * time.Second
+
+// retryAfter returns how long the Retry-After header of resp asks to wait,
+// capped at max. It's 0 if there's no valid header, or max is 0.
+func retryAfter(resp *http.Response, now time.Time, max time.Duration) time.Duration {
+	v := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if v == "" || max <= 0 {
+		return 0
+	}
+	var d time.Duration
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		if secs > int64(max/time.Second) {
+			return max
+		}
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = t.Sub(now)
+	}
+	if d < 0 {
+		return 0
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// MaxRetryAfter returns the longest Retry-After delay which is honored
+func (c *Config) MaxRetryAfter() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	if !c.maxRetryAfterSet {
+		return DefaultMaxRetryAfter
+	}
+	return c.maxRetryAfter
+}
+
+// SetMaxRetryAfter caps the delay requested by the Retry-After header of 429
+// and 503 responses, which is waited for before the next attempt instead of
+// the backoff. Longer delays are cut to d, so a hostile header can't stall
+// requests. A d of 0 ignores Retry-After altogether.
+func (c *Config) SetMaxRetryAfter(d time.Duration) {
+	c.Lock()
+	c.maxRetryAfter, c.maxRetryAfterSet = d, true
+	c.Unlock()
+}
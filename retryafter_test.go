@@ -0,0 +1,74 @@
+package taplink
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	resp := func(v string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": {v}}}
+	}
+	max := time.Minute
+	assert.Equal(t, time.Duration(0), retryAfter(&http.Response{Header: http.Header{}}, now, max))
+	assert.Equal(t, 5*time.Second, retryAfter(resp("5"), now, max))
+	assert.Equal(t, 5*time.Second, retryAfter(resp(" 5 "), now, max))
+	assert.Equal(t, time.Duration(0), retryAfter(resp("0"), now, max))
+	assert.Equal(t, time.Duration(0), retryAfter(resp("-5"), now, max))
+	assert.Equal(t, time.Duration(0), retryAfter(resp("soon"), now, max))
+	assert.Equal(t, 10*time.Second, retryAfter(resp(now.Add(10*time.Second).Format(http.TimeFormat)), now, max))
+	assert.Equal(t, time.Duration(0), retryAfter(resp(now.Add(-time.Second).Format(http.TimeFormat)), now, max))
+
+	// Long delays are capped, and a max of 0 ignores the header.
+	assert.Equal(t, max, retryAfter(resp("3600"), now, max))
+	assert.Equal(t, max, retryAfter(resp("99999999999999999"), now, max))
+	assert.Equal(t, max, retryAfter(resp(now.Add(time.Hour).Format(http.TimeFormat)), now, max))
+	assert.Equal(t, time.Duration(0), retryAfter(resp("5"), now, 0))
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, code := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		st, restore := useScript()
+		st.Enqueue(
+			taplinktest.Outcome{StatusCode: code, Body: []byte("slow down"), Header: http.Header{"Retry-After": {"3600"}}},
+			taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`),
+		)
+		c := New(testAppID).(*Client)
+		c.Stats().Enable()
+		// The Retry-After replaces the backoff, and is capped.
+		c.Config().SetBackoff(ConstantBackoff(time.Hour))
+		c.Config().SetMaxRetryAfter(20 * time.Millisecond)
+		clk := useClock(c)
+		clk.autoAdvance = true
+		start := clk.Now()
+		_, err := c.GetSalt(testHashBytes, 0)
+		assert.NoError(t, err, code)
+		assert.Equal(t, 20*time.Millisecond, clk.Now().Sub(start), code)
+		assert.Equal(t, 2, st.Attempts(DefaultHost), code)
+		assert.Equal(t, 1, c.Stats().Get(DefaultHost).Errors().Count(code), code)
+		restore()
+	}
+}
+
+func TestTooManyRequestsRetried(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(http.StatusTooManyRequests, "slow down"))
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.ErrorIs(t, err, ErrRetriesExhausted)
+	assert.Equal(t, RetryLimit, st.Attempts(DefaultHost))
+}
+
+func TestMaxRetryAfterDefault(t *testing.T) {
+	c := newConfig("")
+	assert.Equal(t, DefaultMaxRetryAfter, c.MaxRetryAfter())
+	c.SetMaxRetryAfter(0)
+	assert.Equal(t, time.Duration(0), c.MaxRetryAfter())
+}
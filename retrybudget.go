@@ -0,0 +1,148 @@
+package taplink
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrRetryBudgetExhausted is matched by the error of a request which wasn't
+// retried because the client's retry budget was spent, see
+// Config.SetRetryBudget
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// DefaultRetryBudgetWindow is the window of a retry budget set without one
+const DefaultRetryBudgetWindow = 10 * time.Second
+
+// retryBudgetError is returned for a request the retry budget stopped. It
+// wraps the error of the last attempt.
+type retryBudgetError struct {
+	attempts int
+	err      error
+}
+
+func (e *retryBudgetError) Error() string {
+	msg := fmt.Sprintf("retry budget exhausted after %d attempts", e.attempts)
+	if e.err != nil {
+		msg += ": " + e.err.Error()
+	}
+	return msg
+}
+
+func (e *retryBudgetError) Unwrap() error {
+	return e.err
+}
+
+func (e *retryBudgetError) Is(target error) bool {
+	return target == ErrRetryBudgetExhausted
+}
+
+// retryBudget limits the retries a client makes within window to ratio of
+// the attempts the stats recorded in it, or minRetries if that's more
+type retryBudget struct {
+	ratio      float64
+	minRetries int
+	window     time.Duration
+
+	mu sync.Mutex
+	// retries are the times of the retries made within the window, oldest
+	// first
+	retries []time.Time
+}
+
+func newRetryBudget(ratio float64, minRetries int, window time.Duration) *retryBudget {
+	if minRetries < 0 {
+		minRetries = 0
+	}
+	if window <= 0 {
+		window = DefaultRetryBudgetWindow
+	}
+	return &retryBudget{ratio: ratio, minRetries: minRetries, window: window}
+}
+
+// withdraw takes a retry made at now from the budget, returning false if
+// none are left. The attempts within the window are those stats has for its
+// hosts.
+func (b *retryBudget) withdraw(stats Statistics, now time.Time) bool {
+	var attempts int
+	for _, h := range stats.Hosts() {
+		m := stats.Get(h).Last(b.window)
+		attempts += m.Requests() + m.Errors().Len() + m.Timeouts()
+	}
+	allowed := int(b.ratio * float64(attempts))
+	if allowed < b.minRetries {
+		allowed = b.minRetries
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	expired := 0
+	for expired < len(b.retries) && now.Sub(b.retries[expired]) >= b.window {
+		expired++
+	}
+	b.retries = b.retries[expired:]
+	if len(b.retries) >= allowed {
+		return false
+	}
+	b.retries = append(b.retries, now)
+	return true
+}
+
+// RetryBudget returns the retry budget set with SetRetryBudget. A ratio of 0
+// means there's none.
+func (c *Config) RetryBudget() (ratio float64, minRetries int, window time.Duration) {
+	c.RLock()
+	defer c.RUnlock()
+	if c.budget == nil {
+		return 0, 0, 0
+	}
+	return c.budget.ratio, c.budget.minRetries, c.budget.window
+}
+
+// SetRetryBudget limits the retries of all the client's requests together,
+// so that during an outage concurrent requests don't each retry up to the
+// retry limit, multiplying the load on the API when it can least handle it.
+// Within any window, the client retries at most ratio times the attempts
+// its stats recorded in the window, or minRetries if that's more. A request
+// which would retry once the budget is spent fails straight away, with an
+// error matching ErrRetryBudgetExhausted which wraps the error of its last
+// attempt.
+//
+// The attempts are counted from the stats, so without stats being enabled
+// only minRetries retries are made in each window. A window of 0 or less is
+// DefaultRetryBudgetWindow. A ratio of 0 or less removes the budget, which is
+// the default.
+func (c *Config) SetRetryBudget(ratio float64, minRetries int, window time.Duration) {
+	var b *retryBudget
+	if ratio > 0 {
+		b = newRetryBudget(ratio, minRetries, window)
+	}
+	c.Lock()
+	c.budget = b
+	c.Unlock()
+}
+
+// budgetConfig is implemented by Config, whose retry budget limits the
+// client's retries
+type budgetConfig interface {
+	retryBudget() *retryBudget
+}
+
+func (c *Config) retryBudget() *retryBudget {
+	c.RLock()
+	defer c.RUnlock()
+	return c.budget
+}
+
+// withdrawRetry returns whether the client's retry budget allows another
+// retry at now, taking it from the budget if so. Without a budget it always
+// does.
+func (c *Client) withdrawRetry(now time.Time) bool {
+	bc, ok := c.Config().(budgetConfig)
+	if !ok {
+		return true
+	}
+	b := bc.retryBudget()
+	return b == nil || b.withdraw(c.Stats(), now)
+}
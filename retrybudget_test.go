@@ -0,0 +1,88 @@
+package taplink
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBudget(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(503, "unavailable"))
+	c := New(testAppID).(*Client)
+	c.Config().SetRetryPolicy(3, 0)
+	ratio, minRetries, window := c.Config().RetryBudget()
+	assert.Equal(t, 0.0, ratio)
+	assert.Equal(t, 0, minRetries)
+	assert.Equal(t, time.Duration(0), window)
+
+	// Without stats, only the minimum is allowed
+	c.Config().SetRetryBudget(0.2, 2, 0)
+	ratio, minRetries, window = c.Config().RetryBudget()
+	assert.Equal(t, 0.2, ratio)
+	assert.Equal(t, 2, minRetries)
+	assert.Equal(t, DefaultRetryBudgetWindow, window)
+	clk := useClock(c)
+
+	_, err := c.getFromAPI(testAppID)
+	assert.ErrorIs(t, err, ErrRetriesExhausted)
+	assert.Equal(t, 3, st.Attempts(DefaultHost))
+
+	_, err = c.getFromAPI(testAppID)
+	assert.ErrorIs(t, err, ErrRetryBudgetExhausted)
+	assert.False(t, errors.Is(err, ErrRetriesExhausted))
+	assert.EqualError(t, err, "retry budget exhausted after 1 attempts: unavailable")
+	assert.Equal(t, 4, st.Attempts(DefaultHost))
+
+	// The retries are refunded once they're out of the window
+	clk.Advance(DefaultRetryBudgetWindow)
+	_, err = c.getFromAPI(testAppID)
+	assert.ErrorIs(t, err, ErrRetriesExhausted)
+	assert.Equal(t, 7, st.Attempts(DefaultHost))
+
+	// A ratio of 0 removes the budget
+	c.Config().SetRetryBudget(0, 2, 0)
+	_, err = c.getFromAPI(testAppID)
+	assert.ErrorIs(t, err, ErrRetriesExhausted)
+	assert.Equal(t, 10, st.Attempts(DefaultHost))
+}
+
+func TestRetryBudgetConcurrent(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(503, "unavailable"))
+	c := New(testAppID, WithStatsEnabled()).(*Client)
+	c.Config().SetRetryPolicy(3, 0)
+	c.Config().SetRetryBudget(0.1, 5, time.Minute)
+	useClock(c)
+
+	// 200 failing calls would make 600 attempts without the budget. With it,
+	// the retries are at most a tenth of the attempts.
+	const calls = 200
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	stopped := 0
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.getFromAPI(testAppID)
+			assert.Error(t, err)
+			if errors.Is(err, ErrRetryBudgetExhausted) {
+				mu.Lock()
+				stopped++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	attempts := st.Attempts(DefaultHost)
+	assert.GreaterOrEqual(t, attempts, calls+5)
+	assert.LessOrEqual(t, attempts-calls, attempts/10)
+	assert.GreaterOrEqual(t, stopped, calls-(attempts-calls))
+}
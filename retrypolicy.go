@@ -0,0 +1,74 @@
+package taplink
+
+import (
+	"errors"
+	"net/http"
+)
+
+// RetryPolicy decides whether a failed attempt is retried. Whatever it
+// decides, the failure is recorded in the stats, and no more attempts are
+// made than the retry limit allows.
+type RetryPolicy interface {
+	// ShouldRetry returns whether to retry after an attempt failed with err.
+	// statusCode is the status of the response, or 0 if there wasn't one,
+	// e.g. for a timeout or a connection reset. attempt is the number of
+	// attempts made so far, including the failed one.
+	ShouldRetry(statusCode int, err error, attempt int) bool
+}
+
+// RetryPolicyFunc adapts a func to the RetryPolicy interface
+type RetryPolicyFunc func(statusCode int, err error, attempt int) bool
+
+// ShouldRetry implements the RetryPolicy interface
+func (f RetryPolicyFunc) ShouldRetry(statusCode int, err error, attempt int) bool {
+	return f(statusCode, err, attempt)
+}
+
+type defaultRetryPolicy struct{}
+
+// DefaultRetryPolicy retries attempts which failed without a response, such
+// as timeouts and transport errors, and responses which can't be used, like
+// an empty body, apart from ones larger than the max response size, which
+// wouldn't be any smaller from another host. Of the error statuses, it
+// retries only 408, 429, 500, 502, 503 and 504: others, like 501 or most
+// 4xx, would fail again. Policies can delegate to it for the cases they
+// don't handle.
+var DefaultRetryPolicy RetryPolicy = defaultRetryPolicy{}
+
+// ShouldRetry implements the RetryPolicy interface
+func (defaultRetryPolicy) ShouldRetry(statusCode int, err error, attempt int) bool {
+	if errors.Is(err, ErrResponseTooLarge) {
+		return false
+	}
+	switch statusCode {
+	case 0:
+		return true
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return statusCode < 400
+}
+
+// RetryPolicy returns the policy deciding which failed attempts are retried
+func (c *Config) RetryPolicy() RetryPolicy {
+	c.RLock()
+	defer c.RUnlock()
+	if c.retryPolicy == nil {
+		return DefaultRetryPolicy
+	}
+	return c.retryPolicy
+}
+
+// UseRetryPolicy sets the policy deciding which failed attempts are retried.
+// A nil policy restores DefaultRetryPolicy. The number of attempts and the
+// delay between them are set by SetRetryPolicy and SetBackoff.
+func (c *Config) UseRetryPolicy(p RetryPolicy) {
+	c.Lock()
+	c.retryPolicy = p
+	c.Unlock()
+}
@@ -0,0 +1,83 @@
+package taplink
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultRetryPolicy(t *testing.T) {
+	p := DefaultRetryPolicy
+	// No response
+	assert.True(t, p.ShouldRetry(0, syscall.ECONNRESET, 1))
+	assert.True(t, p.ShouldRetry(0, testNetError{true}, 1))
+	// A response which can't be used
+	assert.True(t, p.ShouldRetry(200, io.ErrUnexpectedEOF, 1))
+	assert.True(t, p.ShouldRetry(200, ErrUnexpectedContentType, 1))
+	// Retryable statuses
+	for _, code := range []int{408, 429, 500, 502, 503, 504} {
+		assert.True(t, p.ShouldRetry(code, &APIError{StatusCode: code}, 1), code)
+	}
+	// Statuses which would fail again
+	for _, code := range []int{400, 401, 403, 404, 501, 505} {
+		assert.False(t, p.ShouldRetry(code, &APIError{StatusCode: code}, 1), code)
+	}
+}
+
+func TestRetryPolicyStatuses(t *testing.T) {
+	_, restore := useScript()
+	defer restore()
+	c := New(testAppID).(*Client)
+	c.Stats().Enable()
+	c.Config().SetRetryPolicy(3, 0)
+
+	tests := []struct {
+		outcome  taplinktest.Outcome
+		attempts int
+	}{
+		{taplinktest.TransportError(syscall.ECONNRESET), 3},
+		{taplinktest.Respond(429, "slow down"), 3},
+		{taplinktest.Respond(408, "timeout"), 3},
+		{taplinktest.Respond(501, "not implemented"), 1},
+		{taplinktest.Respond(401, "unauthorized"), 1},
+	}
+	for _, tt := range tests {
+		st := &taplinktest.ScriptedTransport{}
+		HTTPClient.Transport = st
+		c.Stats().Reset()
+		st.Enqueue(taplinktest.Repeat(3, tt.outcome)...)
+		_, err := c.getFromAPI(testAppID)
+		assert.Error(t, err)
+		assert.Equal(t, tt.attempts, st.Attempts(DefaultHost), tt.outcome)
+		hs := c.Stats().Get(DefaultHost)
+		assert.Equal(t, tt.attempts, hs.Errors().Len(), tt.outcome)
+	}
+}
+
+func TestUseRetryPolicy(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(404, "not found"), taplinktest.Respond(404, "not found"), taplinktest.Respond(200, `{"servers":[]}`))
+	c := New(testAppID).(*Client)
+	c.Stats().Enable()
+
+	var calls []int
+	c.Config().UseRetryPolicy(RetryPolicyFunc(func(code int, err error, attempt int) bool {
+		calls = append(calls, attempt)
+		var apiErr *APIError
+		return errors.As(err, &apiErr) && code == 404
+	}))
+	_, err := c.getFromAPI(testAppID)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, calls)
+	// The policy doesn't change what's recorded
+	assert.Equal(t, Errors{404: 2}, c.Stats().Get(DefaultHost).Errors())
+	assert.Equal(t, 1, c.Stats().Get(DefaultHost).Requests())
+
+	c.Config().UseRetryPolicy(nil)
+	assert.Equal(t, DefaultRetryPolicy, c.Config().RetryPolicy())
+}
@@ -0,0 +1,118 @@
+package taplink
+
+import "time"
+
+// rankWindow is the window hosts are ranked over by Statistics.Hosts
+const rankWindow = time.Minute
+
+// rollingWindow keeps the aggregates a host is ranked by over the last
+// rankWindow. They're updated as events are added and old events are expired
+// lazily, so ranking a host doesn't need to walk its events like Last does.
+type rollingWindow struct {
+	// latency, errors and timeouts are the indexes of the first event of
+	// each kind in the window
+	latency, errors, timeouts int
+
+	// latencySum is the total latency of the successes in the window
+	latencySum time.Duration
+	// failed is the number of errors in the window which aren't client
+	// errors, see serverFailure
+	failed int
+
+	// penaltySum is the total latency of the errors and timeouts in the
+	// window which were recorded with one, and penalties their number
+	penaltySum time.Duration
+	penalties  int
+}
+
+// addPenalty adds the latency of an error or timeout to the window
+func (w *rollingWindow) addPenalty(latency time.Duration) {
+	if latency > 0 {
+		w.penaltySum += latency
+		w.penalties++
+	}
+}
+
+// dropPenalty removes the latency of an error or timeout from the window
+func (w *rollingWindow) dropPenalty(latency time.Duration) {
+	if latency > 0 {
+		w.penaltySum -= latency
+		w.penalties--
+	}
+}
+
+// serverFailure returns whether an error with code counts against the host
+// when it's ranked, which is any error but a client error (4xx)
+func serverFailure(code int) bool {
+	return code < 400 || code >= 500
+}
+
+// dropLatency removes dropped, the oldest successes, from the window
+func (w *rollingWindow) dropLatency(dropped []successResp) {
+	for i := w.latency; i < len(dropped); i++ {
+		w.latencySum -= dropped[i].latency
+	}
+	w.latency = max(w.latency-len(dropped), 0)
+}
+
+// dropErrors removes dropped, the oldest errors, from the window
+func (w *rollingWindow) dropErrors(dropped []errorResp) {
+	for i := w.errors; i < len(dropped); i++ {
+		if serverFailure(dropped[i].code) {
+			w.failed--
+		}
+		w.dropPenalty(dropped[i].latency)
+	}
+	w.errors = max(w.errors-len(dropped), 0)
+}
+
+// dropTimeouts removes dropped, the oldest timeouts, from the window
+func (w *rollingWindow) dropTimeouts(dropped []timeoutResp) {
+	for i := w.timeouts; i < len(dropped); i++ {
+		w.dropPenalty(dropped[i].latency)
+	}
+	w.timeouts = max(w.timeouts-len(dropped), 0)
+}
+
+// expire moves the start of the window past the events before cutoff. It
+// must be called with s.mu held for writing.
+func (s *hostStatistics) expire(cutoff time.Time) {
+	w := &s.window
+	for ; w.latency < len(s.latency) && s.latency[w.latency].ts.Before(cutoff); w.latency++ {
+		w.latencySum -= s.latency[w.latency].latency
+	}
+	for ; w.errors < len(s.errors) && s.errors[w.errors].ts.Before(cutoff); w.errors++ {
+		if serverFailure(s.errors[w.errors].code) {
+			w.failed--
+		}
+		w.dropPenalty(s.errors[w.errors].latency)
+	}
+	for ; w.timeouts < len(s.timeouts) && s.timeouts[w.timeouts].ts.Before(cutoff); w.timeouts++ {
+		w.dropPenalty(s.timeouts[w.timeouts].latency)
+	}
+}
+
+// rank returns how the host ranks over the rankWindow up to now: the same as
+// ranking the result of Last(rankWindow), without copying its events. It
+// must be called with s.mu held for writing, as it expires old events.
+func (s *hostStatistics) rank(now time.Time) hostRank {
+	cutoff := now.Add(-rankWindow)
+	s.expire(cutoff)
+	w := &s.window
+	successes := len(s.latency) - w.latency
+	timeouts := len(s.timeouts) - w.timeouts
+	r := hostRank{host: s.host}
+	if failed := w.failed + timeouts; failed > 0 {
+		r.errorRate = float64(failed) / float64(successes+len(s.errors)-w.errors+timeouts)
+	}
+	// The latency is a penalty latency: errors and timeouts recorded with a
+	// latency count towards it, so a host which is slow to fail ranks below
+	// one which fails fast
+	if n := successes + w.penalties; n > 0 {
+		r.latency = (w.latencySum + w.penaltySum) / time.Duration(n)
+	}
+	if n := len(s.probes); n > 0 && !s.probes[n-1].ts.Before(cutoff) {
+		r.failing = !s.probes[n-1].healthy
+	}
+	return r
+}
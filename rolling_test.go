@@ -0,0 +1,112 @@
+package taplink
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lastRank is how hosts were ranked before the rolling window, from the
+// result of Last. It's kept to check the window against, and to compare with
+// in BenchmarkStatsHostsLast.
+func lastRank(hs *hostStatistics) hostRank {
+	m := hs.Last(rankWindow)
+	lat := m.Latency()
+	for _, d := range append(m.ErrorLatency(), m.TimeoutLatency()...) {
+		if d > 0 {
+			lat = append(lat, d)
+		}
+	}
+	return hostRank{host: hs.Host(), errorRate: m.ServerErrorRate(), latency: lat.Avg(), failing: m.Probes().Failing}
+}
+
+func TestRollingWindowRank(t *testing.T) {
+	s := newHostStatistics("foo.com")
+	s.capacity = 5
+	assert.Equal(t, lastRank(s), rankHost(s, false))
+
+	// The window follows the events as they're added and trimmed
+	for i := 0; i < 12; i++ {
+		s.addSuccess(time.Duration(i+1) * time.Millisecond)
+		switch i % 4 {
+		case 0:
+			s.addError(503, time.Duration(i+5)*time.Millisecond)
+		case 1:
+			s.addError(401, 0)
+		case 2:
+			s.addTimeout(time.Duration(i) * time.Millisecond)
+		case 3:
+			s.addError(TransportErrorCode, 2*time.Millisecond)
+		}
+		if i%3 == 0 {
+			s.addProbe(time.Millisecond, i%2 == 0)
+		}
+		assert.Equal(t, lastRank(s), rankHost(s, false))
+	}
+
+	// Events older than the window are expired
+	s.mu.Lock()
+	r := s.rank(time.Now().Add(2 * rankWindow))
+	assert.Equal(t, hostRank{host: "foo.com"}, r)
+	assert.Equal(t, rollingWindow{latency: 5, errors: 5, timeouts: 3}, s.window)
+	s.mu.Unlock()
+
+	s.reset()
+	assert.Equal(t, rollingWindow{}, s.window)
+	s.addSuccess(time.Millisecond)
+	s.addError(500, 0)
+	assert.Equal(t, hostRank{host: "foo.com", errorRate: 0.5, latency: time.Millisecond}, rankHost(s, false))
+}
+
+// newBenchStatistics returns stats with n events of each host of hosts,
+// mostly successes
+func newBenchStatistics(hosts, n int) *statistics {
+	s := newStatistics()
+	s.Enable()
+	s.setLimits(0, 0)
+	for h := 0; h < hosts; h++ {
+		host := fmt.Sprintf("host%d.example.com", h)
+		for i := 0; i < n; i++ {
+			switch {
+			case i%20 == 0:
+				s.AddError(host, 503, 0)
+			case i%50 == 1:
+				s.AddTimeout(host, 0)
+			default:
+				s.AddSuccess(host, time.Duration(h+i%7)*time.Millisecond)
+			}
+		}
+	}
+	return s
+}
+
+// BenchmarkStatsHosts ranks hosts with 50k events each, as every retry does
+func BenchmarkStatsHosts(b *testing.B) {
+	s := newBenchStatistics(5, 50000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = s.Hosts()
+	}
+}
+
+// BenchmarkStatsHostsLast is BenchmarkStatsHosts, ranking the hosts by the
+// results of Last as Hosts used to
+func BenchmarkStatsHostsLast(b *testing.B) {
+	s := newBenchStatistics(5, 50000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.mu.RLock()
+		hfr := make(hostFailRate, 0, len(s.stats))
+		for _, hs := range s.stats {
+			hfr = append(hfr, lastRank(hs))
+		}
+		s.mu.RUnlock()
+		sort.Sort(hfr)
+		_ = hfr.Hosts()
+	}
+}
@@ -0,0 +1,89 @@
+package taplink
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SaltEncoding is how the salts in responses from the API are encoded
+type SaltEncoding int
+
+const (
+	// SaltEncodingAuto decodes salts which only have hex digits as hex, and
+	// others as standard base64, with or without padding. It's the default,
+	// and accepts the responses of proxies which re-encode the salts as
+	// base64.
+	SaltEncodingAuto SaltEncoding = iota
+	// SaltEncodingHex only accepts hex salts, as the API sends them
+	SaltEncodingHex
+	// SaltEncodingBase64 only accepts standard base64 salts, with or without
+	// padding
+	SaltEncodingBase64
+)
+
+// String implements the fmt.Stringer interface
+func (e SaltEncoding) String() string {
+	switch e {
+	case SaltEncodingAuto:
+		return "auto"
+	case SaltEncodingHex:
+		return "hex"
+	case SaltEncodingBase64:
+		return "base64"
+	}
+	return fmt.Sprintf("SaltEncoding(%d)", int(e))
+}
+
+// decode decodes s. The length of the result isn't checked.
+func (e SaltEncoding) decode(s string) ([]byte, error) {
+	switch e {
+	case SaltEncodingHex:
+		return hex.DecodeString(s)
+	case SaltEncodingBase64:
+		return decodeBase64(s)
+	}
+	if isHex(s) {
+		return hex.DecodeString(s)
+	}
+	return decodeBase64(s)
+}
+
+// isHex returns whether s only has hex digits. A base64 salt could too, but
+// it's 86 or 88 characters long, which is unlikely with only hex digits.
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeBase64 decodes standard base64 with or without padding
+func decodeBase64(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("empty")
+	}
+	return base64.RawStdEncoding.Strict().DecodeString(strings.TrimRight(s, "="))
+}
+
+// SaltEncoding returns how salts in responses are decoded
+func (c *Config) SaltEncoding() SaltEncoding {
+	c.RLock()
+	defer c.RUnlock()
+	return c.saltEncoding
+}
+
+// SetSaltEncoding sets how salts in responses are decoded. By default they're
+// decoded as hex or base64, whichever they are; to reject one or the other,
+// set SaltEncodingHex or SaltEncodingBase64. Either way a salt must decode to
+// SaltSize bytes, or the request fails with ErrMalformedSalt.
+func (c *Config) SetSaltEncoding(enc SaltEncoding) {
+	c.Lock()
+	c.saltEncoding = enc
+	c.Unlock()
+}
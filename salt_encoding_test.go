@@ -0,0 +1,52 @@
+package taplink
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaltEncodings(t *testing.T) {
+	padded := base64.StdEncoding.EncodeToString(testHashExpectedSaltBytes)
+	unpadded := base64.RawStdEncoding.EncodeToString(testHashExpectedSaltBytes)
+	tests := []struct {
+		name, s2 string
+		enc      SaltEncoding
+		ok       bool
+	}{
+		{"hex", testHashExpectedSalt, SaltEncodingAuto, true},
+		{"padded base64", padded, SaltEncodingAuto, true},
+		{"unpadded base64", unpadded, SaltEncodingAuto, true},
+		{"forced hex", testHashExpectedSalt, SaltEncodingHex, true},
+		{"base64 with forced hex", padded, SaltEncodingHex, false},
+		{"forced base64", unpadded, SaltEncodingBase64, true},
+		{"hex with forced base64", testHashExpectedSalt, SaltEncodingBase64, false},
+		{"garbage", "not a salt!", SaltEncodingAuto, false},
+		{"short base64", padded[:44], SaltEncodingAuto, false},
+	}
+	for _, tt := range tests {
+		st, restore := useScript()
+		st.Enqueue(taplinktest.Respond(200, `{"s2":"`+tt.s2+`","vid":3}`))
+		c := New(testAppID).(*Client)
+		c.Config().SetSaltEncoding(tt.enc)
+		assert.Equal(t, tt.enc, c.Config().SaltEncoding(), tt.name)
+		s, err := c.GetSalt(testHashBytes, 0)
+		if tt.ok {
+			if assert.NoError(t, err, tt.name) {
+				assert.Equal(t, testHashExpectedSaltBytes, s.Salt, tt.name)
+			}
+		} else {
+			assert.ErrorIs(t, err, ErrMalformedSalt, tt.name)
+		}
+		restore()
+	}
+}
+
+func TestSaltEncodingString(t *testing.T) {
+	assert.Equal(t, "auto", SaltEncodingAuto.String())
+	assert.Equal(t, "hex", SaltEncodingHex.String())
+	assert.Equal(t, "base64", SaltEncodingBase64.String())
+	assert.Equal(t, "SaltEncoding(7)", SaltEncoding(7).String())
+}
@@ -0,0 +1,136 @@
+package taplink
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+type saltCacheEntry struct {
+	key     [sha256.Size]byte
+	salt    Salt
+	expires time.Time
+}
+
+// saltCache is an in-memory cache of GetSalt results with a TTL, which
+// evicts the least recently used entry when it's full. Like the verify memo,
+// keys are a SHA-256 of the inputs so the password hash isn't held by it.
+type saltCache struct {
+	ttl        time.Duration
+	maxEntries int
+	entries    map[[sha256.Size]byte]*list.Element
+	lru        *list.List
+
+	mu sync.Mutex
+}
+
+func newSaltCache(ttl time.Duration, maxEntries int) *saltCache {
+	return &saltCache{ttl: ttl, maxEntries: maxEntries, entries: make(map[[sha256.Size]byte]*list.Element), lru: list.New()}
+}
+
+func saltCacheKey(hash []byte, versionID int64) [sha256.Size]byte {
+	var b [8]byte
+	sum := sha256.New()
+	binary.BigEndian.PutUint64(b[:], uint64(versionID))
+	sum.Write(b[:])
+	sum.Write(hash)
+	var key [sha256.Size]byte
+	copy(key[:], sum.Sum(nil))
+	return key
+}
+
+// get returns a copy of the cached salt for key, if there is one
+func (c *saltCache) get(key [sha256.Size]byte) (*Salt, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*saltCacheEntry)
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return copySalt(&e.salt), true
+}
+
+// set stores a copy of s for key, evicting the least recently used entries
+// if the cache is full
+func (c *saltCache) set(key [sha256.Size]byte, s *Salt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxEntries <= 0 {
+		return
+	}
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*saltCacheEntry)
+		e.salt.Wipe()
+		e.salt, e.expires = *copySalt(s), expires
+		c.lru.MoveToFront(el)
+		return
+	}
+	for c.lru.Len() >= c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(&saltCacheEntry{key: key, salt: *copySalt(s), expires: expires})
+}
+
+func (c *saltCache) remove(el *list.Element) {
+	e := el.Value.(*saltCacheEntry)
+	e.salt.Wipe()
+	delete(c.entries, e.key)
+	c.lru.Remove(el)
+}
+
+// len returns the number of entries in the cache, including expired ones
+func (c *saltCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// wipe removes all entries from the cache, scrubbing their salts
+func (c *saltCache) wipe() {
+	c.mu.Lock()
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		el.Value.(*saltCacheEntry).salt.Wipe()
+	}
+	c.entries = make(map[[sha256.Size]byte]*list.Element)
+	c.lru.Init()
+	c.mu.Unlock()
+}
+
+func copySalt(s *Salt) *Salt {
+	cp := *s
+	cp.Salt = copyBytes(s.Salt)
+	cp.NewSalt = copyBytes(s.NewSalt)
+	return &cp
+}
+
+// EnableSaltCache caches GetSalt results for ttl, so repeated requests for
+// the same hash and version (for example failed login retries) don't each
+// make a request. At most maxEntries salts are kept, and the least recently
+// used is evicted when the cache is full.
+//
+// The cache is held in memory only, and keyed by a SHA-256 of the hash and
+// version. It does hold the salts themselves, so keep the ttl short.
+func (c *Client) EnableSaltCache(ttl time.Duration, maxEntries int) {
+	c.Lock()
+	c.salts = newSaltCache(ttl, maxEntries)
+	c.Unlock()
+}
+
+// DisableSaltCache disables the salt cache and wipes any cached salts
+func (c *Client) DisableSaltCache() {
+	c.Lock()
+	if c.salts != nil {
+		c.salts.wipe()
+	}
+	c.salts = nil
+	c.Unlock()
+}
@@ -0,0 +1,156 @@
+package taplink
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaltCache(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID).(*Client)
+	c.EnableSaltCache(time.Second, 10)
+
+	for i := 0; i < 3; i++ {
+		s, err := c.GetSalt(testHashBytes, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, testHashExpectedSaltBytes, s.Salt)
+	}
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+
+	// A different version is a different entry.
+	_, err := c.GetSalt(testHashBytes, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+
+	// Salts are copies, so changing them doesn't change the cache.
+	s, _ := c.GetSalt(testHashBytes, 0)
+	s.Salt[0]++
+	s, _ = c.GetSalt(testHashBytes, 0)
+	assert.Equal(t, testHashExpectedSaltBytes, s.Salt)
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+
+	// NewPassword and VerifyPassword use the cache too.
+	_, err = c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+
+	c.DisableSaltCache()
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, st.Attempts(DefaultHost))
+}
+
+func TestSaltCacheClosed(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID).(*Client)
+	c.EnableSaltCache(time.Second, 10)
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+
+	// The cached salt is wiped on Close, and isn't handed out after it
+	cache := c.salts
+	cached := cache.entries[saltCacheKey(testHashBytes, 0)].Value.(*saltCacheEntry).salt.Salt
+	assert.NoError(t, c.Close())
+	assert.Equal(t, make([]byte, len(cached)), cached)
+	assert.Nil(t, c.salts)
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.Equal(t, ErrClientClosed, err)
+	_, err = c.NewPassword(testHashBytes)
+	assert.Equal(t, ErrClientClosed, err)
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+}
+
+func TestSaltCacheErrorsNotCached(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(401, "unauthorized"))
+	c := New(testAppID).(*Client)
+	c.EnableSaltCache(time.Second, 10)
+	for i := 0; i < 2; i++ {
+		_, err := c.GetSalt(testHashBytes, 0)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+}
+
+func TestSaltCacheRejectsVersion(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID).(*Client)
+	c.EnableSaltCache(time.Second, 10)
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+
+	// Raising the minimum version applies to cached salts too.
+	c.SetMinimumVersion(4)
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.ErrorIs(t, err, ErrVersionBelowMinimum)
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+}
+
+func TestSaltCacheTTL(t *testing.T) {
+	sc := newSaltCache(10*time.Millisecond, 10)
+	key := saltCacheKey(testHashBytes, 0)
+	sc.set(key, &Salt{VersionID: 1})
+	_, ok := sc.get(key)
+	assert.True(t, ok)
+	time.Sleep(20 * time.Millisecond)
+	_, ok = sc.get(key)
+	assert.False(t, ok)
+	assert.Equal(t, 0, sc.len())
+}
+
+func TestSaltCacheLRU(t *testing.T) {
+	sc := newSaltCache(time.Second, 2)
+	k1 := saltCacheKey(testHashBytes, 1)
+	k2 := saltCacheKey(testHashBytes, 2)
+	k3 := saltCacheKey(testHashBytes, 3)
+	sc.set(k1, &Salt{VersionID: 1})
+	sc.set(k2, &Salt{VersionID: 2})
+
+	// Using k1 makes k2 the least recently used, so it's evicted for k3.
+	_, ok := sc.get(k1)
+	assert.True(t, ok)
+	sc.set(k3, &Salt{VersionID: 3})
+	assert.Equal(t, 2, sc.len())
+	_, ok = sc.get(k2)
+	assert.False(t, ok)
+	s, ok := sc.get(k1)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), s.VersionID)
+	_, ok = sc.get(k3)
+	assert.True(t, ok)
+
+	// Updating an entry doesn't grow the cache.
+	sc.set(k3, &Salt{VersionID: 4})
+	assert.Equal(t, 2, sc.len())
+	s, _ = sc.get(k3)
+	assert.Equal(t, int64(4), s.VersionID)
+}
+
+func TestSaltCacheConcurrent(t *testing.T) {
+	sc := newSaltCache(time.Second, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := saltCacheKey(testHashBytes, int64(i%12))
+			for j := 0; j < 100; j++ {
+				sc.set(key, &Salt{VersionID: int64(i), Salt: []byte{byte(j)}})
+				sc.get(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.True(t, sc.len() <= 8)
+}
@@ -0,0 +1,52 @@
+package taplink
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The schema versions of the JSON documents the package writes are declared
+// here, as "major.minor". The minor version is bumped for changes older
+// readers can ignore, like new fields, and the major version for anything else.
+
+// configSchemaVersion is the schema version of configurations saved by
+// Config.SaveTo
+const configSchemaVersion = "1.0"
+
+// ErrSchemaVersion is returned when loading a JSON document whose schema
+// version isn't compatible with the one the package writes
+type ErrSchemaVersion struct {
+	Got  string
+	Want string
+}
+
+func (e ErrSchemaVersion) Error() string {
+	return fmt.Sprintf("schema version %q is not compatible with %q", e.Got, e.Want)
+}
+
+// checkSchemaVersion returns an ErrSchemaVersion unless got has the same
+// major version as want. Minor versions, older or newer, are accepted.
+func checkSchemaVersion(got, want string) error {
+	gotMajor, ok := schemaMajor(got)
+	wantMajor, _ := schemaMajor(want)
+	if !ok || gotMajor != wantMajor {
+		return ErrSchemaVersion{Got: got, Want: want}
+	}
+	return nil
+}
+
+func schemaMajor(v string) (int, bool) {
+	i := strings.IndexByte(v, '.')
+	if i < 0 {
+		return 0, false
+	}
+	major, err := strconv.Atoi(v[:i])
+	if err != nil {
+		return 0, false
+	}
+	if _, err := strconv.Atoi(v[i+1:]); err != nil {
+		return 0, false
+	}
+	return major, true
+}
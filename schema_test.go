@@ -0,0 +1,22 @@
+package taplink
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSchemaVersion(t *testing.T) {
+	assert.NoError(t, checkSchemaVersion("1.0", "1.0"))
+	assert.NoError(t, checkSchemaVersion("1.3", "1.0"))
+	assert.NoError(t, checkSchemaVersion("1.0", "1.3"))
+	for _, got := range []string{"2.0", "0.9", "", "1", "1.x", "x.0"} {
+		err := checkSchemaVersion(got, "1.0")
+		var verr ErrSchemaVersion
+		if assert.True(t, errors.As(err, &verr), got) {
+			assert.Equal(t, ErrSchemaVersion{Got: got, Want: "1.0"}, verr)
+		}
+	}
+	assert.EqualError(t, checkSchemaVersion("2.0", "1.0"), `schema version "2.0" is not compatible with "1.0"`)
+}
@@ -0,0 +1,166 @@
+package taplink
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// HostSelector picks the server each attempt of a request is made to, in
+// place of the host selection method. It's called concurrently by requests,
+// so it must be safe for concurrent use.
+type HostSelector interface {
+	// Select returns the server to make attempt to, counting from 0 for the
+	// first attempt of a request, out of servers: the active servers, which
+	// are never empty. A retry's attempt number can be used to avoid picking
+	// the server the previous attempt failed on. If it returns a host which
+	// isn't one of servers, the first of them is used.
+	Select(servers []string, stats Statistics, attempt int) string
+}
+
+// HostSelectorFunc adapts a func to the HostSelector interface
+type HostSelectorFunc func(servers []string, stats Statistics, attempt int) string
+
+// Select implements the HostSelector interface
+func (f HostSelectorFunc) Select(servers []string, stats Statistics, attempt int) string {
+	return f(servers, stats, attempt)
+}
+
+type primarySelector struct{}
+
+// PrimarySelector returns a HostSelector which makes the first attempt of
+// each request to the first server, and retries to the servers after it in
+// turn. Hosts whose circuit breaker is open are skipped.
+func PrimarySelector() HostSelector {
+	return primarySelector{}
+}
+
+// Select implements the HostSelector interface
+func (primarySelector) Select(servers []string, stats Statistics, attempt int) string {
+	return pickHost(stats, servers, attempt)
+}
+
+type roundRobinSelector struct {
+	next uint32
+}
+
+// RoundRobinSelector returns a HostSelector which makes the first attempt of
+// each request to the server after the one the previous request started at,
+// and retries to the servers after that in turn. A request's retry goes to
+// the server after the one its previous attempt failed on, however many
+// requests have started since. Hosts whose circuit breaker is open are
+// skipped.
+func RoundRobinSelector() HostSelector {
+	return &roundRobinSelector{}
+}
+
+// Select implements the HostSelector interface
+func (s *roundRobinSelector) Select(servers []string, stats Statistics, attempt int) string {
+	var start uint32
+	if attempt == 0 {
+		start = atomic.AddUint32(&s.next, 1) - 1
+	} else {
+		start = atomic.LoadUint32(&s.next) - 1
+	}
+	return pickHost(stats, servers, int((start+uint32(attempt))%uint32(len(servers))))
+}
+
+// selectRetry implements the retrySelector interface, going on from the
+// server failed rather than from where the latest request started
+func (s *roundRobinSelector) selectRetry(servers []string, stats Statistics, attempt int, failed string) string {
+	for i, h := range servers {
+		if h == failed {
+			return pickHost(stats, servers, i+1)
+		}
+	}
+	return s.Select(servers, stats, attempt)
+}
+
+type randomSelector struct{}
+
+// RandomSelector returns a HostSelector which makes each attempt to a random
+// server. Hosts whose circuit breaker is open are skipped.
+func RandomSelector() HostSelector {
+	return randomSelector{}
+}
+
+// Select implements the HostSelector interface
+func (randomSelector) Select(servers []string, stats Statistics, attempt int) string {
+	return pickHost(stats, servers, rand.Intn(len(servers)))
+}
+
+type rankedSelector struct{}
+
+// RankedSelector returns a HostSelector which makes the first attempt of
+// each request like PrimarySelector, and retries to the other servers in the
+// order ranked by stats.Hosts(), best first. While stats are disabled, or
+// there are none for the last minute, retries go to the servers after the
+// first in turn instead.
+func RankedSelector() HostSelector {
+	return rankedSelector{}
+}
+
+// Select implements the HostSelector interface
+func (rankedSelector) Select(servers []string, stats Statistics, attempt int) string {
+	first := pickHost(stats, servers, 0)
+	if attempt == 0 {
+		return first
+	}
+	ranked := rankedHosts(stats, servers)
+	if ranked == nil {
+		return pickHost(stats, servers, attempt)
+	}
+	return pickHost(stats, withoutHost(ranked, first), attempt-1)
+}
+
+// withoutHost returns hosts without host, unless it's the only one
+func withoutHost(hosts []string, host string) []string {
+	if len(hosts) < 2 {
+		return hosts
+	}
+	for i, h := range hosts {
+		if h == host {
+			return append(hosts[:i:i], hosts[i+1:]...)
+		}
+	}
+	return hosts
+}
+
+// HostSelector returns the selector set with SetHostSelector, or nil if the
+// host selection method is used
+func (c *Config) HostSelector() HostSelector {
+	c.RLock()
+	defer c.RUnlock()
+	return c.selector
+}
+
+// SetHostSelector makes s pick the server for each attempt of a request, in
+// place of the host selection method. A nil s goes back to the host
+// selection method.
+func (c *Config) SetHostSelector(s HostSelector) {
+	c.Lock()
+	c.selector = s
+	c.Unlock()
+}
+
+// retrySelector is implemented by a HostSelector which picks the server for a
+// retry from the one the previous attempt failed on
+type retrySelector interface {
+	selectRetry(servers []string, stats Statistics, attempt int, failed string) string
+}
+
+// selectHost asks s for the server for attempt, out of hosts, which is the
+// caller's own copy. failed is the server the previous attempt failed on.
+func selectHost(s HostSelector, stats Statistics, hosts []string, attempt int, failed string) string {
+	var host string
+	if rs, ok := s.(retrySelector); ok && attempt > 0 {
+		host = rs.selectRetry(hosts, stats, attempt, failed)
+	} else {
+		host = s.Select(hosts, stats, attempt)
+	}
+	for _, h := range hosts {
+		if h == host {
+			return host
+		}
+	}
+	return hosts[0]
+}
@@ -0,0 +1,121 @@
+package taplink
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostSelector(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := New(testAppID).(*Client)
+	c.Config().SetBackoff(ConstantBackoff(0))
+	assert.NoError(t, c.Config().SetServers([]string{"us-1.com", "eu-1.com", "eu-2.com"}))
+
+	// Prefer the EU hosts, moving on to the next for each retry.
+	var attempts []int
+	c.Config().SetHostSelector(HostSelectorFunc(func(servers []string, stats Statistics, attempt int) string {
+		attempts = append(attempts, attempt)
+		var eu []string
+		for _, h := range servers {
+			if h[:2] == "eu" {
+				eu = append(eu, h)
+			}
+		}
+		return eu[attempt%len(eu)]
+	}))
+	st.Enqueue(taplinktest.Respond(503, "error"), taplinktest.Respond(503, "error"), taplinktest.Respond(200, "ok"))
+	_, err := c.getFromAPI("foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eu-1.com", "eu-2.com", "eu-1.com"}, st.Hosts())
+	assert.Equal(t, []int{0, 1, 2}, attempts)
+
+	// A host which isn't a server falls back to the first one.
+	c.Config().SetHostSelector(HostSelectorFunc(func([]string, Statistics, int) string {
+		return "elsewhere.com"
+	}))
+	assert.Equal(t, "us-1.com", c.Config().Host(0))
+
+	// And nil goes back to the host selection method.
+	c.Config().SetHostSelector(nil)
+	assert.Nil(t, c.Config().HostSelector())
+	assert.Equal(t, "eu-1.com", c.Config().Host(1))
+}
+
+func TestBuiltinHostSelectors(t *testing.T) {
+	servers := []string{"a.com", "b.com", "c.com"}
+	stats := newStatistics()
+
+	var hosts []string
+	for i := 0; i < 4; i++ {
+		hosts = append(hosts, PrimarySelector().Select(servers, stats, i))
+	}
+	assert.Equal(t, []string{"a.com", "b.com", "c.com", "a.com"}, hosts)
+
+	rr := RoundRobinSelector()
+	hosts = nil
+	for i := 0; i < 3; i++ {
+		hosts = append(hosts, rr.Select(servers, stats, 0), rr.Select(servers, stats, 1))
+	}
+	assert.Equal(t, []string{"a.com", "b.com", "b.com", "c.com", "c.com", "a.com"}, hosts)
+
+	// A retry goes on from the server which failed, even once other requests
+	// have started since
+	c := newConfig("")
+	assert.NoError(t, c.SetServers(servers))
+	c.SetHostSelector(RoundRobinSelector())
+	first := c.RetryHost(0, 0, "")
+	for i := 0; i < 2; i++ {
+		c.RetryHost(0, 0, "")
+	}
+	assert.Equal(t, "a.com", first)
+	assert.Equal(t, "b.com", c.RetryHost(0, 1, first))
+	assert.Equal(t, "c.com", c.RetryHost(0, 2, "b.com"))
+
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		counts[RandomSelector().Select(servers, stats, 0)]++
+	}
+	assert.Len(t, counts, 3)
+
+	// Without stats, retries go through the servers in turn.
+	assert.Equal(t, "a.com", RankedSelector().Select(servers, stats, 0))
+	assert.Equal(t, "b.com", RankedSelector().Select(servers, stats, 1))
+
+	// With them, they go to the best ranked other than the first server.
+	stats.Enable()
+	stats.AddError("b.com", http.StatusServiceUnavailable, 0)
+	stats.AddSuccess("c.com", time.Millisecond)
+	stats.AddSuccess("a.com", time.Millisecond)
+	assert.Equal(t, "a.com", RankedSelector().Select(servers, stats, 0))
+	assert.Equal(t, "c.com", RankedSelector().Select(servers, stats, 1))
+	assert.Equal(t, "b.com", RankedSelector().Select(servers, stats, 2))
+}
+
+func TestHostSelectorConcurrent(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	f.Servers = []string{"a.com", "b.com", "c.com"}
+	c := New(testAppID).(*Client)
+	assert.NoError(t, c.Config().Load())
+	c.Config().SetHostSelector(RoundRobinSelector())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.NewPassword(testHashBytes)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	for _, h := range f.Servers {
+		assert.Equal(t, 10, f.Attempts(h), h)
+	}
+}
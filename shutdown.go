@@ -0,0 +1,294 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrClientClosed is returned by requests made once a client has started
+// shutting down
+var ErrClientClosed = errors.New("client closed")
+
+// ShutdownState is how far a client has got through shutting down
+type ShutdownState int32
+
+// The shutdown states, in the order Shutdown goes through them
+const (
+	// StateRunning means the client is accepting requests
+	StateRunning ShutdownState = iota
+	// StateDraining means new requests are rejected, requests queued on the
+	// rate limiter are cancelled and in-flight requests are being waited for
+	StateDraining
+	// StateStoppingBackground means background loops are being stopped
+	StateStoppingBackground
+	// StateFlushingStats means stats are being persisted
+	StateFlushingStats
+	// StateClosingConnections means idle connections are being closed
+	StateClosingConnections
+	// StateShutdown means the client has shut down
+	StateShutdown
+)
+
+var shutdownStateNames = [...]string{"running", "draining", "stopping background", "flushing stats", "closing connections", "shut down"}
+
+func (s ShutdownState) String() string {
+	if s < 0 || int(s) >= len(shutdownStateNames) {
+		return "unknown"
+	}
+	return shutdownStateNames[s]
+}
+
+type lifecycle struct {
+	state    ShutdownState
+	inflight sync.WaitGroup
+
+	// quit is closed when shutdown starts, and done when it has finished
+	quit chan struct{}
+	done chan struct{}
+
+	// root is the client's root context, which the background work's
+	// contexts are derived from, and cancel cancels it when shutdown starts
+	root   context.Context
+	cancel context.CancelFunc
+
+	// stoppers stop background loops, and flushers persist stats
+	stoppers []func()
+	flushers []func() error
+
+	// changed is called on each state change, for tests
+	changed func(ShutdownState)
+
+	mu sync.Mutex
+}
+
+func newLifecycle() *lifecycle {
+	root, cancel := context.WithCancel(context.Background())
+	return &lifecycle{quit: make(chan struct{}), done: make(chan struct{}), root: root, cancel: cancel}
+}
+
+// begin registers an in-flight request, unless the client is shutting down.
+// end must be called when the request is finished.
+func (l *lifecycle) begin() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.state != StateRunning {
+		return ErrClientClosed
+	}
+	l.inflight.Add(1)
+	return nil
+}
+
+func (l *lifecycle) end() {
+	l.inflight.Done()
+}
+
+// onStop registers a func which stops a background loop during shutdown
+func (l *lifecycle) onStop(fn func()) {
+	l.mu.Lock()
+	l.stoppers = append(l.stoppers, fn)
+	l.mu.Unlock()
+}
+
+// onFlush registers a func which persists stats during shutdown, unless
+// shutdown has started
+func (l *lifecycle) onFlush(fn func() error) {
+	l.mu.Lock()
+	if l.state == StateRunning {
+		l.flushers = append(l.flushers, fn)
+	}
+	l.mu.Unlock()
+}
+
+func (l *lifecycle) setState(s ShutdownState) {
+	l.mu.Lock()
+	l.state = s
+	fn := l.changed
+	l.mu.Unlock()
+	if fn != nil {
+		fn(s)
+	}
+}
+
+// queueContext returns a context for waiting on the rate limiter, which is
+// also cancelled when shutdown starts. The returned func must be called once
+// the wait is over.
+func (l *lifecycle) queueContext(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-l.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// waitQueue waits for the client's rate limiter and the shared one, if
+// any, and records the time spent waiting on both as a single queue wait.
+// The wait is cancelled with ErrClientClosed if the client starts shutting
+// down.
+func (c *Client) waitQueue(ctx context.Context, host string) error {
+	var bucket *tokenBucket
+	var maxWait time.Duration
+	if cl, ok := c.Config().(clientLimiter); ok {
+		bucket, maxWait = cl.rateLimiter()
+	}
+	if bucket == nil && c.Config().SharedRateLimiter() == nil {
+		return nil
+	}
+	qctx, cancel := c.lc.queueContext(ctx)
+	defer cancel()
+	var wait, shared time.Duration
+	var err error
+	if bucket != nil {
+		wait, err = bucket.wait(qctx, maxWait)
+	}
+	if err == nil {
+		shared, err = waitRateLimit(qctx, c.Config())
+	}
+	if err == nil {
+		// Both waits delayed the same request, so it's recorded once
+		c.Stats().AddQueueWait(host, wait+shared)
+		return nil
+	}
+	if ctx.Err() == nil {
+		select {
+		case <-c.lc.quit:
+			return ErrClientClosed
+		default:
+		}
+	}
+	return err
+}
+
+// rootContext returns the root context of the client the config belongs to,
+// which is cancelled when it starts shutting down, or a context which is
+// never cancelled for a config of its own. Background work derives its
+// contexts from it.
+func (c *Config) rootContext() context.Context {
+	c.RLock()
+	client := c.client
+	c.RUnlock()
+	if client == nil {
+		return context.Background()
+	}
+	return client.lc.root
+}
+
+// closed returns ErrClientClosed if the client the config belongs to has
+// started shutting down, so no background loop is started which its
+// Shutdown wouldn't stop. c must be locked, which Shutdown's stoppers wait
+// for.
+func (c *Config) closed() error {
+	if c.client != nil && c.client.ShutdownState() != StateRunning {
+		return ErrClientClosed
+	}
+	return nil
+}
+
+// ShutdownState returns how far the client has got through shutting down
+func (c *Client) ShutdownState() ShutdownState {
+	c.lc.mu.Lock()
+	defer c.lc.mu.Unlock()
+	return c.lc.state
+}
+
+// Shutdown shuts the client down, in this order:
+//
+//  1. new requests are rejected with ErrClientClosed, and background work,
+//     like a reload in progress, is cancelled
+//  2. requests queued on the rate limiter are cancelled with ErrClientClosed
+//  3. in-flight requests are waited for, until ctx is done
+//  4. background loops are stopped, and the verify memo and salt cache are
+//     wiped
+//  5. stats are persisted by the PersistStatsOnShutdown funcs
+//  6. idle connections are closed
+//
+// If ctx is done before the in-flight requests finish, the remaining steps
+// still run, and ctx.Err() is returned. Otherwise the first error a
+// PersistStatsOnShutdown func returned is returned. Calling Shutdown again
+// waits for the first call to finish, or for ctx to be done.
+func (c *Client) Shutdown(ctx context.Context) error {
+	l := c.lc
+	l.mu.Lock()
+	if l.state != StateRunning {
+		l.mu.Unlock()
+		select {
+		case <-l.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	l.state = StateDraining
+	fn := l.changed
+	l.mu.Unlock()
+	if fn != nil {
+		fn(StateDraining)
+	}
+	close(l.quit)
+	l.cancel()
+	drained := make(chan struct{})
+	go func() {
+		l.inflight.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.setState(StateStoppingBackground)
+	l.mu.Lock()
+	stoppers, flushers := l.stoppers, l.flushers
+	l.mu.Unlock()
+	for _, stop := range stoppers {
+		stop()
+	}
+
+	l.setState(StateFlushingStats)
+	for _, flush := range flushers {
+		if ferr := flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+
+	l.setState(StateClosingConnections)
+	c.baseHTTPClient().CloseIdleConnections()
+	c.RLock()
+	pools := c.affinity
+	c.RUnlock()
+	pools.closeIdle()
+
+	l.setState(StateShutdown)
+	close(l.done)
+	return err
+}
+
+// PersistStatsOnShutdown registers fn to persist the stats when the client
+// shuts down, e.g. by writing them to a file. fn is given a snapshot taken
+// once the in-flight requests have finished and the background loops have
+// stopped, so nothing is recorded after it, and is called before idle
+// connections are closed. Shutdown and Close return the first error such a
+// func returns. A func registered after shutdown has started isn't called.
+func (c *Client) PersistStatsOnShutdown(fn func(s StatsSnapshot) error) {
+	c.lc.onFlush(func() error {
+		return fn(c.Stats().Snapshot())
+	})
+}
+
+// Close shuts the client down like Shutdown, letting in-flight requests
+// finish rather than cancelling them: new requests fail with
+// ErrClientClosed, background loops like AutoReload and the health checks
+// are stopped, the stats are persisted by the PersistStatsOnShutdown funcs,
+// and idle connections are closed. It returns the first error persisting
+// them, if any. Close is safe to call more than once, and concurrently with
+// requests; calls after the first wait for it to finish and return nil.
+func (c *Client) Close() error {
+	return c.Shutdown(context.Background())
+}
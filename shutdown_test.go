@@ -0,0 +1,203 @@
+package taplink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownOrdering(t *testing.T) {
+	defer checkGoroutines(t)()
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, `{"servers":[]}`))
+	st.EnqueueFor("/*/"+testHashString+"/*", taplinktest.RespondAfter(50*time.Millisecond, 200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+
+	var mu sync.Mutex
+	var events []string
+	event := func(e string) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}
+
+	// Every feature is running: reloads, probes, an observer, requests in
+	// flight and queued, and stats persisted on shutdown
+	c := New(testAppID, WithStatsEnabled(), WithVerifyMemo(time.Second, 10)).(*Client)
+	obs := &eventObserver{}
+	c.Config().AddObserver(obs)
+	reloaded := make(chan struct{}, 1)
+	c.Config().OnReload(func(*LoadInfo) {
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	})
+	assert.NoError(t, c.Config().AutoReload(5*time.Millisecond))
+	assert.NoError(t, c.Config().EnableHealthChecks(5*time.Millisecond, DefaultHost))
+	<-reloaded
+	assert.Eventually(t, func() bool { return c.Stats().Get(DefaultHost).Probes().Healthy > 0 }, time.Second, time.Millisecond)
+
+	var persisted StatsSnapshot
+	var observed int
+	var probes Probes
+	c.PersistStatsOnShutdown(func(s StatsSnapshot) error {
+		assert.Equal(t, StateFlushingStats, c.ShutdownState())
+		persisted, observed, probes = s, len(obs.Events()), c.Stats().Get(DefaultHost).Probes()
+		event("persisted")
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	var inflightErr, queuedErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, inflightErr = c.VerifyPassword(testHashBytes, nil, 0)
+		assert.Equal(t, StateDraining, c.ShutdownState())
+		event("in-flight done")
+	}()
+	assert.Eventually(t, func() bool { return c.Stats().InFlight() > 0 }, time.Second, time.Millisecond)
+
+	// Everything from here on queues on the limiter for an hour
+	c.Config().SetSharedRateLimiter(&testLimiter{interval: time.Hour, next: time.Now().Add(time.Hour)})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Another hash, so it isn't coalesced with the in-flight request
+		_, queuedErr = c.NewPassword(bytes.Repeat([]byte{1}, len(testHashBytes)))
+		event("queued done")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, StateRunning, c.ShutdownState())
+	assert.NoError(t, c.Close())
+	wg.Wait()
+	assert.Equal(t, StateShutdown, c.ShutdownState())
+	assert.NoError(t, inflightErr)
+	assert.Equal(t, ErrClientClosed, queuedErr)
+
+	// The queued request is cancelled while draining, and the in-flight one
+	// finishes before the stats are persisted
+	mu.Lock()
+	assert.Equal(t, []string{"queued done", "in-flight done", "persisted"}, events)
+	mu.Unlock()
+
+	// The background loops had stopped, so nothing was recorded or observed
+	// after the stats were persisted
+	assert.Equal(t, 0, persisted.InFlight)
+	for _, h := range persisted.Hosts {
+		assert.Equal(t, h.Totals, c.Stats().Get(h.Host).Totals(), h.Host)
+	}
+	assert.Equal(t, probes, c.Stats().Get(DefaultHost).Probes())
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, obs.Events(), observed)
+
+	// Nothing is accepted afterwards, and shutting down again is a no-op.
+	_, err := c.VerifyPassword(testHashBytes, nil, 0)
+	assert.Equal(t, ErrClientClosed, err)
+	assert.NoError(t, c.Shutdown(context.Background()))
+	assert.Len(t, obs.Events(), observed)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.RespondAfter(100*time.Millisecond, 200, "slow"))
+
+	c := New(testAppID).(*Client)
+	persistErr := errors.New("persist failed")
+	var persisted bool
+	c.PersistStatsOnShutdown(func(StatsSnapshot) error {
+		persisted = true
+		return persistErr
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.getFromAPI("slow")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// The remaining steps run even if in-flight requests don't finish in time.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, c.Shutdown(ctx))
+	assert.True(t, persisted)
+	assert.Equal(t, StateShutdown, c.ShutdownState())
+	<-done
+
+	c = New(testAppID).(*Client)
+	c.PersistStatsOnShutdown(func(StatsSnapshot) error {
+		return persistErr
+	})
+	assert.Equal(t, persistErr, c.Close())
+
+	// Nothing registered once the client is closed is called
+	c.PersistStatsOnShutdown(func(StatsSnapshot) error {
+		t.Error("persisted after shutting down")
+		return nil
+	})
+	assert.NoError(t, c.Close())
+}
+
+func TestCloseStopsAutoReload(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	f.Servers = []string{"a.com"}
+	defer checkGoroutines(t)()
+
+	c := New(testAppID).(*Client)
+	c.Config().AutoReload(time.Millisecond)
+	c.Config().EnableHealthChecks(time.Millisecond)
+	assert.Eventually(t, func() bool { return f.Requests() > 2 }, time.Second, time.Millisecond)
+
+	assert.NoError(t, c.Close())
+	assert.Equal(t, StateShutdown, c.ShutdownState())
+	n := f.Requests()
+
+	// Nothing would stop loops started once the client is closed
+	assert.ErrorIs(t, c.Config().AutoReload(time.Millisecond), ErrClientClosed)
+	assert.ErrorIs(t, c.Config().EnableHealthChecks(time.Millisecond, DefaultHost), ErrClientClosed)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, n, f.Requests())
+}
+
+func TestCloseConcurrent(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	f.Latency = 50 * time.Millisecond
+	var api API = New(testAppID)
+
+	// Requests in flight when Close is called are let finish.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := api.NewPassword(testHashBytes)
+			assert.NoError(t, err)
+		}()
+	}
+	assert.Eventually(t, func() bool { return api.Stats().InFlight() > 0 }, time.Second, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, api.Close())
+		}()
+	}
+	wg.Wait()
+
+	_, err := api.NewPassword(testHashBytes)
+	assert.Equal(t, ErrClientClosed, err)
+	_, err = api.VerifyPassword(testHashBytes, testHashBytes, 0)
+	assert.Equal(t, ErrClientClosed, err)
+	assert.NoError(t, api.Close())
+}
@@ -0,0 +1,222 @@
+package taplink
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// StatsSnapshot is a plain copy of the stats of every host, which can be
+// serialized, e.g. to report the client's health as JSON
+type StatsSnapshot struct {
+	// Time is when the snapshot was taken
+	Time      time.Time `json:"time"`
+	Enabled   bool      `json:"enabled"`
+	Fallbacks int       `json:"fallbacks"`
+	// Calls counts the client's calls, apart from their attempts
+	Calls    ClientStats    `json:"calls"`
+	InFlight int            `json:"inFlight"`
+	Hosts    []HostSnapshot `json:"hosts"`
+}
+
+// HostSnapshot is a plain copy of the stats of a host
+type HostSnapshot struct {
+	Host     string `json:"host"`
+	Requests int    `json:"requests"`
+	// Errors is the number of errors for each code
+	Errors          map[int]int    `json:"errors"`
+	Timeouts        int            `json:"timeouts"`
+	ErrorRate       float64        `json:"errorRate"`
+	ServerErrorRate float64        `json:"serverErrorRate"`
+	InFlight        int            `json:"inFlight"`
+	Latency         LatencySummary `json:"latency"`
+	// Totals are the host's events since its stats were created or reset,
+	// which Diff subtracts
+	Totals HostTotals `json:"totals"`
+}
+
+// LatencySummary summarizes the latency of successful requests. Durations
+// are serialized as milliseconds.
+type LatencySummary struct {
+	Count int
+	Avg   time.Duration
+	P95   time.Duration
+	Max   time.Duration
+}
+
+// MarshalJSON encodes the durations as milliseconds
+func (l LatencySummary) MarshalJSON() ([]byte, error) {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return json.Marshal(struct {
+		Count int     `json:"count"`
+		Avg   float64 `json:"avgMs"`
+		P95   float64 `json:"p95Ms"`
+		Max   float64 `json:"maxMs"`
+	}{l.Count, ms(l.Avg), ms(l.P95), ms(l.Max)})
+}
+
+// MarshalJSON encodes the durations as milliseconds
+func (t HostTotals) MarshalJSON() ([]byte, error) {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return json.Marshal(struct {
+		Requests  int64         `json:"requests"`
+		Latency   float64       `json:"latencyMs"`
+		Timeouts  int64         `json:"timeouts"`
+		Errors    map[int]int64 `json:"errors"`
+		Delayed   int64         `json:"delayed"`
+		QueueWait float64       `json:"queueWaitMs"`
+	}{t.Requests, ms(t.Latency), t.Timeouts, t.Errors, t.Delayed, ms(t.QueueWait)})
+}
+
+// Summary returns the count, average, p95 and maximum of the latency
+func (l Latency) Summary() LatencySummary {
+	return LatencySummary{Count: len(l), Avg: l.Avg(), P95: l.Percentile(0.95), Max: l.Max()}
+}
+
+// snapshot must be called with s.mu held
+func (s *hostStatistics) snapshot() HostSnapshot {
+	hs := HostSnapshot{
+		Host:            s.host,
+		Requests:        len(s.latency),
+		Errors:          make(map[int]int, len(s.errorCounts)),
+		Timeouts:        len(s.timeouts),
+		ErrorRate:       s.errorRate(),
+		ServerErrorRate: s.serverErrorRate(),
+		InFlight:        s.inFlight,
+		Totals:          s.totals.copyOf(),
+	}
+	for code, ct := range s.errorCounts {
+		hs.Errors[code] = int(ct)
+	}
+	lat := make(Latency, len(s.latency))
+	for i := range s.latency {
+		lat[i] = s.latency[i].latency
+	}
+	hs.Latency = lat.Summary()
+	return hs
+}
+
+// Snapshot returns a plain copy of the host's stats
+func (s *hostStatistics) Snapshot() HostSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshot()
+}
+
+// MarshalJSON encodes the host's stats as its Snapshot
+func (s *hostStatistics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Snapshot())
+}
+
+// Snapshot returns a copy of the stats of every host, sorted by host. No
+// events are recorded while it's taken, so it's consistent across hosts.
+func (s *statistics) Snapshot() StatsSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap := StatsSnapshot{Time: s.now(), Enabled: s.enabled, Fallbacks: s.fallbacks, Calls: s.calls, InFlight: s.InFlight(), Hosts: make([]HostSnapshot, 0, len(s.stats))}
+	for host, hs := range s.stats {
+		h := hs.Snapshot()
+		h.InFlight = s.hostInFlight(host)
+		snap.Hosts = append(snap.Hosts, h)
+	}
+	sort.Slice(snap.Hosts, func(i, j int) bool { return snap.Hosts[i].Host < snap.Hosts[j].Host })
+	return snap
+}
+
+// MarshalJSON encodes the stats as their Snapshot
+func (s *statistics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Snapshot())
+}
+
+// Diff returns what happened between prev and s, an earlier snapshot of the
+// same stats, so a reporter can compute deltas, e.g. the errors since its
+// last scrape, while the stats stay cumulative. Each host's requests, errors,
+// timeouts and latency count and average are those recorded in between,
+// from its Totals, and its error rates are computed from them; a host missing
+// from either snapshot is taken to have none. The percentiles and maximum of
+// the latency can't be diffed, so they're zero. InFlight and Enabled are
+// those of s, as are the fallbacks and calls since prev.
+//
+// A count lower than in prev means the stats were reset in between, so the
+// count in s is taken as the delta, as metrics systems do for counters.
+func (s StatsSnapshot) Diff(prev StatsSnapshot) StatsSnapshot {
+	d := StatsSnapshot{
+		Time:      s.Time,
+		Enabled:   s.Enabled,
+		Fallbacks: int(delta(int64(s.Fallbacks), int64(prev.Fallbacks))),
+		Calls: ClientStats{
+			Calls:     int(delta(int64(s.Calls.Calls), int64(prev.Calls.Calls))),
+			Succeeded: int(delta(int64(s.Calls.Succeeded), int64(prev.Calls.Succeeded))),
+			Failed:    int(delta(int64(s.Calls.Failed), int64(prev.Calls.Failed))),
+			Attempts:  int(delta(int64(s.Calls.Attempts), int64(prev.Calls.Attempts))),
+		},
+		InFlight: s.InFlight,
+	}
+
+	before := make(map[string]HostSnapshot, len(prev.Hosts))
+	for _, h := range prev.Hosts {
+		before[h.Host] = h
+	}
+	seen := make(map[string]bool, len(s.Hosts))
+	for _, h := range s.Hosts {
+		seen[h.Host] = true
+		d.Hosts = append(d.Hosts, h.diff(before[h.Host]))
+	}
+	for _, h := range prev.Hosts {
+		if !seen[h.Host] {
+			d.Hosts = append(d.Hosts, HostSnapshot{Host: h.Host}.diff(h))
+		}
+	}
+	sort.Slice(d.Hosts, func(i, j int) bool { return d.Hosts[i].Host < d.Hosts[j].Host })
+	return d
+}
+
+// diff returns the host's events since prev, see StatsSnapshot.Diff
+func (h HostSnapshot) diff(prev HostSnapshot) HostSnapshot {
+	t, p := h.Totals, prev.Totals
+	dt := HostTotals{
+		Requests:  delta(t.Requests, p.Requests),
+		Timeouts:  delta(t.Timeouts, p.Timeouts),
+		Errors:    make(map[int]int64, len(t.Errors)),
+		Delayed:   delta(t.Delayed, p.Delayed),
+		Latency:   time.Duration(delta(int64(t.Latency), int64(p.Latency))),
+		QueueWait: time.Duration(delta(int64(t.QueueWait), int64(p.QueueWait))),
+	}
+	d := HostSnapshot{
+		Host:     h.Host,
+		Requests: int(dt.Requests),
+		Errors:   make(map[int]int, len(t.Errors)),
+		Timeouts: int(dt.Timeouts),
+		InFlight: h.InFlight,
+		Latency:  LatencySummary{Count: int(dt.Requests)},
+		Totals:   dt,
+	}
+	if dt.Requests > 0 {
+		d.Latency.Avg = dt.Latency / time.Duration(dt.Requests)
+	}
+
+	failed, serverFailed := dt.Timeouts, dt.Timeouts
+	for code, ct := range t.Errors {
+		if n := delta(ct, p.Errors[code]); n > 0 {
+			dt.Errors[code], d.Errors[code] = n, int(n)
+			failed += n
+			if serverFailure(code) {
+				serverFailed += n
+			}
+		}
+	}
+	if total := dt.Requests + failed; total > 0 {
+		d.ErrorRate = float64(failed) / float64(total)
+		d.ServerErrorRate = float64(serverFailed) / float64(total)
+	}
+	return d
+}
+
+// delta returns how much a counter went up from prev to cur, or cur if it
+// went down because it was reset
+func delta(cur, prev int64) int64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
@@ -0,0 +1,134 @@
+package taplink
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsSnapshot(t *testing.T) {
+	s := newStatistics()
+	s.Enable()
+	s.AddSuccess("foo.com", 10*time.Millisecond)
+	s.AddSuccess("foo.com", 30*time.Millisecond)
+	s.AddError("foo.com", 503, 0)
+	s.AddTimeout("foo.com", 0)
+	s.AddError("bar.com", 401, 0)
+	s.AddFallback()
+
+	before := time.Now()
+	snap := s.Snapshot()
+	assert.False(t, snap.Time.Before(before))
+	assert.True(t, snap.Enabled)
+	assert.Equal(t, 1, snap.Fallbacks)
+	assert.Equal(t, []HostSnapshot{
+		{Host: "bar.com", Errors: map[int]int{401: 1}, ErrorRate: 1, Totals: HostTotals{Errors: map[int]int64{401: 1}}},
+		{
+			Host:            "foo.com",
+			Requests:        2,
+			Errors:          map[int]int{503: 1},
+			Timeouts:        1,
+			ErrorRate:       0.5,
+			ServerErrorRate: 0.5,
+			Latency:         LatencySummary{Count: 2, Avg: 20 * time.Millisecond, P95: 29 * time.Millisecond, Max: 30 * time.Millisecond},
+			Totals:          HostTotals{Requests: 2, Latency: 40 * time.Millisecond, Timeouts: 1, Errors: map[int]int64{503: 1}},
+		},
+	}, snap.Hosts)
+
+	// The snapshot doesn't share anything with the stats
+	snap.Hosts[1].Errors[503] = 10
+	s.AddError("foo.com", 503, 0)
+	assert.Equal(t, 2, s.Snapshot().Hosts[1].Errors[503])
+	assert.Equal(t, 1, snap.Hosts[0].Errors[401])
+}
+
+func TestStatsMarshalJSON(t *testing.T) {
+	s := newStatistics()
+	s.Enable()
+	s.AddSuccess("foo.com", 10*time.Millisecond)
+	s.AddError("foo.com", 503, 0)
+
+	b, err := json.Marshal(s.Get("foo.com"))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"host": "foo.com",
+		"requests": 1,
+		"errors": {"503": 1},
+		"timeouts": 0,
+		"errorRate": 0.5,
+		"serverErrorRate": 0.5,
+		"inFlight": 0,
+		"latency": {"count": 1, "avgMs": 10, "p95Ms": 10, "maxMs": 10},
+		"totals": {"requests": 1, "latencyMs": 10, "timeouts": 0, "errors": {"503": 1}, "delayed": 0, "queueWaitMs": 0}
+	}`, string(b))
+
+	b, err = json.Marshal(s)
+	assert.NoError(t, err)
+	var snap map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &snap))
+	assert.Contains(t, snap, "time")
+	assert.Equal(t, true, snap["enabled"])
+	assert.Len(t, snap["hosts"], 1)
+}
+
+func TestStatsSnapshotDiff(t *testing.T) {
+	s := newStatistics()
+	s.Enable()
+	s.setLimits(2, 0)
+	s.AddSuccess("foo.com", 10*time.Millisecond)
+	s.AddError("foo.com", 503, 0)
+	s.AddError("gone.com", 500, 0)
+	s.AddFallback()
+	prev := s.Snapshot()
+
+	// More events than the capacity keeps are still counted
+	s.AddSuccess("foo.com", 20*time.Millisecond)
+	s.AddSuccess("foo.com", 30*time.Millisecond)
+	s.AddSuccess("foo.com", 40*time.Millisecond)
+	s.AddError("foo.com", 503, 0)
+	s.AddError("foo.com", 401, 0)
+	s.AddTimeout("foo.com", 0)
+	s.AddSuccess("new.com", 5*time.Millisecond)
+	s.ResetHost("gone.com")
+	s.AddFallback()
+	cur := s.Snapshot()
+
+	d := cur.Diff(prev)
+	assert.Equal(t, cur.Time, d.Time)
+	assert.Equal(t, 1, d.Fallbacks)
+	if !assert.Len(t, d.Hosts, 3) {
+		return
+	}
+	foo := d.Hosts[0]
+	assert.Equal(t, "foo.com", foo.Host)
+	assert.Equal(t, 3, foo.Requests)
+	assert.Equal(t, map[int]int{503: 1, 401: 1}, foo.Errors)
+	assert.Equal(t, 1, foo.Timeouts)
+	assert.Equal(t, LatencySummary{Count: 3, Avg: 30 * time.Millisecond}, foo.Latency)
+	assert.Equal(t, 90*time.Millisecond, foo.Totals.Latency)
+	assert.Equal(t, float64(3)/6, foo.ErrorRate)
+	assert.Equal(t, float64(2)/6, foo.ServerErrorRate)
+
+	// The reset host had nothing since
+	assert.Equal(t, HostSnapshot{Host: "gone.com", Errors: map[int]int{}, Totals: HostTotals{Errors: map[int]int64{}}}, d.Hosts[1])
+
+	// A new host's events are all new
+	assert.Equal(t, "new.com", d.Hosts[2].Host)
+	assert.Equal(t, 1, d.Hosts[2].Requests)
+	assert.Equal(t, 5*time.Millisecond, d.Hosts[2].Latency.Avg)
+
+	// A host missing from the new snapshot had nothing since
+	d = StatsSnapshot{}.Diff(prev)
+	assert.Len(t, d.Hosts, 2)
+	for _, h := range d.Hosts {
+		assert.Equal(t, 0, h.Requests+h.Timeouts+len(h.Errors), h.Host)
+	}
+
+	// Diffing with itself gives nothing
+	d = cur.Diff(cur)
+	for _, h := range d.Hosts {
+		assert.Equal(t, 0, h.Requests+h.Timeouts+len(h.Errors), h.Host)
+	}
+}
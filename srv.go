@@ -0,0 +1,137 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoSRVRecords is returned by LoadFromSRV when the SRV record has no
+// targets
+var ErrNoSRVRecords = errors.New("no SRV records")
+
+// SRVResolver looks up SRV records. A *net.Resolver is one, and it's how
+// LoadFromSRV can be pointed at another DNS server or a fake in tests.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// Resolver returns the resolver LoadFromSRV uses, which is
+// net.DefaultResolver unless another one has been set
+func (c *Config) Resolver() SRVResolver {
+	c.RLock()
+	defer c.RUnlock()
+	if c.resolver == nil {
+		return net.DefaultResolver
+	}
+	return c.resolver
+}
+
+// SetResolver sets the resolver LoadFromSRV uses. A nil resolver restores
+// net.DefaultResolver.
+func (c *Config) SetResolver(r SRVResolver) {
+	c.Lock()
+	c.resolver = r
+	c.Unlock()
+}
+
+// LoadFromSRV loads the server list from the SRV record name, e.g.
+// "_taplink._tcp.example.com", instead of from the API, so no request to
+// DefaultHost is needed. Targets are ordered by priority, and then by weight
+// with the heaviest first. The port is added to a target unless it's 443.
+//
+// As with Load, stats are initialized for each server, and if the lookup
+// fails the previous server list is kept. Once it has been called,
+// AutoReload looks up the SRV record again on each reload rather than
+// loading from the API. The standard resolver doesn't report the TTL of
+// records, so set the AutoReload interval to match it.
+func (c *Config) LoadFromSRV(name string) error {
+	return c.LoadFromSRVContext(context.Background(), name)
+}
+
+// LoadFromSRVContext is like LoadFromSRV, but the lookup is made with ctx.
+func (c *Config) LoadFromSRVContext(ctx context.Context, name string) error {
+	_, err := c.loadFromSRV(ctx, name)
+	return err
+}
+
+// loadFromSRV loads the server list from the SRV record name, and reports
+// what was loaded. There's no LastModified for a record.
+func (c *Config) loadFromSRV(ctx context.Context, name string) (*LoadInfo, error) {
+	c.loading.Lock()
+	defer c.loading.Unlock()
+	t := time.Now()
+	_, addrs, err := c.Resolver().LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get configuration: %w", err)
+	}
+	info := &LoadInfo{Duration: time.Since(t)}
+	servers := srvServers(addrs)
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("Could not get configuration: %w for %s", ErrNoSRVRecords, name)
+	}
+	for _, host := range servers {
+		if !validRemoteHost(host, c.globals.defaultHost()) {
+			return nil, fmt.Errorf("Could not get configuration: %w: %q", ErrInvalidHost, host)
+		}
+	}
+	c.Lock()
+	var prev []string
+	if c.options != nil {
+		prev = c.options.Servers
+	}
+	c.options = &Options{Servers: servers}
+	c.loadedAt = time.Now()
+	c.srvName = name
+	c.Unlock()
+	c.Stats().SetServers(servers)
+
+	info.Servers = len(servers)
+	info.Added = diffHosts(servers, prev)
+	info.Removed = diffHosts(prev, servers)
+	return info, nil
+}
+
+// srvServers returns the hosts of the SRV targets, ordered by priority and
+// then by weight. A "." target means the service isn't available, so it's
+// left out.
+func srvServers(addrs []*net.SRV) []string {
+	sorted := make([]*net.SRV, 0, len(addrs))
+	for _, a := range addrs {
+		if a != nil && a.Target != "." && a.Target != "" {
+			sorted = append(sorted, a)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+		return sorted[i].Weight > sorted[j].Weight
+	})
+	servers := make([]string, len(sorted))
+	for i, a := range sorted {
+		host := strings.TrimSuffix(a.Target, ".")
+		if a.Port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(int(a.Port)))
+		}
+		servers[i] = host
+	}
+	return servers
+}
+
+// reloadContext loads the configuration the way it was loaded last: from
+// the SRV record if LoadFromSRV was used, and otherwise from the API
+func (c *Config) reloadContext(ctx context.Context) (*LoadInfo, error) {
+	c.RLock()
+	name := c.srvName
+	c.RUnlock()
+	if name != "" {
+		return c.loadFromSRV(ctx, name)
+	}
+	return c.LoadResultContext(ctx)
+}
@@ -0,0 +1,101 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeResolver answers SRV lookups with addrs, or fails with err
+type fakeResolver struct {
+	addrs   []*net.SRV
+	err     error
+	lookups []string
+
+	mu sync.Mutex
+}
+
+func (r *fakeResolver) set(addrs []*net.SRV, err error) {
+	r.mu.Lock()
+	r.addrs, r.err = addrs, err
+	r.mu.Unlock()
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups = append(r.lookups, name)
+	return name, r.addrs, r.err
+}
+
+func TestLoadFromSRV(t *testing.T) {
+	r := &fakeResolver{addrs: []*net.SRV{
+		{Target: "backup.example.com.", Port: 443, Priority: 20, Weight: 100},
+		{Target: "light.example.com.", Port: 8443, Priority: 10, Weight: 10},
+		{Target: "heavy.example.com.", Port: 443, Priority: 10, Weight: 50},
+	}}
+	c := New(testAppID).(*Client)
+	var _ SRVResolver = net.DefaultResolver
+	assert.Equal(t, net.DefaultResolver, c.Config().Resolver())
+	c.Config().SetResolver(r)
+
+	assert.NoError(t, c.Config().LoadFromSRV("_taplink._tcp.example.com"))
+	assert.Equal(t, []string{"_taplink._tcp.example.com"}, r.lookups)
+	servers := []string{"heavy.example.com", "light.example.com:8443", "backup.example.com"}
+	assert.Equal(t, servers, c.Config().Servers())
+	assert.ElementsMatch(t, servers, c.Stats().Hosts())
+
+	// A failed lookup keeps the previous servers
+	r.set(nil, errors.New("no such host"))
+	err := c.Config().LoadFromSRV("_taplink._tcp.example.com")
+	assert.EqualError(t, err, "Could not get configuration: no such host")
+	assert.Equal(t, servers, c.Config().Servers())
+
+	r.set([]*net.SRV{{Target: "."}}, nil)
+	assert.ErrorIs(t, c.Config().LoadFromSRV("_taplink._tcp.example.com"), ErrNoSRVRecords)
+	assert.Equal(t, servers, c.Config().Servers())
+
+	r.set([]*net.SRV{{Target: "bad host.", Port: 443}}, nil)
+	assert.ErrorIs(t, c.Config().LoadFromSRV("_taplink._tcp.example.com"), ErrInvalidHost)
+	assert.Equal(t, servers, c.Config().Servers())
+
+	// Nor can a record move the requests off TLS
+	r.set([]*net.SRV{{Target: "http://evil.com.", Port: 80}}, nil)
+	assert.ErrorIs(t, c.Config().LoadFromSRV("_taplink._tcp.example.com"), ErrInvalidHost)
+	assert.Equal(t, servers, c.Config().Servers())
+
+	c.Config().SetResolver(nil)
+	assert.Equal(t, net.DefaultResolver, c.Config().Resolver())
+}
+
+func TestAutoReloadFromSRV(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	r := &fakeResolver{addrs: []*net.SRV{{Target: "a.example.com.", Port: 443}}}
+	c := New(testAppID).(*Client)
+	cfg := c.Config()
+	cfg.SetResolver(r)
+	assert.NoError(t, cfg.LoadFromSRV("_taplink._tcp.example.com"))
+
+	cfg.AutoReload(10 * time.Millisecond)
+	defer cfg.StopAutoReload()
+	r.set([]*net.SRV{{Target: "a.example.com.", Port: 443}, {Target: "b.example.com.", Port: 443}}, nil)
+	assert.Eventually(t, func() bool { return len(cfg.Servers()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, cfg.Servers())
+	cfg.StopAutoReload()
+	// The API wasn't asked for the configuration
+	assert.Equal(t, 0, f.Requests())
+
+	// Loading from the API makes reloads use it again
+	f.Servers = []string{"api.example.com"}
+	assert.NoError(t, cfg.Load())
+	_, err := cfg.(*Config).reloadContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"api.example.com"}, cfg.Servers())
+	assert.Equal(t, 2, f.Requests())
+}
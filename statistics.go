@@ -3,6 +3,7 @@ package taplink
 import (
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,27 +11,277 @@ var (
 	_ Statistics = (*statistics)(nil)
 )
 
-// Statistics defines an interface for getting and setting connection statistics
+// Statistics defines an interface for getting and setting connection
+// statistics. The built-in implementation is used unless another one is
+// given to New with WithStatistics.
 type Statistics interface {
+	// Enable and Disable turn recording on and off
+	Enable()
+	Disable()
+
+	// AddSuccess, AddError and AddTimeout record the outcome of a request to
+	// host. The latency of an error or timeout is how long the host took to
+	// fail.
+	AddSuccess(host string, latency time.Duration)
+	AddError(host string, code int, latency time.Duration)
+	AddTimeout(host string, latency time.Duration)
+
+	// AddQueueWait records time spent waiting on the rate limiter for host
+	AddQueueWait(host string, wait time.Duration)
+
+	// AddProbe records the result of a health check probe to host, which is
+	// kept apart from requests
+	AddProbe(host string, latency time.Duration, healthy bool)
+
+	// AddFallback records a verification answered by the fallback verifier
+	AddFallback()
+	Fallbacks() int
+
+	// Get returns a snapshot of the stats for host, which doesn't change as
+	// more requests are recorded. It must not return nil, and returns empty
+	// stats for a host it has none for.
+	Get(host string) HostStats
+
+	// Lookup is like Get, but returns ErrHostNotFound for a host which
+	// hasn't been set as a server or had anything recorded for it.
+	Lookup(host string) (HostStats, error)
+
+	// SetServers is called with the server list each time the config is
+	// loaded, so stats can be kept for every server. Hosts returns the servers
+	// and the hosts anything has been recorded for, ranked best first.
+	SetServers(servers []string)
+	Hosts() []string
+
+	// Reset clears the stats of every host, and the fallback and call counts.
+	// ResetHost clears the stats of host. Hosts stay registered, and Get
+	// returns empty stats for them.
+	Reset()
+	ResetHost(host string)
+
+	// Snapshot returns a serializable copy of the stats of every host
+	Snapshot() StatsSnapshot
+
+	// InFlight returns the number of requests to any host in progress
+	InFlight() int
+}
+
+// LegacyStatistics is Statistics as it was before errors and timeouts were
+// recorded with their latency, and before the methods added since, for
+// implementations given to WithStatistics which haven't been updated yet.
+// Adapt one with FromLegacyStatistics.
+type LegacyStatistics interface {
 	Enable()
 	Disable()
 	AddSuccess(host string, latency time.Duration)
 	AddError(host string, code int)
 	AddTimeout(host string)
-	Get(host string) HostStats
+	Get(host string) LegacyHostStats
 	SetServers(servers []string)
 	Hosts() []string
 }
 
+// LegacyHostStats is HostStats as it was before the methods added since, as
+// returned by the Get of LegacyStatistics.
+type LegacyHostStats interface {
+	Errors() Errors
+	Requests() int
+	Timeouts() int
+	Latency() Latency
+	ErrorRate() float64
+	Last(time.Duration) LegacyHostStats
+}
+
+// FromLegacyStatistics returns s as Statistics. The latency of errors and
+// timeouts is dropped, and so are queue waits, probes and fallbacks. Lookup
+// and Snapshot are made from Get and Hosts, Reset and ResetHost do nothing,
+// and Fallbacks and InFlight are 0. The HostStats methods missing from
+// LegacyHostStats are made from the others where they can be, and return
+// zero values otherwise.
+func FromLegacyStatistics(s LegacyStatistics) Statistics {
+	return legacyStatistics{s}
+}
+
+// legacyStatistics adapts LegacyStatistics to Statistics
+type legacyStatistics struct {
+	LegacyStatistics
+}
+
+// AddError records the error without its latency
+func (s legacyStatistics) AddError(host string, code int, _ time.Duration) {
+	s.LegacyStatistics.AddError(host, code)
+}
+
+// AddTimeout records the timeout without its latency
+func (s legacyStatistics) AddTimeout(host string, _ time.Duration) {
+	s.LegacyStatistics.AddTimeout(host)
+}
+
+func (legacyStatistics) AddQueueWait(string, time.Duration)   {}
+func (legacyStatistics) AddProbe(string, time.Duration, bool) {}
+func (legacyStatistics) AddFallback()                         {}
+func (legacyStatistics) Fallbacks() int                       { return 0 }
+func (legacyStatistics) Reset()                               {}
+func (legacyStatistics) ResetHost(string)                     {}
+func (legacyStatistics) InFlight() int                        { return 0 }
+
+// Get returns the stats of host as HostStats
+func (s legacyStatistics) Get(host string) HostStats {
+	return legacyHostStats{s.LegacyStatistics.Get(host), host}
+}
+
+// Lookup returns the stats of host if Hosts includes it
+func (s legacyStatistics) Lookup(host string) (HostStats, error) {
+	for _, h := range s.Hosts() {
+		if h == host {
+			return s.Get(host), nil
+		}
+	}
+	return nil, ErrHostNotFound
+}
+
+// Snapshot returns the counts and error rates of each of Hosts
+func (s legacyStatistics) Snapshot() StatsSnapshot {
+	hosts := s.Hosts()
+	snap := StatsSnapshot{Time: time.Now(), Hosts: make([]HostSnapshot, 0, len(hosts))}
+	for _, host := range hosts {
+		hs := s.Get(host)
+		snap.Hosts = append(snap.Hosts, HostSnapshot{
+			Host:            host,
+			Requests:        hs.Requests(),
+			Errors:          hs.Errors(),
+			Timeouts:        hs.Timeouts(),
+			ErrorRate:       hs.ErrorRate(),
+			ServerErrorRate: hs.ServerErrorRate(),
+		})
+	}
+	sort.Slice(snap.Hosts, func(i, j int) bool { return snap.Hosts[i].Host < snap.Hosts[j].Host })
+	return snap
+}
+
+// legacyHostStats adapts LegacyHostStats to HostStats
+type legacyHostStats struct {
+	LegacyHostStats
+	host string
+}
+
+func (s legacyHostStats) Host() string             { return s.host }
+func (legacyHostStats) ErrorLatency() Latency      { return nil }
+func (legacyHostStats) TimeoutLatency() Latency    { return nil }
+func (legacyHostStats) QueueWait() Latency         { return nil }
+func (legacyHostStats) Rate(time.Duration) float64 { return 0 }
+func (legacyHostStats) InFlight() int              { return 0 }
+func (legacyHostStats) Score() float64             { return 0 }
+func (legacyHostStats) CircuitState() CircuitState { return CircuitClosed }
+func (legacyHostStats) Capacity() int              { return 0 }
+func (legacyHostStats) Retention() time.Duration   { return 0 }
+func (legacyHostStats) Probes() Probes             { return Probes{} }
+func (legacyHostStats) Phases() Phases             { return Phases{} }
+func (s legacyHostStats) AllLatency() Latency      { return s.Latency() }
+
+// ErrorsByClass classifies the errors by their code
+func (s legacyHostStats) ErrorsByClass() ErrorClasses {
+	c := ErrorClasses{Timeouts: s.Timeouts()}
+	for code, ct := range s.Errors() {
+		c.classify(code, ct)
+	}
+	return c
+}
+
+// ServerErrorRate is worked out from the error classes like the built-in
+// stats do
+func (s legacyHostStats) ServerErrorRate() float64 {
+	c := s.ErrorsByClass()
+	failed := c.Server + c.Timeouts + c.Transport
+	if failed == 0 {
+		return 0
+	}
+	return float64(failed) / float64(s.Requests()+c.Len())
+}
+
+// Totals returns the counts held now, as the earlier events are unknown
+func (s legacyHostStats) Totals() HostTotals {
+	t := HostTotals{
+		Requests: int64(s.Requests()),
+		Timeouts: int64(s.Timeouts()),
+		Errors:   make(map[int]int64),
+	}
+	for _, l := range s.Latency() {
+		t.Latency += l
+	}
+	for code, ct := range s.Errors() {
+		t.Errors[code] = int64(ct)
+	}
+	return t
+}
+
+// Last returns the stats of the last window, adapted the same way
+func (s legacyHostStats) Last(window time.Duration) HostStats {
+	return legacyHostStats{s.LegacyHostStats.Last(window), s.host}
+}
+
+// inFlightStats is implemented by the built-in stats, which count the
+// requests in progress whether or not they're enabled
+type inFlightStats interface {
+	startRequest(host string) (done func())
+}
+
 type statistics struct {
-	enabled bool
-	stats   map[string]*hostStatistics
+	enabled   bool
+	stats     map[string]*hostStatistics
+	fallbacks int
+	calls     ClientStats
+	breaker   *circuitBreaker
+
+	// inFlight is the number of requests in progress, and hostsInFlight the
+	// number to each host. They're accessed atomically.
+	inFlight      int64
+	hostsInFlight map[string]*int64
+
+	// capacity and retention limit the events kept for each host
+	capacity  int
+	retention time.Duration
+
+	// scoring is whether hosts are ranked by their score, whose half-life
+	// is halfLife
+	scoring  bool
+	halfLife time.Duration
+
+	// clock is the clock of the stats, or nil for the real one, see setClock
+	clock clock
 
 	mu sync.RWMutex
 }
 
 func newStatistics() *statistics {
-	return &statistics{stats: make(map[string]*hostStatistics)}
+	return &statistics{stats: make(map[string]*hostStatistics), capacity: DefaultStatsCapacity}
+}
+
+// setLimits sets the number of events of each kind kept for each host, and
+// how long they're kept for. Zero means no limit.
+func (s *statistics) setLimits(capacity int, retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capacity, s.retention = capacity, retention
+	for _, hs := range s.stats {
+		hs.setLimits(capacity, retention)
+	}
+}
+
+// setClock makes the stats timestamp and window events with clk
+func (s *statistics) setClock(clk clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clk
+	for _, hs := range s.stats {
+		hs.mu.Lock()
+		hs.clock = clk
+		hs.mu.Unlock()
+	}
+}
+
+// now returns the time of the stats' clock
+func (s *statistics) now() time.Time {
+	return clockNow(s.clock)
 }
 
 // Enable enables the tracking of request statistics.
@@ -40,6 +291,13 @@ func (s *statistics) Enable() {
 	s.mu.Unlock()
 }
 
+// isEnabled returns whether stats are being recorded
+func (s *statistics) isEnabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.enabled
+}
+
 // Disable disables the tracking of request statistics
 func (s *statistics) Disable() {
 	s.mu.Lock()
@@ -50,77 +308,304 @@ func (s *statistics) Disable() {
 func (s *statistics) AddSuccess(host string, latency time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.recordCircuit(host, false)
 	if !s.enabled {
 		return
 	}
 	s.init(host)
-	s.stats[host].latency = append(s.stats[host].latency, successResp{time.Now(), latency})
+	s.stats[host].addSuccess(latency)
 }
 
-func (s *statistics) AddError(host string, code int) {
+func (s *statistics) AddError(host string, code int, latency time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if isCircuitFailure(code) {
+		s.recordCircuit(host, true)
+	}
 	if !s.enabled {
 		return
 	}
 	s.init(host)
-	s.stats[host].errors = append(s.stats[host].errors, errorResp{time.Now(), code})
+	s.stats[host].addError(code, latency)
 }
 
-func (s *statistics) AddTimeout(host string) {
+func (s *statistics) AddTimeout(host string, latency time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.recordCircuit(host, true)
 	if !s.enabled {
 		return
 	}
 	s.init(host)
-	s.stats[host].timeouts = append(s.stats[host].timeouts, timeoutResp{time.Now()})
+	s.stats[host].addTimeout(latency)
 }
 
-func (s *statistics) Get(host string) HostStats {
+// addPhases records the phase timings of a request to host
+func (s *statistics) addPhases(host string, t *RequestTrace) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.enabled {
+		return
+	}
+	s.init(host)
+	s.stats[host].addPhases(t)
+}
+
+// AddQueueWait records time spent waiting on a rate limiter before a request
+// to host was made. It's kept separate from the latency of the request itself.
+func (s *statistics) AddQueueWait(host string, wait time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if !s.enabled {
+		return
+	}
 	s.init(host)
-	return s.stats[host]
+	s.stats[host].addQueueWait(wait)
+}
+
+// AddProbe records a health check probe to host. Probes don't count as
+// requests, nor towards the circuit breaker.
+func (s *statistics) AddProbe(host string, latency time.Duration, healthy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.enabled {
+		return
+	}
+	s.init(host)
+	s.stats[host].addProbe(latency, healthy)
+}
+
+// AddFallback records a verification made by the fallback verifier
+func (s *statistics) AddFallback() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.enabled {
+		return
+	}
+	s.fallbacks++
+}
+
+// Fallbacks returns the number of verifications made by the fallback verifier
+func (s *statistics) Fallbacks() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.fallbacks
+}
+
+// InFlight returns the number of requests to any host in progress. They're
+// counted whether or not stats are enabled, since it's only a counter.
+func (s *statistics) InFlight() int {
+	return int(atomic.LoadInt64(&s.inFlight))
+}
+
+// startRequest counts a request to host as in progress until done is called
+func (s *statistics) startRequest(host string) (done func()) {
+	s.mu.Lock()
+	if s.hostsInFlight == nil {
+		s.hostsInFlight = make(map[string]*int64)
+	}
+	n, ok := s.hostsInFlight[host]
+	if !ok {
+		n = new(int64)
+		s.hostsInFlight[host] = n
+	}
+	s.mu.Unlock()
+	atomic.AddInt64(&s.inFlight, 1)
+	atomic.AddInt64(n, 1)
+	return func() {
+		atomic.AddInt64(n, -1)
+		atomic.AddInt64(&s.inFlight, -1)
+	}
+}
+
+// hostInFlight returns the number of requests to host in progress. It must
+// be called with s.mu held.
+func (s *statistics) hostInFlight(host string) int {
+	if n, ok := s.hostsInFlight[host]; ok {
+		return int(atomic.LoadInt64(n))
+	}
+	return 0
+}
+
+// Get returns a snapshot of the stats for host, which is safe to read while
+// requests are in flight and doesn't change as more are recorded. A host
+// without stats gets empty ones, and isn't added to Hosts().
+func (s *statistics) Get(host string) HostStats {
+	hs, err := s.Lookup(host)
+	if err != nil {
+		s.mu.RLock()
+		empty := newHostStatistics(host)
+		empty.capacity, empty.retention, empty.clock = s.capacity, s.retention, s.clock
+		empty.inFlight = s.hostInFlight(host)
+		s.mu.RUnlock()
+		return empty
+	}
+	return hs
+}
+
+// Lookup is like Get, but returns ErrHostNotFound for a host which isn't one
+// of the servers and has nothing recorded for it, e.g. a typo
+func (s *statistics) Lookup(host string) (HostStats, error) {
+	s.mu.RLock()
+	hs, ok := s.stats[host]
+	inFlight := s.hostInFlight(host)
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrHostNotFound
+	}
+	cp := hs.CopyOf()
+	cp.inFlight = inFlight
+	return &cp, nil
+}
+
+// Reset clears the stats of every host and the fallback and call counts
+func (s *statistics) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, hs := range s.stats {
+		hs.reset()
+	}
+	s.fallbacks = 0
+	s.calls = ClientStats{}
+}
+
+// ResetHost clears the stats of host, if it has any
+func (s *statistics) ResetHost(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hs, ok := s.stats[host]; ok {
+		hs.reset()
+	}
 }
 
 // SetServers initializes statistics for the given servers
 func (s *statistics) SetServers(servers []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for i := range servers {
 		s.init(servers[i])
 	}
 }
 
-type hostFailRate []hostStatistics
+// recordCircuit updates the circuit breaker state of host, whether or not
+// stats are enabled. It must be called with s.mu held.
+func (s *statistics) recordCircuit(host string, failed bool) {
+	if s.breaker == nil {
+		return
+	}
+	s.init(host)
+	hs := s.stats[host]
+	hs.mu.Lock()
+	hs.circuit.record(s.breaker, failed, hs.now())
+	hs.mu.Unlock()
+}
+
+// setCircuitBreaker sets the circuit breaker settings, and closes every
+// circuit. A nil breaker disables it.
+func (s *statistics) setCircuitBreaker(b *circuitBreaker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breaker = b
+	for _, hs := range s.stats {
+		hs.mu.Lock()
+		hs.circuit = circuit{}
+		hs.mu.Unlock()
+	}
+}
+
+// allowHost returns whether the circuit breaker lets a request be made to
+// host now
+func (s *statistics) allowHost(host string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hs, ok := s.stats[host]
+	if s.breaker == nil || !ok {
+		return true
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return hs.circuit.allow(s.breaker, now)
+}
+
+// lastFailure returns when a request to host last failed, while the circuit
+// breaker is enabled
+func (s *statistics) lastFailure(host string) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hs, ok := s.stats[host]
+	if !ok {
+		return time.Time{}
+	}
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	return hs.circuit.lastFailure
+}
+
+// hostRank is what hosts are sorted by
+type hostRank struct {
+	host string
+	// errorRate is the server error rate, or the score if hosts are scored
+	errorRate float64
+	latency   time.Duration
+	failing   bool
+}
+
+// rankHost returns how hs ranks over the last minute, from its rolling
+// window rather than by walking its events, or by its score if scored
+func rankHost(hs *hostStatistics, scored bool) hostRank {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	now := hs.now()
+	r := hs.rank(now)
+	if scored {
+		r.errorRate = hs.score.get(now, hs.halfLife)
+	}
+	return r
+}
+
+type hostFailRate []hostRank
 
 func (hfr hostFailRate) Len() int { return len(hfr) }
 
-func (hfr hostFailRate) Swap(i, j int) { hfr[i], hfr[j] = hfr[j].CopyOf(), hfr[i].CopyOf() }
+func (hfr hostFailRate) Swap(i, j int) { hfr[i], hfr[j] = hfr[j], hfr[i] }
 
+// Less orders hosts failing their health check last, then by server error rate,
+// then by average latency, of errors as well as successes, then by name
 func (hfr hostFailRate) Less(i, j int) bool {
-	im := hfr[i].Last(time.Minute)
-	jm := hfr[j].Last(time.Minute)
-	return im.ErrorRate() < jm.ErrorRate() || im.Latency().Avg() < jm.Latency().Avg()
+	a, b := hfr[i], hfr[j]
+	if a.failing != b.failing {
+		return b.failing
+	}
+	if a.errorRate != b.errorRate {
+		return a.errorRate < b.errorRate
+	}
+	if a.latency != b.latency {
+		return a.latency < b.latency
+	}
+	return a.host < b.host
 }
 
 func (hfr hostFailRate) Hosts() []string {
 	hosts := make([]string, len(hfr))
 	for i := range hfr {
-		hosts[i] = hfr[i].Host()
+		hosts[i] = hfr[i].host
 	}
 	return hosts
 }
 
-// Hosts returns a sorted slice of hosts, with the most optimal host being first.
-// Hosts are sorted by error rate and if error rate is equal, then latency.
+// Hosts returns a sorted slice of the servers and the hosts anything has been
+// recorded for, with the most optimal host being first.
+// Hosts whose latest health check probe in the last minute failed come last.
+// Otherwise hosts are sorted by their server error rate over the last minute,
+// which leaves out client errors, or by their Score if EWMA scoring is
+// enabled, then by their average latency over it, and then by name.
 func (s *statistics) Hosts() []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	l := make([]hostStatistics, 0)
-	for h := range s.stats {
-		l = append(l, s.stats[h].CopyOf())
+	hfr := make(hostFailRate, 0, len(s.stats))
+	for _, hs := range s.stats {
+		hfr = append(hfr, rankHost(hs, s.scoring))
 	}
-	hfr := hostFailRate(l)
 	sort.Sort(hfr)
 	return hfr.Hosts()
 }
@@ -130,6 +615,8 @@ func (s *statistics) init(host string) {
 		s.stats = make(map[string]*hostStatistics, 0)
 	}
 	if _, ok := s.stats[host]; !ok {
-		s.stats[host] = newHostStatistics(host)
+		hs := newHostStatistics(host)
+		hs.capacity, hs.retention, hs.halfLife, hs.clock = s.capacity, s.retention, s.halfLife, s.clock
+		s.stats[host] = hs
 	}
 }
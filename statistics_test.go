@@ -2,9 +2,11 @@ package taplink
 
 import (
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/TapLink/taplink-go/taplinktest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -30,6 +32,82 @@ func TestStatsGetNil(t *testing.T) {
 	})
 }
 
+func TestStatsLookup(t *testing.T) {
+	s := newStatistics()
+	s.Enable()
+	s.SetServers([]string{"foo.com"})
+	s.AddSuccess("bar.com", time.Millisecond)
+
+	hs, err := s.Lookup("foo.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, hs.Requests())
+	hs, err = s.Lookup("bar.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hs.Requests())
+
+	// A typo is reported, and getting or resetting it doesn't add a phantom
+	// host to the ranking.
+	_, err = s.Lookup("fo.com")
+	assert.Equal(t, ErrHostNotFound, err)
+	assert.Equal(t, 0, s.Get("fo.com").Requests())
+	assert.Equal(t, "fo.com", s.Get("fo.com").Host())
+	s.ResetHost("fo.com")
+	_, err = s.Lookup("fo.com")
+	assert.Equal(t, ErrHostNotFound, err)
+	assert.ElementsMatch(t, []string{"foo.com", "bar.com"}, s.Hosts())
+	assert.Len(t, s.Snapshot().Hosts, 2)
+}
+
+func TestStatsRate(t *testing.T) {
+	clk := newFakeClock()
+	s := newStatistics()
+	s.setClock(clk)
+	s.Enable()
+	s.AddSuccess("foo.com", time.Millisecond)
+	s.AddError("foo.com", 503, 0)
+	s.AddTimeout("foo.com", 0)
+	clk.Advance(1100 * time.Millisecond)
+	s.AddSuccess("foo.com", time.Millisecond)
+
+	assert.Equal(t, float64(1), s.Get("foo.com").Rate(time.Second))
+	assert.Equal(t, float64(4)/10, s.Get("foo.com").Rate(10*time.Second))
+	assert.Equal(t, float64(0), s.Get("foo.com").Rate(0))
+	assert.Equal(t, float64(0), s.Get("bar.com").Rate(time.Second))
+}
+
+func TestStatsInFlight(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	f.Latency = 200 * time.Millisecond
+	c := New(testAppID).(*Client)
+
+	// In-flight requests are counted even though stats are disabled.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			hash := append([]byte(nil), testHashBytes...)
+			hash[0] = byte(i)
+			_, err := c.NewPassword(hash)
+			assert.NoError(t, err)
+		}(i)
+	}
+	deadline := time.Now().Add(time.Second)
+	for c.Stats().InFlight() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, 3, c.Stats().InFlight())
+	assert.Equal(t, 3, c.Stats().Get(DefaultHost).InFlight())
+	assert.Equal(t, 3, c.Stats().Snapshot().InFlight)
+	wg.Wait()
+
+	assert.Equal(t, 0, c.Stats().InFlight())
+	assert.Equal(t, 0, c.Stats().Get(DefaultHost).InFlight())
+	// And they don't add the host to the stats.
+	assert.Empty(t, c.Stats().Hosts())
+}
+
 func TestStatsEnabled(t *testing.T) {
 	s := &statistics{}
 	s.Enable()
@@ -42,29 +120,30 @@ func TestHostSorting(t *testing.T) {
 	// foo.com will have errors, bar.com will not, so bar.com should be the server of choice
 	f := newHostStatistics("foo.com")
 	b := newHostStatistics("bar.com")
-	f.errors = []errorResp{{time.Now(), 503}}
-	b.latency = []successResp{{time.Now(), time.Millisecond}}
-	l := hostFailRate([]hostStatistics{f.CopyOf(), b.CopyOf()})
+	f.addError(503, 0)
+	b.addSuccess(time.Millisecond)
+	l := hostFailRate{rankHost(f, false), rankHost(b, false)}
 	sort.Sort(l)
 	assert.Equal(t, []string{"bar.com", "foo.com"}, l.Hosts())
 
 	// Set the servers to some test values, add stats, and make sure the proper one is selected.
 	// Usually the config would be loaded from c.Config().Load() but so we can test we'll set it
-	// manually here.
+	// with SetServers here.
 	svrs := []string{"foo.com", "bar.com", "foobar.com"}
 	c := New(testAppID)
-	c.Config().Load()
-	c.(*Client).cfg.(*Config).options.Servers = svrs
+	assert.NoError(t, c.Config().SetServers(svrs))
 	c.Stats().(*statistics).stats = map[string]*hostStatistics{
 		"foo.com":    newHostStatistics("foo.com"),
 		"bar.com":    newHostStatistics("bar.com"),
 		"foobar.com": newHostStatistics("foobar.com"),
 	}
 
-	assert.Equal(t, []string{"foo.com", "bar.com", "foobar.com"}, c.Stats().Hosts())
-	c.Stats().AddError("foo.com", 503)
+	assert.Equal(t, []string{"bar.com", "foo.com", "foobar.com"}, c.Stats().Hosts())
+	c.Stats().Enable()
+	c.Stats().AddError("foo.com", 503, 0)
 	c.Stats().AddSuccess("foo.com", time.Millisecond)
 	c.Stats().AddSuccess("bar.com", time.Millisecond)
+	c.Stats().AddSuccess("foobar.com", 2*time.Millisecond)
 
 	// The preferred host should be attempted first.
 	assert.Equal(t, "foo.com", c.Config().Host(0))
@@ -74,4 +153,403 @@ func TestHostSorting(t *testing.T) {
 	assert.Equal(t, "bar.com", c.Config().Host(1))
 	assert.Equal(t, "foobar.com", c.Config().Host(2))
 	assert.Equal(t, "foo.com", c.Config().Host(3))
+
+	// The host which just failed is left out of the ranking.
+	assert.Equal(t, "foobar.com", c.Config().RetryHost(0, 1, "bar.com"))
+	assert.Equal(t, "foo.com", c.Config().RetryHost(0, 2, "bar.com"))
+}
+
+func TestHostFailRateLess(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name string
+		a, b hostRank
+		less bool
+	}{
+		{"lower error rate", hostRank{"a.com", 0.1, 50 * ms, false}, hostRank{"b.com", 0.5, 10 * ms, false}, true},
+		{"higher error rate, lower latency", hostRank{"a.com", 0.5, 10 * ms, false}, hostRank{"b.com", 0.1, 50 * ms, false}, false},
+		{"same error rate, lower latency", hostRank{"b.com", 0.1, 10 * ms, false}, hostRank{"a.com", 0.1, 50 * ms, false}, true},
+		{"same error rate, higher latency", hostRank{"a.com", 0.1, 50 * ms, false}, hostRank{"b.com", 0.1, 10 * ms, false}, false},
+		{"tie, earlier name", hostRank{"a.com", 0.1, 10 * ms, false}, hostRank{"b.com", 0.1, 10 * ms, false}, true},
+		{"tie, later name", hostRank{"b.com", 0.1, 10 * ms, false}, hostRank{"a.com", 0.1, 10 * ms, false}, false},
+		{"equal", hostRank{"a.com", 0, 0, false}, hostRank{"a.com", 0, 0, false}, false},
+		{"failing probe, lower error rate", hostRank{"a.com", 0, 10 * ms, true}, hostRank{"b.com", 0.5, 50 * ms, false}, false},
+		{"passing probe, higher error rate", hostRank{"b.com", 0.5, 50 * ms, false}, hostRank{"a.com", 0, 10 * ms, true}, true},
+		{"both failing, lower error rate", hostRank{"b.com", 0.1, 50 * ms, true}, hostRank{"a.com", 0.5, 10 * ms, true}, true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.less, hostFailRate{tt.a, tt.b}.Less(0, 1), tt.name)
+	}
+}
+
+func TestStatsHostsOrder(t *testing.T) {
+	s := newStatistics()
+	s.Enable()
+	// c.com has the lowest error rate despite the highest latency, and a.com
+	// and b.com tie on both so are ordered by name.
+	for _, host := range []string{"a.com", "b.com"} {
+		s.AddError(host, 503, 0)
+		s.AddSuccess(host, time.Millisecond)
+	}
+	s.AddSuccess("c.com", time.Second)
+	s.AddError("d.com", 503, 0)
+	assert.Equal(t, []string{"c.com", "a.com", "b.com", "d.com"}, s.Hosts())
+}
+
+func TestStatsQueueWaitConcurrent(t *testing.T) {
+	s := newStatistics()
+	s.Enable()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			s.AddQueueWait("foobar.com", time.Millisecond)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		s.Get("foobar.com").QueueWait().Avg()
+	}
+	<-done
+	assert.Equal(t, 1000, s.Get("foobar.com").QueueWait().Len())
+}
+
+func TestStatsReset(t *testing.T) {
+	s := newStatistics()
+	s.Enable()
+	s.SetServers([]string{"foo.com", "bar.com"})
+	for _, host := range []string{"foo.com", "bar.com"} {
+		s.AddSuccess(host, time.Millisecond)
+		s.AddError(host, 503, 0)
+		s.AddTimeout(host, 0)
+		s.AddQueueWait(host, time.Millisecond)
+	}
+	s.AddFallback()
+
+	s.ResetHost("foo.com")
+	assert.Equal(t, 0, s.Get("foo.com").Requests())
+	assert.Equal(t, 0, s.Get("foo.com").Errors().Len())
+	assert.Equal(t, 0, s.Get("foo.com").Timeouts())
+	assert.Equal(t, 0, s.Get("foo.com").QueueWait().Len())
+	assert.Equal(t, 1, s.Get("bar.com").Requests())
+	assert.Equal(t, 1, s.Fallbacks())
+	assert.ElementsMatch(t, []string{"foo.com", "bar.com"}, s.Hosts())
+
+	s.Reset()
+	assert.Equal(t, 0, s.Get("bar.com").Requests())
+	assert.Equal(t, 0, s.Get("bar.com").Errors().Len())
+	assert.Equal(t, float64(0), s.Get("bar.com").ErrorRate())
+	assert.Equal(t, 0, s.Fallbacks())
+	assert.ElementsMatch(t, []string{"foo.com", "bar.com"}, s.Hosts())
+
+	// Each reset host still records stats.
+	s.AddSuccess("foo.com", time.Millisecond)
+	assert.Equal(t, 1, s.Get("foo.com").Requests())
+}
+
+func TestStatsResetConcurrent(t *testing.T) {
+	s := newStatistics()
+	s.Enable()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			s.AddSuccess("foo.com", time.Millisecond)
+			s.AddError("foo.com", 503, 0)
+			s.AddTimeout("foo.com", 0)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		s.Reset()
+		s.ResetHost("foo.com")
+		s.Get("foo.com").ErrorRate()
+	}
+	<-done
+	assert.NotNil(t, s.Get("foo.com"))
+}
+
+func TestStatsGetConcurrent(t *testing.T) {
+	s := newStatistics()
+	s.Enable()
+	s.setLimits(100, 0)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			s.AddSuccess("foo.com", time.Millisecond)
+			s.AddError("foo.com", 503, 0)
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		hs := s.Get("foo.com")
+		hs.Latency().Avg()
+		hs.Last(time.Second).ErrorRate()
+		hs.Errors()
+	}
+	<-done
+
+	// The snapshot doesn't see requests recorded after it was taken.
+	hs := s.Get("foo.com")
+	n := hs.Requests()
+	s.AddSuccess("foo.com", time.Millisecond)
+	assert.Equal(t, n, hs.Requests())
+	assert.Equal(t, n, hs.Latency().Len())
+}
+
+// Statistics implementations outside the package rely on every method here,
+// so removing one from the interface should break the build.
+var _ interface {
+	Enable()
+	Disable()
+	AddSuccess(host string, latency time.Duration)
+	AddError(host string, code int, latency time.Duration)
+	AddTimeout(host string, latency time.Duration)
+	AddQueueWait(host string, wait time.Duration)
+	AddProbe(host string, latency time.Duration, healthy bool)
+	AddFallback()
+	Fallbacks() int
+	Get(host string) HostStats
+	Lookup(host string) (HostStats, error)
+	SetServers(servers []string)
+	Hosts() []string
+	Reset()
+	ResetHost(host string)
+	Snapshot() StatsSnapshot
+	InFlight() int
+} = Statistics(nil)
+
+type countingStatistics struct {
+	Statistics
+	successes int
+	servers   []string
+}
+
+func (s *countingStatistics) AddSuccess(host string, latency time.Duration) {
+	s.successes++
+	s.Statistics.AddSuccess(host, latency)
+}
+
+func (s *countingStatistics) SetServers(servers []string) {
+	s.servers = servers
+	s.Statistics.SetServers(servers)
+}
+
+func TestWithStatistics(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"servers":["foo.com"]}`))
+	st.EnqueueFor("foo.com", taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+
+	s := &countingStatistics{Statistics: newStatistics()}
+	c := New(testAppID, WithStatistics(s)).(*Client)
+	assert.Equal(t, s, c.Stats())
+	assert.NoError(t, c.Config().Load())
+	assert.Equal(t, []string{"foo.com"}, s.servers)
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	// Both the load and the salt request are recorded
+	assert.Equal(t, 2, s.successes)
+
+	// A nil implementation leaves the built-in one in place.
+	c = New(testAppID, WithStatistics(nil)).(*Client)
+	assert.NotNil(t, c.Stats().Get("foo.com"))
+}
+
+func TestErrorsByClass(t *testing.T) {
+	s := newHostStatistics("foo.com")
+	assert.Equal(t, ErrorClasses{}, s.ErrorsByClass())
+	assert.Equal(t, float64(0), s.ServerErrorRate())
+
+	s.addSuccess(time.Millisecond)
+	s.addError(401, 0)
+	s.addError(429, 0)
+	s.addError(503, 0)
+	s.addError(TransportErrorCode, 0)
+	s.addTimeout(0)
+	c := s.ErrorsByClass()
+	assert.Equal(t, ErrorClasses{Client: 2, Server: 1, Timeouts: 1, Transport: 1}, c)
+	assert.Equal(t, 5, c.Len())
+	assert.Equal(t, float64(5)/float64(6), s.ErrorRate())
+	assert.Equal(t, float64(3)/float64(6), s.ServerErrorRate())
+
+	// Old errors drop out of the window
+	s.errors[0].ts = time.Now().Add(-time.Hour)
+	s.errors[2].ts = time.Now().Add(-time.Hour)
+	last := s.Last(time.Minute)
+	assert.Equal(t, ErrorClasses{Client: 1, Timeouts: 1, Transport: 1}, last.ErrorsByClass())
+	assert.Equal(t, float64(2)/float64(4), last.ServerErrorRate())
+
+	// Client errors alone don't make a host fail
+	s = newHostStatistics("bar.com")
+	s.addError(401, 0)
+	assert.Equal(t, float64(1), s.ErrorRate())
+	assert.Equal(t, float64(0), s.ServerErrorRate())
+}
+
+func TestClientErrorsDontDemoteHost(t *testing.T) {
+	c := New(testAppID)
+	c.Stats().Enable()
+	for i := 0; i < 10; i++ {
+		c.Stats().AddError("unauthorized.com", 401, 0)
+	}
+	c.Stats().AddSuccess("unauthorized.com", 5*time.Millisecond)
+	c.Stats().AddError("down.com", 503, 0)
+	c.Stats().AddSuccess("down.com", time.Millisecond)
+	assert.Equal(t, []string{"unauthorized.com", "down.com"}, c.Stats().Hosts())
+}
+
+func TestErrorLatency(t *testing.T) {
+	s := newHostStatistics("foo.com")
+	s.addSuccess(time.Millisecond)
+	s.addError(503, 8*time.Second)
+	s.addSuccess(3 * time.Millisecond)
+	s.addError(500, 0)
+	assert.Equal(t, Latency{8 * time.Second, 0}, s.ErrorLatency())
+	assert.Equal(t, Latency{time.Millisecond, 8 * time.Second, 3 * time.Millisecond, 0}, s.AllLatency())
+	assert.Equal(t, Latency{8 * time.Second, 0}, s.Last(time.Minute).ErrorLatency())
+	cp := s.CopyOf()
+	assert.Equal(t, s.AllLatency(), cp.AllLatency())
+
+	// Of two hosts failing as often, the one slow to fail ranks last
+	stats := newStatistics()
+	stats.Enable()
+	stats.AddSuccess("slow.com", time.Millisecond)
+	stats.AddError("slow.com", 503, 8*time.Second)
+	stats.AddSuccess("fast.com", 2*time.Millisecond)
+	stats.AddError("fast.com", 503, 5*time.Millisecond)
+	assert.Equal(t, []string{"fast.com", "slow.com"}, stats.Hosts())
+}
+
+func TestErrorLatencyRecorded(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.RespondAfter(20*time.Millisecond, 503, "unavailable"), taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID).(*Client)
+	c.Stats().Enable()
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	lat := c.Stats().Get(DefaultHost).ErrorLatency()
+	if assert.Len(t, lat, 1) {
+		assert.GreaterOrEqual(t, lat[0], 20*time.Millisecond)
+	}
+	assert.Len(t, c.Stats().Get(DefaultHost).AllLatency(), 2)
+}
+
+// legacyCountingStatistics implements LegacyStatistics, and only it
+type legacyCountingStatistics struct {
+	s        *statistics
+	errors   []int
+	timeouts int
+}
+
+func (s *legacyCountingStatistics) Enable()  { s.s.Enable() }
+func (s *legacyCountingStatistics) Disable() { s.s.Disable() }
+
+func (s *legacyCountingStatistics) AddSuccess(host string, latency time.Duration) {
+	s.s.AddSuccess(host, latency)
+}
+
+func (s *legacyCountingStatistics) AddTimeout(host string) {
+	s.timeouts++
+	s.s.AddTimeout(host, 0)
+}
+
+func (s *legacyCountingStatistics) AddError(host string, code int) {
+	s.errors = append(s.errors, code)
+	s.s.AddError(host, code, 0)
+}
+
+func (s *legacyCountingStatistics) Get(host string) LegacyHostStats {
+	return legacyHostCounts{s.s.Get(host)}
+}
+func (s *legacyCountingStatistics) SetServers(servers []string) { s.s.SetServers(servers) }
+func (s *legacyCountingStatistics) Hosts() []string             { return s.s.Hosts() }
+
+// legacyHostCounts implements LegacyHostStats, and only it
+type legacyHostCounts struct {
+	hs HostStats
+}
+
+func (s legacyHostCounts) Errors() Errors     { return s.hs.Errors() }
+func (s legacyHostCounts) Requests() int      { return s.hs.Requests() }
+func (s legacyHostCounts) Timeouts() int      { return s.hs.Timeouts() }
+func (s legacyHostCounts) Latency() Latency   { return s.hs.Latency() }
+func (s legacyHostCounts) ErrorRate() float64 { return s.hs.ErrorRate() }
+
+func (s legacyHostCounts) Last(window time.Duration) LegacyHostStats {
+	return legacyHostCounts{s.hs.Last(window)}
+}
+
+func TestFromLegacyStatistics(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(503, "unavailable"), taplinktest.Timeout(0), taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	s := &legacyCountingStatistics{s: newStatistics()}
+	c := New(testAppID, WithStatistics(FromLegacyStatistics(s)), WithStatsEnabled()).(*Client)
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{503}, s.errors)
+	assert.Equal(t, 1, s.timeouts)
+	assert.Equal(t, 1, c.Stats().Get(DefaultHost).Errors().Count(503))
+
+	// The methods added since are made from the old ones, or do nothing
+	hs, err := c.Stats().Lookup(DefaultHost)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, hs.Requests())
+		assert.Equal(t, DefaultHost, hs.Host())
+		assert.Equal(t, ErrorClasses{Server: 1, Timeouts: 1}, hs.ErrorsByClass())
+		assert.InDelta(t, 2.0/3, hs.ServerErrorRate(), 0.001)
+		assert.Equal(t, map[int]int64{503: 1}, hs.Totals().Errors)
+		assert.Equal(t, CircuitClosed, hs.CircuitState())
+		assert.Nil(t, hs.TimeoutLatency())
+		assert.Equal(t, DefaultHost, hs.Last(time.Minute).Host())
+	}
+	_, err = c.Stats().Lookup("unknown.com")
+	assert.Equal(t, ErrHostNotFound, err)
+	snap := c.Stats().Snapshot()
+	if assert.Len(t, snap.Hosts, 1) {
+		assert.Equal(t, DefaultHost, snap.Hosts[0].Host)
+		assert.Equal(t, 1, snap.Hosts[0].Timeouts)
+		assert.Equal(t, map[int]int{503: 1}, snap.Hosts[0].Errors)
+	}
+	c.Stats().AddFallback()
+	c.Stats().Reset()
+	assert.Equal(t, 0, c.Stats().Fallbacks())
+	assert.Equal(t, 0, c.Stats().InFlight())
+	assert.Equal(t, 1, c.Stats().Get(DefaultHost).Requests())
+}
+
+func TestTimeoutLatency(t *testing.T) {
+	s := newHostStatistics("foo.com")
+	s.addSuccess(time.Millisecond)
+	s.addTimeout(30 * time.Second)
+	s.addError(503, 2*time.Millisecond)
+	assert.Equal(t, Latency{30 * time.Second}, s.TimeoutLatency())
+	assert.Equal(t, Latency{time.Millisecond, 30 * time.Second, 2 * time.Millisecond}, s.AllLatency())
+	assert.Equal(t, Latency{30 * time.Second}, s.Last(time.Minute).TimeoutLatency())
+
+	// Of two hosts timing out as often, the one slow to time out ranks last
+	stats := newStatistics()
+	stats.Enable()
+	stats.AddSuccess("slow.com", time.Millisecond)
+	stats.AddTimeout("slow.com", 30*time.Second)
+	stats.AddSuccess("fast.com", 2*time.Millisecond)
+	stats.AddTimeout("fast.com", 100*time.Millisecond)
+	assert.Equal(t, []string{"fast.com", "slow.com"}, stats.Hosts())
+}
+
+func TestTimeoutLatencyRecorded(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.RespondAfter(time.Second, 200, "{}"), taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID).(*Client)
+	c.Stats().Enable()
+	c.Config().SetRequestTimeout(30 * time.Millisecond)
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	lat := c.Stats().Get(DefaultHost).TimeoutLatency()
+	if assert.Len(t, lat, 1) {
+		assert.GreaterOrEqual(t, lat[0], 30*time.Millisecond)
+		assert.Less(t, lat[0], time.Second)
+	}
+	assert.Len(t, c.Stats().Get(DefaultHost).AllLatency(), 2)
 }
@@ -0,0 +1,247 @@
+// Package statsdstats sends the requests of a TapLink client to statsd, with
+// DogStatsD tags.
+//
+//	e, err := statsdstats.Attach(api, "127.0.0.1:8125", statsdstats.WithPrefix("auth"))
+//	defer e.Close()
+package statsdstats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TapLink/taplink-go"
+)
+
+var _ taplink.RequestObserver = (*Emitter)(nil)
+
+const (
+	// DefaultFlushInterval is how often buffered metrics are sent, unless
+	// set with WithFlushInterval
+	DefaultFlushInterval = time.Second
+
+	// maxPacketSize keeps each packet within a typical MTU, so it isn't
+	// fragmented
+	maxPacketSize = 1432
+)
+
+// Emitter is a taplink.RequestObserver which sends, tagged with the host:
+//
+//	taplink.requests     counter of successful requests
+//	taplink.errors       counter of failed requests, tagged with the code
+//	taplink.timeouts     counter of requests which timed out
+//	taplink.latency      timing of successful requests, in milliseconds
+//
+// Failures without a response other than timeouts have the code
+// taplink.TransportErrorCode, as in the client's stats.
+//
+// Metrics are buffered and sent over UDP every flush interval, or sooner
+// once a packet is full. Sending never blocks a request: if the statsd
+// endpoint can't be reached the packet is dropped, and counted by Dropped.
+type Emitter struct {
+	conn   net.Conn
+	prefix string
+	tags   string
+
+	buf     bytes.Buffer
+	dropped atomic.Int64
+	closed  bool
+	mu      sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Option configures an Emitter
+type Option func(*options)
+
+type options struct {
+	prefix   string
+	tags     []string
+	interval time.Duration
+}
+
+// WithPrefix prefixes the metric names with prefix and a dot, e.g.
+// "auth.taplink.requests"
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithTags adds tags, such as "env:prod", to every metric
+func WithTags(tags ...string) Option {
+	return func(o *options) {
+		o.tags = append(o.tags, tags...)
+	}
+}
+
+// WithFlushInterval sets how often buffered metrics are sent. Zero or less
+// uses DefaultFlushInterval.
+func WithFlushInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// New returns an Emitter sending to the statsd endpoint at addr, a
+// "host:port" UDP address. It's an error only if addr can't be resolved,
+// not if nothing is listening on it. Close it to stop sending.
+func New(addr string, opts ...Option) (*Emitter, error) {
+	o := options{interval: DefaultFlushInterval}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.interval <= 0 {
+		o.interval = DefaultFlushInterval
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	e := &Emitter{conn: conn, stop: make(chan struct{}), done: make(chan struct{})}
+	if o.prefix != "" {
+		e.prefix = o.prefix + "."
+	}
+	if len(o.tags) > 0 {
+		e.tags = "," + strings.Join(o.tags, ",")
+	}
+	go e.flushLoop(o.interval)
+	return e, nil
+}
+
+// Attach returns an Emitter sending to addr, as New, which is added as an
+// observer of api's requests. Stats don't need to be enabled.
+func Attach(api taplink.Inspector, addr string, opts ...Option) (*Emitter, error) {
+	e, err := New(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	api.Config().AddObserver(e)
+	return e, nil
+}
+
+// OnAttempt implements the taplink.RequestObserver interface. Attempts
+// aren't sent, as they're the sum of the other counters.
+func (e *Emitter) OnAttempt(host string, attempt int) {}
+
+// OnSuccess implements the taplink.RequestObserver interface
+func (e *Emitter) OnSuccess(host string, latency time.Duration, status int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.add("taplink.requests", "1", "c", host, "")
+	e.add("taplink.latency", strconv.FormatFloat(float64(latency)/float64(time.Millisecond), 'f', -1, 64), "ms", host, "")
+}
+
+// OnError implements the taplink.RequestObserver interface
+func (e *Emitter) OnError(host string, status int, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if status == 0 && isTimeout(err) {
+		e.add("taplink.timeouts", "1", "c", host, "")
+		return
+	}
+	if status == 0 {
+		status = taplink.TransportErrorCode
+	}
+	e.add("taplink.errors", "1", "c", host, ",code:"+strconv.Itoa(status))
+}
+
+// OnRetry implements the taplink.RequestObserver interface. Retries aren't
+// sent, as the errors before them are.
+func (e *Emitter) OnRetry(host string, nextDelay time.Duration) {}
+
+// isTimeout returns whether err is the error of an attempt which timed out
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, taplink.ErrRequestTimeout) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &ne) && ne.Timeout()
+}
+
+// add buffers a metric line, sending the buffer first if the line would
+// overflow the packet. It must be called with e.mu held.
+func (e *Emitter) add(name, value, kind, host, tags string) {
+	if e.closed {
+		return
+	}
+	// name:value|kind|#host:h,tags
+	n := len(e.prefix) + len(name) + len(value) + len(kind) + len(host) + len(tags) + len(e.tags) + 10
+	if e.buf.Len() > 0 && e.buf.Len()+n > maxPacketSize {
+		e.flush()
+	}
+	if e.buf.Len() > 0 {
+		e.buf.WriteByte('\n')
+	}
+	e.buf.WriteString(e.prefix)
+	e.buf.WriteString(name)
+	e.buf.WriteByte(':')
+	e.buf.WriteString(value)
+	e.buf.WriteByte('|')
+	e.buf.WriteString(kind)
+	e.buf.WriteString("|#host:")
+	e.buf.WriteString(host)
+	e.buf.WriteString(tags)
+	e.buf.WriteString(e.tags)
+}
+
+// flush sends the buffer as a packet. A packet which can't be sent is
+// dropped. It must be called with e.mu held.
+func (e *Emitter) flush() {
+	if e.buf.Len() == 0 {
+		return
+	}
+	// A UDP write doesn't wait for the other end, so this doesn't block for
+	// long even when nothing is listening
+	if _, err := e.conn.Write(e.buf.Bytes()); err != nil {
+		e.dropped.Add(1)
+	}
+	e.buf.Reset()
+}
+
+// Flush sends the buffered metrics now
+func (e *Emitter) Flush() {
+	e.mu.Lock()
+	e.flush()
+	e.mu.Unlock()
+}
+
+// Dropped returns the number of packets which couldn't be sent
+func (e *Emitter) Dropped() int64 {
+	return e.dropped.Load()
+}
+
+func (e *Emitter) flushLoop(interval time.Duration) {
+	defer close(e.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-t.C:
+			e.Flush()
+		}
+	}
+}
+
+// Close sends the buffered metrics and stops sending. Requests made after
+// it are no longer sent, though the Emitter stays an observer of the client.
+func (e *Emitter) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.flush()
+	e.closed = true
+	e.mu.Unlock()
+	close(e.stop)
+	<-e.done
+	return e.conn.Close()
+}
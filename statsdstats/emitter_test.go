@@ -0,0 +1,141 @@
+package statsdstats
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TapLink/taplink-go"
+	"github.com/TapLink/taplink-go/taplinktest"
+)
+
+const testAppID = "0000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000a"
+
+// listen returns a UDP listener standing in for statsd
+func listen(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// read returns the lines of the next packet pc receives
+func read(t *testing.T, pc net.PacketConn) []string {
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestEmitter(t *testing.T) {
+	pc := listen(t)
+	e, err := New(pc.LocalAddr().String(), WithPrefix("auth"), WithTags("env:test"), WithFlushInterval(time.Hour))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer e.Close()
+
+	e.OnAttempt("foo.com", 1)
+	e.OnSuccess("foo.com", 1500*time.Microsecond, 200)
+	e.OnError("foo.com", 503, &taplink.APIError{StatusCode: 503})
+	e.OnError("foo.com", 0, syscall.ECONNRESET)
+	e.OnError("bar.com", 0, context.DeadlineExceeded)
+	e.OnRetry("bar.com", time.Second)
+	e.Flush()
+	assert.Equal(t, []string{
+		"auth.taplink.requests:1|c|#host:foo.com,env:test",
+		"auth.taplink.latency:1.5|ms|#host:foo.com,env:test",
+		"auth.taplink.errors:1|c|#host:foo.com,code:503,env:test",
+		"auth.taplink.errors:1|c|#host:foo.com,code:999,env:test",
+		"auth.taplink.timeouts:1|c|#host:bar.com,env:test",
+	}, read(t, pc))
+}
+
+func TestEmitterPacketSize(t *testing.T) {
+	pc := listen(t)
+	e, err := New(pc.LocalAddr().String(), WithFlushInterval(time.Hour))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer e.Close()
+
+	// A full packet is sent without waiting for the flush.
+	line := "taplink.timeouts:1|c|#host:foo.com"
+	n := maxPacketSize/(len(line)+1) + 1
+	for i := 0; i < n; i++ {
+		e.OnError("foo.com", 0, context.DeadlineExceeded)
+	}
+	lines := read(t, pc)
+	assert.Equal(t, n-1, len(lines))
+	assert.Equal(t, line, lines[0])
+
+	// And the rest on Close.
+	e.Close()
+	assert.Equal(t, []string{line}, read(t, pc))
+	e.OnError("foo.com", 0, context.DeadlineExceeded)
+	assert.Equal(t, int64(0), e.Dropped())
+}
+
+func TestEmitterFlushInterval(t *testing.T) {
+	pc := listen(t)
+	e, err := New(pc.LocalAddr().String(), WithFlushInterval(10*time.Millisecond))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer e.Close()
+	e.OnSuccess("foo.com", time.Millisecond, 200)
+	assert.Equal(t, []string{"taplink.requests:1|c|#host:foo.com", "taplink.latency:1|ms|#host:foo.com"}, read(t, pc))
+}
+
+func TestEmitterUnreachable(t *testing.T) {
+	// Nothing listens on the port once it's closed, so writes fail with
+	// connection refused, or go nowhere. Either way requests aren't held up.
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	addr := pc.LocalAddr().String()
+	pc.Close()
+	e, err := New(addr, WithFlushInterval(time.Hour))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer e.Close()
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		e.OnSuccess("foo.com", time.Millisecond, 200)
+	}
+	e.Flush()
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = New("no-such-host.invalid:8125")
+	assert.Error(t, err)
+}
+
+func TestAttach(t *testing.T) {
+	pc := listen(t)
+	st := &taplinktest.ScriptedTransport{}
+	st.Enqueue(taplinktest.Respond(503, "unavailable"), taplinktest.Respond(200, `{"servers":[]}`))
+	api := taplink.New(testAppID, taplink.WithHTTPClient(&http.Client{Transport: st}), taplink.WithRetry(2, 0))
+	e, err := Attach(api, pc.LocalAddr().String(), WithFlushInterval(time.Hour))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, api.Config().Load())
+	e.Close()
+
+	lines := read(t, pc)
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, "taplink.errors:1|c|#host:"+taplink.DefaultHost+",code:503", lines[0])
+		assert.Equal(t, "taplink.requests:1|c|#host:"+taplink.DefaultHost, lines[1])
+		assert.True(t, strings.HasPrefix(lines[2], "taplink.latency:"))
+	}
+}
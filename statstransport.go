@@ -0,0 +1,175 @@
+package taplink
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StatsTransport returns a RoundTripper which makes requests with next, or
+// http.DefaultTransport if it's nil, and records their outcomes in s the way
+// the client does: the latency of successes, errors by status code, with
+// TransportErrorCode or ProxyErrorCode for requests which got no response,
+// and timeouts. It lets an HTTP client shared with the rest of an
+// application, with its own middlewares, feed the stats.
+//
+// A response is recorded once its body is closed, with the latency of its
+// headers, unless reading the body failed first. Requests whose context was
+// cancelled aren't recorded. Each round trip is recorded, so a redirect
+// followed by the HTTP client counts as a request to each host. Hosts are
+// the servers as the client names them for its own requests, and otherwise
+// the host of the URL, with the scheme in front unless it's https.
+//
+// The client records its requests too unless SetTransportStats tells it the
+// transport does.
+func StatsTransport(next http.RoundTripper, s Statistics) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &statsTransport{next: next, stats: s}
+}
+
+// statsTransport is the RoundTripper returned by StatsTransport
+type statsTransport struct {
+	next  http.RoundTripper
+	stats Statistics
+}
+
+// statsHostKey is the context key of the server the client makes a request
+// to, which the stats of the request are recorded for
+type statsHostKey struct{}
+
+// RoundTrip implements http.RoundTripper
+func (t *statsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host, ok := req.Context().Value(statsHostKey{}).(string)
+	if !ok {
+		host = req.URL.Host
+		if req.URL.Scheme != "https" {
+			host = req.URL.Scheme + "://" + host
+		}
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		code := TransportErrorCode
+		if proxyFailed(err) {
+			code = ProxyErrorCode
+		}
+		t.failed(req.Context(), host, err, code, time.Since(start), start)
+		return resp, err
+	}
+	resp.Body = &statsBody{
+		ReadCloser: resp.Body,
+		t:          t,
+		ctx:        req.Context(),
+		host:       host,
+		start:      start,
+		latency:    time.Since(start),
+		status:     resp.StatusCode,
+	}
+	return resp, nil
+}
+
+// failed records the failure of the request to host which started at start:
+// a timeout if it timed out, or otherwise an error with code and latency
+func (t *statsTransport) failed(ctx context.Context, host string, err error, code int, latency time.Duration, start time.Time) {
+	switch {
+	case ctx.Err() == context.Canceled:
+	case ctx.Err() == context.DeadlineExceeded || isTimeout(err):
+		t.stats.AddTimeout(host, time.Since(start))
+	default:
+		t.stats.AddError(host, code, latency)
+	}
+}
+
+// statsBody is the body of a response from a statsTransport, which records
+// the response once it's closed or reading it fails
+type statsBody struct {
+	io.ReadCloser
+	t       *statsTransport
+	ctx     context.Context
+	host    string
+	start   time.Time
+	latency time.Duration
+	status  int
+	once    sync.Once
+}
+
+// Read records the response as failed if reading it fails
+func (b *statsBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.once.Do(func() { b.t.failed(b.ctx, b.host, err, TransportErrorCode, b.latency, b.start) })
+	}
+	return n, err
+}
+
+// Close records the response by its status, unless reading it failed
+func (b *statsBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		if b.status >= 400 {
+			b.t.stats.AddError(b.host, b.status, b.latency)
+		} else {
+			b.t.stats.AddSuccess(b.host, b.latency)
+		}
+	})
+	return err
+}
+
+// TransportStats returns whether the transport of the HTTP client records
+// the requests, see SetTransportStats
+func (c *Config) TransportStats() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.transportStats
+}
+
+// SetTransportStats tells the client whether the transport of its HTTP client
+// records the outcomes of requests, having been wrapped with StatsTransport
+// around the client's Stats(). The client then doesn't record them itself,
+// so they aren't counted twice. The transport only sees the responses, so a
+// response the client rejects after reading it, such as one which is too
+// large or empty, or has the wrong content type, is recorded by its status,
+// and the request a hedged request wins over isn't recorded as a timeout.
+// The in-flight counts and the traces of requests are still recorded by the
+// client.
+func (c *Config) SetTransportStats(enabled bool) {
+	c.Lock()
+	c.transportStats = enabled
+	c.Unlock()
+}
+
+// WithTransportStats tells the client the transport of its HTTP client
+// records the requests, see SetTransportStats
+func WithTransportStats() Option {
+	return func(c *Config) {
+		c.transportStats = true
+	}
+}
+
+// attemptStats records the outcomes of requests to hosts
+type attemptStats interface {
+	AddSuccess(host string, latency time.Duration)
+	AddError(host string, code int, latency time.Duration)
+	AddTimeout(host string, latency time.Duration)
+}
+
+// discardAttempts is the attemptStats of a client whose transport records
+// the requests
+type discardAttempts struct{}
+
+func (discardAttempts) AddSuccess(string, time.Duration)    {}
+func (discardAttempts) AddError(string, int, time.Duration) {}
+func (discardAttempts) AddTimeout(string, time.Duration)    {}
+
+// attemptStats returns where the client records the outcomes of its
+// requests: the stats, unless the transport records them
+func (c *Client) attemptStats() attemptStats {
+	if c.Config().TransportStats() {
+		return discardAttempts{}
+	}
+	return c.Stats()
+}
@@ -0,0 +1,99 @@
+package taplink
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+// statsTransportScript enqueues a success after an error status, a timeout
+// and a transport error, then a client error which isn't retried
+func statsTransportScript(st *taplinktest.ScriptedTransport) {
+	st.Enqueue(
+		taplinktest.RespondAfter(10*time.Millisecond, 503, "unavailable"),
+		taplinktest.Timeout(0),
+		taplinktest.TransportError(errors.New("connection reset")),
+		taplinktest.RespondAfter(10*time.Millisecond, 200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`),
+		taplinktest.Respond(404, "not found"),
+	)
+}
+
+// statsTransportCalls makes the calls statsTransportScript is for
+func statsTransportCalls(t *testing.T, c *Client) {
+	c.Config().SetRetryPolicy(5, 0)
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.Error(t, err)
+}
+
+func TestStatsTransportMatchesInline(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	statsTransportScript(st)
+	inline := New(testAppID, WithStatsEnabled()).(*Client)
+	statsTransportCalls(t, inline)
+
+	statsTransportScript(st)
+	hc := &http.Client{}
+	wrapped := New(testAppID, WithHTTPClient(hc), WithStatsEnabled(), WithTransportStats()).(*Client)
+	hc.Transport = StatsTransport(st, wrapped.Stats())
+	statsTransportCalls(t, wrapped)
+
+	want, got := inline.Stats().Get(DefaultHost).Totals(), wrapped.Stats().Get(DefaultHost).Totals()
+	assert.Equal(t, int64(1), want.Requests)
+	assert.Equal(t, int64(1), want.Timeouts)
+	assert.Equal(t, map[int]int64{503: 1, 404: 1, TransportErrorCode: 1}, want.Errors)
+	assert.Equal(t, want.Requests, got.Requests)
+	assert.Equal(t, want.Timeouts, got.Timeouts)
+	assert.Equal(t, want.Errors, got.Errors)
+	assert.GreaterOrEqual(t, got.Latency, 10*time.Millisecond)
+	lat := wrapped.Stats().Get(DefaultHost).ErrorLatency()
+	if assert.Len(t, lat, 3) {
+		assert.GreaterOrEqual(t, lat[0], 10*time.Millisecond)
+	}
+	assert.Equal(t, 0, st.Unclosed())
+}
+
+func TestStatsTransportWithoutTransportStats(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	hc := &http.Client{}
+	c := New(testAppID, WithHTTPClient(hc), WithStatsEnabled()).(*Client)
+	hc.Transport = StatsTransport(st, c.Stats())
+	assert.False(t, c.Config().TransportStats())
+
+	// Both the client and the transport record the request
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), c.Stats().Get(DefaultHost).Totals().Requests)
+
+	c.Config().SetTransportStats(true)
+	st.Enqueue(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	_, err = c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), c.Stats().Get(DefaultHost).Totals().Requests)
+}
+
+func TestStatsTransportOwnClient(t *testing.T) {
+	st := &taplinktest.ScriptedTransport{}
+	st.Enqueue(taplinktest.Respond(500, "oops"), taplinktest.Respond(200, "ok"))
+	s := newStatistics()
+	s.Enable()
+	hc := &http.Client{Transport: StatsTransport(st, s)}
+
+	for _, u := range []string{"https://api.example.com/a", "http://localhost:8080/b"} {
+		resp, err := hc.Get(u)
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp.Body.Close()
+	}
+	assert.Equal(t, map[int]int64{500: 1}, s.Get("api.example.com").Totals().Errors)
+	assert.Equal(t, int64(1), s.Get("http://localhost:8080").Totals().Requests)
+}
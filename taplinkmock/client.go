@@ -0,0 +1,199 @@
+// Package taplinkmock provides a programmable mock of the TapLink client's
+// password operations, for the unit tests of applications which depend on
+// taplink.PasswordHasher, taplink.PasswordVerifier or taplink.SaltProvider
+// rather than the whole taplink.API. It makes no requests.
+//
+//	m := taplinkmock.New()
+//	m.VerifyPasswordFunc = func(ctx context.Context, hash, expected []byte, versionID int64) (*taplink.VerifyPassword, error) {
+//		return nil, taplink.ErrRetriesExhausted
+//	}
+//	svc := NewLoginService(m)
+//
+// taplinktest fakes the API at the HTTP level instead, for testing a real
+// client.
+package taplinkmock
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"sync"
+
+	"github.com/TapLink/taplink-go"
+)
+
+var (
+	// ensures the Client implements the interfaces it mocks
+	_ taplink.PasswordHasher      = (*Client)(nil)
+	_ taplink.PasswordVerifier    = (*Client)(nil)
+	_ taplink.SaltProvider        = (*Client)(nil)
+	_ taplink.SaltProviderContext = (*Client)(nil)
+)
+
+// DefaultVersionID is the data pool version of the salts a Client makes for
+// the latest version, unless LatestVersion is set
+const DefaultVersionID int64 = 1
+
+// Call is a call made to a Client
+type Call struct {
+	// Method is "NewPassword", "VerifyPassword" or "GetSalt", for the
+	// methods with and without a context
+	Method    string
+	Hash      []byte
+	VersionID int64
+}
+
+// Client is a mock of the password operations. Each of its funcs, if set,
+// is called for the method of the same name, with context.Background() for
+// the methods without a context. Otherwise the methods behave like the API
+// would: salts are derived from the hash and version, so the same hash
+// always gets the same salt, and hashes made by NewPassword verify with
+// VerifyPassword. Funcs and LatestVersion must not be changed while the
+// Client is used; the zero Client is ready to use.
+type Client struct {
+	NewPasswordFunc    func(ctx context.Context, hash []byte) (*taplink.NewPassword, error)
+	VerifyPasswordFunc func(ctx context.Context, hash, expected []byte, versionID int64) (*taplink.VerifyPassword, error)
+	GetSaltFunc        func(ctx context.Context, hash []byte, versionID int64) (*taplink.Salt, error)
+
+	// LatestVersion is the version requests for the latest version get,
+	// DefaultVersionID if it's 0. Hashes verified with an older version get
+	// an upgrade to it.
+	LatestVersion int64
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// New returns a Client with the default behavior
+func New() *Client {
+	return &Client{}
+}
+
+// Calls returns the calls made so far, in order
+func (c *Client) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call(nil), c.calls...)
+}
+
+// Reset forgets the calls made so far
+func (c *Client) Reset() {
+	c.mu.Lock()
+	c.calls = nil
+	c.mu.Unlock()
+}
+
+// record records a call to method
+func (c *Client) record(method string, hash []byte, versionID int64) {
+	c.mu.Lock()
+	c.calls = append(c.calls, Call{Method: method, Hash: append([]byte(nil), hash...), VersionID: versionID})
+	c.mu.Unlock()
+}
+
+// latest returns the version requests for the latest version get
+func (c *Client) latest() int64 {
+	if c.LatestVersion > 0 {
+		return c.LatestVersion
+	}
+	return DefaultVersionID
+}
+
+// NewPassword implements taplink.Provisioner
+func (c *Client) NewPassword(hash []byte) (*taplink.NewPassword, error) {
+	return c.NewPasswordContext(context.Background(), hash)
+}
+
+// NewPasswordContext implements taplink.ProvisionerContext
+func (c *Client) NewPasswordContext(ctx context.Context, hash []byte) (*taplink.NewPassword, error) {
+	c.record("NewPassword", hash, 0)
+	if c.NewPasswordFunc != nil {
+		return c.NewPasswordFunc(ctx, hash)
+	}
+	s, err := c.salt(ctx, hash, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &taplink.NewPassword{Hash: sum(s.Salt, hash), VersionID: s.VersionID}, nil
+}
+
+// VerifyPassword implements taplink.Verifier
+func (c *Client) VerifyPassword(hash, expected []byte, versionID int64) (*taplink.VerifyPassword, error) {
+	return c.VerifyPasswordContext(context.Background(), hash, expected, versionID)
+}
+
+// VerifyPasswordContext implements taplink.VerifierContext
+func (c *Client) VerifyPasswordContext(ctx context.Context, hash, expected []byte, versionID int64) (*taplink.VerifyPassword, error) {
+	c.record("VerifyPassword", hash, versionID)
+	if c.VerifyPasswordFunc != nil {
+		return c.VerifyPasswordFunc(ctx, hash, expected, versionID)
+	}
+	s, err := c.salt(ctx, hash, versionID)
+	if err != nil {
+		return nil, err
+	}
+	vp := &taplink.VerifyPassword{Hash: sum(s.Salt, hash), VersionID: s.VersionID, NewVersionID: s.NewVersionID}
+	vp.Matched = bytes.Equal(vp.Hash, expected)
+	if vp.Matched && s.NewSalt != nil {
+		vp.NewHash = sum(s.NewSalt, hash)
+	}
+	return vp, nil
+}
+
+// GetSalt implements taplink.SaltProvider
+func (c *Client) GetSalt(hash []byte, versionID int64) (*taplink.Salt, error) {
+	return c.GetSaltContext(context.Background(), hash, versionID)
+}
+
+// GetSaltContext implements taplink.SaltProviderContext
+func (c *Client) GetSaltContext(ctx context.Context, hash []byte, versionID int64) (*taplink.Salt, error) {
+	c.record("GetSalt", hash, versionID)
+	return c.salt(ctx, hash, versionID)
+}
+
+// salt returns the salt for hash and versionID, from GetSaltFunc if it's set
+func (c *Client) salt(ctx context.Context, hash []byte, versionID int64) (*taplink.Salt, error) {
+	if len(hash) != taplink.HashSize {
+		return nil, taplink.ErrInvalidHash
+	}
+	if versionID < 0 {
+		return nil, taplink.ErrInvalidVersion
+	}
+	if c.GetSaltFunc != nil {
+		return c.GetSaltFunc(ctx, hash, versionID)
+	}
+	latest := c.latest()
+	if versionID == 0 {
+		versionID = latest
+	}
+	s := &taplink.Salt{Salt: Salt(hash, versionID), VersionID: versionID}
+	if versionID < latest {
+		s.NewSalt, s.NewVersionID = Salt(hash, latest), latest
+	}
+	return s, nil
+}
+
+// Salt returns the salt a Client makes for hash and versionID by default
+func Salt(hash []byte, versionID int64) []byte {
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], uint64(versionID))
+	h := sha512.New()
+	h.Write([]byte("taplinkmock"))
+	h.Write(v[:])
+	h.Write(hash)
+	return h.Sum(nil)
+}
+
+// Hash returns the hash a Client makes for hash with the salt for versionID
+// by default, e.g. to set up the expected hash of a stored user
+func Hash(hash []byte, versionID int64) []byte {
+	return sum(Salt(hash, versionID), hash)
+}
+
+// sum is the hash of hash with salt, as the taplink client makes it
+func sum(salt, hash []byte) []byte {
+	mac := hmac.New(sha512.New, salt)
+	mac.Write(hash)
+	return mac.Sum(nil)
+}
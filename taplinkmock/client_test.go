@@ -0,0 +1,86 @@
+package taplinkmock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/TapLink/taplink-go"
+	"github.com/stretchr/testify/assert"
+)
+
+var testHash = bytes.Repeat([]byte{1}, taplink.HashSize)
+
+// login is application code which only depends on verifying passwords
+func login(v taplink.PasswordVerifier, hash, stored []byte, versionID int64) (bool, error) {
+	vp, err := v.VerifyPassword(hash, stored, versionID)
+	if err != nil {
+		return false, err
+	}
+	return vp.Matched, nil
+}
+
+func TestClientDefaults(t *testing.T) {
+	c := New()
+	np, err := c.NewPassword(testHash)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultVersionID, np.VersionID)
+	assert.Equal(t, Hash(testHash, DefaultVersionID), np.Hash)
+
+	ok, err := login(c, testHash, np.Hash, np.VersionID)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = login(c, testHash, []byte("wrong"), np.VersionID)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = c.GetSalt(testHash[:10], 0)
+	assert.Equal(t, taplink.ErrInvalidHash, err)
+
+	assert.Equal(t, []Call{
+		{Method: "NewPassword", Hash: testHash},
+		{Method: "VerifyPassword", Hash: testHash, VersionID: 1},
+		{Method: "VerifyPassword", Hash: testHash, VersionID: 1},
+		{Method: "GetSalt", Hash: testHash[:10]},
+	}, c.Calls())
+	c.Reset()
+	assert.Empty(t, c.Calls())
+}
+
+func TestClientUpgrade(t *testing.T) {
+	c := &Client{LatestVersion: 3}
+	vp, err := c.VerifyPasswordContext(context.Background(), testHash, Hash(testHash, 2), 2)
+	assert.NoError(t, err)
+	assert.True(t, vp.Matched)
+	assert.Equal(t, int64(2), vp.VersionID)
+	assert.Equal(t, int64(3), vp.NewVersionID)
+	assert.Equal(t, Hash(testHash, 3), vp.NewHash)
+
+	// The hash matches what a taplink client makes with the same salt
+	s, err := c.GetSalt(testHash, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, Salt(testHash, 3), s.Salt)
+	assert.Nil(t, s.NewSalt)
+}
+
+func TestClientFuncs(t *testing.T) {
+	unavailable := errors.New("unavailable")
+	c := New()
+	c.VerifyPasswordFunc = func(ctx context.Context, hash, expected []byte, versionID int64) (*taplink.VerifyPassword, error) {
+		return nil, unavailable
+	}
+	c.GetSaltFunc = func(ctx context.Context, hash []byte, versionID int64) (*taplink.Salt, error) {
+		return &taplink.Salt{Salt: bytes.Repeat([]byte{2}, taplink.SaltSize), VersionID: 7}, nil
+	}
+
+	_, err := login(c, testHash, nil, 0)
+	assert.Equal(t, unavailable, err)
+
+	// NewPassword hashes with the salt from GetSaltFunc
+	np, err := c.NewPassword(testHash)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), np.VersionID)
+	assert.Equal(t, sum(bytes.Repeat([]byte{2}, taplink.SaltSize), testHash), np.Hash)
+	assert.Len(t, c.Calls(), 2)
+}
@@ -0,0 +1,253 @@
+package taplinktest
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ensures the Fake implements the http.Handler and http.RoundTripper interfaces
+	_ http.Handler      = (*Fake)(nil)
+	_ http.RoundTripper = (*Fake)(nil)
+
+	// DefaultFakeKey is the key a Fake derives salts from unless it's given
+	// another one
+	DefaultFakeKey = []byte("taplinktest fake data pool key")
+)
+
+// Fake is an in-memory fake of the TapLink API. It answers config requests
+// with its Servers, and salt requests with a salt derived deterministically
+// from the hash and version, so the same hash always gets the same salt.
+// Requests for a version older than the latest also get the latest salt, as
+// an upgrade.
+//
+// A Fake is an http.RoundTripper, so it can be used as the transport of the
+// client's HTTP client without any network, and an http.Handler, so it can
+// be served by an httptest.Server. Its fields must not be changed while
+// requests are being made.
+type Fake struct {
+	// Key is the secret salts are derived from
+	Key []byte
+	// Servers and LastModified are the config returned for every AppID
+	Servers      []string
+	LastModified int64
+
+	// Latency is how long each request takes
+	Latency time.Duration
+	// ErrorRate and TimeoutRate are the fractions of requests, between 0 and
+	// 1, which fail with ErrorStatus and which time out
+	ErrorRate   float64
+	TimeoutRate float64
+	ErrorStatus int
+
+	latest   int64
+	rand     *rand.Rand
+	requests []string
+
+	mu sync.Mutex
+}
+
+// NewFake returns a Fake using DefaultFakeKey, whose latest version is
+// latestVersion. Failures are injected in the same order each run.
+func NewFake(latestVersion int64) *Fake {
+	return &Fake{
+		Key:         DefaultFakeKey,
+		ErrorStatus: http.StatusServiceUnavailable,
+		latest:      latestVersion,
+		rand:        rand.New(rand.NewSource(1)),
+	}
+}
+
+// LatestVersion returns the latest data pool version
+func (f *Fake) LatestVersion() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.latest
+}
+
+// SetLatestVersion sets the latest data pool version, e.g. to test upgrading
+// hashes made with an older one. It's safe to call while requests are made.
+func (f *Fake) SetLatestVersion(v int64) {
+	f.mu.Lock()
+	f.latest = v
+	f.mu.Unlock()
+}
+
+// Salt returns the salt the Fake answers with for hash and versionID
+func (f *Fake) Salt(hash []byte, versionID int64) []byte {
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], uint64(versionID))
+	mac := hmac.New(sha512.New, f.Key)
+	mac.Write(v[:])
+	mac.Write(hash)
+	return mac.Sum(nil)
+}
+
+// Hash returns the hash the client makes for hash with the salt for
+// versionID, i.e. what NewPassword returns and VerifyPassword expects
+func (f *Fake) Hash(hash []byte, versionID int64) []byte {
+	mac := hmac.New(sha512.New, f.Salt(hash, versionID))
+	mac.Write(hash)
+	return mac.Sum(nil)
+}
+
+// Attempts returns the number of requests made to host
+func (f *Fake) Attempts(host string) (n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, h := range f.requests {
+		if h == host {
+			n++
+		}
+	}
+	return
+}
+
+// Requests returns the number of requests made
+func (f *Fake) Requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.requests)
+}
+
+// fakeResponse is the Fake's answer to a request
+type fakeResponse struct {
+	code    int
+	body    []byte
+	timeout bool
+}
+
+// handle records the request and decides the response to it
+func (f *Fake) handle(r *http.Request) fakeResponse {
+	// Requests made by a client have the host in the URL, and requests
+	// received by a server in Host
+	host := r.URL.Host
+	if host == "" {
+		host = r.Host
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, host)
+	latest := f.latest
+	roll := f.rand.Float64()
+	f.mu.Unlock()
+
+	switch {
+	case roll < f.TimeoutRate:
+		return fakeResponse{timeout: true}
+	case roll < f.TimeoutRate+f.ErrorRate:
+		return fakeResponse{code: f.ErrorStatus, body: []byte(http.StatusText(f.ErrorStatus))}
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if !validHex(parts[0]) {
+		return badRequest("First part of the path must be a 64-byte AppID, encoded as a 128-character hexidecimal string, e.g. '/<AppID>/'")
+	}
+	if len(parts) == 1 || len(parts) == 2 && parts[1] == "" {
+		servers := f.Servers
+		if servers == nil {
+			servers = []string{}
+		}
+		return jsonResponse(map[string]interface{}{"lastModified": f.LastModified, "servers": servers})
+	}
+	if len(parts) > 3 || !validHex(parts[1]) {
+		return badRequest("Second part of the path must be a 64-byte hash, encoded as a 128-character hexidecimal string")
+	}
+	hash, _ := hex.DecodeString(parts[1])
+	version := latest
+	if len(parts) == 3 && parts[2] != "" {
+		v, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || v < 1 || v > latest {
+			return badRequest("Unknown version")
+		}
+		version = v
+	}
+	resp := map[string]interface{}{"s2": hex.EncodeToString(f.Salt(hash, version)), "vid": version}
+	if version < latest {
+		resp["new_s2"] = hex.EncodeToString(f.Salt(hash, latest))
+		resp["new_vid"] = latest
+	}
+	return jsonResponse(resp)
+}
+
+func validHex(s string) bool {
+	if len(s) != 128 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func badRequest(msg string) fakeResponse {
+	return fakeResponse{code: http.StatusBadRequest, body: []byte(msg)}
+}
+
+func jsonResponse(v interface{}) fakeResponse {
+	body, _ := json.Marshal(v)
+	return fakeResponse{code: http.StatusOK, body: body}
+}
+
+// wait waits for the Fake's latency, or for the request to be cancelled
+func (f *Fake) wait(r *http.Request) error {
+	if f.Latency <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(f.Latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+}
+
+// ServeHTTP implements the http.Handler interface. Requests which time out
+// aren't answered until they're cancelled.
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.wait(r) != nil {
+		return
+	}
+	resp := f.handle(r)
+	if resp.timeout {
+		<-r.Context().Done()
+		return
+	}
+	f.write(w, r, resp)
+}
+
+func (f *Fake) write(w http.ResponseWriter, r *http.Request, resp fakeResponse) {
+	if resp.code == http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(resp.code)
+	if r.Method != "HEAD" {
+		w.Write(resp.body)
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface. Requests which time
+// out fail with a timeout error after the latency.
+func (f *Fake) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := f.wait(req); err != nil {
+		return nil, err
+	}
+	resp := f.handle(req)
+	if resp.timeout {
+		return nil, timeoutError{}
+	}
+	rec := httptest.NewRecorder()
+	f.write(rec, req, resp)
+	res := rec.Result()
+	res.Request = req
+	return res, nil
+}
@@ -0,0 +1,96 @@
+package taplinktest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	fakeAppID = strings.Repeat("ab", 64)
+	fakeHash  = strings.Repeat("cd", 64)
+)
+
+type fakeSalt struct {
+	Salt2Hex     string `json:"s2"`
+	VersionID    int64  `json:"vid"`
+	NewSalt2Hex  string `json:"new_s2"`
+	NewVersionID int64  `json:"new_vid"`
+}
+
+func getSalt(t *testing.T, c *http.Client, url string) (int, fakeSalt) {
+	code, body, err := get(t, c, url)
+	assert.NoError(t, err)
+	var s fakeSalt
+	if code == 200 {
+		assert.NoError(t, json.Unmarshal([]byte(body), &s))
+	}
+	return code, s
+}
+
+func TestFakeSalts(t *testing.T) {
+	f := NewFake(3)
+	c := &http.Client{Transport: f}
+	hash, _ := hex.DecodeString(fakeHash)
+
+	code, s := getSalt(t, c, "https://api.example.com/"+fakeAppID+"/"+fakeHash+"/")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, fakeSalt{Salt2Hex: hex.EncodeToString(f.Salt(hash, 3)), VersionID: 3}, s)
+	assert.Len(t, f.Salt(hash, 3), 64)
+
+	// The same request gets the same salt, and an older version an upgrade.
+	_, again := getSalt(t, c, "https://api.example.com/"+fakeAppID+"/"+fakeHash+"/3")
+	assert.Equal(t, s, again)
+	_, old := getSalt(t, c, "https://api.example.com/"+fakeAppID+"/"+fakeHash+"/2")
+	assert.Equal(t, fakeSalt{Salt2Hex: hex.EncodeToString(f.Salt(hash, 2)), VersionID: 2, NewSalt2Hex: s.Salt2Hex, NewVersionID: 3}, old)
+	assert.NotEqual(t, s.Salt2Hex, old.Salt2Hex)
+
+	for _, path := range []string{"/foo", "/" + fakeAppID + "/foo/", "/" + fakeAppID + "/" + fakeHash + "/4", "/" + fakeAppID + "/" + fakeHash + "/0"} {
+		code, _ = getSalt(t, c, "https://api.example.com"+path)
+		assert.Equal(t, 400, code, path)
+	}
+	assert.Equal(t, 7, f.Attempts("api.example.com"))
+}
+
+func TestFakeConfig(t *testing.T) {
+	f := NewFake(1)
+	f.Servers = []string{"a.example.com", "b.example.com"}
+	f.LastModified = 100
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	code, body, err := get(t, srv.Client(), srv.URL+"/"+fakeAppID)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, code)
+	assert.JSONEq(t, `{"lastModified":100,"servers":["a.example.com","b.example.com"]}`, body)
+	assert.Equal(t, 1, f.Requests())
+}
+
+func TestFakeFailures(t *testing.T) {
+	f := NewFake(1)
+	f.ErrorRate, f.TimeoutRate, f.ErrorStatus = 0.3, 0.2, 500
+	c := &http.Client{Transport: f}
+	var ok, errs, timeouts int
+	for i := 0; i < 1000; i++ {
+		code, _, err := get(t, c, "https://api.example.com/"+fakeAppID+"/"+fakeHash+"/")
+		var ne net.Error
+		switch {
+		case errors.As(err, &ne) && ne.Timeout():
+			timeouts++
+		case code == 500:
+			errs++
+		case code == 200:
+			ok++
+		}
+	}
+	assert.InDelta(t, 500, ok, 60)
+	assert.InDelta(t, 300, errs, 60)
+	assert.InDelta(t, 200, timeouts, 60)
+}
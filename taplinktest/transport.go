@@ -0,0 +1,258 @@
+// Package taplinktest provides utilities for testing code which uses the
+// TapLink API client, without making requests to the TapLink API.
+package taplinktest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+var (
+	// ensures the ScriptedTransport implements the http.RoundTripper interface
+	_ http.RoundTripper = (*ScriptedTransport)(nil)
+
+	// ErrNoOutcome is returned by a ScriptedTransport when a request is made
+	// and there is no scripted outcome left for it
+	ErrNoOutcome = errors.New("no scripted outcome for request")
+)
+
+// Outcome is a scripted result for a single request
+type Outcome struct {
+	// StatusCode and Body are the response to return. They're ignored if
+	// Err is set or Timeout is true.
+	StatusCode int
+	Body       []byte
+	Header     http.Header
+	// Err is returned instead of a response, as a transport error
+	Err error
+	// Timeout returns a timeout error instead of a response, after Latency
+	Timeout bool
+	// Latency is how long to wait before returning the outcome
+	Latency time.Duration
+}
+
+// Respond returns an outcome which responds with the given status code and
+// body, with a JSON content type like the API
+func Respond(code int, body string) Outcome {
+	return Outcome{StatusCode: code, Body: []byte(body), Header: jsonHeader()}
+}
+
+// RespondAfter returns an outcome which responds with the given status code
+// and body after waiting d, with a JSON content type like the API
+func RespondAfter(d time.Duration, code int, body string) Outcome {
+	return Outcome{StatusCode: code, Body: []byte(body), Header: jsonHeader(), Latency: d}
+}
+
+func jsonHeader() http.Header {
+	return http.Header{"Content-Type": {"application/json"}}
+}
+
+// TransportError returns an outcome which fails with err instead of responding
+func TransportError(err error) Outcome {
+	return Outcome{Err: err}
+}
+
+// Timeout returns an outcome which fails with a timeout error after waiting d
+func Timeout(d time.Duration) Outcome {
+	return Outcome{Timeout: true, Latency: d}
+}
+
+// Repeat returns a slice containing o n times
+func Repeat(n int, o Outcome) []Outcome {
+	l := make([]Outcome, n)
+	for i := range l {
+		l[i] = o
+	}
+	return l
+}
+
+// timeoutError is a net.Error which is a timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "taplinktest: scripted timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type script struct {
+	pattern  string
+	outcomes []Outcome
+}
+
+// ScriptedTransport is an http.RoundTripper which returns scripted outcomes
+// in the order they were enqueued, and records the requests made. Outcomes
+// enqueued for a host or path pattern are used before unkeyed outcomes.
+// The zero value is ready to use.
+type ScriptedTransport struct {
+	scripts  []*script
+	outcomes []Outcome
+	fallback *Outcome
+	hosts    []string
+	paths    []string
+	unclosed int
+
+	mu sync.Mutex
+}
+
+// Enqueue adds outcomes to be used for requests to any host or path
+func (t *ScriptedTransport) Enqueue(outcomes ...Outcome) {
+	t.mu.Lock()
+	t.outcomes = append(t.outcomes, outcomes...)
+	t.mu.Unlock()
+}
+
+// EnqueueFor adds outcomes to be used for requests whose host or path
+// matches pattern. Patterns use the path.Match syntax.
+func (t *ScriptedTransport) EnqueueFor(pattern string, outcomes ...Outcome) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.scripts {
+		if s.pattern == pattern {
+			s.outcomes = append(s.outcomes, outcomes...)
+			return
+		}
+	}
+	t.scripts = append(t.scripts, &script{pattern: pattern, outcomes: outcomes})
+}
+
+// SetDefault sets an outcome to use once the enqueued outcomes run out.
+// Without a default, ErrNoOutcome is returned.
+func (t *ScriptedTransport) SetDefault(o Outcome) {
+	t.mu.Lock()
+	t.fallback = &o
+	t.mu.Unlock()
+}
+
+// Attempts returns the number of requests made to host
+func (t *ScriptedTransport) Attempts(host string) (n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, h := range t.hosts {
+		if h == host {
+			n++
+		}
+	}
+	return
+}
+
+// Hosts returns the host of each request made, in order
+func (t *ScriptedTransport) Hosts() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.hosts...)
+}
+
+// Paths returns the path of each request made, in order
+func (t *ScriptedTransport) Paths() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.paths...)
+}
+
+// Unclosed returns the number of response bodies which haven't been closed
+func (t *ScriptedTransport) Unclosed() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.unclosed
+}
+
+// body is a response body which tells its transport when it's closed
+type body struct {
+	*bytes.Reader
+	t    *ScriptedTransport
+	once sync.Once
+}
+
+func (b *body) Close() error {
+	b.once.Do(func() {
+		b.t.mu.Lock()
+		b.t.unclosed--
+		b.t.mu.Unlock()
+	})
+	return nil
+}
+
+// Remaining returns the number of enqueued outcomes which haven't been used
+func (t *ScriptedTransport) Remaining() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(t.outcomes)
+	for _, s := range t.scripts {
+		n += len(s.outcomes)
+	}
+	return n
+}
+
+func (t *ScriptedTransport) next(req *http.Request) (Outcome, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hosts = append(t.hosts, req.URL.Host)
+	t.paths = append(t.paths, req.URL.Path)
+	for _, s := range t.scripts {
+		if len(s.outcomes) == 0 || !(match(s.pattern, req.URL.Host) || match(s.pattern, req.URL.Path)) {
+			continue
+		}
+		o := s.outcomes[0]
+		s.outcomes = s.outcomes[1:]
+		return o, true
+	}
+	if len(t.outcomes) > 0 {
+		o := t.outcomes[0]
+		t.outcomes = t.outcomes[1:]
+		return o, true
+	}
+	if t.fallback != nil {
+		return *t.fallback, true
+	}
+	return Outcome{}, false
+}
+
+func match(pattern, s string) bool {
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *ScriptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	o, ok := t.next(req)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoOutcome, req.Method, req.URL)
+	}
+	if o.Latency > 0 {
+		timer := time.NewTimer(o.Latency)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+	switch {
+	case o.Timeout:
+		return nil, timeoutError{}
+	case o.Err != nil:
+		return nil, o.Err
+	}
+	header := make(http.Header)
+	for k, v := range o.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	t.mu.Lock()
+	t.unclosed++
+	t.mu.Unlock()
+	return &http.Response{
+		StatusCode:    o.StatusCode,
+		Status:        fmt.Sprintf("%d %s", o.StatusCode, http.StatusText(o.StatusCode)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          &body{Reader: bytes.NewReader(o.Body), t: t},
+		ContentLength: int64(len(o.Body)),
+		Request:       req,
+	}, nil
+}
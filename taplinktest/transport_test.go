@@ -0,0 +1,110 @@
+package taplinktest
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func get(t *testing.T, c *http.Client, url string) (int, string, error) {
+	resp, err := c.Get(url)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.StatusCode, string(b), nil
+}
+
+func TestScriptedTransport(t *testing.T) {
+	st := &ScriptedTransport{}
+	st.Enqueue(Timeout(0), Respond(503, "down"), RespondAfter(10*time.Millisecond, 200, "ok"))
+	c := &http.Client{Transport: st}
+
+	_, _, err := get(t, c, "https://a.example.com/foo")
+	var ne net.Error
+	if assert.True(t, errors.As(err, &ne)) {
+		assert.True(t, ne.Timeout())
+	}
+
+	code, body, err := get(t, c, "https://b.example.com/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 503, code)
+	assert.Equal(t, "down", body)
+
+	start := time.Now()
+	code, body, err = get(t, c, "https://a.example.com/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "ok", body)
+	assert.True(t, time.Since(start) >= 10*time.Millisecond)
+
+	_, _, err = get(t, c, "https://a.example.com/foo")
+	assert.True(t, errors.Is(err, ErrNoOutcome))
+
+	assert.Equal(t, []string{"a.example.com", "b.example.com", "a.example.com", "a.example.com"}, st.Hosts())
+	assert.Equal(t, 3, st.Attempts("a.example.com"))
+	assert.Equal(t, 1, st.Attempts("b.example.com"))
+	assert.Equal(t, 0, st.Remaining())
+}
+
+func TestScriptedTransportKeyed(t *testing.T) {
+	st := &ScriptedTransport{}
+	st.Enqueue(Respond(200, "any"))
+	st.EnqueueFor("b.example.com", Repeat(2, Respond(503, "b"))...)
+	st.EnqueueFor("/config/*", TransportError(errors.New("config error")))
+	st.SetDefault(Respond(404, "default"))
+	c := &http.Client{Transport: st}
+
+	for i := 0; i < 2; i++ {
+		code, body, err := get(t, c, "https://b.example.com/foo")
+		assert.NoError(t, err)
+		assert.Equal(t, 503, code)
+		assert.Equal(t, "b", body)
+	}
+	_, _, err := get(t, c, "https://a.example.com/config/123")
+	assert.EqualError(t, errors.Unwrap(err), "config error")
+
+	_, body, err := get(t, c, "https://b.example.com/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "any", body)
+
+	code, body, err := get(t, c, "https://b.example.com/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 404, code)
+	assert.Equal(t, "default", body)
+	assert.Equal(t, []string{"/foo", "/foo", "/config/123", "/foo", "/foo"}, st.Paths())
+}
+
+func TestScriptedTransportCancelled(t *testing.T) {
+	st := &ScriptedTransport{}
+	st.Enqueue(RespondAfter(time.Hour, 200, "ok"))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://a.example.com/foo", nil)
+	_, err := (&http.Client{Transport: st}).Do(req)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestScriptedTransportUnclosed(t *testing.T) {
+	st := &ScriptedTransport{}
+	st.Enqueue(Respond(200, "a"), Respond(200, "b"))
+	client := &http.Client{Transport: st}
+	r1, err := client.Get("https://a.example.com/")
+	assert.NoError(t, err)
+	r2, err := client.Get("https://a.example.com/")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, st.Unclosed())
+	r1.Body.Close()
+	r1.Body.Close()
+	assert.Equal(t, 1, st.Unclosed())
+	r2.Body.Close()
+	assert.Equal(t, 0, st.Unclosed())
+}
@@ -0,0 +1,49 @@
+//go:build appengine
+// +build appengine
+
+package taplink
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/urlfetch"
+)
+
+// isTimeout returns whether err is from a request which timed out. As well as
+// net.Error timeouts, this recognizes urlfetch deadline errors, which may be
+// wrapped in a *url.Error by the http.Client.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		if appengine.IsTimeoutError(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestClient returns the client to make requests with ctx on, and a func
+// to call once they're done. urlfetch takes its deadline from the context of
+// its transport rather than the request, so if ctx has a deadline, a client
+// whose transport context has the same deadline is returned.
+func requestClient(ctx context.Context, client *http.Client) (*http.Client, func()) {
+	t, ok := client.Transport.(*urlfetch.Transport)
+	deadline, hasDeadline := ctx.Deadline()
+	if !ok || !hasDeadline || t.Context == nil {
+		return client, func() {}
+	}
+	tctx, cancel := context.WithDeadline(t.Context, deadline)
+	c := *client
+	c.Transport = &urlfetch.Transport{
+		Context:                       tctx,
+		AllowInvalidServerCertificate: t.AllowInvalidServerCertificate,
+	}
+	return &c, cancel
+}
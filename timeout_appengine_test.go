@@ -0,0 +1,26 @@
+//go:build appengine
+// +build appengine
+
+package taplink
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testDeadlineError struct {
+	timeout bool
+}
+
+func (e testDeadlineError) Error() string   { return "API error 5 (urlfetch: DEADLINE_EXCEEDED)" }
+func (e testDeadlineError) IsTimeout() bool { return e.timeout }
+
+func TestIsTimeoutAppEngine(t *testing.T) {
+	assert.True(t, isTimeout(testDeadlineError{true}))
+	assert.True(t, isTimeout(&url.Error{Op: "Get", URL: "https://foo.com", Err: testDeadlineError{true}}))
+	assert.True(t, isTimeout(&url.Error{Op: "Get", URL: "https://foo.com", Err: context.DeadlineExceeded}))
+	assert.False(t, isTimeout(testDeadlineError{false}))
+}
@@ -0,0 +1,24 @@
+//go:build !appengine
+// +build !appengine
+
+package taplink
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// isTimeout returns whether err is from a request which timed out
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// requestClient returns the client to make requests with ctx on, and a func
+// to call once they're done. The request context already carries the
+// deadline, so it's the given client.
+func requestClient(ctx context.Context, client *http.Client) (*http.Client, func()) {
+	return client, func() {}
+}
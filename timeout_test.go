@@ -0,0 +1,35 @@
+package taplink
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testNetError struct {
+	timeout bool
+}
+
+func (e testNetError) Error() string   { return "test net error" }
+func (e testNetError) Timeout() bool   { return e.timeout }
+func (e testNetError) Temporary() bool { return false }
+
+func TestIsTimeout(t *testing.T) {
+	assert.True(t, isTimeout(testNetError{true}))
+	assert.True(t, isTimeout(&url.Error{Op: "Get", URL: "https://foo.com", Err: testNetError{true}}))
+	assert.True(t, isTimeout(&MultiAttemptError{Attempts: []Attempt{{Err: testNetError{true}}}}))
+	assert.False(t, isTimeout(testNetError{false}))
+	assert.False(t, isTimeout(errors.New("foobar")))
+	assert.False(t, isTimeout(nil))
+}
+
+func TestRequestClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, release := requestClient(ctx, HTTPClient)
+	defer release()
+	assert.NotNil(t, client)
+}
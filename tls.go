@@ -0,0 +1,203 @@
+package taplink
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrPinMismatch is the error of a request to a host whose certificate chain
+// has none of the pinned public keys, see SetPinnedSPKIHashes. The request
+// isn't retried on that host.
+var ErrPinMismatch = errors.New("certificate doesn't match a pinned public key")
+
+// MinTLSVersion returns the minimum TLS version of the client's connections,
+// or 0 for the transport's default
+func (c *Config) MinTLSVersion() uint16 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.minTLSVersion
+}
+
+// SetMinTLSVersion sets the minimum TLS version of the client's connections,
+// e.g. tls.VersionTLS12. Zero restores the transport's default.
+//
+// Like the other TLS settings, it's set on a copy of the transport of the
+// HTTP client, which must be an *http.Transport, so it doesn't affect other
+// clients. It applies to the pools of EnableAffinity too, but not to clients
+// from ClientFromContext.
+func (c *Config) SetMinTLSVersion(v uint16) error {
+	switch v {
+	case 0, tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13:
+	default:
+		return fmt.Errorf("unknown TLS version %#04x", v)
+	}
+	c.Lock()
+	defer c.Unlock()
+	prev := c.minTLSVersion
+	c.minTLSVersion = v
+	if err := c.applyTransport(); err != nil {
+		c.minTLSVersion = prev
+		return err
+	}
+	return nil
+}
+
+// RootCAs returns the certificates the client verifies hosts with, or nil
+// for the system's
+func (c *Config) RootCAs() *x509.CertPool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.rootCAs
+}
+
+// SetRootCAs sets the certificates the client verifies hosts with, e.g. the
+// CA of an on-prem deployment. A nil pool restores the system's. See
+// SetMinTLSVersion.
+func (c *Config) SetRootCAs(pool *x509.CertPool) error {
+	c.Lock()
+	defer c.Unlock()
+	prev := c.rootCAs
+	c.rootCAs = pool
+	if err := c.applyTransport(); err != nil {
+		c.rootCAs = prev
+		return err
+	}
+	return nil
+}
+
+// PinnedSPKIHashes returns the pinned public keys, see SetPinnedSPKIHashes
+func (c *Config) PinnedSPKIHashes() []string {
+	c.RLock()
+	defer c.RUnlock()
+	return append([]string(nil), c.pins...)
+}
+
+// SetPinnedSPKIHashes pins the public keys hosts' certificates may have.
+// Each pin is the base64 SHA-256 hash of a certificate's
+// SubjectPublicKeyInfo, optionally prefixed with "sha256/". A host is trusted
+// if any certificate in its verified chain matches any pin, so the pins for
+// both the old and new keys can be given while they're rotated.
+//
+// A request to a host which matches no pin fails with ErrPinMismatch, and is
+// retried only on other hosts. No pins disables pinning. See
+// SetMinTLSVersion.
+func (c *Config) SetPinnedSPKIHashes(pins []string) error {
+	for _, pin := range pins {
+		if _, err := decodePin(pin); err != nil {
+			return err
+		}
+	}
+	c.Lock()
+	defer c.Unlock()
+	prev := c.pins
+	c.pins = nil
+	if len(pins) > 0 {
+		c.pins = append([]string(nil), pins...)
+	}
+	if err := c.applyTransport(); err != nil {
+		c.pins = prev
+		return err
+	}
+	return nil
+}
+
+// decodePin returns the SHA-256 hash pin is the base64 encoding of
+func decodePin(pin string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+	if err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("invalid pin %q: want a base64 SHA-256 hash", pin)
+	}
+	return b, nil
+}
+
+// verifyPins returns a VerifyPeerCertificate func which accepts a chain with
+// a certificate whose public key hashes to one of pins
+func verifyPins(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	hashes := make([][]byte, len(pins))
+	for i, pin := range pins {
+		hashes[i], _ = decodePin(pin)
+	}
+	matches := func(cert *x509.Certificate) bool {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, h := range hashes {
+			if subtle.ConstantTimeCompare(sum[:], h) == 1 {
+				return true
+			}
+		}
+		return false
+	}
+	return func(raw [][]byte, chains [][]*x509.Certificate) error {
+		for _, chain := range chains {
+			for _, cert := range chain {
+				if matches(cert) {
+					return nil
+				}
+			}
+		}
+		// Without verification there are no chains, so only the peer's own
+		// certificates can be checked
+		if len(chains) == 0 {
+			for _, b := range raw {
+				if cert, err := x509.ParseCertificate(b); err == nil && matches(cert) {
+					return nil
+				}
+			}
+		}
+		return ErrPinMismatch
+	}
+}
+
+// applyTransport sets the proxy, TLS settings and transport options on a copy
+// of the transport of the given HTTP client, which requests are then made on.
+// Connections of the previous copy are closed once idle. The caller must hold
+// the lock, other than while the config is created.
+func (c *Config) applyTransport() error {
+	if c.proxy == nil && c.minTLSVersion == 0 && c.rootCAs == nil && c.pins == nil && c.transportOpts == nil {
+		if old := c.transport.Swap(nil); old != nil {
+			old.CloseIdleConnections()
+		}
+		return nil
+	}
+	base := c.givenHTTPClient()
+	rt := base.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("transport %T isn't an *http.Transport", rt)
+	}
+	t = t.Clone()
+	if c.transportOpts != nil {
+		c.transportOpts.apply(t)
+	}
+	if c.proxy != nil {
+		t.Proxy = c.proxy
+		t.OnProxyConnectResponse = onProxyConnectResponse
+	}
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	if c.minTLSVersion != 0 {
+		t.TLSClientConfig.MinVersion = c.minTLSVersion
+	}
+	if c.rootCAs != nil {
+		t.TLSClientConfig.RootCAs = c.rootCAs
+	}
+	if c.pins != nil {
+		t.TLSClientConfig.VerifyPeerCertificate = verifyPins(c.pins)
+	}
+	client := *base
+	client.Transport = t
+	if old := c.transport.Swap(&client); old != nil {
+		old.CloseIdleConnections()
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package taplink
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+// tlsServer returns a TLS server answering as a Fake, a pool trusting its
+// certificate and the pin of its public key
+func tlsServer(t *testing.T) (*httptest.Server, *x509.CertPool, string) {
+	srv := quietTLSServer(nil)
+	t.Cleanup(srv.Close)
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	sum := sha256.Sum256(srv.Certificate().RawSubjectPublicKeyInfo)
+	return srv, pool, "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// quietTLSServer starts a TLS server answering as a Fake with config, which
+// doesn't log the handshakes the tests make fail
+func quietTLSServer(config *tls.Config) *httptest.Server {
+	srv := httptest.NewUnstartedServer(taplinktest.NewFake(3))
+	srv.TLS = config
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	return srv
+}
+
+// tlsClient returns a client making requests to srv on a transport of its own
+func tlsClient(srv *httptest.Server) *Client {
+	return New(testAppID, WithServers([]string{srv.URL}), WithHTTPClient(&http.Client{Transport: &http.Transport{}}), WithStatsEnabled()).(*Client)
+}
+
+func TestPinnedSPKIHashes(t *testing.T) {
+	srv, pool, pin := tlsServer(t)
+	other := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	c := tlsClient(srv)
+	defer c.Close()
+	assert.NoError(t, c.Config().SetRootCAs(pool))
+	// Either pin matching is enough, e.g. while keys are rotated
+	assert.NoError(t, c.Config().SetPinnedSPKIHashes([]string{other, pin}))
+	assert.Equal(t, []string{other, pin}, c.Config().PinnedSPKIHashes())
+	_, err := c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+
+	// A mismatch isn't retried on the same host
+	assert.NoError(t, c.Config().SetPinnedSPKIHashes([]string{other}))
+	_, err = c.NewPassword(testHashBytes)
+	assert.ErrorIs(t, err, ErrPinMismatch)
+	assert.Equal(t, Errors{TransportErrorCode: 1}, c.Stats().Get(srv.URL).Errors())
+
+	// No pins disables pinning
+	assert.NoError(t, c.Config().SetPinnedSPKIHashes(nil))
+	_, err = c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+}
+
+func TestPinnedSPKIHashesAffinity(t *testing.T) {
+	srv, pool, pin := tlsServer(t)
+	other := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	c := tlsClient(srv)
+	defer c.Close()
+	c.EnableAffinity(4)
+	ctx := WithAffinityKey(context.Background(), "user1")
+	assert.NoError(t, c.Config().SetRootCAs(pool))
+	assert.NoError(t, c.Config().SetPinnedSPKIHashes([]string{pin}))
+	_, err := c.NewPasswordContext(ctx, testHashBytes)
+	assert.NoError(t, err)
+
+	// Settings changed once the pools are in use reach them too
+	assert.NoError(t, c.Config().SetPinnedSPKIHashes([]string{other}))
+	_, err = c.NewPasswordContext(ctx, testHashBytes)
+	assert.ErrorIs(t, err, ErrPinMismatch)
+}
+
+func TestPinMismatchRetriesOtherHost(t *testing.T) {
+	srv, pool, _ := tlsServer(t)
+	f := taplinktest.NewFake(3)
+	plain := httptest.NewServer(f)
+	defer plain.Close()
+
+	c := tlsClient(srv)
+	defer c.Close()
+	assert.NoError(t, c.Config().SetServers([]string{srv.URL, plain.URL}))
+	assert.NoError(t, c.Config().SetRootCAs(pool))
+	assert.NoError(t, c.Config().SetPinnedSPKIHashes([]string{base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))}))
+
+	// Each request tries the pinned host at most once
+	for i := 0; i < 3; i++ {
+		hash := sha512.Sum512([]byte{byte(i)})
+		before := c.Stats().Get(srv.URL).Errors().Len()
+		_, err := c.NewPassword(hash[:])
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, c.Stats().Get(srv.URL).Errors().Len()-before, 1)
+	}
+	assert.Equal(t, 3, f.Requests())
+}
+
+func TestRootCAs(t *testing.T) {
+	srv, pool, _ := tlsServer(t)
+
+	c := tlsClient(srv)
+	defer c.Close()
+	c.Config().SetRetryPolicy(1, 0)
+	_, err := c.NewPassword(testHashBytes)
+	assert.Error(t, err, "the test certificate isn't trusted by default")
+
+	assert.NoError(t, c.Config().SetRootCAs(pool))
+	assert.Equal(t, pool, c.Config().RootCAs())
+	_, err = c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+}
+
+func TestMinTLSVersion(t *testing.T) {
+	srv := quietTLSServer(&tls.Config{MaxVersion: tls.VersionTLS12})
+	defer srv.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	c := tlsClient(srv)
+	defer c.Close()
+	c.Config().SetRetryPolicy(1, 0)
+	assert.NoError(t, c.Config().SetRootCAs(pool))
+	assert.NoError(t, c.Config().SetMinTLSVersion(tls.VersionTLS12))
+	_, err := c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Config().SetMinTLSVersion(tls.VersionTLS13))
+	assert.Equal(t, uint16(tls.VersionTLS13), c.Config().MinTLSVersion())
+	_, err = c.NewPassword(testHashBytes)
+	assert.Error(t, err)
+}
+
+func TestTLSSettingsInvalid(t *testing.T) {
+	c := New(testAppID)
+	assert.Error(t, c.Config().SetMinTLSVersion(0x0200))
+	assert.Error(t, c.Config().SetPinnedSPKIHashes([]string{"sha256/not a hash"}))
+	assert.Error(t, c.Config().SetPinnedSPKIHashes([]string{base64.StdEncoding.EncodeToString([]byte("short"))}))
+	assert.Nil(t, c.Config().PinnedSPKIHashes())
+
+	// The settings can't be set on a transport which isn't an *http.Transport
+	c = New(testAppID, WithHTTPClient(&http.Client{Transport: taplinktest.NewFake(3)}))
+	assert.Error(t, c.Config().SetMinTLSVersion(tls.VersionTLS12))
+	assert.Equal(t, uint16(0), c.Config().MinTLSVersion())
+}
@@ -0,0 +1,172 @@
+package taplink
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// RequestTrace is the timing of each phase of a single attempt of a request,
+// as given to the OnRequestComplete func
+type RequestTrace struct {
+	Host    string
+	Attempt int
+	// StatusCode is the status of the response, or 0 if there wasn't one,
+	// and Err the error the attempt failed with, if any
+	StatusCode int
+	Err        error
+
+	// Start is when the attempt was made, and Total how long it took,
+	// including reading the body
+	Start time.Time
+	Total time.Duration
+
+	// DNS, Connect and TLS are how long the DNS lookup, the TCP connect and
+	// the TLS handshake took. They're 0 when a connection was reused.
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// TimeToFirstByte is from the request being written to the first byte of
+	// the response, i.e. how long the server took to answer
+	TimeToFirstByte time.Duration
+	// BodyRead is how long reading the response body took
+	BodyRead time.Duration
+
+	// Reused is whether the request was sent on a kept-alive connection
+	Reused bool
+}
+
+// Phases are the timings of the phases of the requests to a host. A phase
+// which didn't happen isn't included, e.g. there's no DNS lookup when a
+// connection is reused.
+type Phases struct {
+	DNS             Latency
+	Connect         Latency
+	TLS             Latency
+	TimeToFirstByte Latency
+	BodyRead        Latency
+	// Reused and New are the number of requests sent on a kept-alive
+	// connection and on a new one
+	Reused int
+	New    int
+}
+
+// phaseStats is implemented by the built-in stats, which keep the phase
+// timings of requests
+type phaseStats interface {
+	isEnabled() bool
+	addPhases(host string, t *RequestTrace)
+}
+
+// tracer collects a RequestTrace from the httptrace hooks, which may be
+// called from other goroutines, e.g. when dialing several addresses
+type tracer struct {
+	trace RequestTrace
+
+	dnsStart, connectStart, tlsStart, wrote time.Time
+
+	mu sync.Mutex
+}
+
+func newTracer(host string, attempt int) *tracer {
+	return &tracer{trace: RequestTrace{Host: host, Attempt: attempt, Start: time.Now()}}
+}
+
+// since sets *d to the time since start, if it's set
+func (t *tracer) since(d *time.Duration, start time.Time) {
+	t.mu.Lock()
+	if !start.IsZero() {
+		*d = time.Since(start)
+	}
+	t.mu.Unlock()
+}
+
+func (t *tracer) now(ts *time.Time) {
+	t.mu.Lock()
+	*ts = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.trace.Reused = info.Reused
+			t.mu.Unlock()
+		},
+		DNSStart:             func(httptrace.DNSStartInfo) { t.now(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.since(&t.trace.DNS, t.dnsStart) },
+		ConnectStart:         func(string, string) { t.now(&t.connectStart) },
+		ConnectDone:          func(string, string, error) { t.since(&t.trace.Connect, t.connectStart) },
+		TLSHandshakeStart:    func() { t.now(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.since(&t.trace.TLS, t.tlsStart) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.now(&t.wrote) },
+		GotFirstResponseByte: func() { t.since(&t.trace.TimeToFirstByte, t.wrote) },
+	}
+}
+
+// bodyRead records how long reading the body took. It's safe to call on a
+// nil tracer, which is what's used when nothing is traced.
+func (t *tracer) bodyRead(d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.trace.BodyRead = d
+	t.mu.Unlock()
+}
+
+// finish completes the trace with the outcome of the attempt, and returns it
+func (t *tracer) finish(o *outcome) RequestTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trace.StatusCode, t.trace.Err = o.status, o.err
+	t.trace.Total = time.Since(t.trace.Start)
+	return t.trace
+}
+
+// startTrace returns a tracer for an attempt to host, and ctx with its hooks,
+// if the stats or the OnRequestComplete func want the trace. Otherwise it
+// returns a nil tracer and ctx as it is, so nothing is traced.
+func (c *Client) startTrace(ctx context.Context, host string, attempt int) (*tracer, context.Context) {
+	ps, ok := c.Stats().(phaseStats)
+	if !(ok && ps.isEnabled()) && c.Config().RequestObserver() == nil {
+		return nil, ctx
+	}
+	t := newTracer(host, attempt)
+	return t, httptrace.WithClientTrace(ctx, t.clientTrace())
+}
+
+// finishTrace records the trace of an attempt in the stats and gives it to
+// the OnRequestComplete func
+func (c *Client) finishTrace(t *tracer, o *outcome) {
+	if t == nil {
+		return
+	}
+	trace := t.finish(o)
+	if ps, ok := c.Stats().(phaseStats); ok {
+		ps.addPhases(trace.Host, &trace)
+	}
+	if fn := c.Config().RequestObserver(); fn != nil {
+		fn(trace)
+	}
+}
+
+// OnRequestComplete sets a func which is called with the trace of each
+// attempt once it's complete, e.g. to log slow requests with where the time
+// went. It's called synchronously, so it should be quick. Attempts which are
+// cancelled by the caller aren't traced.
+func (c *Config) OnRequestComplete(fn func(RequestTrace)) {
+	c.Lock()
+	c.requestComplete = fn
+	c.Unlock()
+}
+
+// RequestObserver returns the func set by OnRequestComplete, if any
+func (c *Config) RequestObserver() func(RequestTrace) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.requestComplete
+}
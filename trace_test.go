@@ -0,0 +1,103 @@
+package taplink
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestTrace(t *testing.T) {
+	f := taplinktest.NewFake(3)
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	prevHost := DefaultHost
+	DefaultHost = srv.URL
+	defer func() { DefaultHost = prevHost }()
+	HTTPClient.Transport = &http.Transport{}
+	defer func() { HTTPClient.Transport = origTransport }()
+
+	c := New(testAppID).(*Client)
+	c.Stats().Enable()
+	var mu sync.Mutex
+	var traces []RequestTrace
+	c.Config().OnRequestComplete(func(rt RequestTrace) {
+		mu.Lock()
+		traces = append(traces, rt)
+		mu.Unlock()
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := c.GetSalt(testHashBytes, 0)
+		assert.NoError(t, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !assert.Len(t, traces, 2) {
+		return
+	}
+	first, second := traces[0], traces[1]
+	assert.Equal(t, srv.URL, first.Host)
+	assert.Equal(t, 1, first.Attempt)
+	assert.Equal(t, 200, first.StatusCode)
+	assert.Nil(t, first.Err)
+	assert.False(t, first.Start.IsZero())
+	assert.Greater(t, first.Connect, time.Duration(0))
+	assert.Greater(t, first.TimeToFirstByte, time.Duration(0))
+	assert.GreaterOrEqual(t, first.Total, first.Connect+first.TimeToFirstByte)
+	assert.False(t, first.Reused)
+
+	// The second request reuses the connection, so there's no connect
+	assert.True(t, second.Reused)
+	assert.Equal(t, time.Duration(0), second.Connect)
+
+	p := c.Stats().Get(srv.URL).Phases()
+	assert.Equal(t, 1, p.New)
+	assert.Equal(t, 1, p.Reused)
+	assert.Len(t, p.Connect, 1)
+	assert.Len(t, p.TimeToFirstByte, 2)
+	assert.Equal(t, p, c.Stats().Get(srv.URL).Last(time.Minute).Phases())
+}
+
+func TestRequestTraceFailure(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(503, "down"), taplinktest.Respond(401, "unauthorized"))
+	c := New(testAppID).(*Client)
+	var traces []RequestTrace
+	c.Config().OnRequestComplete(func(rt RequestTrace) { traces = append(traces, rt) })
+
+	_, err := c.getFromAPI(testAppID)
+	assert.Error(t, err)
+	if assert.Len(t, traces, 2) {
+		assert.Equal(t, 503, traces[0].StatusCode)
+		assert.Error(t, traces[0].Err)
+		assert.Equal(t, 2, traces[1].Attempt)
+		assert.Equal(t, 401, traces[1].StatusCode)
+	}
+	// Stats are disabled, so they don't keep the phases
+	assert.Equal(t, Phases{}, c.Stats().Get(DefaultHost).Phases())
+}
+
+func TestRequestTraceDisabled(t *testing.T) {
+	c := New(testAppID).(*Client)
+	ctx := context.Background()
+	tr, tctx := c.startTrace(ctx, DefaultHost, 1)
+	assert.Nil(t, tr)
+	assert.Equal(t, ctx, tctx)
+	assert.NotPanics(t, func() {
+		tr.bodyRead(time.Millisecond)
+		c.finishTrace(tr, &outcome{})
+	})
+
+	c.Stats().Enable()
+	tr, tctx = c.startTrace(ctx, DefaultHost, 1)
+	assert.NotNil(t, tr)
+	assert.NotEqual(t, ctx, tctx)
+}
@@ -0,0 +1,112 @@
+package taplink
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransportOptions tune the connections of the HTTP transport. Zero fields
+// leave the transport's own value.
+type TransportOptions struct {
+	// DialTimeout limits how long connecting takes, and KeepAlive is the
+	// interval of TCP keep-alive probes
+	DialTimeout time.Duration
+	KeepAlive   time.Duration
+	// MaxIdleConns and MaxIdleConnsPerHost limit the idle connections kept
+	// for reuse, in total and for each host. Parallel requests to a host
+	// beyond MaxIdleConnsPerHost open new connections rather than reusing
+	// ones.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the connections to each host, in use or not.
+	// Requests beyond it wait for a connection.
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept for
+	IdleConnTimeout time.Duration
+	// TLSHandshakeTimeout limits how long the TLS handshake takes
+	TLSHandshakeTimeout time.Duration
+}
+
+// DefaultTransportOptions are the options of the transport of HTTPClient.
+// They keep enough idle connections for many parallel requests to a host.
+var DefaultTransportOptions = TransportOptions{
+	DialTimeout:         DefaultTimeout,
+	KeepAlive:           DefaultKeepAlive,
+	MaxIdleConns:        128,
+	MaxIdleConnsPerHost: 64,
+	IdleConnTimeout:     90 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+// newTransport returns a transport with the options o, which uses the proxy
+// from the environment
+func newTransport(o TransportOptions) *http.Transport {
+	t := &http.Transport{
+		Proxy:                  http.ProxyFromEnvironment,
+		OnProxyConnectResponse: onProxyConnectResponse,
+		DialContext:            (&net.Dialer{}).DialContext,
+	}
+	o.apply(t)
+	return t
+}
+
+// apply sets the non-zero options on t
+func (o TransportOptions) apply(t *http.Transport) {
+	if o.DialTimeout > 0 || o.KeepAlive > 0 {
+		t.DialContext = (&net.Dialer{Timeout: o.DialTimeout, KeepAlive: o.KeepAlive}).DialContext
+	}
+	if o.MaxIdleConns > 0 {
+		t.MaxIdleConns = o.MaxIdleConns
+	}
+	if o.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	}
+	if o.MaxConnsPerHost > 0 {
+		t.MaxConnsPerHost = o.MaxConnsPerHost
+	}
+	if o.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = o.IdleConnTimeout
+	}
+	if o.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = o.TLSHandshakeTimeout
+	}
+}
+
+// WithTransportOptions tunes the connections of the client's transport, see
+// Config.SetTransportOptions
+func WithTransportOptions(o TransportOptions) Option {
+	return func(c *Config) {
+		c.transportOpts = &o
+	}
+}
+
+// TransportOptions returns the options set with SetTransportOptions
+func (c *Config) TransportOptions() TransportOptions {
+	c.RLock()
+	defer c.RUnlock()
+	if c.transportOpts == nil {
+		return TransportOptions{}
+	}
+	return *c.transportOpts
+}
+
+// SetTransportOptions tunes the connections of the client's transport, e.g.
+// to keep more idle connections for parallel requests, without replacing the
+// HTTP client. Like the TLS settings, the options are set on a copy of the
+// transport, which must be an *http.Transport, see SetMinTLSVersion. Zero
+// options restore the transport's own.
+func (c *Config) SetTransportOptions(o TransportOptions) error {
+	c.Lock()
+	defer c.Unlock()
+	prev := c.transportOpts
+	c.transportOpts = nil
+	if o != (TransportOptions{}) {
+		c.transportOpts = &o
+	}
+	if err := c.applyTransport(); err != nil {
+		c.transportOpts = prev
+		return err
+	}
+	return nil
+}
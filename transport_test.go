@@ -0,0 +1,55 @@
+package taplink
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultTransport(t *testing.T) {
+	tr, ok := origTransport.(*http.Transport)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.NotNil(t, tr.DialContext)
+	assert.Nil(t, tr.Dial)
+	assert.NotNil(t, tr.Proxy)
+	assert.Equal(t, DefaultTransportOptions.MaxIdleConnsPerHost, tr.MaxIdleConnsPerHost)
+	assert.GreaterOrEqual(t, tr.MaxIdleConnsPerHost, 32)
+	assert.Equal(t, DefaultTransportOptions.IdleConnTimeout, tr.IdleConnTimeout)
+	assert.Equal(t, DefaultTransportOptions.TLSHandshakeTimeout, tr.TLSHandshakeTimeout)
+}
+
+func TestTransportOptions(t *testing.T) {
+	c := New(testAppID, WithTransportOptions(TransportOptions{MaxIdleConnsPerHost: 8, MaxConnsPerHost: 16})).(*Client)
+	defer c.Close()
+	assert.Equal(t, TransportOptions{MaxIdleConnsPerHost: 8, MaxConnsPerHost: 16}, c.Config().TransportOptions())
+	tr := c.baseHTTPClient().Transport.(*http.Transport)
+	assert.Equal(t, 8, tr.MaxIdleConnsPerHost)
+	assert.Equal(t, 16, tr.MaxConnsPerHost)
+	// Options which aren't set are the transport's own
+	assert.Equal(t, DefaultTransportOptions.IdleConnTimeout, tr.IdleConnTimeout)
+	// The HTTPClient isn't changed
+	assert.Equal(t, DefaultTransportOptions.MaxIdleConnsPerHost, origTransport.(*http.Transport).MaxIdleConnsPerHost)
+
+	assert.NoError(t, c.Config().SetTransportOptions(TransportOptions{IdleConnTimeout: time.Second}))
+	tr = c.baseHTTPClient().Transport.(*http.Transport)
+	assert.Equal(t, DefaultTransportOptions.MaxIdleConnsPerHost, tr.MaxIdleConnsPerHost)
+	assert.Equal(t, time.Second, tr.IdleConnTimeout)
+
+	// No options make requests on the HTTPClient again
+	assert.NoError(t, c.Config().SetTransportOptions(TransportOptions{}))
+	assert.Equal(t, HTTPClient, c.baseHTTPClient())
+}
+
+func TestTransportOptionsInvalid(t *testing.T) {
+	_, err := NewWithError(testAppID, WithHTTPClient(&http.Client{Transport: taplinktest.NewFake(3)}), WithTransportOptions(TransportOptions{MaxIdleConnsPerHost: 8}))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+
+	c := New(testAppID, WithHTTPClient(&http.Client{Transport: taplinktest.NewFake(3)}))
+	assert.Error(t, c.Config().SetTransportOptions(TransportOptions{MaxIdleConnsPerHost: 8}))
+	assert.Equal(t, TransportOptions{}, c.Config().TransportOptions())
+}
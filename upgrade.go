@@ -0,0 +1,30 @@
+package taplink
+
+import "context"
+
+// UpgradeStore stores the upgraded hash and version of a password. Both must
+// be stored together, or neither.
+type UpgradeStore func(newHash []byte, newVersionID int64) error
+
+// VerifyPasswordWithUpgrade is like VerifyPassword, but when the password
+// matched and a newer data pool version is available, it calls store with the
+// new hash and version. Upgraded is set if store succeeds, and StoreErr if it
+// doesn't. A failed store doesn't change Matched, or the error returned.
+func (c *Client) VerifyPasswordWithUpgrade(hash []byte, expected []byte, versionID int64, store UpgradeStore) (*VerifyPassword, error) {
+	return c.VerifyPasswordWithUpgradeContext(context.Background(), hash, expected, versionID, store)
+}
+
+// VerifyPasswordWithUpgradeContext is like VerifyPasswordWithUpgrade, but
+// requests to the API are made with ctx.
+func (c *Client) VerifyPasswordWithUpgradeContext(ctx context.Context, hash []byte, expected []byte, versionID int64, store UpgradeStore) (*VerifyPassword, error) {
+	vp, err := c.VerifyPasswordContext(ctx, hash, expected, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if vp.Matched && vp.NewHash != nil && vp.NewVersionID != vp.VersionID && store != nil {
+		if vp.StoreErr = store(vp.NewHash, vp.NewVersionID); vp.StoreErr == nil {
+			vp.Upgraded = true
+		}
+	}
+	return vp, nil
+}
@@ -0,0 +1,97 @@
+package taplink
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	upgradeOldSalt = bytes.Repeat([]byte("old salt"), SaltSize/8)
+	upgradeNewSalt = bytes.Repeat([]byte("new salt"), SaltSize/8)
+)
+
+func upgradeHash(salt []byte) []byte {
+	sum := hmac.New(sha512.New, salt)
+	sum.Write(testHashBytes)
+	return sum.Sum(nil)
+}
+
+// upgradeScript responds to one salt request with an upgrade from version 2
+// to version 3
+func upgradeScript(st *taplinktest.ScriptedTransport) {
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"s2":"`+hex.EncodeToString(upgradeOldSalt)+`","vid":2,"new_s2":"`+hex.EncodeToString(upgradeNewSalt)+`","new_vid":3}`))
+}
+
+func TestVerifyPasswordWithUpgrade(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	upgradeScript(st)
+	c := New(testAppID).(*Client)
+
+	var stored []byte
+	var storedVersion int64
+	v, err := c.VerifyPasswordWithUpgrade(testHashBytes, upgradeHash(upgradeOldSalt), 2, func(newHash []byte, newVersionID int64) error {
+		stored, storedVersion = newHash, newVersionID
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, v.Matched)
+	assert.True(t, v.Upgraded)
+	assert.NoError(t, v.StoreErr)
+	assert.Equal(t, upgradeHash(upgradeNewSalt), stored)
+	assert.Equal(t, int64(3), storedVersion)
+}
+
+func TestVerifyPasswordWithUpgradeStoreError(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	upgradeScript(st)
+	c := New(testAppID).(*Client)
+
+	storeErr := errors.New("store failed")
+	v, err := c.VerifyPasswordWithUpgrade(testHashBytes, upgradeHash(upgradeOldSalt), 2, func([]byte, int64) error {
+		return storeErr
+	})
+	assert.NoError(t, err)
+	assert.True(t, v.Matched)
+	assert.False(t, v.Upgraded)
+	assert.Equal(t, storeErr, v.StoreErr)
+}
+
+func TestVerifyPasswordWithUpgradeNotCalled(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	store := func([]byte, int64) error {
+		t.Error("store shouldn't be called")
+		return nil
+	}
+	c := New(testAppID).(*Client)
+
+	// No upgrade without a match.
+	upgradeScript(st)
+	v, err := c.VerifyPasswordWithUpgrade(testHashBytes, []byte("foobar"), 2, store)
+	assert.NoError(t, err)
+	assert.False(t, v.Matched)
+	assert.False(t, v.Upgraded)
+
+	// Nor when the version is already the latest.
+	st.EnqueueFor(DefaultHost, taplinktest.Respond(200, `{"s2":"`+hex.EncodeToString(upgradeNewSalt)+`","vid":3}`))
+	v, err = c.VerifyPasswordWithUpgrade(testHashBytes, upgradeHash(upgradeNewSalt), 3, store)
+	assert.NoError(t, err)
+	assert.True(t, v.Matched)
+	assert.False(t, v.Upgraded)
+
+	// Nor when verifying fails.
+	c.Config().SetBackoff(ConstantBackoff(0))
+	st.EnqueueFor(DefaultHost, taplinktest.Repeat(RetryLimit, taplinktest.Respond(503, "unavailable"))...)
+	v, err = c.VerifyPasswordWithUpgrade(testHashBytes, upgradeHash(upgradeOldSalt), 2, store)
+	assert.Error(t, err)
+	assert.Nil(t, v)
+}
@@ -0,0 +1,79 @@
+package taplink
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// apiURL returns the URL of the API path made up of segments on host. Each
+// segment is escaped, so it can't change the structure of the URL. The host
+// may start with a scheme, see splitHost.
+func apiURL(host string, segments ...string) string {
+	scheme, host := splitHost(host)
+	escaped := make([]string, len(segments))
+	for i, s := range segments {
+		escaped[i] = url.PathEscape(s)
+	}
+	u := url.URL{
+		Scheme:  scheme,
+		Host:    host,
+		Path:    "/" + strings.Join(segments, "/"),
+		RawPath: "/" + strings.Join(escaped, "/"),
+	}
+	return u.String()
+}
+
+// splitHost splits host into its scheme and the rest, for hosts such as
+// "http://localhost:8443" which give one. Bare hosts use https.
+func splitHost(host string) (scheme, rest string) {
+	if i := strings.Index(host, "://"); i >= 0 {
+		return strings.ToLower(host[:i]), host[i+len("://"):]
+	}
+	return "https", host
+}
+
+// validHost returns whether host is a DNS name, optionally preceded by an
+// http or https scheme and followed by a port, and nothing else: no
+// userinfo, path, query or fragment.
+func validHost(host string) bool {
+	scheme, host := splitHost(host)
+	if scheme != "https" && scheme != "http" {
+		return false
+	}
+	name := host
+	if i := strings.LastIndexByte(host, ':'); i >= 0 {
+		name = host[:i]
+		port, err := strconv.ParseUint(host[i+1:], 10, 16)
+		if err != nil || port == 0 {
+			return false
+		}
+	}
+	if len(name) == 0 || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validRemoteHost returns whether host, from a configuration loaded from the
+// API or an SRV record, is a valid host whose scheme is no weaker than that
+// of defaultHost, which it was loaded through. Only servers given locally can
+// move requests, whose paths hold the hash, from https to plain http.
+func validRemoteHost(host, defaultHost string) bool {
+	if !validHost(host) {
+		return false
+	}
+	scheme, _ := splitHost(host)
+	base, _ := splitHost(defaultHost)
+	return scheme == "https" || base == "http"
+}
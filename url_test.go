@@ -0,0 +1,110 @@
+package taplink
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIURL(t *testing.T) {
+	assert.Equal(t, "https://foo.com/app/abc/", apiURL("foo.com", "app", "abc", ""))
+	assert.Equal(t, "https://foo.com:8443/app", apiURL("foo.com:8443", "app"))
+	assert.Equal(t, "http://localhost:8443/app", apiURL("http://localhost:8443", "app"))
+	assert.Equal(t, "https://taplink.internal:8443/app", apiURL("HTTPS://taplink.internal:8443", "app"))
+
+	// Segments can't add path segments, a query or a fragment.
+	s := apiURL("foo.com", "a/b?c#d@e", "f")
+	assert.Equal(t, "https://foo.com/a%2Fb%3Fc%23d@e/f", s)
+	u, err := url.Parse(s)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "foo.com", u.Host)
+		assert.Equal(t, "", u.RawQuery)
+		assert.Equal(t, "", u.Fragment)
+	}
+}
+
+func TestValidHost(t *testing.T) {
+	for _, host := range []string{
+		"api.taplink.co",
+		"API-2.taplink.co",
+		"localhost",
+		"127.0.0.1",
+		"foo.com:443",
+		"https://api.taplink.co",
+		"http://localhost:8443",
+		"https://taplink.internal:8443",
+	} {
+		assert.True(t, validHost(host), host)
+	}
+	for _, host := range []string{
+		"",
+		"evil.com/#@api.taplink.co",
+		"evil.com?@api.taplink.co",
+		"user@api.taplink.co",
+		"ftp://api.taplink.co",
+		"://api.taplink.co",
+		"https://",
+		"https://api.taplink.co/",
+		"https://user@api.taplink.co",
+		"api.taplink.co/path",
+		"api.taplink.co:",
+		"api.taplink.co:0",
+		"api.taplink.co:65536",
+		"api.taplink.co:+443",
+		"api..taplink.co",
+		"-api.taplink.co",
+		"api taplink.co",
+		"[::1]:443",
+	} {
+		assert.False(t, validHost(host), host)
+	}
+}
+
+// TestSchemeHosts runs requests against a local plain HTTP server, which is
+// reached through a DefaultHost and servers with a scheme and port rather
+// than by replacing the transport.
+func TestSchemeHosts(t *testing.T) {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) == 1 && parts[0] == testAppID {
+			w.Write([]byte(`{"servers":["` + srvURL + `"]}`))
+			return
+		}
+		if len(parts) == 2 && parts[1] == hex.EncodeToString(testHashBytes) {
+			w.Write([]byte(`{"s2":"` + testHashExpectedSalt + `","vid":3}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+	assert.True(t, strings.HasPrefix(srvURL, "http://"))
+
+	prevHost := DefaultHost
+	DefaultHost = srv.URL
+	defer func() { DefaultHost = prevHost }()
+
+	c := New(testAppID).(*Client)
+	c.Stats().Enable()
+	s, err := c.GetSalt(testHashBytes, 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, testHashExpectedSaltBytes, s.Salt)
+	}
+
+	assert.NoError(t, c.Config().Load())
+	assert.Equal(t, []string{srv.URL}, c.Config().Servers())
+	assert.Equal(t, srv.URL, c.Config().Host(0))
+	s, err = c.GetSalt(testHashBytes, 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(3), s.VersionID)
+	}
+	// The load is recorded too
+	assert.Equal(t, 3, c.Stats().Get(srv.URL).Requests())
+}
@@ -0,0 +1,93 @@
+package taplink
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	// HashSize is the length of the hashes sent to the API, e.g. a SHA-512 sum
+	HashSize = 64
+	// SaltSize is the length of the salts returned by the API
+	SaltSize = 64
+)
+
+var (
+	// ErrInvalidAppID is returned, without making a request, for an AppID
+	// which isn't a 128-character hex string
+	ErrInvalidAppID = errors.New("invalid app ID")
+	// ErrInvalidVersion is returned, without making a request, for a
+	// negative version ID, and matched by the errors of ParseVersion and
+	// SetMinimumVersion
+	ErrInvalidVersion = errors.New("invalid version")
+	// ErrMalformedSalt is matched by the error returned when the API responds
+	// with a salt which isn't SaltSize bytes of hex or base64, or with
+	// missing or invalid versions. It matches ErrMalformedResponse too.
+	ErrMalformedSalt error = &malformedError{"malformed salt response"}
+)
+
+// validAppID reports whether appID is a 64-byte AppID hex encoded
+func validAppID(appID string) bool {
+	if len(appID) != HashSize*2 {
+		return false
+	}
+	_, err := hex.DecodeString(appID)
+	return err == nil
+}
+
+// checkMinimumVersion checks a version given to SetMinimumVersion or
+// WithMinimumVersion
+func checkMinimumVersion(v int64) error {
+	if v < 0 {
+		return fmt.Errorf("%w: negative minimum version %d", ErrInvalidVersion, v)
+	}
+	return nil
+}
+
+// validateRequest checks a request the same way the API would, so requests
+// which would be rejected anyway aren't made
+func validateRequest(appID string, hash []byte, versionID int64) error {
+	if len(hash) != HashSize {
+		return ErrInvalidHash
+	}
+	if !validAppID(appID) {
+		return ErrInvalidAppID
+	}
+	if versionID < 0 {
+		return ErrInvalidVersion
+	}
+	return nil
+}
+
+// decodeSaltResponse checks a salt response and decodes its salts with enc.
+// Errors match ErrMalformedSalt, and describe what's wrong with the response.
+func decodeSaltResponse(sr *saltResponse, enc SaltEncoding) (salt, newSalt []byte, err error) {
+	if sr.VersionID <= 0 {
+		return nil, nil, fmt.Errorf("%w: vid %d isn't positive", ErrMalformedSalt, sr.VersionID)
+	}
+	if sr.NewVersionID != 0 && sr.NewVersionID < sr.VersionID {
+		return nil, nil, fmt.Errorf("%w: new_vid %d is older than vid %d", ErrMalformedSalt, sr.NewVersionID, sr.VersionID)
+	}
+	if salt, err = decodeSalt("s2", sr.Salt2Hex, enc); err != nil {
+		return nil, nil, err
+	}
+	if sr.NewSalt2Hex != "" {
+		if newSalt, err = decodeSalt("new_s2", sr.NewSalt2Hex, enc); err != nil {
+			return nil, nil, err
+		}
+	}
+	return salt, newSalt, nil
+}
+
+// decodeSalt decodes the salt in the named field, encoded with enc
+func decodeSalt(field, s string, enc SaltEncoding) ([]byte, error) {
+	salt, err := enc.decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrMalformedSalt, field, err)
+	}
+	if len(salt) != SaltSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes, not %d", ErrMalformedSalt, field, len(salt), SaltSize)
+	}
+	return salt, nil
+}
@@ -0,0 +1,115 @@
+package taplink
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		appID     string
+		hash      []byte
+		versionID int64
+		err       error
+	}{
+		{"valid", testAppID, testHashBytes, 0, nil},
+		{"valid version", testAppID, testHashBytes, 3, nil},
+		{"nil hash", testAppID, nil, 0, ErrInvalidHash},
+		{"short hash", testAppID, testHashBytes[:63], 0, ErrInvalidHash},
+		{"long hash", testAppID, append(testHashBytes[:64:64], 0), 0, ErrInvalidHash},
+		{"empty app ID", "", testHashBytes, 0, ErrInvalidAppID},
+		{"short app ID", testAppID[:126], testHashBytes, 0, ErrInvalidAppID},
+		{"non-hex app ID", strings.Repeat("z", 128), testHashBytes, 0, ErrInvalidAppID},
+		{"negative version", testAppID, testHashBytes, -1, ErrInvalidVersion},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.err, validateRequest(tt.appID, tt.hash, tt.versionID), tt.name)
+	}
+}
+
+func TestInvalidRequestsNotSent(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+
+	c := New("foobar").(*Client)
+	_, err := c.GetSalt(testHashBytes, 0)
+	assert.Equal(t, ErrInvalidAppID, err)
+	_, err = c.NewPassword(testHashBytes)
+	assert.Equal(t, ErrInvalidAppID, err)
+	_, err = c.VerifyPassword(testHashBytes, testHashBytes, 0)
+	assert.Equal(t, ErrInvalidAppID, err)
+
+	c = New(testAppID).(*Client)
+	_, err = c.GetSalt(testHashBytes, -1)
+	assert.Equal(t, ErrInvalidVersion, err)
+	_, err = c.VerifyPassword(testHashBytes, testHashBytes, -1)
+	assert.Equal(t, ErrInvalidVersion, err)
+	_, err = c.VerifyPassword(testHashBytes[:32], testHashBytes, 0)
+	assert.Equal(t, ErrInvalidHash, err)
+
+	assert.Equal(t, 0, st.Attempts(DefaultHost))
+}
+
+func TestInvalidRequestSkipsFallback(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	c := New(testAppID).(*Client)
+	fb := &testFallbackVerifier{}
+	c.SetFallback(fb, 10, nil)
+	_, err := c.VerifyPassword(nil, testHashBytes, 0)
+	assert.Equal(t, ErrInvalidHash, err)
+	assert.Equal(t, 0, fb.calls)
+	assert.Equal(t, 0, st.Attempts(DefaultHost))
+}
+
+func TestNewStrict(t *testing.T) {
+	a, err := NewStrict(testAppID)
+	assert.NoError(t, err)
+	assert.Equal(t, testAppID, a.Config().AppID())
+
+	a, err = NewStrict("foobar")
+	assert.Equal(t, ErrInvalidAppID, err)
+	assert.Nil(t, a)
+}
+
+func TestMalformedSaltResponses(t *testing.T) {
+	short := testHashExpectedSalt[:64]
+	tests := []struct {
+		name, body, msg string
+	}{
+		{"truncated s2", `{"s2":"` + testHashExpectedSalt[:127] + `","vid":3}`, "s2: encoding/hex: odd length hex string"},
+		{"short s2", `{"s2":"` + short + `","vid":3}`, "s2 is 32 bytes, not 64"},
+		{"empty s2", `{"vid":3}`, "s2 is 0 bytes, not 64"},
+		{"short new_s2", `{"s2":"` + testHashExpectedSalt + `","vid":2,"new_s2":"` + short + `","new_vid":3}`, "new_s2 is 32 bytes, not 64"},
+		{"invalid new_s2", `{"s2":"` + testHashExpectedSalt + `","vid":2,"new_s2":"zz","new_vid":3}`, "new_s2: illegal base64 data at input byte 0"},
+		{"missing vid", `{"s2":"` + testHashExpectedSalt + `"}`, "vid 0 isn't positive"},
+		{"negative vid", `{"s2":"` + testHashExpectedSalt + `","vid":-1}`, "vid -1 isn't positive"},
+		{"older new_vid", `{"s2":"` + testHashExpectedSalt + `","vid":3,"new_s2":"` + testHashExpectedSalt + `","new_vid":2}`, "new_vid 2 is older than vid 3"},
+	}
+	for _, tt := range tests {
+		st, restore := useScript()
+		st.Enqueue(taplinktest.Respond(200, tt.body))
+		c := New(testAppID).(*Client)
+		s, err := c.GetSalt(testHashBytes, 0)
+		assert.Nil(t, s, tt.name)
+		assert.ErrorIs(t, err, ErrMalformedSalt, tt.name)
+		assert.EqualError(t, err, "malformed salt response: "+tt.msg, tt.name)
+		restore()
+	}
+
+	// A new version equal to the requested one is accepted, as is no new version.
+	for _, body := range []string{
+		`{"s2":"` + testHashExpectedSalt + `","vid":3,"new_s2":"` + testHashExpectedSalt + `","new_vid":3}`,
+		`{"s2":"` + testHashExpectedSalt + `","vid":3,"new_vid":0}`,
+	} {
+		st, restore := useScript()
+		st.Enqueue(taplinktest.Respond(200, body))
+		_, err := New(testAppID).GetSalt(testHashBytes, 0)
+		assert.NoError(t, err, body)
+		restore()
+	}
+}
@@ -0,0 +1,133 @@
+package taplink
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// MaxVerifyMemoTTL is the longest time a VerifyPassword result can be memoized
+const MaxVerifyMemoTTL = 5 * time.Second
+
+type memoEntry struct {
+	vp      VerifyPassword
+	expires time.Time
+}
+
+// verifyMemo is a short lived, memory only cache of VerifyPassword results.
+// Keys are a SHA-256 of the inputs, so the password hash isn't held by the
+// memo, but the hashes of the results are, until they're wiped.
+type verifyMemo struct {
+	ttl        time.Duration
+	maxEntries int
+	entries    map[[sha256.Size]byte]memoEntry
+
+	mu sync.Mutex
+}
+
+func newVerifyMemo(ttl time.Duration, maxEntries int) *verifyMemo {
+	if ttl > MaxVerifyMemoTTL {
+		ttl = MaxVerifyMemoTTL
+	}
+	return &verifyMemo{ttl: ttl, maxEntries: maxEntries, entries: make(map[[sha256.Size]byte]memoEntry)}
+}
+
+func verifyMemoKey(hash []byte, expected []byte, versionID int64) [sha256.Size]byte {
+	var b [8]byte
+	sum := sha256.New()
+	binary.BigEndian.PutUint64(b[:], uint64(len(hash)))
+	sum.Write(b[:])
+	sum.Write(hash)
+	binary.BigEndian.PutUint64(b[:], uint64(len(expected)))
+	sum.Write(b[:])
+	sum.Write(expected)
+	binary.BigEndian.PutUint64(b[:], uint64(versionID))
+	sum.Write(b[:])
+	var key [sha256.Size]byte
+	copy(key[:], sum.Sum(nil))
+	return key
+}
+
+// get returns a copy of the memoized result for key, if there is one
+func (m *verifyMemo) get(key [sha256.Size]byte) (*VerifyPassword, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		m.remove(key)
+		return nil, false
+	}
+	return copyVerifyPassword(&e.vp), true
+}
+
+// set stores a copy of vp for key, evicting expired entries, and then the
+// oldest entry, if the memo is full
+func (m *verifyMemo) set(key [sha256.Size]byte, vp *VerifyPassword) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.maxEntries <= 0 {
+		return
+	}
+	now := time.Now()
+	if _, ok := m.entries[key]; ok {
+		m.remove(key)
+	} else if len(m.entries) >= m.maxEntries {
+		var oldest [sha256.Size]byte
+		var oldestExpires time.Time
+		for k, e := range m.entries {
+			if now.After(e.expires) {
+				m.remove(k)
+				continue
+			}
+			if oldestExpires.IsZero() || e.expires.Before(oldestExpires) {
+				oldest, oldestExpires = k, e.expires
+			}
+		}
+		if len(m.entries) >= m.maxEntries {
+			m.remove(oldest)
+		}
+	}
+	m.entries[key] = memoEntry{vp: *copyVerifyPassword(vp), expires: now.Add(m.ttl)}
+}
+
+// len returns the number of entries in the memo, including expired ones
+func (m *verifyMemo) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// remove overwrites the hashes of the entry for key with zeros and removes
+// it. m.mu must be held.
+func (m *verifyMemo) remove(key [sha256.Size]byte) {
+	e := m.entries[key]
+	e.vp.Wipe()
+	delete(m.entries, key)
+}
+
+// wipe overwrites the hashes of all entries with zeros and removes them
+func (m *verifyMemo) wipe() {
+	m.mu.Lock()
+	for key := range m.entries {
+		m.remove(key)
+	}
+	m.mu.Unlock()
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func copyVerifyPassword(vp *VerifyPassword) *VerifyPassword {
+	cp := *vp
+	cp.Hash = copyBytes(vp.Hash)
+	cp.NewHash = copyBytes(vp.NewHash)
+	return &cp
+}
@@ -0,0 +1,159 @@
+package taplink
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyMemo(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID).(*Client)
+	c.EnableVerifyMemo(time.Second, 10)
+
+	// Mismatches are memoized, as they're the common retry case.
+	for i := 0; i < 3; i++ {
+		v, err := c.VerifyPassword(testHashBytes, []byte("foobar"), 0)
+		assert.NoError(t, err)
+		assert.False(t, v.Matched)
+	}
+	assert.Equal(t, 1, st.Attempts(DefaultHost))
+
+	// Different inputs are a different entry.
+	v, err := c.VerifyPassword(testHashBytes, hexString(testPasswordSumHashStr).Bytes(), 0)
+	assert.NoError(t, err)
+	assert.True(t, v.Matched)
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+
+	// Results are copies, so changing them doesn't change the memo.
+	v.Hash[0]++
+	v, err = c.VerifyPassword(testHashBytes, hexString(testPasswordSumHashStr).Bytes(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, testPasswordSumHashStr, v.String())
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+
+	c.DisableVerifyMemo()
+	_, err = c.VerifyPassword(testHashBytes, []byte("foobar"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, st.Attempts(DefaultHost))
+}
+
+func TestVerifyMemoErrorsNotMemoized(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(401, http.StatusText(401)))
+	c := New(testAppID).(*Client)
+	c.EnableVerifyMemo(time.Second, 10)
+	for i := 0; i < 2; i++ {
+		_, err := c.VerifyPassword(testHashBytes, []byte("foobar"), 0)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+}
+
+func TestVerifyMemoTTL(t *testing.T) {
+	m := newVerifyMemo(time.Hour, 10)
+	assert.Equal(t, MaxVerifyMemoTTL, m.ttl)
+
+	m = newVerifyMemo(10*time.Millisecond, 10)
+	key := verifyMemoKey(testHashBytes, nil, 0)
+	m.set(key, &VerifyPassword{Matched: true})
+	_, ok := m.get(key)
+	assert.True(t, ok)
+	time.Sleep(20 * time.Millisecond)
+	_, ok = m.get(key)
+	assert.False(t, ok)
+	assert.Equal(t, 0, m.len())
+}
+
+func TestVerifyMemoMaxEntries(t *testing.T) {
+	m := newVerifyMemo(time.Second, 2)
+	k1 := verifyMemoKey(testHashBytes, nil, 1)
+	k2 := verifyMemoKey(testHashBytes, nil, 2)
+	k3 := verifyMemoKey(testHashBytes, nil, 3)
+	m.set(k1, &VerifyPassword{VersionID: 1})
+	m.set(k2, &VerifyPassword{VersionID: 2})
+	m.set(k3, &VerifyPassword{VersionID: 3})
+	assert.Equal(t, 2, m.len())
+	_, ok := m.get(k1)
+	assert.False(t, ok)
+	v, ok := m.get(k3)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), v.VersionID)
+
+	m.wipe()
+	assert.Equal(t, 0, m.len())
+}
+
+func TestVerifyMemoKey(t *testing.T) {
+	assert.NotEqual(t, verifyMemoKey([]byte("ab"), []byte("c"), 0), verifyMemoKey([]byte("a"), []byte("bc"), 0))
+	assert.NotEqual(t, verifyMemoKey(testHashBytes, nil, 1), verifyMemoKey(testHashBytes, nil, 2))
+	assert.Equal(t, verifyMemoKey(testHashBytes, nil, 2), verifyMemoKey(testHashBytes, nil, 2))
+}
+
+func TestVerifyMemoMinimumVersion(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":2}`))
+	c := New(testAppID).(*Client)
+	c.EnableVerifyMemo(time.Second, 10)
+
+	_, err := c.VerifyPassword(testHashBytes, []byte("foobar"), 2)
+	assert.NoError(t, err)
+	_, err = c.VerifyPassword(testHashBytes, []byte("foobar"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+
+	// Raising the floor applies to memoized results too, both for the requested
+	// version and for the version of a memoized "latest" result.
+	c.SetMinimumVersion(3)
+	_, err = c.VerifyPassword(testHashBytes, []byte("foobar"), 2)
+	assert.Equal(t, ErrVersionBelowMinimum, err)
+	_, err = c.VerifyPassword(testHashBytes, []byte("foobar"), 0)
+	assert.Equal(t, ErrVersionBelowMinimum, err)
+	assert.Equal(t, 2, st.Attempts(DefaultHost))
+}
+
+func TestVerifyMemoWipedOnClose(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID, WithVerifyMemo(time.Second, 10)).(*Client)
+	expected := hexString(testPasswordSumHashStr).Bytes()
+	v, err := c.VerifyPassword(testHashBytes, expected, 0)
+	assert.NoError(t, err)
+	assert.True(t, v.Matched)
+
+	// The memoized hash is the expected one, and is zeroed on Close
+	memo := c.memo
+	stored := memo.entries[verifyMemoKey(testHashBytes, expected, 0)].vp.Hash
+	assert.Equal(t, expected, stored)
+	assert.NoError(t, c.Close())
+	assert.Equal(t, make([]byte, len(expected)), stored)
+	assert.Equal(t, 0, memo.len())
+	assert.Nil(t, c.memo)
+	assert.Equal(t, testPasswordSumHashStr, v.String())
+}
+
+func TestVerifyMemoWipedOnReenable(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.SetDefault(taplinktest.Respond(200, `{"s2":"`+testHashExpectedSalt+`","vid":3}`))
+	c := New(testAppID, WithVerifyMemo(time.Second, 10)).(*Client)
+	expected := hexString(testPasswordSumHashStr).Bytes()
+	_, err := c.VerifyPassword(testHashBytes, expected, 0)
+	assert.NoError(t, err)
+
+	// Replacing the memo zeroes the results of the old one
+	memo := c.memo
+	stored := memo.entries[verifyMemoKey(testHashBytes, expected, 0)].vp.Hash
+	c.EnableVerifyMemo(2*time.Second, 10)
+	assert.Equal(t, make([]byte, len(expected)), stored)
+	assert.Equal(t, 0, memo.len())
+	assert.True(t, memo != c.memo)
+}
@@ -0,0 +1,260 @@
+package taplink
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// ServerEntry is a server with its priority and weight, as the configuration
+// can give them: {"host": "us-east.example.com", "weight": 10, "priority": 1}
+//
+// Requests go to the servers with the lowest priority, and only fail over to
+// the next priority when none of them can be used. Within a priority, each
+// request starts at a random server picked in proportion to the weights,
+// where a weight below 1 counts as 1, from those the stats don't demote.
+type ServerEntry struct {
+	Host     string `json:"host"`
+	Weight   int    `json:"weight,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+// UnmarshalJSON decodes options whose servers are either hosts or
+// ServerEntry objects
+func (o *Options) UnmarshalJSON(data []byte) error {
+	return o.unmarshalJSON(data, false)
+}
+
+// unmarshalJSON decodes the options, rejecting unknown fields in strict mode
+func (o *Options) unmarshalJSON(data []byte, strict bool) error {
+	var wire struct {
+		LastModified int64           `json:"lastModified"`
+		Servers      json.RawMessage `json:"servers"`
+	}
+	if err := unmarshalJSON(data, &wire, strict); err != nil {
+		return err
+	}
+	*o = Options{LastModified: wire.LastModified}
+	if len(wire.Servers) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(wire.Servers, &o.Servers); err == nil {
+		return nil
+	}
+	var entries []ServerEntry
+	if err := unmarshalJSON(wire.Servers, &entries, strict); err != nil {
+		return fmt.Errorf("servers are neither hosts nor entries: %w", err)
+	}
+	o.Servers, o.Entries = entryHosts(entries), entries
+	return nil
+}
+
+// MarshalJSON encodes the servers as ServerEntry objects if the options have
+// entries, and as hosts otherwise
+func (o Options) MarshalJSON() ([]byte, error) {
+	var servers any = o.Servers
+	if o.Entries != nil {
+		servers = o.Entries
+	}
+	return json.Marshal(struct {
+		LastModified int64 `json:"lastModified"`
+		Servers      any   `json:"servers"`
+	}{o.LastModified, servers})
+}
+
+// entryHosts returns the hosts of entries
+func entryHosts(entries []ServerEntry) []string {
+	hosts := make([]string, len(entries))
+	for i, e := range entries {
+		hosts[i] = e.Host
+	}
+	return hosts
+}
+
+// ServerEntries returns the servers with their priorities and weights. The
+// servers of a configuration which gave only hosts have neither, and are
+// chosen by the host selection method rather than by weight.
+func (c *Config) ServerEntries() []ServerEntry {
+	if entries := c.weightedEntries(); entries != nil {
+		return append([]ServerEntry{}, entries...)
+	}
+	servers := c.servers()
+	entries := make([]ServerEntry, len(servers))
+	for i, host := range servers {
+		entries[i] = ServerEntry{Host: host}
+	}
+	return entries
+}
+
+// SetServerEntries replaces the server list with a copy of entries, as
+// SetServers does with hosts, so requests are spread over the servers by
+// their priorities and weights. If any of the hosts isn't valid, the list is
+// left as it was and ErrInvalidHost is returned.
+func (c *Config) SetServerEntries(entries []ServerEntry) error {
+	for _, e := range entries {
+		if !validHost(e.Host) {
+			return fmt.Errorf("%w: %q", ErrInvalidHost, e.Host)
+		}
+	}
+	entries = append(make([]ServerEntry, 0, len(entries)), entries...)
+	servers := entryHosts(entries)
+	c.Lock()
+	c.options = &Options{Servers: servers, Entries: entries}
+	c.loadedAt = time.Now()
+	c.srvName = ""
+	c.Unlock()
+	c.Stats().SetServers(servers)
+	return nil
+}
+
+// weightedEntries returns the server entries if the configuration gave
+// priorities and weights, or nil. The list must not be modified.
+func (c *Config) weightedEntries() []ServerEntry {
+	c.RLock()
+	defer c.RUnlock()
+	if c.options == nil {
+		return nil
+	}
+	return c.options.Entries
+}
+
+// weightedHost returns the server for attempt out of hosts, the active
+// servers, by the priorities and weights of entries. The servers are put in
+// order of priority, and the first one the circuit breaker allows is used.
+//
+// Within each priority, the first attempt goes to a server picked at random
+// by weight, from the servers the stats don't demote: those whose latest
+// health check probe failed or which had server errors in the last minute
+// go behind the others. Retries leave out failed, unless it's the only
+// server left, and go through each priority in the order ranked by
+// Stats().Hosts(), or by weight while there's no ranking, so that every
+// retry doesn't reshuffle it.
+func weightedHost(stats Statistics, entries []ServerEntry, hosts []string, attempts int, failed string) string {
+	active := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		active[h] = true
+	}
+	candidates := make([]ServerEntry, 0, len(entries))
+	for _, e := range entries {
+		if active[e.Host] {
+			candidates = append(candidates, e)
+			delete(active, e.Host)
+		}
+	}
+	if len(candidates) == 0 {
+		return pickHost(stats, hosts, attempts)
+	}
+	ordered := make([]string, 0, len(candidates))
+	if attempts == 0 {
+		for _, group := range priorityGroups(candidates) {
+			ordered = append(ordered, demoteHosts(stats, weightedOrder(group))...)
+		}
+		return pickHost(stats, ordered, 0)
+	}
+	ranked := rankedHosts(stats, hosts)
+	for _, group := range priorityGroups(candidates) {
+		ordered = append(ordered, retryOrder(group, ranked)...)
+	}
+	return pickHost(stats, withoutHost(ordered, failed), attempts-1)
+}
+
+// priorityGroups returns a copy of entries split by priority, lowest first,
+// keeping their order within each priority
+func priorityGroups(entries []ServerEntry) [][]ServerEntry {
+	entries = append([]ServerEntry(nil), entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Priority < entries[j].Priority
+	})
+	var groups [][]ServerEntry
+	for start := 0; start < len(entries); {
+		end := start + 1
+		for end < len(entries) && entries[end].Priority == entries[start].Priority {
+			end++
+		}
+		groups = append(groups, entries[start:end:end])
+		start = end
+	}
+	return groups
+}
+
+// weightedOrder returns the hosts of entries in a random order, in which a
+// server is ahead of another as often as its share of their weights
+func weightedOrder(entries []ServerEntry) []string {
+	group := append([]ServerEntry(nil), entries...)
+	hosts := make([]string, 0, len(group))
+	for len(group) > 0 {
+		i := pickWeighted(group)
+		hosts = append(hosts, group[i].Host)
+		group[i], group[len(group)-1] = group[len(group)-1], group[i]
+		group = group[:len(group)-1]
+	}
+	return hosts
+}
+
+// demoteHosts returns hosts with those whose latest health check probe in
+// the last minute failed, or which had server errors in it, moved behind
+// the others, keeping their order otherwise
+func demoteHosts(stats Statistics, hosts []string) []string {
+	if s, ok := stats.(*statistics); ok && !s.isEnabled() {
+		return hosts
+	}
+	healthy := make([]string, 0, len(hosts))
+	var demoted []string
+	for _, h := range hosts {
+		m := stats.Get(h).Last(time.Minute)
+		if m.Probes().Failing || m.ServerErrorRate() > 0 {
+			demoted = append(demoted, h)
+		} else {
+			healthy = append(healthy, h)
+		}
+	}
+	return append(healthy, demoted...)
+}
+
+// retryOrder returns the hosts of entries in the order of ranked, or if
+// there's no ranking, by weight, heaviest first, and then in their
+// configured order
+func retryOrder(entries []ServerEntry, ranked []string) []string {
+	hosts := entryHosts(entries)
+	if ranked != nil {
+		pos := make(map[string]int, len(ranked))
+		for i, h := range ranked {
+			pos[h] = i
+		}
+		sort.SliceStable(hosts, func(i, j int) bool {
+			return pos[hosts[i]] < pos[hosts[j]]
+		})
+		return hosts
+	}
+	entries = append([]ServerEntry(nil), entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entryWeight(entries[i]) > entryWeight(entries[j])
+	})
+	return entryHosts(entries)
+}
+
+// pickWeighted returns the index of a random entry, picked in proportion to
+// their weights
+func pickWeighted(entries []ServerEntry) int {
+	total := 0
+	for _, e := range entries {
+		total += entryWeight(e)
+	}
+	n := rand.Intn(total)
+	for i, e := range entries {
+		if n -= entryWeight(e); n < 0 {
+			return i
+		}
+	}
+	return len(entries) - 1
+}
+
+// entryWeight returns the weight of e, which is at least 1
+func entryWeight(e ServerEntry) int {
+	if e.Weight < 1 {
+		return 1
+	}
+	return e.Weight
+}
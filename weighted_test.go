@@ -0,0 +1,133 @@
+package taplink
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/TapLink/taplink-go/taplinktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionsServerEntriesJSON(t *testing.T) {
+	var opts Options
+	assert.NoError(t, json.Unmarshal([]byte(`{"lastModified":1,"servers":["a.com","b.com"]}`), &opts))
+	assert.Equal(t, Options{LastModified: 1, Servers: []string{"a.com", "b.com"}}, opts)
+	b, err := json.Marshal(opts)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"lastModified":1,"servers":["a.com","b.com"]}`, string(b))
+
+	body := `{"lastModified":2,"servers":[{"host":"a.com","weight":10,"priority":1},{"host":"b.com"}]}`
+	assert.NoError(t, json.Unmarshal([]byte(body), &opts))
+	assert.Equal(t, Options{
+		LastModified: 2,
+		Servers:      []string{"a.com", "b.com"},
+		Entries:      []ServerEntry{{Host: "a.com", Weight: 10, Priority: 1}, {Host: "b.com"}},
+	}, opts)
+	b, err = json.Marshal(opts)
+	assert.NoError(t, err)
+	assert.JSONEq(t, body, string(b))
+
+	assert.Error(t, json.Unmarshal([]byte(`{"servers":[1,2]}`), &opts))
+
+	// Strict decoding reaches the entries
+	extra := []byte(`{"servers":[{"host":"a.com","region":"us"}]}`)
+	assert.NoError(t, decodeJSON(extra, &opts, false))
+	assert.ErrorIs(t, decodeJSON(extra, &opts, true), ErrMalformedResponse)
+}
+
+func TestLoadServerEntries(t *testing.T) {
+	st, restore := useScript()
+	defer restore()
+	st.Enqueue(taplinktest.Respond(200, `{"lastModified":1,"servers":[{"host":"us-east.com","weight":3,"priority":1},{"host":"eu-west.com","priority":2}]}`))
+	c := New(testAppID)
+	assert.NoError(t, c.Config().Load())
+	assert.Equal(t, []string{"us-east.com", "eu-west.com"}, c.Config().Servers())
+	assert.Equal(t, []ServerEntry{{Host: "us-east.com", Weight: 3, Priority: 1}, {Host: "eu-west.com", Priority: 2}}, c.Config().ServerEntries())
+
+	// They're saved and restored with the configuration
+	var buf bytes.Buffer
+	assert.NoError(t, c.Config().SaveTo(&buf))
+	restored := New(testAppID)
+	assert.NoError(t, restored.Config().LoadFrom(&buf))
+	assert.Equal(t, c.Config().ServerEntries(), restored.Config().ServerEntries())
+
+	// A plain list has no priorities or weights
+	assert.NoError(t, c.Config().SetServers([]string{"a.com"}))
+	assert.Equal(t, []ServerEntry{{Host: "a.com"}}, c.Config().ServerEntries())
+	assert.ErrorIs(t, c.Config().SetServerEntries([]ServerEntry{{Host: "bad host"}}), ErrInvalidHost)
+}
+
+func TestWeightedSelection(t *testing.T) {
+	c := newConfig("")
+	assert.NoError(t, c.SetServerEntries([]ServerEntry{
+		{Host: "us-east-1.com", Weight: 3, Priority: 1},
+		{Host: "us-east-2.com", Weight: 1, Priority: 1},
+		{Host: "eu-west.com", Weight: 100, Priority: 2},
+	}))
+
+	// The first priority gets every request, split by weight
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		counts[c.Host(0)]++
+	}
+	assert.Equal(t, 0, counts["eu-west.com"])
+	assert.Greater(t, counts["us-east-1.com"], 600)
+	assert.Greater(t, counts["us-east-2.com"], 100)
+
+	// A retry leaves out the server which failed, staying in the priority
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, "us-east-2.com", c.RetryHost(0, 1, "us-east-1.com"))
+	}
+
+	// Only once the circuits of the first priority are open does the next get
+	// requests
+	c.EnableCircuitBreaker(1, time.Minute, time.Minute)
+	c.Stats().AddError("us-east-1.com", 503, 0)
+	assert.Equal(t, "us-east-2.com", c.Host(0))
+	c.Stats().AddError("us-east-2.com", 503, 0)
+	assert.Equal(t, "eu-west.com", c.Host(0))
+
+	// A HostSelector still takes precedence
+	c.SetHostSelector(PrimarySelector())
+	assert.Equal(t, "eu-west.com", c.Host(0))
+	c.SetHostSelector(HostSelectorFunc(func(servers []string, _ Statistics, _ int) string { return servers[1] }))
+	assert.Equal(t, "us-east-2.com", c.Host(0))
+}
+
+func TestWeightedSelectionDemotesFailingHost(t *testing.T) {
+	c := newConfig("")
+	c.Stats().Enable()
+	assert.NoError(t, c.SetServerEntries([]ServerEntry{
+		{Host: "us-east-1.com", Weight: 100, Priority: 1},
+		{Host: "us-east-2.com", Weight: 1, Priority: 1},
+		{Host: "us-east-3.com", Weight: 1, Priority: 1},
+		{Host: "eu-west.com", Weight: 100, Priority: 2},
+	}))
+
+	// Without the circuit breaker, a server of the first priority with
+	// server errors only gets requests once its peers have been tried, and
+	// the next priority none
+	c.Stats().AddError("us-east-1.com", 503, 0)
+	c.Stats().AddSuccess("us-east-3.com", 2*time.Millisecond)
+	c.Stats().AddSuccess("us-east-2.com", time.Millisecond)
+	for i := 0; i < 100; i++ {
+		assert.NotEqual(t, "us-east-1.com", c.Host(0))
+		assert.NotEqual(t, "eu-west.com", c.Host(0))
+	}
+
+	// Retries go through the priority in the order of the stats rather than
+	// at random, leaving out the server which failed
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, "us-east-2.com", c.RetryHost(0, 1, "us-east-3.com"))
+		assert.Equal(t, "us-east-3.com", c.RetryHost(0, 1, "us-east-2.com"))
+		assert.Equal(t, "us-east-1.com", c.RetryHost(0, 2, "us-east-2.com"))
+	}
+
+	// A failing health check demotes a server too
+	c.Stats().AddProbe("us-east-2.com", 0, false)
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, "us-east-3.com", c.Host(0))
+	}
+}
@@ -0,0 +1,36 @@
+package taplink
+
+// Wiping is best-effort: it overwrites the slices it's given, but Go's
+// garbage collector may already have moved or copied them, and the pooled
+// SHA-512 digests the HMACs are computed with keep parts of their last input
+// until they're reused. It shortens how long salts and hashes stay in memory
+// rather than guaranteeing they're gone.
+
+// wipeBytes overwrites b with zeros
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Wipe overwrites Salt and NewSalt with zeros. The salts returned by GetSalt
+// are the caller's own copies, so defer Wipe once they've been used.
+// Scrubbing is best-effort, as the garbage collector may have copied them.
+func (s *Salt) Wipe() {
+	wipeBytes(s.Salt)
+	wipeBytes(s.NewSalt)
+}
+
+// Wipe overwrites Hash with zeros, e.g. once it's been stored. Scrubbing is
+// best-effort, as the garbage collector may have copied it.
+func (p *NewPassword) Wipe() {
+	wipeBytes(p.Hash)
+}
+
+// Wipe overwrites Hash and NewHash with zeros, e.g. once NewHash has been
+// stored. NewEncoded is a string, which can't be overwritten. Scrubbing is
+// best-effort, as the garbage collector may have copied them.
+func (vp *VerifyPassword) Wipe() {
+	wipeBytes(vp.Hash)
+	wipeBytes(vp.NewHash)
+}
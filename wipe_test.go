@@ -0,0 +1,69 @@
+package taplink
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func zeroed(b []byte) bool {
+	return len(b) > 0 && bytes.Count(b, []byte{0}) == len(b)
+}
+
+func TestWipe(t *testing.T) {
+	s := &Salt{Salt: []byte("salt"), NewSalt: []byte("new salt")}
+	s.Wipe()
+	assert.True(t, zeroed(s.Salt))
+	assert.True(t, zeroed(s.NewSalt))
+
+	p := &NewPassword{Hash: []byte("hash")}
+	p.Wipe()
+	assert.True(t, zeroed(p.Hash))
+
+	vp := &VerifyPassword{Hash: []byte("hash"), NewHash: []byte("new hash")}
+	vp.Wipe()
+	assert.True(t, zeroed(vp.Hash))
+	assert.True(t, zeroed(vp.NewHash))
+
+	// Missing values are fine
+	(&Salt{}).Wipe()
+	(&VerifyPassword{}).Wipe()
+}
+
+func TestSaltWipedAfterUse(t *testing.T) {
+	f, restore := useFake()
+	defer restore()
+	f.SetLatestVersion(2)
+
+	salt := &Salt{Salt: f.Salt(testHashBytes, 2), VersionID: 2, NewVersionID: 2}
+	p := newPasswordWithSalt(salt, testHashBytes)
+	assert.Equal(t, f.Hash(testHashBytes, 2), p.Hash)
+	assert.True(t, zeroed(salt.Salt))
+
+	salt = &Salt{Salt: f.Salt(testHashBytes, 1), VersionID: 1, NewVersionID: 2, NewSalt: f.Salt(testHashBytes, 2)}
+	vp := verifyWithSalt(salt, testHashBytes, f.Hash(testHashBytes, 1))
+	assert.True(t, vp.Matched)
+	assert.Equal(t, f.Hash(testHashBytes, 2), vp.NewHash)
+	assert.True(t, zeroed(salt.Salt))
+	assert.True(t, zeroed(salt.NewSalt))
+
+	// Cached salts are copied out, so wiping them after use leaves the cache
+	// intact, and cached salts are wiped when they're dropped
+	c := New(testAppID).(*Client)
+	c.EnableSaltCache(time.Second, 10)
+	_, err := c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+	_, err = c.NewPassword(testHashBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, f.Requests())
+	s, err := c.GetSalt(testHashBytes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, f.Salt(testHashBytes, 2), s.Salt)
+
+	cache := c.salts
+	el := cache.lru.Front()
+	c.DisableSaltCache()
+	assert.True(t, zeroed(el.Value.(*saltCacheEntry).salt.Salt))
+}